@@ -1,9 +1,11 @@
 package aof
 
 import (
+	"errors"
 	"github.com/ygxiaobai111/GolixirDB/config"
 	databaseface "github.com/ygxiaobai111/GolixirDB/interface/database"
 	util "github.com/ygxiaobai111/GolixirDB/lib/logger"
+	"github.com/ygxiaobai111/GolixirDB/lib/sync/atomic"
 	"github.com/ygxiaobai111/GolixirDB/lib/utils"
 	"github.com/ygxiaobai111/GolixirDB/resp/connection"
 	"github.com/ygxiaobai111/GolixirDB/resp/parser"
@@ -11,6 +13,8 @@ import (
 	"io"
 	"os"
 	"strconv"
+	"sync"
+	"time"
 )
 
 /*
@@ -31,13 +35,22 @@ type AofHandler struct {
 	db          databaseface.Database
 	aofChan     chan *payload //缓存区
 	aofFile     *os.File
-	aofFilename string //持久化文件名
-	currentDB   int    //哪一个数据库
+	aofFilename string         //持久化文件名
+	currentDB   int            //哪一个数据库
+	writeFailed atomic.Boolean // 最近一次写入是否失败
+
+	// 关闭时持有写锁，保证关闭 aofChan 后不会再有命令写入
+	closeMu   sync.RWMutex
+	closed    bool
+	discard   atomic.Boolean // 关闭时丢弃缓冲区中尚未写入的命令
+	aofDone   chan struct{}  // handleAof 退出时关闭
+	stopFsync chan struct{}  // 关闭时通知 fsyncEverySec 退出
+	fsyncDone chan struct{}  // fsyncEverySec 退出时关闭
 }
 
 func NewAOFHandler(db databaseface.Database) (*AofHandler, error) {
 	handler := &AofHandler{}
-	handler.aofFilename = config.Properties.AppendFilename
+	handler.aofFilename = config.Properties().AppendFilename
 	handler.db = db
 	handler.LoadAof()
 	//进行追加、读写操作
@@ -47,13 +60,24 @@ func NewAOFHandler(db databaseface.Database) (*AofHandler, error) {
 	}
 	handler.aofFile = aofFile
 	handler.aofChan = make(chan *payload, aofQueueSize)
+	handler.aofDone = make(chan struct{})
+	handler.stopFsync = make(chan struct{})
+	handler.fsyncDone = make(chan struct{})
 	go func() {
 		handler.handleAof()
 	}()
+	go handler.fsyncEverySec()
 	return handler, nil
 }
 func (handler *AofHandler) AddAof(dbIndex int, cmdLine CmdLine) {
-	if config.Properties.AppendOnly && handler.aofChan != nil {
+	if config.Properties().AppendOnly && handler.aofChan != nil {
+		handler.closeMu.RLock()
+		defer handler.closeMu.RUnlock()
+		if handler.closed {
+			// 关闭后仍在执行的命令无法再写入
+			util.LogrusObj.Warn("aof is closed, dropping command " + string(cmdLine[0]))
+			return
+		}
 		handler.aofChan <- &payload{
 			cmdLine: cmdLine,
 			dbIndex: dbIndex,
@@ -65,13 +89,20 @@ func (handler *AofHandler) AddAof(dbIndex int, cmdLine CmdLine) {
 func (handler *AofHandler) handleAof() {
 	// serialized execution
 	handler.currentDB = 0
+	defer close(handler.aofDone)
+	discarded := 0
 	for p := range handler.aofChan {
+		if handler.discard.Get() {
+			discarded++
+			continue
+		}
 		if p.dbIndex != handler.currentDB {
 			// select db
 			data := reply.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(p.dbIndex))).ToBytes()
 			_, err := handler.aofFile.Write(data)
 			if err != nil {
 				util.LogrusObj.Warn(err)
+				handler.writeFailed.Set(true)
 				continue // skip this command
 			}
 			handler.currentDB = p.dbIndex
@@ -81,7 +112,88 @@ func (handler *AofHandler) handleAof() {
 		if err != nil {
 			util.LogrusObj.Warn(err)
 		}
+		handler.writeFailed.Set(err != nil)
+		if err == nil && config.Properties().AppendFsync == config.FsyncAlways {
+			handler.fsync()
+		}
+	}
+	if discarded > 0 {
+		util.LogrusObj.Warn("aof closed without saving, discarded " + strconv.Itoa(discarded) + " commands")
+	}
+}
+
+// fsyncEverySec 刷盘策略为 everysec 时每秒刷盘一次，策略可以在运行时修改
+func (handler *AofHandler) fsyncEverySec() {
+	ticker := time.NewTicker(time.Second)
+	defer func() {
+		ticker.Stop()
+		close(handler.fsyncDone)
+	}()
+	for {
+		select {
+		case <-ticker.C:
+			if config.Properties().AppendFsync == config.FsyncEverySec {
+				handler.fsync()
+			}
+		case <-handler.stopFsync:
+			return
+		}
+	}
+}
+
+// Close 停止写入并关闭 aof 文件
+// save 为 true 时先将缓冲区中的命令全部写入文件，否则丢弃尚未写入的命令，两种情况都会在关闭前刷盘
+func (handler *AofHandler) Close(save bool) error {
+	handler.closeMu.Lock()
+	if handler.closed {
+		handler.closeMu.Unlock()
+		return nil
 	}
+	handler.closed = true
+	handler.discard.Set(!save)
+	close(handler.aofChan)
+	handler.closeMu.Unlock()
+
+	<-handler.aofDone
+	close(handler.stopFsync)
+	<-handler.fsyncDone
+	if err := handler.aofFile.Sync(); err != nil {
+		_ = handler.aofFile.Close()
+		return err
+	}
+	if err := handler.aofFile.Close(); err != nil {
+		return err
+	}
+	if handler.writeFailed.Get() {
+		return errors.New("the last write to the aof file failed")
+	}
+	return nil
+}
+
+// fsync 将 aof 文件刷入磁盘
+func (handler *AofHandler) fsync() {
+	if err := handler.aofFile.Sync(); err != nil {
+		util.LogrusObj.Warn("aof fsync failed: " + err.Error())
+	}
+}
+
+// CurrentSize 返回 aof 文件当前大小
+func (handler *AofHandler) CurrentSize() int64 {
+	info, err := handler.aofFile.Stat()
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// BufferLength 返回缓冲区中尚未写入文件的命令数
+func (handler *AofHandler) BufferLength() int {
+	return len(handler.aofChan)
+}
+
+// LastWriteOK 返回最近一次写入 aof 文件是否成功
+func (handler *AofHandler) LastWriteOK() bool {
+	return !handler.writeFailed.Get()
 }
 
 // 将本地数据读取
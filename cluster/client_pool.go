@@ -7,7 +7,10 @@ import (
 	"context"
 	"errors"
 	"github.com/jolestar/go-commons-pool/v2"
+	"github.com/ygxiaobai111/GolixirDB/config"
+	"github.com/ygxiaobai111/GolixirDB/lib/utils"
 	"github.com/ygxiaobai111/GolixirDB/resp/client"
+	"github.com/ygxiaobai111/GolixirDB/resp/reply"
 )
 
 type connectionFactory struct {
@@ -21,6 +24,23 @@ func (f *connectionFactory) MakeObject(ctx context.Context) (*pool.PooledObject,
 		return nil, err
 	}
 	c.Start()
+	// 集群各节点使用相同的密码
+	if password := config.Properties().RequirePass; password != "" {
+		if errReply, ok := c.Auth(password).(reply.ErrorReply); ok {
+			c.Close()
+			return nil, errors.New("auth failed: " + errReply.Error())
+		}
+	}
+	// 认证为集群节点后才能执行节点之间的内部命令
+	if errReply, ok := c.Setup(utils.ToCmdLine(relayPeerAuth, config.Properties().ClusterSecret)).(reply.ErrorReply); ok {
+		c.Close()
+		return nil, errors.New("peer auth failed: " + errReply.Error())
+	}
+	// 节点间使用 RESP3 通信，保留 map 等类型信息，再按客户端协商的协议返回
+	if errReply, ok := c.Hello(reply.Resp3).(reply.ErrorReply); ok {
+		c.Close()
+		return nil, errors.New("hello failed: " + errReply.Error())
+	}
 	return pool.NewPooledObject(c), nil
 }
 
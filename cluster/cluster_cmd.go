@@ -0,0 +1,429 @@
+package cluster
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/ygxiaobai111/GolixirDB/interface/resp"
+	"github.com/ygxiaobai111/GolixirDB/lib/slot"
+	"github.com/ygxiaobai111/GolixirDB/resp/reply"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/*
+CLUSTER 命令，输出格式与 redis cluster 相同，供客户端获取哈希槽分配后直接访问负责的节点
+CLUSTER SLOTS、CLUSTER SHARDS、CLUSTER NODES 只在哈希槽路由下可用
+CLUSTER MEET ip port [bus-port] 让新节点加入集群，CLUSTER FORGET node-id 将节点移出集群，两者都会通过集群总线通知其他节点
+CLUSTER RESHARD node-id start end [start end ...] 将当前节点负责的哈希槽在线迁往其他节点，CLUSTER REBALANCE 将哈希槽平均分配给所有节点，
+CLUSTER MIGRATIONS 查看迁移进度
+CLUSTER TRANSACTIONS 列出当前节点上尚未结束的分布式事务，CLUSTER TXRESOLVE txid COMMIT|ABORT 手动处理 decider 无法回答的事务
+CLUSTER REPLICATE node-id 让当前节点成为主节点的从节点，CLUSTER REPLICAS node-id 列出主节点的从节点
+*/
+
+// nodeID 返回节点的 40 位十六进制标识，由节点地址计算得出，所有节点对同一地址得到相同的标识
+func nodeID(node string) string {
+	sum := sha1.Sum([]byte(node))
+	return hex.EncodeToString(sum[:])
+}
+
+// splitAddr 将节点地址拆分为主机与端口
+func splitAddr(node string) (string, int) {
+	host, portStr, err := net.SplitHostPort(node)
+	if err != nil {
+		return node, 0
+	}
+	port, _ := strconv.Atoi(portStr)
+	return host, port
+}
+
+var errNoSlots = reply.MakeErrReply("ERR hash slots are not available with consistent-hash routing")
+
+// execCluster CLUSTER subcommand [args]
+func execCluster(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("cluster")
+	}
+	sub := strings.ToLower(string(args[1]))
+	switch sub {
+	case "keyslot":
+		if len(args) != 3 {
+			return reply.MakeArgNumErrReply("cluster|keyslot")
+		}
+		return reply.MakeIntReply(int64(slot.KeySlot(string(args[2]))))
+	case "myid":
+		return reply.MakeBulkReply([]byte(nodeID(cluster.self)))
+	case "info":
+		return cluster.clusterInfo()
+	case "meet":
+		if len(args) != 4 && len(args) != 5 {
+			return reply.MakeArgNumErrReply("cluster|meet")
+		}
+		return cluster.clusterMeet(args[2:])
+	case "forget":
+		if len(args) != 3 {
+			return reply.MakeArgNumErrReply("cluster|forget")
+		}
+		return cluster.clusterForget(string(args[2]))
+	case "reshard":
+		if len(args) < 5 || len(args)%2 != 1 {
+			return reply.MakeArgNumErrReply("cluster|reshard")
+		}
+		return cluster.clusterReshard(args[2:])
+	case "rebalance":
+		return cluster.clusterRebalance(c)
+	case "migrations":
+		return cluster.clusterMigrations()
+	case "transactions":
+		return cluster.clusterTransactions()
+	case "txresolve":
+		if len(args) != 4 {
+			return reply.MakeArgNumErrReply("cluster|txresolve")
+		}
+		return cluster.clusterTxResolve(string(args[2]), string(args[3]))
+	case "replicate":
+		if len(args) != 3 {
+			return reply.MakeArgNumErrReply("cluster|replicate")
+		}
+		return cluster.clusterReplicate(string(args[2]))
+	case "slots", "shards", "nodes", "replicas":
+		if cluster.slots == nil {
+			return errNoSlots
+		}
+		switch sub {
+		case "slots":
+			return cluster.clusterSlots()
+		case "shards":
+			return cluster.clusterShards()
+		case "replicas":
+			if len(args) != 3 {
+				return reply.MakeArgNumErrReply("cluster|replicas")
+			}
+			return cluster.clusterReplicas(string(args[2]))
+		default:
+			return cluster.clusterNodes()
+		}
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + sub +
+		"'. Try CLUSTER KEYSLOT, CLUSTER MYID, CLUSTER INFO, CLUSTER MEET, CLUSTER FORGET, CLUSTER RESHARD, CLUSTER REBALANCE, CLUSTER MIGRATIONS, CLUSTER TRANSACTIONS, CLUSTER TXRESOLVE, CLUSTER REPLICATE, CLUSTER REPLICAS, CLUSTER SLOTS, CLUSTER SHARDS, CLUSTER NODES.")
+}
+
+// clusterMeet CLUSTER MEET ip port [bus-port]，等待对方回复后返回
+func (cluster *ClusterDatabase) clusterMeet(args [][]byte) resp.Reply {
+	port, err := strconv.Atoi(string(args[1]))
+	if err != nil || port <= 0 || port > 65535 {
+		return reply.MakeErrReply("ERR Invalid node address specified: " + string(args[0]) + ":" + string(args[1]))
+	}
+	node := net.JoinHostPort(string(args[0]), strconv.Itoa(port))
+	busPort := port + 10000
+	if len(args) == 3 {
+		busPort, err = strconv.Atoi(string(args[2]))
+		if err != nil || busPort <= 0 || busPort > 65535 {
+			return reply.MakeErrReply("ERR Invalid bus port specified: " + string(args[2]))
+		}
+	}
+	if node == cluster.self {
+		return reply.MakeOkReply()
+	}
+	target := makeNodeState(node, busPort)
+	pong, err := sendBusMessage(target.busAddr(), cluster.makeBusMessage(busMeet))
+	if err != nil {
+		return reply.MakeErrReply("ERR failed to meet " + node + ": " + err.Error())
+	}
+	cluster.mu.Lock()
+	delete(cluster.forgotten, pong.Sender)
+	cluster.mu.Unlock()
+	cluster.handleBusMessage(pong)
+	return reply.MakeOkReply()
+}
+
+// clusterForget CLUSTER FORGET node-id
+func (cluster *ClusterDatabase) clusterForget(id string) resp.Reply {
+	if id == nodeID(cluster.self) {
+		return reply.MakeErrReply("ERR I tried hard but I can't forget myself...")
+	}
+	cluster.mu.Lock()
+	node := ""
+	for addr := range cluster.states {
+		if nodeID(addr) == id {
+			node = addr
+			break
+		}
+	}
+	if node == "" {
+		cluster.mu.Unlock()
+		return reply.MakeErrReply("ERR Unknown node " + id)
+	}
+	cluster.forgetLocked(node)
+	msg := cluster.makeBusMessageLocked(busForget)
+	msg.Target = node
+	cluster.mu.Unlock()
+	cluster.broadcastBus(msg)
+	return reply.MakeOkReply()
+}
+
+// clusterInfo CLUSTER INFO
+func (cluster *ClusterDatabase) clusterInfo() resp.Reply {
+	cluster.mu.RLock()
+	knownNodes := len(cluster.states)
+	currentEpoch := cluster.currentEpoch
+	myEpoch := cluster.states[cluster.self].configEpoch
+	assigned, pfail, fail := slot.Count, 0, 0
+	size := 0
+	var counts map[string]int
+	if cluster.slots != nil {
+		counts = cluster.slots.countByNode()
+		assigned = 0
+	} else {
+		counts = make(map[string]int)
+		for _, node := range cluster.nodes {
+			counts[node] = 1
+		}
+	}
+	for node, count := range counts {
+		size++
+		if cluster.slots == nil {
+			continue
+		}
+		assigned += count
+		if state, ok := cluster.states[node]; ok && state.fail {
+			fail += count
+		} else if ok && state.pfail {
+			pfail += count
+		}
+	}
+	cluster.mu.RUnlock()
+	state := "ok"
+	if assigned < slot.Count || fail > 0 {
+		state = "fail"
+	}
+	var sb strings.Builder
+	field := func(name string, value interface{}) {
+		sb.WriteString(name + ":")
+		switch v := value.(type) {
+		case int:
+			sb.WriteString(strconv.Itoa(v))
+		case string:
+			sb.WriteString(v)
+		}
+		sb.WriteString("\r\n")
+	}
+	field("cluster_state", state)
+	field("cluster_slots_assigned", assigned)
+	field("cluster_slots_ok", assigned-pfail-fail)
+	field("cluster_slots_pfail", pfail)
+	field("cluster_slots_fail", fail)
+	field("cluster_known_nodes", knownNodes)
+	field("cluster_size", size)
+	field("cluster_current_epoch", strconv.FormatUint(currentEpoch, 10))
+	field("cluster_my_epoch", strconv.FormatUint(myEpoch, 10))
+	return reply.MakeVerbatimStringReply("txt", sb.String())
+}
+
+// makeNodeReply 返回 CLUSTER SLOTS 中描述节点的 [host, port, id]
+func makeNodeReply(node string) resp.Reply {
+	host, port := splitAddr(node)
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte(host)),
+		reply.MakeIntReply(int64(port)),
+		reply.MakeBulkReply([]byte(nodeID(node))),
+	})
+}
+
+// clusterSlots CLUSTER SLOTS，每个连续区间返回 [start, end, [host, port, id], [从节点 host, port, id] ...]
+func (cluster *ClusterDatabase) clusterSlots() resp.Reply {
+	ranges := cluster.slots.ranges()
+	result := make([]resp.Reply, 0, len(ranges))
+	cluster.mu.RLock()
+	defer cluster.mu.RUnlock()
+	for _, r := range ranges {
+		item := []resp.Reply{
+			reply.MakeIntReply(int64(r.start)),
+			reply.MakeIntReply(int64(r.end)),
+			makeNodeReply(r.node),
+		}
+		for _, replica := range cluster.replicasOf(r.node) {
+			if !cluster.states[replica].fail {
+				item = append(item, makeNodeReply(replica))
+			}
+		}
+		result = append(result, reply.MakeMultiRawReply(item))
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// clusterShards CLUSTER SHARDS，每个主节点与它的从节点是一个分片，不负责任何哈希槽的主节点也会列出
+func (cluster *ClusterDatabase) clusterShards() resp.Reply {
+	slotsByNode := make(map[string][]resp.Reply)
+	for _, r := range cluster.slots.ranges() {
+		slotsByNode[r.node] = append(slotsByNode[r.node],
+			reply.MakeIntReply(int64(r.start)), reply.MakeIntReply(int64(r.end)))
+	}
+	cluster.mu.RLock()
+	defer cluster.mu.RUnlock()
+	nodes := make([]string, 0, len(cluster.states))
+	for node, state := range cluster.states {
+		if state.replicaOf == "" {
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Strings(nodes)
+	result := make([]resp.Reply, 0, len(nodes))
+	for _, node := range nodes {
+		members := []resp.Reply{cluster.makeShardNodeLocked(node, "master")}
+		for _, replica := range cluster.replicasOf(node) {
+			members = append(members, cluster.makeShardNodeLocked(replica, "replica"))
+		}
+		shard := reply.MakeMapReply(nil, nil)
+		shard.Add(reply.MakeBulkReply([]byte("slots")), reply.MakeMultiRawReply(slotsByNode[node]))
+		shard.Add(reply.MakeBulkReply([]byte("nodes")), reply.MakeMultiRawReply(members))
+		result = append(result, shard)
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// makeShardNodeLocked 返回 CLUSTER SHARDS 中一个节点的信息，调用方需持有 mu
+func (cluster *ClusterDatabase) makeShardNodeLocked(node string, role string) resp.Reply {
+	state := cluster.states[node]
+	health := "online"
+	if state.fail {
+		health = "failed"
+	}
+	offset := state.replOffset
+	if node == cluster.self {
+		offset = atomic.LoadInt64(&cluster.replOffset)
+	}
+	host, port := splitAddr(node)
+	nodeInfo := reply.MakeMapReply(nil, nil)
+	nodeInfo.Add(reply.MakeBulkReply([]byte("id")), reply.MakeBulkReply([]byte(nodeID(node))))
+	nodeInfo.Add(reply.MakeBulkReply([]byte("port")), reply.MakeIntReply(int64(port)))
+	nodeInfo.Add(reply.MakeBulkReply([]byte("ip")), reply.MakeBulkReply([]byte(host)))
+	nodeInfo.Add(reply.MakeBulkReply([]byte("endpoint")), reply.MakeBulkReply([]byte(host)))
+	nodeInfo.Add(reply.MakeBulkReply([]byte("role")), reply.MakeBulkReply([]byte(role)))
+	nodeInfo.Add(reply.MakeBulkReply([]byte("replication-offset")), reply.MakeIntReply(offset))
+	nodeInfo.Add(reply.MakeBulkReply([]byte("health")), reply.MakeBulkReply([]byte(health)))
+	return nodeInfo
+}
+
+// clusterNodes CLUSTER NODES，每个节点一行：
+// <id> <ip:port@cport> <flags> <master> <ping-sent> <pong-recv> <config-epoch> <link-state> <slot> ...
+// 当前节点的行末尾与 redis 相同列出正在迁出的 [slot->-node-id] 与正在导入的 [slot-<-node-id]
+func (cluster *ClusterDatabase) clusterNodes() resp.Reply {
+	slotsByNode := cluster.slotItemsByNode()
+	cluster.mu.RLock()
+	defer cluster.mu.RUnlock()
+	nodes := make([]string, 0, len(cluster.states))
+	for node := range cluster.states {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	var sb strings.Builder
+	for _, node := range nodes {
+		cluster.writeNodeLineLocked(&sb, node, slotsByNode[node])
+	}
+	return reply.MakeVerbatimStringReply("txt", sb.String())
+}
+
+// clusterReplicas CLUSTER REPLICAS，以 CLUSTER NODES 的格式列出主节点的从节点
+func (cluster *ClusterDatabase) clusterReplicas(id string) resp.Reply {
+	cluster.mu.RLock()
+	defer cluster.mu.RUnlock()
+	primary := cluster.findNodeLocked(id)
+	if primary == "" {
+		return reply.MakeErrReply("ERR Unknown node " + id)
+	}
+	if cluster.states[primary].replicaOf != "" {
+		return reply.MakeErrReply("ERR The specified node is not a master")
+	}
+	replicas := cluster.replicasOf(primary)
+	result := make([]resp.Reply, 0, len(replicas))
+	for _, replica := range replicas {
+		var sb strings.Builder
+		cluster.writeNodeLineLocked(&sb, replica, nil)
+		result = append(result, reply.MakeBulkReply([]byte(strings.TrimSuffix(sb.String(), "\n"))))
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// slotItemsByNode 返回每个节点负责的哈希槽区间，格式为 start-end，只有一个哈希槽时为 start
+func (cluster *ClusterDatabase) slotItemsByNode() map[string][]string {
+	slotsByNode := make(map[string][]string)
+	for _, r := range cluster.slots.ranges() {
+		item := strconv.Itoa(r.start)
+		if r.end != r.start {
+			item += "-" + strconv.Itoa(r.end)
+		}
+		slotsByNode[r.node] = append(slotsByNode[r.node], item)
+	}
+	return slotsByNode
+}
+
+// writeNodeLineLocked 写入 CLUSTER NODES 中一个节点的行，调用方需持有 mu
+func (cluster *ClusterDatabase) writeNodeLineLocked(sb *strings.Builder, node string, slotItems []string) {
+	state := cluster.states[node]
+	role, master := "master", "-"
+	if state.replicaOf != "" {
+		role, master = "slave", nodeID(state.replicaOf)
+	}
+	flags := role
+	if node == cluster.self {
+		flags = "myself," + role
+	}
+	if f := state.flags(); f != "" {
+		flags += "," + f
+	}
+	linkState := "connected"
+	if state.pfail {
+		linkState = "disconnected"
+	}
+	sb.WriteString(strings.Join([]string{
+		nodeID(node),
+		node + "@" + strconv.Itoa(state.busPort),
+		flags,
+		master,
+		strconv.FormatInt(unixMilli(state.pingSent), 10),
+		strconv.FormatInt(unixMilli(state.pongRecv), 10),
+		strconv.FormatUint(state.configEpoch, 10),
+		linkState,
+	}, " "))
+	for _, item := range slotItems {
+		sb.WriteString(" " + item)
+	}
+	if node == cluster.self {
+		sb.WriteString(migrationMarks(cluster.migrating, "->-"))
+		sb.WriteString(migrationMarks(cluster.importing, "-<-"))
+	}
+	sb.WriteString("\n")
+}
+
+// unixMilli 返回 unix 毫秒时间戳，零值返回 0
+func unixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// sortedNodes 返回按地址排序的所有节点
+func (cluster *ClusterDatabase) sortedNodes() []string {
+	nodes := cluster.nodeList()
+	sort.Strings(nodes)
+	return nodes
+}
+
+// migrationMarks 返回 CLUSTER NODES 中按哈希槽排序的迁移标记
+func migrationMarks(slots map[int]string, arrow string) string {
+	ids := make([]int, 0, len(slots))
+	for id := range slots {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	var sb strings.Builder
+	for _, id := range ids {
+		sb.WriteString(" [" + strconv.Itoa(id) + arrow + nodeID(slots[id]) + "]")
+	}
+	return sb.String()
+}
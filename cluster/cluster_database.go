@@ -12,10 +12,14 @@ import (
 	"github.com/ygxiaobai111/GolixirDB/interface/resp"
 	"github.com/ygxiaobai111/GolixirDB/lib/consistenthash"
 	util "github.com/ygxiaobai111/GolixirDB/lib/logger"
+	"github.com/ygxiaobai111/GolixirDB/lib/slot"
+	"github.com/ygxiaobai111/GolixirDB/lib/sync/atomic"
 	"github.com/ygxiaobai111/GolixirDB/resp/reply"
-
+	"net"
 	"runtime/debug"
 	"strings"
+	"sync"
+	"time"
 )
 
 // ClusterDatabase 代表一个 godis 集群的节点
@@ -23,50 +27,180 @@ import (
 type ClusterDatabase struct {
 	self string // 当前节点的标识
 
+	// mu 保护集群成员信息，节点通过集群总线加入或离开时会修改 nodes、peerConnection 与 states
+	mu             sync.RWMutex
 	nodes          []string                    // 集群中所有节点的列表
-	peerPicker     *consistenthash.NodeMap     // 用于一致性哈希的节点选择器
+	peerPicker     peerPicker                  // 根据 key 选择负责的节点
+	slots          *slotTable                  // 哈希槽的分配，使用一致性哈希路由时为 nil
+	ring           *consistenthash.NodeMap     // 一致性哈希环，使用哈希槽路由时为 nil
 	peerConnection map[string]*pool.ObjectPool // 对等节点的连接池
 	db             databaseface.Database       // 数据库实例
+
+	states       map[string]*nodeState // 通过集群总线得知的各节点状态，包括当前节点
+	currentEpoch uint64                // 集群中见过的最大 configEpoch
+	forgotten    map[string]time.Time  // 通过 CLUSTER FORGET 移除的节点，期间内不会再被加入
+	bus          net.Listener          // 集群总线
+	stopGossip   chan struct{}
+
+	migrating      map[int]string  // 正在迁出的哈希槽 -> 目标节点
+	importing      map[int]string  // 正在导入的哈希槽 -> 源节点
+	jobs           []*migrationJob // 当前节点发起的迁移任务
+	jobSeq         int
+	rebalancing    bool      // 是否正在迁移一致性哈希环上不再由当前节点负责的键
+	rebalanceAgain bool      // 迁移期间一致性哈希环再次改变，结束后需要重新检查
+	ringChangedAt  time.Time // 一致性哈希环最近一次加入节点的时间
+	// tookOver 因 FAIL 从一致性哈希环移除的节点，期间由其他节点接管它的键，
+	// 节点恢复后当前节点交回的键比它本地的旧值新，迁移时覆盖它的值，交回完成后删除
+	tookOver map[string]bool
+	// migrateMu 搬走单个键时持有写锁，访问正在迁出的哈希槽中的键时持有读锁
+	migrateMu sync.RWMutex
+
+	replicaLinks  map[string]*replicaLink // 到各从节点的复制连接，由 linkMu 保护
+	linkMu        sync.RWMutex
+	replOffset    int64                // 复制偏移量，使用 sync/atomic 访问
+	replica       atomic.Boolean       // 当前节点是否为从节点，与 states[self].replicaOf 一致
+	failoverAt    time.Time            // 主节点下线后发起选举的时间
+	electing      bool                 // 是否正在选举
+	lastVoteEpoch uint64               // 最近一次投票的 epoch
+	votedFor      map[string]time.Time // 为下线主节点的从节点投票的时间
+
+	// txMu 保护分布式事务的参与者状态
+	txMu         sync.Mutex
+	txs          map[string]*txState // 尚未结束的事务
+	txHistory    map[string]txRecord // 最近结束的事务的结果
+	keyLocks     map[string]string   // 被事务锁住的键 -> 事务 id
+	keyUsers     map[string]int      // 正在被其他命令访问的键 -> 访问的命令数
+	lockReleased chan struct{}       // 有事务释放锁时关闭并替换
+	txSeq        uint64              // 当前节点作为协调者的事务序号，使用 sync/atomic 访问
+	journal      *txJournal          // 事务日志，没有开启 aof 时为 nil
+	txUndoing    int                 // 正在执行 undo 日志的事务数，期间不能重写事务日志
 }
 
-// MakeClusterDatabase 创建并启动一个集群节点
-func MakeClusterDatabase() *ClusterDatabase {
+// MakeClusterDatabase 创建并启动一个集群节点，无法恢复事务日志时返回错误
+func MakeClusterDatabase() (*ClusterDatabase, error) {
 	cluster := &ClusterDatabase{
-		self: config.Properties.Self,
+		self: config.Properties().Self,
 
-		db:             database.NewStandaloneDatabase(),
-		peerPicker:     consistenthash.NewNodeMap(nil),
 		peerConnection: make(map[string]*pool.ObjectPool),
+		states:         make(map[string]*nodeState),
+		forgotten:      make(map[string]time.Time),
+		migrating:      make(map[int]string),
+		importing:      make(map[int]string),
+		tookOver:       make(map[string]bool),
+		stopGossip:     make(chan struct{}),
+		replicaLinks:   make(map[string]*replicaLink),
+		votedFor:       make(map[string]time.Time),
+		txs:            make(map[string]*txState),
+		txHistory:      make(map[string]txRecord),
+		keyLocks:       make(map[string]string),
+		keyUsers:       make(map[string]int),
+		lockReleased:   make(chan struct{}),
+	}
+	db := database.NewEmbeddedDatabase()
+	db.SetWriteFeed(cluster.feedReplicas)
+	db.SetReplicationInfo(cluster.replicationInfo)
+	cluster.db = db
+	if err := cluster.openJournal(); err != nil {
+		_ = db.Close(false)
+		return nil, fmt.Errorf("open transaction journal failed: %v", err)
 	}
-	nodes := make([]string, 0, len(config.Properties.Peers)+1)
-	for _, peer := range config.Properties.Peers {
+	nodes := make([]string, 0, len(config.Properties().Peers)+1)
+	for _, peer := range config.Properties().Peers {
 		nodes = append(nodes, peer)
 	}
-	nodes = append(nodes, config.Properties.Self)
-	cluster.peerPicker.AddNode(nodes...)
+	nodes = append(nodes, config.Properties().Self)
+	if config.Properties().ClusterRouter == config.RouterConsistentHash {
+		cluster.ring = makeHashRing(nodes)
+		cluster.peerPicker = cluster.ring
+		// 当前节点下线期间其他节点可能接管了它的键，启动后先向其他节点索取本地找不到或可能已过时的键
+		cluster.ringChangedAt = time.Now()
+	} else {
+		// 从节点不负责哈希槽
+		primaries := make([]string, 0, len(nodes))
+		for _, node := range nodes {
+			if _, ok := config.Properties().ClusterReplicas[node]; !ok {
+				primaries = append(primaries, node)
+			}
+		}
+		cluster.slots = makeSlotTable(primaries, config.Properties().ClusterSlots)
+		cluster.peerPicker = cluster.slots
+		counts := cluster.slots.countByNode()
+		assigned := 0
+		for _, node := range primaries {
+			assigned += counts[node]
+			util.LogrusObj.Info(fmt.Sprintf("node %s serves %d hash slots", node, counts[node]))
+		}
+		if assigned < slot.Count {
+			util.LogrusObj.Warn(fmt.Sprintf("%d hash slots are not served by any node", slot.Count-assigned))
+		}
+	}
 	ctx := context.Background()
-	for _, peer := range config.Properties.Peers {
+	for _, peer := range config.Properties().Peers {
 		//对兄弟节点新建连接
 		cluster.peerConnection[peer] = pool.NewObjectPoolWithDefaultConfig(ctx, &connectionFactory{
 			Peer: peer,
 		})
 	}
+	for _, node := range nodes {
+		cluster.states[node] = makeNodeState(node, defaultBusPort(node))
+		cluster.states[node].replicaOf = config.Properties().ClusterReplicas[node]
+	}
+	cluster.states[cluster.self].busPort = selfBusPort()
+	cluster.replica.Set(cluster.states[cluster.self].replicaOf != "")
 	cluster.nodes = nodes
-	return cluster
+	if err := cluster.startBus(); err != nil {
+		util.LogrusObj.Error("cluster bus is disabled: " + err.Error())
+	}
+	return cluster, nil
+}
+
+// makeHashRing 按配置的权重与虚拟节点数创建一致性哈希环
+func makeHashRing(nodes []string) *consistenthash.NodeMap {
+	ring := consistenthash.NewNodeMap(config.Properties().ClusterVirtualNodes, nil)
+	for _, node := range nodes {
+		ring.AddWeightedNode(node, nodeWeight(node))
+	}
+	for _, share := range ring.Distribution() {
+		util.LogrusObj.Info(fmt.Sprintf("node %s weight %d owns %d virtual nodes, %.2f%% of hash space",
+			share.Node, share.Weight, share.Points, share.Share*100))
+	}
+	return ring
 }
 
 // CmdFunc 代表一个命令的处理函数
 type CmdFunc func(cluster *ClusterDatabase, c resp.Connection, cmdAndArgs [][]byte) resp.Reply
 
-// Close 关闭当前的集群节点
-func (cluster *ClusterDatabase) Close() {
-	cluster.db.Close()
+// Close 关闭当前的集群节点，先停止集群总线并关闭到其他节点的连接池，再关闭本地数据库与事务日志
+func (cluster *ClusterDatabase) Close(save bool) error {
+	cluster.stopBus()
+	cluster.stopReplicaLinks()
+	cluster.mu.RLock()
+	defer cluster.mu.RUnlock()
+	for peer, p := range cluster.peerConnection {
+		p.Close(context.Background())
+		util.LogrusObj.Info("closed connection pool to " + peer)
+	}
+	err := cluster.db.Close(save)
+	cluster.txMu.Lock()
+	defer cluster.txMu.Unlock()
+	if cluster.journal != nil {
+		_ = cluster.journal.close()
+		cluster.journal = nil
+	}
+	return err
 }
 
-var router = makeRouter()
+var (
+	router     = makeRouter()
+	peerRouter = makePeerRouter()
+)
 
 // Exec 在集群上执行命令
 func (cluster *ClusterDatabase) Exec(c resp.Connection, cmdLine [][]byte) (result resp.Reply) {
+	start := time.Now()
+	defer func() {
+		database.RecordCommand(c, cmdLine, result, time.Since(start))
+	}()
 	defer func() {
 		if err := recover(); err != nil {
 			util.LogrusObj.Warn(fmt.Sprintf("error occurs: %v\n%s", err, string(debug.Stack())))
@@ -74,11 +208,25 @@ func (cluster *ClusterDatabase) Exec(c resp.Connection, cmdLine [][]byte) (resul
 		}
 	}()
 	cmdName := strings.ToLower(string(cmdLine[0]))
+	if c.InMultiState() && cmdName != "multi" && cmdName != "exec" && cmdName != "discard" {
+		return cluster.enqueue(c, cmdLine)
+	}
 	cmdFunc, ok := router[cmdName]
+	if !ok {
+		// 节点之间的内部命令只有认证为集群节点的连接可以执行
+		cmdFunc, ok = peerRouter[cmdName]
+		if ok && !c.IsPeer() {
+			return peerOnlyReply
+		}
+	}
 	if !ok {
 		return reply.MakeErrReply("ERR unknown command '" + cmdName + "', or not supported in cluster mode")
 	}
 	result = cmdFunc(cluster, c, cmdLine)
+	if cmdName != "asking" {
+		// ASKING 只对下一条命令有效
+		c.SetAsking(false)
+	}
 	return
 }
 
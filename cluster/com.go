@@ -5,20 +5,38 @@ import (
 	"context"
 	"errors"
 	"github.com/ygxiaobai111/GolixirDB/interface/resp"
+	"github.com/ygxiaobai111/GolixirDB/lib/metrics"
 	"github.com/ygxiaobai111/GolixirDB/lib/utils"
 	"github.com/ygxiaobai111/GolixirDB/resp/client"
 	"github.com/ygxiaobai111/GolixirDB/resp/reply"
-
 	"strconv"
+	"sync"
+	"time"
+)
+
+// relayErrors 记录向各节点转发命令失败的次数
+var relayErrors = metrics.NewCounterVec("golixir_cluster_relay_errors_total",
+	"Number of commands that could not be relayed to a peer.", "peer")
+
+// relayTimeout 转发一次命令等待响应的最长时间，广播时所有节点共用同一个截止时间
+const relayTimeout = 3 * time.Second
+
+var (
+	clusterDownReply = reply.MakeErrReply("CLUSTERDOWN Hash slot not served")
+	nodeFailedReply  = reply.MakeErrReply("CLUSTERDOWN The cluster is down")
 )
 
-// getPeerClient 通过地址获取目标节点连接
-func (cluster *ClusterDatabase) getPeerClient(peer string) (*client.Client, error) {
+// getPeerClient 通过地址获取目标节点连接，连接池耗尽时最多等待到 deadline
+func (cluster *ClusterDatabase) getPeerClient(peer string, deadline time.Time) (*client.Client, error) {
+	cluster.mu.RLock()
 	factory, ok := cluster.peerConnection[peer]
+	cluster.mu.RUnlock()
 	if !ok {
 		return nil, errors.New("connection factory not found")
 	}
-	raw, err := factory.BorrowObject(context.Background())
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	raw, err := factory.BorrowObject(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -31,7 +49,9 @@ func (cluster *ClusterDatabase) getPeerClient(peer string) (*client.Client, erro
 
 // returnPeerClient 将用完的连接返回连接池
 func (cluster *ClusterDatabase) returnPeerClient(peer string, peerClient *client.Client) error {
+	cluster.mu.RLock()
 	connectionFactory, ok := cluster.peerConnection[peer]
+	cluster.mu.RUnlock()
 	if !ok {
 		return errors.New("connection factory not found")
 	}
@@ -41,27 +61,81 @@ func (cluster *ClusterDatabase) returnPeerClient(peer string, peerClient *client
 // relay中继命令到peer
 // select db by c.GetDBIndex()
 func (cluster *ClusterDatabase) relay(peer string, c resp.Connection, args [][]byte) resp.Reply {
+	return cluster.relayTo(peer, c, args, false)
+}
+
+// relayTo 中继命令到 peer，asking 为 true 时先发送 ASKING，用于访问 peer 正在导入的哈希槽
+func (cluster *ClusterDatabase) relayTo(peer string, c resp.Connection, args [][]byte, asking bool) resp.Reply {
+	return cluster.relayUntil(peer, c, args, asking, time.Now().Add(relayTimeout))
+}
+
+// relayUntil 与 relayTo 相同，deadline 之前没有收到响应时返回超时错误
+func (cluster *ClusterDatabase) relayUntil(peer string, c resp.Connection, args [][]byte, asking bool, deadline time.Time) resp.Reply {
+	if peer == "" {
+		// 哈希槽没有分配给任何节点
+		return clusterDownReply
+	}
 	if peer == cluster.self {
 		// to self db
 		return cluster.db.Exec(c, args)
 	}
-	peerClient, err := cluster.getPeerClient(peer)
+	if cluster.isFailed(peer) {
+		// 节点已下线，不再等待连接超时
+		return nodeFailedReply
+	}
+	peerClient, err := cluster.getPeerClient(peer, deadline)
 	if err != nil {
+		relayErrors.Inc(peer)
 		return reply.MakeErrReply(err.Error())
 	}
 	defer func() {
 		_ = cluster.returnPeerClient(peer, peerClient)
 	}()
-	peerClient.Send(utils.ToCmdLine("SELECT", strconv.Itoa(c.GetDBIndex())))
-	return peerClient.Send(args)
+	result := sendInDB(peerClient, c.GetDBIndex(), asking, args, time.Until(deadline))
+	if client.IsTransportError(result) {
+		relayErrors.Inc(peer)
+	}
+	return result
+}
+
+// sendInDB 在 dbIndex 中执行命令，连接当前选择的数据库不同时把 SELECT 与命令放在同一批发送，asking 为 true 时先发送 ASKING
+// 各节点的数据库数量相同，SELECT 出错时命令同样无法执行，返回第一个错误
+func sendInDB(peerClient *client.Client, dbIndex int, asking bool, args [][]byte, timeout time.Duration) resp.Reply {
+	cmds := make([][][]byte, 0, 3)
+	if peerClient.DBIndex() != dbIndex {
+		cmds = append(cmds, utils.ToCmdLine("SELECT", strconv.Itoa(dbIndex)))
+	}
+	if asking {
+		cmds = append(cmds, utils.ToCmdLine("ASKING"))
+	}
+	cmds = append(cmds, args)
+	replies := peerClient.Pipeline(cmds, timeout)
+	for _, r := range replies[:len(replies)-1] {
+		if reply.IsErrorReply(r) {
+			return r
+		}
+	}
+	return replies[len(replies)-1]
 }
 
-// broadcast 广播给所有节点 通过map存储每个节点的响应
+// broadcast 并发广播给所有负责数据的节点，其他节点收到后只在本地执行，通过map存储每个节点的响应
+// 所有节点共用 relayTimeout 的截止时间，超时的节点返回超时错误
 func (cluster *ClusterDatabase) broadcast(c resp.Connection, args [][]byte) map[string]resp.Reply {
-	result := make(map[string]resp.Reply)
-	for _, node := range cluster.nodes {
-		reply := cluster.relay(node, c, args)
-		result[node] = reply
+	nodes := cluster.dataNodes()
+	deadline := time.Now().Add(relayTimeout)
+	result := make(map[string]resp.Reply, len(nodes))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, node := range nodes {
+		wg.Add(1)
+		go func(node string) {
+			defer wg.Done()
+			reply := cluster.execOn(node, c, args, deadline)
+			mu.Lock()
+			result[node] = reply
+			mu.Unlock()
+		}(node)
 	}
+	wg.Wait()
 	return result
 }
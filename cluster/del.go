@@ -5,25 +5,11 @@ import (
 	"github.com/ygxiaobai111/GolixirDB/resp/reply"
 )
 
-// Del 向所有节点进行广播来删除 返回的是最终删除个数
+// Del 返回的是最终删除个数，key 位于多个节点时在分布式事务中删除，所有节点要么都删除要么都不删除
+// 重定向模式下所有 key 必须位于同一个哈希槽，由负责的节点删除
 func Del(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
-	replies := cluster.broadcast(c, args)
-	var errReply reply.ErrorReply
-	var deleted int64 = 0
-	for _, v := range replies {
-		if reply.IsErrorReply(v) {
-			errReply = v.(reply.ErrorReply)
-			break
-		}
-		intReply, ok := v.(*reply.IntReply)
-		if !ok {
-			errReply = reply.MakeErrReply("error")
-		}
-		deleted += intReply.Code
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("del")
 	}
-
-	if errReply == nil {
-		return reply.MakeIntReply(deleted)
-	}
-	return reply.MakeErrReply("error occurs: " + errReply.Error())
+	return cluster.execMultiKey(c, args)
 }
@@ -0,0 +1,186 @@
+package cluster
+
+import (
+	"fmt"
+	util "github.com/ygxiaobai111/GolixirDB/lib/logger"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+故障转移
+从节点发现主节点被标记为 FAIL 后，等待一段时间（复制偏移量越大的从节点等待越短）发起选举：
+增加 currentEpoch 后向所有负责哈希槽的主节点发送 auth-request，每个主节点在每个 epoch 内只投一票，
+并且在 2 倍 cluster-node-timeout 内只为同一个下线主节点的从节点投一票
+获得超过半数主节点投票的从节点以选举的 epoch 作为 configEpoch 接管下线主节点的哈希槽并成为主节点，
+其他节点通过心跳收到更大 configEpoch 的声明后更新路由，原主节点的其他从节点改为复制新的主节点，
+原主节点恢复后发现哈希槽都已被接管，也会成为新主节点的从节点
+*/
+
+const (
+	busAuthRequest = "auth-request"
+	busAuthAck     = "auth-ack"
+
+	failoverDelay = 500 * time.Millisecond // 主节点下线后发起选举前的固定等待时间
+)
+
+// replicaRankLocked 返回当前节点在同一主节点的从节点中按复制偏移量的排名，0 表示最新，调用方需持有 mu
+func (cluster *ClusterDatabase) replicaRankLocked(primary string) int {
+	myOffset := atomic.LoadInt64(&cluster.replOffset)
+	rank := 0
+	for addr, state := range cluster.states {
+		if addr == cluster.self || state.replicaOf != primary || state.fail {
+			continue
+		}
+		if state.replOffset > myOffset || (state.replOffset == myOffset && addr < cluster.self) {
+			rank++
+		}
+	}
+	return rank
+}
+
+// failoverDueLocked 返回是否应该发起选举，调用方需持有 mu 的写锁
+func (cluster *ClusterDatabase) failoverDueLocked(now time.Time) bool {
+	primary := cluster.states[cluster.self].replicaOf
+	if primary == "" || cluster.electing {
+		return false
+	}
+	state, ok := cluster.states[primary]
+	if !ok || !state.fail {
+		cluster.failoverAt = time.Time{}
+		return false
+	}
+	if cluster.failoverAt.IsZero() {
+		rank := cluster.replicaRankLocked(primary)
+		delay := failoverDelay + time.Duration(rand.Intn(500))*time.Millisecond + time.Duration(rank)*time.Second
+		cluster.failoverAt = now.Add(delay)
+		util.LogrusObj.Warn(fmt.Sprintf("primary %s failed, starting failover election in %v (rank #%d)", primary, delay, rank))
+		return false
+	}
+	if now.Before(cluster.failoverAt) {
+		return false
+	}
+	cluster.electing = true
+	return true
+}
+
+// runElection 向负责哈希槽的主节点请求投票，获得多数票后接管主节点的哈希槽
+func (cluster *ClusterDatabase) runElection() {
+	cluster.mu.Lock()
+	primary := cluster.states[cluster.self].replicaOf
+	cluster.currentEpoch++
+	epoch := cluster.currentEpoch
+	msg := cluster.makeBusMessageLocked(busAuthRequest)
+	msg.Target = primary
+	counts := cluster.slots.countByNode()
+	total := 0
+	var voters []string
+	for addr, state := range cluster.states {
+		if state.replicaOf != "" || counts[addr] == 0 {
+			continue
+		}
+		total++
+		if !state.fail && addr != cluster.self {
+			voters = append(voters, state.busAddr())
+		}
+	}
+	cluster.mu.Unlock()
+	quorum := total/2 + 1
+	util.LogrusObj.Info(fmt.Sprintf("starting failover election for epoch %d, %d votes needed", epoch, quorum))
+
+	var granted int32
+	var wg sync.WaitGroup
+	for _, busAddr := range voters {
+		wg.Add(1)
+		go func(busAddr string) {
+			defer wg.Done()
+			pong, err := sendBusMessage(busAddr, msg)
+			if err != nil {
+				return
+			}
+			if pong.Type == busAuthAck {
+				atomic.AddInt32(&granted, 1)
+			}
+			cluster.handleBusMessage(pong)
+		}(busAddr)
+	}
+	wg.Wait()
+
+	cluster.mu.Lock()
+	cluster.electing = false
+	state, ok := cluster.states[primary]
+	won := int(granted) >= quorum && ok && state.fail && cluster.states[cluster.self].replicaOf == primary
+	if won {
+		cluster.promoteLocked(primary, epoch)
+	} else {
+		cluster.failoverAt = time.Now().Add(2 * nodeTimeout())
+		util.LogrusObj.Warn(fmt.Sprintf("failover election for epoch %d lost with %d/%d votes, retrying later", epoch, granted, quorum))
+	}
+	ping := cluster.makeBusMessageLocked(busPing)
+	cluster.mu.Unlock()
+	if won {
+		cluster.broadcastBus(ping)
+	}
+}
+
+// promoteLocked 以 epoch 作为 configEpoch 接管 primary 的哈希槽并成为主节点，调用方需持有 mu 的写锁
+func (cluster *ClusterDatabase) promoteLocked(primary string, epoch uint64) {
+	ranges := cluster.slots.slotsOf(primary)
+	cluster.states[cluster.self].configEpoch = epoch
+	cluster.setReplicaOfLocked("")
+	for _, r := range ranges {
+		cluster.slots.assign(cluster.self, r.Start, r.End)
+	}
+	util.LogrusObj.Warn(fmt.Sprintf("failover: took over slots %s of failed primary %s with config epoch %d",
+		formatRanges(ranges), primary, epoch))
+}
+
+// grantVote 处理从节点的 auth-request，返回是否投票给它
+func (cluster *ClusterDatabase) grantVote(msg *busMessage) bool {
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	if cluster.slots == nil || cluster.states[cluster.self].replicaOf != "" || len(cluster.slots.slotsOf(cluster.self)) == 0 {
+		// 只有负责哈希槽的主节点可以投票
+		return false
+	}
+	if msg.CurrentEpoch < cluster.currentEpoch || msg.CurrentEpoch <= cluster.lastVoteEpoch {
+		return false
+	}
+	requester, ok := cluster.states[msg.Sender]
+	if !ok || msg.Target == "" || requester.replicaOf != msg.Target {
+		return false
+	}
+	primary, ok := cluster.states[msg.Target]
+	if !ok || !primary.fail {
+		return false
+	}
+	now := time.Now()
+	if votedAt, ok := cluster.votedFor[msg.Target]; ok && now.Sub(votedAt) < 2*nodeTimeout() {
+		return false
+	}
+	cluster.lastVoteEpoch = msg.CurrentEpoch
+	cluster.votedFor[msg.Target] = now
+	util.LogrusObj.Info(fmt.Sprintf("failover auth granted to %s for epoch %d", msg.Sender, msg.CurrentEpoch))
+	return true
+}
+
+// followLoserLocked 节点 loser 的哈希槽被 winner 以更大的 configEpoch 接管后调用，调用方需持有 mu 的写锁
+// loser 失去所有哈希槽时，它自己以及它的从节点都改为复制 winner
+func (cluster *ClusterDatabase) followLoserLocked(loser, winner string) {
+	if len(cluster.slots.slotsOf(loser)) > 0 {
+		return
+	}
+	self := cluster.states[cluster.self]
+	if state, ok := cluster.states[winner]; !ok || state.replicaOf != "" {
+		return
+	}
+	if loser == cluster.self && self.replicaOf == "" {
+		util.LogrusObj.Warn("lost all hash slots to " + winner)
+		cluster.setReplicaOfLocked(winner)
+	} else if self.replicaOf == loser {
+		util.LogrusObj.Warn(fmt.Sprintf("primary %s was replaced by %s", loser, winner))
+		cluster.setReplicaOfLocked(winner)
+	}
+}
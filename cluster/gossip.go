@@ -0,0 +1,349 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ygxiaobai111/GolixirDB/config"
+	util "github.com/ygxiaobai111/GolixirDB/lib/logger"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+/*
+集群总线
+节点之间通过集群总线端口（默认数据端口 + 10000）交换 JSON 编码的消息，每个连接发送一条消息并收到一条 PONG：
+ping    每秒向每个节点发送一次心跳
+meet    CLUSTER MEET 发给新节点，即使对方刚被 FORGET 也会接受
+auth-request 从节点发起故障转移选举时发给所有主节点，同意的主节点回复 auth-ack
+import  CLUSTER RESHARD 开始前发给目标节点，目标节点开始接受带有 ASKING 的命令
+migrated 哈希槽中的键搬完后发给目标节点，目标节点增加 configEpoch 后声明这些哈希槽
+fail    多数节点认为某节点下线后广播，收到的节点立即将其标记为 FAIL
+forget  CLUSTER FORGET 后广播，收到的节点同样移除该节点
+每条消息都带有发送者负责的哈希槽与 configEpoch，以及发送者所知的其他节点及其状态，
+收到消息的节点据此加入未知的节点、更新哈希槽分配并记录其他节点的 PFAIL 报告
+节点超过 cluster-node-timeout 未回复 PING 时被标记为 PFAIL，包括当前节点在内超过半数节点报告 PFAIL 时标记为 FAIL
+*/
+
+const (
+	busPing     = "ping"
+	busPong     = "pong"
+	busMeet     = "meet"
+	busFail     = "fail"
+	busForget   = "forget"
+	busImport   = "import"
+	busMigrated = "migrated"
+
+	gossipInterval = time.Second // 发送心跳的间隔
+	busTimeout     = time.Second // 集群总线上一次请求的超时时间
+)
+
+// busMessage 集群总线上的消息
+type busMessage struct {
+	Type         string             `json:"type"`
+	Sender       string             `json:"sender"`
+	BusPort      int                `json:"busPort"`
+	CurrentEpoch uint64             `json:"currentEpoch"`
+	ConfigEpoch  uint64             `json:"configEpoch"`
+	Slots        []config.SlotRange `json:"slots,omitempty"`
+	Gossip       []gossipEntry      `json:"gossip,omitempty"`
+	Target       string             `json:"target,omitempty"`       // fail 与 forget 的目标节点
+	MigrateSlots []config.SlotRange `json:"migrateSlots,omitempty"` // import 与 migrated 的哈希槽
+	Migrating    bool               `json:"migrating,omitempty"`    // 发送者正在迁移一致性哈希环上的键
+	ReplicaOf    string             `json:"replicaOf,omitempty"`    // 发送者是从节点时为其主节点
+	ReplOffset   int64              `json:"replOffset,omitempty"`   // 发送者的复制偏移量
+}
+
+// gossipEntry 发送者所知的其他节点
+type gossipEntry struct {
+	Addr    string `json:"addr"`
+	BusPort int    `json:"busPort"`
+	Flags   string `json:"flags,omitempty"` // fail? 或 fail
+}
+
+// startBus 监听集群总线端口并开始定期发送心跳
+func (cluster *ClusterDatabase) startBus() error {
+	addr := net.JoinHostPort(config.Properties().Bind, strconv.Itoa(selfBusPort()))
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	cluster.bus = listener
+	util.LogrusObj.Info("cluster bus listening on " + addr)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go cluster.handleBusConn(conn)
+		}
+	}()
+	go func() {
+		ticker := time.NewTicker(gossipInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				cluster.gossipCron()
+			case <-cluster.stopGossip:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// stopBus 停止心跳并关闭集群总线
+func (cluster *ClusterDatabase) stopBus() {
+	if cluster.bus == nil {
+		return
+	}
+	close(cluster.stopGossip)
+	_ = cluster.bus.Close()
+}
+
+// handleBusConn 处理其他节点发来的一条消息
+func (cluster *ClusterDatabase) handleBusConn(conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(busTimeout))
+	msg := &busMessage{}
+	if err := json.NewDecoder(conn).Decode(msg); err != nil {
+		return
+	}
+	cluster.handleBusMessage(msg)
+	pong := cluster.makeBusMessage(busPong)
+	if msg.Type == busAuthRequest && cluster.grantVote(msg) {
+		pong.Type = busAuthAck
+	}
+	_ = json.NewEncoder(conn).Encode(pong)
+}
+
+// sendBusMessage 向集群总线地址发送消息并返回对方的 PONG
+func sendBusMessage(busAddr string, msg *busMessage) (*busMessage, error) {
+	conn, err := net.DialTimeout("tcp", busAddr, busTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(busTimeout))
+	if err := json.NewEncoder(conn).Encode(msg); err != nil {
+		return nil, err
+	}
+	pong := &busMessage{}
+	if err := json.NewDecoder(conn).Decode(pong); err != nil {
+		return nil, err
+	}
+	return pong, nil
+}
+
+// makeBusMessage 生成带有当前节点状态的消息
+func (cluster *ClusterDatabase) makeBusMessage(typ string) *busMessage {
+	cluster.mu.RLock()
+	defer cluster.mu.RUnlock()
+	return cluster.makeBusMessageLocked(typ)
+}
+
+func (cluster *ClusterDatabase) makeBusMessageLocked(typ string) *busMessage {
+	self := cluster.states[cluster.self]
+	msg := &busMessage{
+		Type:         typ,
+		Sender:       cluster.self,
+		BusPort:      self.busPort,
+		CurrentEpoch: cluster.currentEpoch,
+		ConfigEpoch:  self.configEpoch,
+		Migrating:    cluster.rebalancing,
+		ReplicaOf:    self.replicaOf,
+		ReplOffset:   atomic.LoadInt64(&cluster.replOffset),
+	}
+	if cluster.slots != nil {
+		msg.Slots = cluster.slots.slotsOf(cluster.self)
+	}
+	for addr, state := range cluster.states {
+		if addr == cluster.self {
+			continue
+		}
+		msg.Gossip = append(msg.Gossip, gossipEntry{Addr: addr, BusPort: state.busPort, Flags: state.flags()})
+	}
+	return msg
+}
+
+// isForgottenLocked 返回节点是否刚被 FORGET，调用方需持有 mu
+func (cluster *ClusterDatabase) isForgottenLocked(node string) bool {
+	forgetAt, ok := cluster.forgotten[node]
+	return ok && time.Since(forgetAt) < forgetBlacklistTime
+}
+
+// handleBusMessage 根据其他节点发来的消息或回复的 PONG 更新集群状态
+func (cluster *ClusterDatabase) handleBusMessage(msg *busMessage) {
+	if msg.Sender == "" || msg.Sender == cluster.self {
+		return
+	}
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	state, ok := cluster.states[msg.Sender]
+	if !ok {
+		if msg.Type != busMeet && cluster.isForgottenLocked(msg.Sender) {
+			return
+		}
+		delete(cluster.forgotten, msg.Sender)
+		state = cluster.addNodeLocked(msg.Sender, msg.BusPort)
+	}
+	state.busPort = msg.BusPort
+	state.configEpoch = msg.ConfigEpoch
+	state.migrating = msg.Migrating
+	state.replicaOf = msg.ReplicaOf
+	state.replOffset = msg.ReplOffset
+	cluster.markReachableLocked(state)
+	if msg.CurrentEpoch > cluster.currentEpoch {
+		cluster.currentEpoch = msg.CurrentEpoch
+	}
+
+	now := time.Now()
+	for _, entry := range msg.Gossip {
+		if entry.Addr == cluster.self {
+			continue
+		}
+		node, ok := cluster.states[entry.Addr]
+		if !ok {
+			if cluster.isForgottenLocked(entry.Addr) {
+				continue
+			}
+			node = cluster.addNodeLocked(entry.Addr, entry.BusPort)
+		}
+		if entry.Flags != "" {
+			node.failReports[msg.Sender] = now
+		} else {
+			delete(node.failReports, msg.Sender)
+		}
+	}
+
+	switch msg.Type {
+	case busFail:
+		if target, ok := cluster.states[msg.Target]; ok && msg.Target != cluster.self {
+			cluster.markFailLocked(target)
+		}
+	case busForget:
+		if msg.Target != cluster.self {
+			cluster.forgetLocked(msg.Target)
+		}
+	case busImport, busMigrated:
+		cluster.handleMigrationLocked(msg)
+	}
+
+	if cluster.slots != nil && len(msg.Slots) > 0 {
+		changed, losers := cluster.slots.claim(msg.Sender, msg.Slots, msg.ConfigEpoch, cluster.epochOf)
+		if changed {
+			util.LogrusObj.Info(fmt.Sprintf("hash slots of node %s updated to config epoch %d", msg.Sender, msg.ConfigEpoch))
+		}
+		for _, loser := range losers {
+			cluster.followLoserLocked(loser, msg.Sender)
+		}
+	}
+}
+
+// gossipCron 向每个节点发送心跳，并检查超时的节点
+func (cluster *ClusterDatabase) gossipCron() {
+	now := time.Now()
+	timeout := nodeTimeout()
+	var targets []string
+	var failed []string
+	cluster.mu.Lock()
+	for node, forgetAt := range cluster.forgotten {
+		if now.Sub(forgetAt) >= forgetBlacklistTime {
+			delete(cluster.forgotten, node)
+		}
+	}
+	quorum := len(cluster.states)/2 + 1
+	for addr, state := range cluster.states {
+		if addr == cluster.self {
+			continue
+		}
+		if !state.pinging {
+			state.pinging = true
+			if state.pingSent.IsZero() {
+				state.pingSent = now
+			}
+			targets = append(targets, addr)
+		}
+		if !state.pfail && !state.pingSent.IsZero() && now.Sub(state.pingSent) > timeout {
+			state.pfail = true
+			util.LogrusObj.Warn(fmt.Sprintf("node %s is marked as PFAIL", addr))
+		}
+		if !state.pfail || state.fail {
+			continue
+		}
+		// 当前节点也算作一票
+		votes := 1
+		for reporter, reportAt := range state.failReports {
+			if _, ok := cluster.states[reporter]; !ok || now.Sub(reportAt) > 2*timeout {
+				delete(state.failReports, reporter)
+				continue
+			}
+			votes++
+		}
+		if votes >= quorum {
+			cluster.markFailLocked(state)
+			failed = append(failed, addr)
+		}
+	}
+	ping := cluster.makeBusMessageLocked(busPing)
+	elect := cluster.slots != nil && cluster.failoverDueLocked(now)
+	cluster.mu.Unlock()
+
+	if elect {
+		go cluster.runElection()
+	}
+	cluster.syncReplicaLinks()
+	cluster.expireTransactions()
+
+	for _, addr := range targets {
+		go cluster.ping(addr, ping)
+	}
+	for _, addr := range failed {
+		msg := cluster.makeBusMessage(busFail)
+		msg.Target = addr
+		cluster.broadcastBus(msg)
+	}
+}
+
+// ping 向节点发送心跳并处理回复
+func (cluster *ClusterDatabase) ping(node string, msg *busMessage) {
+	cluster.mu.RLock()
+	state, ok := cluster.states[node]
+	var busAddr string
+	if ok {
+		busAddr = state.busAddr()
+	}
+	cluster.mu.RUnlock()
+	if !ok {
+		return
+	}
+	pong, err := sendBusMessage(busAddr, msg)
+	if err == nil {
+		cluster.handleBusMessage(pong)
+	}
+	cluster.mu.Lock()
+	state.pinging = false
+	cluster.mu.Unlock()
+}
+
+// broadcastBus 将消息异步发送给除当前节点外的所有节点
+func (cluster *ClusterDatabase) broadcastBus(msg *busMessage) {
+	cluster.mu.RLock()
+	busAddrs := make([]string, 0, len(cluster.states))
+	for addr, state := range cluster.states {
+		if addr != cluster.self {
+			busAddrs = append(busAddrs, state.busAddr())
+		}
+	}
+	cluster.mu.RUnlock()
+	for _, busAddr := range busAddrs {
+		go func(busAddr string) {
+			if pong, err := sendBusMessage(busAddr, msg); err == nil {
+				cluster.handleBusMessage(pong)
+			}
+		}(busAddr)
+	}
+}
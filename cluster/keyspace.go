@@ -0,0 +1,297 @@
+package cluster
+
+import (
+	"github.com/ygxiaobai111/GolixirDB/database"
+	"github.com/ygxiaobai111/GolixirDB/interface/resp"
+	"github.com/ygxiaobai111/GolixirDB/lib/slot"
+	"github.com/ygxiaobai111/GolixirDB/resp/reply"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+键空间命令
+转发模式下 KEYS、SCAN、DBSIZE、RANDOMKEY 汇总所有负责数据的节点（主节点，一致性哈希路由下为所有节点）的结果，
+重定向模式下与 redis cluster 相同只访问当前节点，由客户端分别访问每个主节点
+FLUSHDB、FLUSHALL 广播给所有负责数据的节点，从节点通过复制清空
+多 key 的 EXISTS 按哈希槽拆分后分别交给负责的节点，不同哈希槽之间不保证原子性；重定向模式下 key 必须位于同一个哈希槽，否则返回 CROSSSLOT
+节点之间通过内部命令 local_ 转发只在本地执行的命令，收到的节点不会再次广播
+*/
+
+// relayLocal 节点之间转发只在本地执行的命令使用的内部命令：local_ command [arg ...]
+const relayLocal = "local_"
+
+// localCommands 可以通过 local_ 在其他节点本地执行的命令
+var localCommands = map[string]bool{
+	"keys":      true,
+	"scan":      true,
+	"dbsize":    true,
+	"randomkey": true,
+	"flushdb":   true,
+	"flushall":  true,
+}
+
+// onRelayedLocal 处理其他节点转发的 local_ 命令
+func onRelayedLocal(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply(relayLocal)
+	}
+	cmdName := strings.ToLower(string(args[1]))
+	if !localCommands[cmdName] {
+		return reply.MakeErrReply("ERR command '" + cmdName + "' can not be relayed with " + relayLocal)
+	}
+	return cluster.db.Exec(c, args[1:])
+}
+
+// execOn 让 node 只在本地执行命令，deadline 之前没有收到响应时返回超时错误
+func (cluster *ClusterDatabase) execOn(node string, c resp.Connection, args [][]byte, deadline time.Time) resp.Reply {
+	if node == cluster.self {
+		return cluster.db.Exec(c, args)
+	}
+	localArgs := make([][]byte, 0, len(args)+1)
+	localArgs = append(localArgs, []byte(relayLocal))
+	localArgs = append(localArgs, args...)
+	return cluster.relayUntil(node, c, localArgs, false, deadline)
+}
+
+// dataNodes 返回按地址排序的负责数据的节点，即除从节点以外的所有节点
+func (cluster *ClusterDatabase) dataNodes() []string {
+	cluster.mu.RLock()
+	defer cluster.mu.RUnlock()
+	nodes := make([]string, 0, len(cluster.nodes))
+	for _, node := range cluster.nodes {
+		if state, ok := cluster.states[node]; ok && state.replicaOf != "" {
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// firstError 返回 replies 中的第一个错误，没有错误时返回 nil
+func firstError(replies map[string]resp.Reply) reply.ErrorReply {
+	for _, r := range replies {
+		if errReply, ok := r.(reply.ErrorReply); ok {
+			return errReply
+		}
+	}
+	return nil
+}
+
+// bulkArgs 返回数组回复中的所有元素，回复不是批量字符串数组时返回 false
+func bulkArgs(r resp.Reply) ([][]byte, bool) {
+	switch r := r.(type) {
+	case *reply.MultiBulkReply:
+		return r.Args, true
+	case *reply.EmptyMultiBulkReply:
+		return nil, true
+	}
+	return nil, false
+}
+
+// keys KEYS pattern，汇总所有节点上匹配的键
+func keys(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply("keys")
+	}
+	if cluster.redirecting() {
+		return cluster.db.Exec(c, args)
+	}
+	replies := cluster.broadcast(c, args)
+	if errReply := firstError(replies); errReply != nil {
+		return errReply
+	}
+	result := make([][]byte, 0)
+	for node, r := range replies {
+		items, ok := bulkArgs(r)
+		if !ok {
+			return reply.MakeErrReply("ERR unexpected reply of KEYS from " + node)
+		}
+		result = append(result, items...)
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// dbSize DBSIZE，返回所有节点的键数量之和
+func dbSize(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 1 {
+		return reply.MakeArgNumErrReply("dbsize")
+	}
+	if cluster.redirecting() {
+		return cluster.db.Exec(c, args)
+	}
+	replies := cluster.broadcast(c, args)
+	if errReply := firstError(replies); errReply != nil {
+		return errReply
+	}
+	var size int64
+	for _, r := range replies {
+		if intReply, ok := r.(*reply.IntReply); ok {
+			size += intReply.Code
+		}
+	}
+	return reply.MakeIntReply(size)
+}
+
+// randomKey RANDOMKEY，按随机顺序访问各节点，返回第一个非空节点上的随机键
+func randomKey(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 1 {
+		return reply.MakeArgNumErrReply("randomkey")
+	}
+	if cluster.redirecting() {
+		return cluster.db.Exec(c, args)
+	}
+	nodes := cluster.dataNodes()
+	rand.Shuffle(len(nodes), func(i, j int) {
+		nodes[i], nodes[j] = nodes[j], nodes[i]
+	})
+	deadline := time.Now().Add(relayTimeout)
+	for _, node := range nodes {
+		r := cluster.execOn(node, c, args, deadline)
+		if reply.IsErrorReply(r) {
+			return r
+		}
+		if bulk, ok := r.(*reply.BulkReply); ok && bulk.Arg != nil {
+			return bulk
+		}
+	}
+	return reply.MakeNullBulkReply()
+}
+
+// scan SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]，依次扫描每个节点
+// 游标为 节点序号 * database.ScanBuckets + 节点内的游标，扫描期间节点增减时可能重复或遗漏键
+func scan(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("scan")
+	}
+	if cluster.redirecting() {
+		return cluster.db.Exec(c, args)
+	}
+	cursor, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR invalid cursor")
+	}
+	nodes := cluster.dataNodes()
+	index := cursor / database.ScanBuckets
+	if index >= uint64(len(nodes)) {
+		return reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte("0")),
+			&reply.EmptyMultiBulkReply{},
+		})
+	}
+	nodeArgs := make([][]byte, len(args))
+	copy(nodeArgs, args)
+	nodeArgs[1] = []byte(strconv.FormatUint(cursor%database.ScanBuckets, 10))
+	r := cluster.execOn(nodes[index], c, nodeArgs, time.Now().Add(relayTimeout))
+	if reply.IsErrorReply(r) {
+		return r
+	}
+	multi, ok := r.(*reply.MultiRawReply)
+	if !ok || len(multi.Replies) != 2 {
+		return reply.MakeErrReply("ERR unexpected reply of SCAN from " + nodes[index])
+	}
+	next, ok := multi.Replies[0].(*reply.BulkReply)
+	if !ok {
+		return reply.MakeErrReply("ERR unexpected reply of SCAN from " + nodes[index])
+	}
+	nodeCursor, err := strconv.ParseUint(string(next.Arg), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR unexpected reply of SCAN from " + nodes[index])
+	}
+	if nodeCursor == 0 {
+		// 当前节点扫描结束，下一次从下一个节点开始
+		index++
+		if index >= uint64(len(nodes)) {
+			index = 0
+		}
+	}
+	cursor = index*database.ScanBuckets + nodeCursor
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte(strconv.FormatUint(cursor, 10))),
+		multi.Replies[1],
+	})
+}
+
+// flush FLUSHDB 与 FLUSHALL，广播给所有负责数据的节点
+func flush(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	replies := cluster.broadcast(c, args)
+	if errReply := firstError(replies); errReply != nil {
+		return reply.MakeErrReply("error occurs: " + errReply.Error())
+	}
+	return &reply.OkReply{}
+}
+
+// exists EXISTS key [key ...]，返回存在的键的数量
+func exists(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	return cluster.sumByKeys(c, args)
+}
+
+// sumByKeys 将参数全部为 key 的命令按哈希槽拆分后并发交给负责的节点执行，返回各节点整数回复之和
+// 一致性哈希路由下按负责的节点拆分，重定向模式下所有 key 必须位于同一个哈希槽
+func (cluster *ClusterDatabase) sumByKeys(c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply(strings.ToLower(string(args[0])))
+	}
+	if cluster.redirecting() && !sameSlot(args[1:]) {
+		return crossSlotReply
+	}
+	groups := cluster.groupKeys(args[1:])
+	if len(groups) == 1 {
+		return cluster.relayByKeys(groups[0], c, args)
+	}
+	replies := make([]resp.Reply, len(groups))
+	var wg sync.WaitGroup
+	for i, group := range groups {
+		groupArgs := make([][]byte, 0, len(group)+1)
+		groupArgs = append(groupArgs, args[0])
+		for _, key := range group {
+			groupArgs = append(groupArgs, []byte(key))
+		}
+		wg.Add(1)
+		go func(i int, group []string) {
+			defer wg.Done()
+			replies[i] = cluster.relayByKeys(group, c, groupArgs)
+		}(i, group)
+	}
+	wg.Wait()
+	var sum int64
+	for _, r := range replies {
+		if reply.IsErrorReply(r) {
+			return r
+		}
+		intReply, ok := r.(*reply.IntReply)
+		if !ok {
+			return reply.MakeErrReply("ERR unexpected reply of " + strings.ToUpper(string(args[0])))
+		}
+		sum += intReply.Code
+	}
+	return reply.MakeIntReply(sum)
+}
+
+// groupKeys 将 keys 按哈希槽分组，一致性哈希路由下按负责的节点分组，分组按首次出现的顺序排列
+func (cluster *ClusterDatabase) groupKeys(keys [][]byte) [][]string {
+	var groups [][]string
+	indexOf := make(map[string]int)
+	for _, raw := range keys {
+		key := string(raw)
+		var group string
+		if cluster.slots != nil {
+			group = strconv.Itoa(slot.KeySlot(key))
+		} else {
+			group = cluster.peerPicker.PickNode(key)
+		}
+		i, ok := indexOf[group]
+		if !ok {
+			i = len(groups)
+			indexOf[group] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], key)
+	}
+	return groups
+}
@@ -0,0 +1,8 @@
+package cluster
+
+import "github.com/ygxiaobai111/GolixirDB/interface/resp"
+
+// execLocal 只与当前连接或当前节点相关的命令在本地执行即可
+func execLocal(cluster *ClusterDatabase, c resp.Connection, cmdAndArgs [][]byte) resp.Reply {
+	return cluster.db.Exec(c, cmdAndArgs)
+}
@@ -0,0 +1,198 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	pool "github.com/jolestar/go-commons-pool/v2"
+	"github.com/ygxiaobai111/GolixirDB/config"
+	util "github.com/ygxiaobai111/GolixirDB/lib/logger"
+	"net"
+	"strconv"
+	"time"
+)
+
+/*
+集群成员
+节点以数据端口的地址作为名称，启动时的成员来自配置 self 与 peers，运行时通过 CLUSTER MEET/FORGET 以及集群总线上的消息增删
+节点加入时创建连接池并加入一致性哈希环；节点被标记为 FAIL 时从一致性哈希环中移除并清空连接池，恢复后重新加入
+节点加入一致性哈希环后，每个节点把不再由自己负责的键迁移到新的负责节点，
+从 FAIL 恢复的节点上的同名键是下线前的旧值，接管期间负责该键的节点交回时覆盖它
+*/
+
+// forgetBlacklistTime 被 CLUSTER FORGET 移除的节点在这段时间内不会因为其他节点的消息而重新加入
+const forgetBlacklistTime = time.Minute
+
+// nodeState 当前节点所知的某个节点的状态
+type nodeState struct {
+	addr        string
+	busPort     int                  // 集群总线端口
+	configEpoch uint64               // 节点声明的哈希槽的版本，版本大的声明覆盖版本小的
+	pingSent    time.Time            // 最早一次尚未收到回复的 PING 的发送时间
+	pongRecv    time.Time            // 最近一次收到该节点消息的时间
+	pinging     bool                 // 是否有正在等待回复的 PING
+	pfail       bool                 // 当前节点认为该节点可能下线
+	fail        bool                 // 多数节点认为该节点已下线
+	migrating   bool                 // 节点正在迁移一致性哈希环上的键
+	replicaOf   string               // 从节点复制的主节点，主节点为空
+	replOffset  int64                // 节点最近声明的复制偏移量
+	failReports map[string]time.Time // 其他节点报告该节点 PFAIL 的时间
+}
+
+func makeNodeState(addr string, busPort int) *nodeState {
+	return &nodeState{
+		addr:        addr,
+		busPort:     busPort,
+		failReports: make(map[string]time.Time),
+	}
+}
+
+// busAddr 返回节点的集群总线地址
+func (s *nodeState) busAddr() string {
+	host, _ := splitAddr(s.addr)
+	return net.JoinHostPort(host, strconv.Itoa(s.busPort))
+}
+
+// flags 返回 CLUSTER NODES 中的节点状态
+func (s *nodeState) flags() string {
+	if s.fail {
+		return "fail"
+	}
+	if s.pfail {
+		return "fail?"
+	}
+	return ""
+}
+
+// defaultBusPort 返回节点默认的集群总线端口，即数据端口 + 10000
+func defaultBusPort(node string) int {
+	_, port := splitAddr(node)
+	return port + 10000
+}
+
+// selfBusPort 返回当前节点的集群总线端口
+func selfBusPort() int {
+	if config.Properties().ClusterPort > 0 {
+		return config.Properties().ClusterPort
+	}
+	return config.Properties().Port + 10000
+}
+
+// nodeTimeout 返回节点超时时间
+func nodeTimeout() time.Duration {
+	return time.Duration(config.Properties().ClusterNodeTimeout) * time.Millisecond
+}
+
+// nodeList 返回当前所有节点的快照
+func (cluster *ClusterDatabase) nodeList() []string {
+	cluster.mu.RLock()
+	defer cluster.mu.RUnlock()
+	nodes := make([]string, len(cluster.nodes))
+	copy(nodes, cluster.nodes)
+	return nodes
+}
+
+// isFailed 返回节点是否被标记为 FAIL
+func (cluster *ClusterDatabase) isFailed(node string) bool {
+	cluster.mu.RLock()
+	defer cluster.mu.RUnlock()
+	state, ok := cluster.states[node]
+	return ok && state.fail
+}
+
+// epochOf 返回节点的 configEpoch，调用方需持有 mu
+func (cluster *ClusterDatabase) epochOf(node string) uint64 {
+	if state, ok := cluster.states[node]; ok {
+		return state.configEpoch
+	}
+	return 0
+}
+
+// addNodeLocked 将新节点加入集群，调用方需持有 mu 的写锁
+func (cluster *ClusterDatabase) addNodeLocked(node string, busPort int) *nodeState {
+	state := makeNodeState(node, busPort)
+	cluster.states[node] = state
+	cluster.nodes = append(cluster.nodes, node)
+	cluster.peerConnection[node] = pool.NewObjectPoolWithDefaultConfig(context.Background(), &connectionFactory{
+		Peer: node,
+	})
+	if cluster.ring != nil {
+		cluster.ring.AddWeightedNode(node, nodeWeight(node))
+		cluster.scheduleRebalanceLocked()
+	}
+	util.LogrusObj.Info(fmt.Sprintf("node %s joined the cluster", node))
+	return state
+}
+
+// forgetLocked 将节点移出集群，调用方需持有 mu 的写锁
+func (cluster *ClusterDatabase) forgetLocked(node string) {
+	if _, ok := cluster.states[node]; !ok || node == cluster.self {
+		return
+	}
+	delete(cluster.states, node)
+	for i, n := range cluster.nodes {
+		if n == node {
+			cluster.nodes = append(cluster.nodes[:i:i], cluster.nodes[i+1:]...)
+			break
+		}
+	}
+	if p, ok := cluster.peerConnection[node]; ok {
+		delete(cluster.peerConnection, node)
+		go p.Close(context.Background())
+	}
+	if cluster.ring != nil {
+		cluster.ring.RemoveNode(node)
+	}
+	delete(cluster.tookOver, node)
+	if cluster.slots != nil {
+		cluster.slots.unassign(node)
+	}
+	for _, state := range cluster.states {
+		delete(state.failReports, node)
+	}
+	cluster.forgotten[node] = time.Now()
+	util.LogrusObj.Info(fmt.Sprintf("node %s left the cluster", node))
+}
+
+// markFailLocked 将节点标记为 FAIL，调用方需持有 mu 的写锁
+func (cluster *ClusterDatabase) markFailLocked(state *nodeState) {
+	if state.fail {
+		return
+	}
+	state.fail = true
+	state.pfail = true
+	if cluster.ring != nil {
+		cluster.ring.RemoveNode(state.addr)
+		cluster.tookOver[state.addr] = true
+	}
+	if p, ok := cluster.peerConnection[state.addr]; ok {
+		go p.Clear(context.Background())
+	}
+	util.LogrusObj.Warn(fmt.Sprintf("node %s is marked as FAIL", state.addr))
+}
+
+// markReachableLocked 收到节点的消息后清除 PFAIL 与 FAIL 标记，调用方需持有 mu 的写锁
+func (cluster *ClusterDatabase) markReachableLocked(state *nodeState) {
+	state.pongRecv = time.Now()
+	state.pingSent = time.Time{}
+	if state.pfail && !state.fail {
+		util.LogrusObj.Info(fmt.Sprintf("node %s is reachable again", state.addr))
+	}
+	state.pfail = false
+	state.failReports = make(map[string]time.Time)
+	if state.fail {
+		state.fail = false
+		if cluster.ring != nil {
+			cluster.ring.AddWeightedNode(state.addr, nodeWeight(state.addr))
+			cluster.scheduleRebalanceLocked()
+		}
+		util.LogrusObj.Info(fmt.Sprintf("node %s recovered from FAIL", state.addr))
+	}
+}
+
+// nodeWeight 返回节点在一致性哈希环上的权重
+func nodeWeight(node string) int {
+	if weight, ok := config.Properties().ClusterNodeWeights[node]; ok {
+		return weight
+	}
+	return 1
+}
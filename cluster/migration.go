@@ -0,0 +1,691 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ygxiaobai111/GolixirDB/config"
+	"github.com/ygxiaobai111/GolixirDB/interface/resp"
+	util "github.com/ygxiaobai111/GolixirDB/lib/logger"
+	"github.com/ygxiaobai111/GolixirDB/lib/slot"
+	"github.com/ygxiaobai111/GolixirDB/lib/utils"
+	"github.com/ygxiaobai111/GolixirDB/resp/connection"
+	"github.com/ygxiaobai111/GolixirDB/resp/reply"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/*
+在线迁移
+负责的节点改变后，键需要从原节点搬到新节点，迁移期间两边的键都可以正常读写：
+哈希槽路由下通过 CLUSTER RESHARD 将哈希槽迁出，源节点先通知目标节点导入，再逐个 DUMP 键、在目标节点上 ASKING + RESTORE、删除本地的键，
+迁移期间源节点上仍存在的键由源节点处理，已经搬走或新建的键在重定向模式下返回 -ASK slot host:port，转发模式下带着 ASKING 转发给目标节点；
+所有键搬完后目标节点增加 configEpoch 并声明这些哈希槽，通过集群总线通知其他节点
+一致性哈希路由下节点加入或恢复后，每个节点把不再由自己负责的键搬到新的负责节点，
+迁移期间负责节点上的键会先要求其他正在迁移的节点立即交出再执行命令。两边都有同名键时以最近负责该键的节点为准：
+新加入的节点上的键是它负责之后写入的，保留新节点的值；从 FAIL 恢复的节点上的键是下线前的旧值，由接管期间负责的节点覆盖
+迁移进度通过 CLUSTER MIGRATIONS 查看
+*/
+
+const (
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+
+	relayHandoff = "handoff_" // 要求其他节点立即将 key 交给发送者
+
+	// rebalanceGrace 一致性哈希环改变后的这段时间内，即使尚未通过心跳得知其他节点开始迁移，本地找不到的键也会向其他节点索取
+	rebalanceGrace = 3 * gossipInterval
+	maxJobs        = 16 // CLUSTER MIGRATIONS 保留的迁移任务数
+
+	handoffRetryInterval = 100 * time.Millisecond
+)
+
+var tryAgainReply = reply.MakeErrReply("TRYAGAIN Multiple keys request during rehashing of slot")
+
+// errKeyLocked 键被分布式事务锁住，暂时无法迁移
+var errKeyLocked = errors.New("key is locked by a distributed transaction")
+
+// migrationJob 一次迁移任务
+type migrationJob struct {
+	id           int
+	target       string             // 哈希槽迁移的目标节点，一致性哈希环的迁移为空
+	slots        []config.SlotRange // 迁移的哈希槽
+	state        string
+	err          string
+	keysTotal    int64
+	keysMigrated int64
+	startedAt    time.Time
+	finishedAt   time.Time
+}
+
+// makeAskReply 返回 ASK 错误
+func makeAskReply(slotID int, node string) reply.ErrorReply {
+	return reply.MakeErrReply("ASK " + strconv.Itoa(slotID) + " " + node)
+}
+
+// execAsking ASKING，下一条命令可以访问当前节点正在导入的键
+func execAsking(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 1 {
+		return reply.MakeArgNumErrReply("asking")
+	}
+	c.SetAsking(true)
+	return reply.MakeOkReply()
+}
+
+// acceptAsking 返回执行过 ASKING 的连接能否在当前节点访问 key
+func (cluster *ClusterDatabase) acceptAsking(key string) bool {
+	if cluster.slots == nil {
+		// 一致性哈希路由下只有正在迁移的节点会发送 ASKING
+		return true
+	}
+	cluster.mu.RLock()
+	defer cluster.mu.RUnlock()
+	_, ok := cluster.importing[slot.KeySlot(key)]
+	return ok
+}
+
+// migrationTarget 返回 key 所在哈希槽正在迁往的节点，没有迁移时返回空字符串
+func (cluster *ClusterDatabase) migrationTarget(key string) string {
+	if cluster.slots == nil {
+		return ""
+	}
+	cluster.mu.RLock()
+	defer cluster.mu.RUnlock()
+	return cluster.migrating[slot.KeySlot(key)]
+}
+
+// countLocal 返回 keys 中在当前节点存在的个数
+func (cluster *ClusterDatabase) countLocal(c resp.Connection, keys []string) int {
+	result, ok := cluster.db.Exec(c, utils.ToCmdLine(append([]string{"exists"}, keys...)...)).(*reply.IntReply)
+	if !ok {
+		return 0
+	}
+	return int(result.Code)
+}
+
+// execMigrating 执行访问正在迁出的哈希槽的命令：keys 都还在当前节点时在本地执行，都已搬走时交给目标节点，部分搬走时返回 TRYAGAIN
+func (cluster *ClusterDatabase) execMigrating(target string, keys []string, c resp.Connection, args [][]byte) resp.Reply {
+	// 持有读锁保证执行期间 keys 不会被搬走
+	cluster.migrateMu.RLock()
+	present := cluster.countLocal(c, keys)
+	if present == len(keys) {
+		defer cluster.migrateMu.RUnlock()
+		return cluster.db.Exec(c, args)
+	}
+	cluster.migrateMu.RUnlock()
+	if present > 0 {
+		return tryAgainReply
+	}
+	// 已经搬走的键不会再回到当前节点，不需要继续持有锁
+	if cluster.redirecting() {
+		if cluster.isFailed(target) {
+			return nodeFailedReply
+		}
+		return makeAskReply(slot.KeySlot(keys[0]), target)
+	}
+	return cluster.relayTo(target, c, args, true)
+}
+
+// rebalancingPeers 返回一致性哈希路由下可能还持有当前节点负责的键的节点
+func (cluster *ClusterDatabase) rebalancingPeers() []string {
+	cluster.mu.RLock()
+	defer cluster.mu.RUnlock()
+	recent := time.Since(cluster.ringChangedAt) < rebalanceGrace
+	var peers []string
+	for addr, state := range cluster.states {
+		if addr != cluster.self && !state.fail && (recent || state.migrating) {
+			peers = append(peers, addr)
+		}
+	}
+	return peers
+}
+
+// pullKeys 一致性哈希环改变后，向正在迁移的节点索取 keys
+// 当前节点已有的键也需要索取，当前节点从 FAIL 恢复时本地的值可能比接管期间写入的值旧
+func (cluster *ClusterDatabase) pullKeys(c resp.Connection, keys []string) {
+	peers := cluster.rebalancingPeers()
+	if len(peers) == 0 {
+		return
+	}
+	for _, key := range keys {
+		deadline := time.Now().Add(rebalanceGrace)
+		for {
+			moved, retry := false, false
+			for _, peer := range peers {
+				result, ok := cluster.relay(peer, c, utils.ToCmdLine(relayHandoff, key, cluster.self)).(*reply.IntReply)
+				if ok && result.Code > 0 {
+					moved = true
+					break
+				}
+				retry = retry || (ok && result.Code < 0)
+			}
+			// 对方还不知道当前节点加入了集群，稍后重试
+			if moved || !retry || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(handoffRetryInterval)
+		}
+	}
+}
+
+// onHandoff handoff_ key node，node 成为 key 的负责节点后要求当前节点立即将 key 交给它
+// 返回 1 表示已交出，0 表示没有需要交出的 key，-1 表示当前节点还不知道 node 加入了集群或从 FAIL 恢复，或 key 被事务锁住
+// 各节点的一致性哈希环尚未一致时 node 可能与当前节点计算的负责节点不同，此时仍交给正在等待的 node
+// 当前节点在 node 下线期间接管了它的键时，交出的值覆盖 node 上的旧值
+func onHandoff(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 3 || cluster.ring == nil {
+		return reply.MakeIntReply(0)
+	}
+	key, node := string(args[1]), string(args[2])
+	cluster.mu.RLock()
+	state, known := cluster.states[node]
+	known = known && !state.fail
+	replace := cluster.tookOver[node]
+	cluster.mu.RUnlock()
+	if !known {
+		if cluster.countLocal(c, []string{key}) > 0 {
+			return reply.MakeIntReply(-1)
+		}
+		return reply.MakeIntReply(0)
+	}
+	if node == cluster.self || cluster.ring.PickNode(key) == cluster.self {
+		return reply.MakeIntReply(0)
+	}
+	moved, err := cluster.migrateKey(c.GetDBIndex(), key, node, replace)
+	if err == errKeyLocked {
+		// 事务结束后才能交出，让 node 稍后重试
+		return reply.MakeIntReply(-1)
+	}
+	if err != nil {
+		util.LogrusObj.Warn(fmt.Sprintf("failed to hand off key %s to %s: %v", key, node, err))
+	}
+	if moved {
+		return reply.MakeIntReply(1)
+	}
+	return reply.MakeIntReply(0)
+}
+
+// restoreTTL 将 PTTL 的结果转换为 RESTORE 的 ttl 参数
+// RESTORE 的 0 表示不过期，因此没有过期时间 (-1) 转换为 0，即将过期 (0) 转换为 1 毫秒
+func restoreTTL(pttl int64) []byte {
+	if pttl < 0 {
+		pttl = 0
+	} else if pttl == 0 {
+		pttl = 1
+	}
+	return []byte(strconv.FormatInt(pttl, 10))
+}
+
+// migrateKey 将 dbIndex 中的 key 搬到 target，返回 key 是否被搬走
+// replace 为 true 时覆盖目标节点上的同名 key，为 false 时目标节点已有同名 key 则保留目标节点上的值，丢弃本地的值
+func (cluster *ClusterDatabase) migrateKey(dbIndex int, key string, target string, replace bool) (bool, error) {
+	// 事务锁住的键在事务结束前不能搬走，否则提交或回滚作用在已经不负责该键的节点上
+	release, ok := cluster.useKeys(dbIndex, []string{key})
+	if !ok {
+		return false, errKeyLocked
+	}
+	defer release()
+	cluster.migrateMu.Lock()
+	defer cluster.migrateMu.Unlock()
+	conn := &connection.Connection{}
+	conn.SelectDB(dbIndex)
+	payload, ok := cluster.db.Exec(conn, utils.ToCmdLine("dump", key)).(*reply.BulkReply)
+	if !ok {
+		// key 已经过期或被删除
+		return false, nil
+	}
+	pttl, ok := cluster.db.Exec(conn, utils.ToCmdLine("pttl", key)).(*reply.IntReply)
+	if !ok || pttl.Code == -2 {
+		return false, nil
+	}
+
+	peerClient, err := cluster.getPeerClient(target, time.Now().Add(relayTimeout))
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = cluster.returnPeerClient(target, peerClient)
+	}()
+	restore := [][]byte{[]byte("RESTORE"), []byte(key), restoreTTL(pttl.Code), payload.Arg}
+	if replace {
+		restore = append(restore, []byte("REPLACE"))
+	}
+	result := sendInDB(peerClient, dbIndex, true, restore, relayTimeout)
+	if errReply, ok := result.(reply.ErrorReply); ok {
+		if replace || !strings.HasPrefix(errReply.Error(), "BUSYKEY") {
+			return false, errors.New(errReply.Error())
+		}
+	}
+	cluster.db.Exec(conn, utils.ToCmdLine("del", key))
+	return true, nil
+}
+
+// newJobLocked 创建迁移任务，调用方需持有 mu 的写锁
+func (cluster *ClusterDatabase) newJobLocked(target string, slots []config.SlotRange) *migrationJob {
+	cluster.jobSeq++
+	job := &migrationJob{
+		id:        cluster.jobSeq,
+		target:    target,
+		slots:     slots,
+		state:     jobRunning,
+		startedAt: time.Now(),
+	}
+	cluster.jobs = append(cluster.jobs, job)
+	if len(cluster.jobs) > maxJobs {
+		for i, old := range cluster.jobs {
+			if old.state != jobRunning {
+				cluster.jobs = append(cluster.jobs[:i:i], cluster.jobs[i+1:]...)
+				break
+			}
+		}
+	}
+	return job
+}
+
+// finishJob 结束迁移任务
+func (cluster *ClusterDatabase) finishJob(job *migrationJob, err error) {
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	job.finishedAt = time.Now()
+	if err != nil {
+		job.state = jobFailed
+		job.err = err.Error()
+		util.LogrusObj.Warn(fmt.Sprintf("migration job %d failed: %v", job.id, err))
+		return
+	}
+	job.state = jobDone
+	util.LogrusObj.Info(fmt.Sprintf("migration job %d finished, %d keys migrated", job.id, atomic.LoadInt64(&job.keysMigrated)))
+}
+
+// migrateKeys 将所有数据库中 ownerOf 返回非空节点的键搬到该节点，replace 返回 true 的节点上的同名键被覆盖
+// 返回搬迁失败的键的目标节点
+func (cluster *ClusterDatabase) migrateKeys(job *migrationJob, ownerOf func(key string) string, replace func(target string) bool) (map[string]bool, error) {
+	type pending struct {
+		dbIndex int
+		key     string
+		target  string
+	}
+	var keys []pending
+	for dbIndex := 0; dbIndex < config.Properties().Databases; dbIndex++ {
+		conn := &connection.Connection{}
+		conn.SelectDB(dbIndex)
+		result, ok := cluster.db.Exec(conn, utils.ToCmdLine("keys", "*")).(*reply.MultiBulkReply)
+		if !ok {
+			continue
+		}
+		for _, key := range result.Args {
+			if target := ownerOf(string(key)); target != "" {
+				keys = append(keys, pending{dbIndex: dbIndex, key: string(key), target: target})
+			}
+		}
+	}
+	atomic.StoreInt64(&job.keysTotal, int64(len(keys)))
+	failed := make(map[string]bool)
+	var lastErr error
+	for _, p := range keys {
+		if _, err := cluster.migrateKey(p.dbIndex, p.key, p.target, replace(p.target)); err != nil {
+			failed[p.target] = true
+			if cluster.slots != nil {
+				return failed, fmt.Errorf("migrate key %s to %s: %v", p.key, p.target, err)
+			}
+			// 一致性哈希环上的迁移跳过失败的键，下次环改变时会再次尝试
+			lastErr = fmt.Errorf("migrate key %s to %s: %v", p.key, p.target, err)
+			continue
+		}
+		atomic.AddInt64(&job.keysMigrated, 1)
+	}
+	return failed, lastErr
+}
+
+// scheduleRebalanceLocked 一致性哈希环加入节点后开始迁移不再由当前节点负责的键，调用方需持有 mu 的写锁
+func (cluster *ClusterDatabase) scheduleRebalanceLocked() {
+	cluster.ringChangedAt = time.Now()
+	if cluster.rebalancing {
+		cluster.rebalanceAgain = true
+		return
+	}
+	cluster.rebalancing = true
+	go cluster.rebalanceRing()
+}
+
+// rebalanceRing 将不再由当前节点负责的键搬到一致性哈希环上新的负责节点
+func (cluster *ClusterDatabase) rebalanceRing() {
+	for {
+		cluster.mu.Lock()
+		job := cluster.newJobLocked("", nil)
+		// 值表示开始迁移时节点是否已经恢复，已经恢复的节点在本次迁移中会收到它负责的所有键
+		tookOver := make(map[string]bool, len(cluster.tookOver))
+		for node := range cluster.tookOver {
+			tookOver[node] = !cluster.states[node].fail
+		}
+		cluster.mu.Unlock()
+		failed, err := cluster.migrateKeys(job, func(key string) string {
+			owner := cluster.ring.PickNode(key)
+			if owner == cluster.self {
+				return ""
+			}
+			return owner
+		}, func(target string) bool {
+			_, ok := tookOver[target]
+			return ok
+		})
+		cluster.finishJob(job, err)
+
+		cluster.mu.Lock()
+		// 已恢复的节点的键都已交回，之后它的键只会由它自己负责
+		for node, recovered := range tookOver {
+			if state, ok := cluster.states[node]; ok && recovered && !state.fail && !failed[node] {
+				delete(cluster.tookOver, node)
+			}
+		}
+		if !cluster.rebalanceAgain {
+			cluster.rebalancing = false
+			cluster.mu.Unlock()
+			return
+		}
+		cluster.rebalanceAgain = false
+		cluster.mu.Unlock()
+	}
+}
+
+// findNode 通过节点标识或地址查找节点
+func (cluster *ClusterDatabase) findNode(name string) string {
+	cluster.mu.RLock()
+	defer cluster.mu.RUnlock()
+	return cluster.findNodeLocked(name)
+}
+
+// findNodeLocked 与 findNode 相同，调用方需持有 mu
+func (cluster *ClusterDatabase) findNodeLocked(name string) string {
+	for addr := range cluster.states {
+		if addr == name || nodeID(addr) == name {
+			return addr
+		}
+	}
+	return ""
+}
+
+// clusterReshard CLUSTER RESHARD node-id start end [start end ...]，将当前节点负责的哈希槽迁往目标节点，返回迁移任务编号
+func (cluster *ClusterDatabase) clusterReshard(args [][]byte) resp.Reply {
+	if cluster.slots == nil {
+		return errNoSlots
+	}
+	target := cluster.findNode(string(args[0]))
+	if target == "" {
+		return reply.MakeErrReply("ERR Unknown node " + string(args[0]))
+	}
+	if target == cluster.self {
+		return reply.MakeErrReply("ERR I can't migrate slots to myself")
+	}
+	if cluster.isFailed(target) {
+		return reply.MakeErrReply("ERR Node " + string(args[0]) + " is marked as failed")
+	}
+	var ranges []config.SlotRange
+	for i := 1; i < len(args); i += 2 {
+		start, err1 := strconv.Atoi(string(args[i]))
+		end, err2 := strconv.Atoi(string(args[i+1]))
+		if err1 != nil || err2 != nil || start < 0 || end >= slot.Count || start > end {
+			return reply.MakeErrReply("ERR Invalid or out of range slot")
+		}
+		ranges = append(ranges, config.SlotRange{Start: start, End: end})
+	}
+	if errReply := cluster.checkReshard(target, ranges); errReply != nil {
+		return errReply
+	}
+
+	// 先让目标节点开始导入，否则迁移期间 ASK 到目标节点的命令会被重定向回来
+	msg := cluster.makeBusMessage(busImport)
+	msg.MigrateSlots = ranges
+	cluster.mu.RLock()
+	busAddr := cluster.states[target].busAddr()
+	cluster.mu.RUnlock()
+	pong, err := sendBusMessage(busAddr, msg)
+	if err != nil {
+		return reply.MakeErrReply("ERR failed to notify " + target + ": " + err.Error())
+	}
+	cluster.handleBusMessage(pong)
+
+	cluster.mu.Lock()
+	for _, r := range ranges {
+		for i := r.Start; i <= r.End; i++ {
+			cluster.migrating[i] = target
+		}
+	}
+	job := cluster.newJobLocked(target, ranges)
+	cluster.mu.Unlock()
+	util.LogrusObj.Info(fmt.Sprintf("migration job %d started, migrating slots %s to %s",
+		job.id, formatRanges(ranges), target))
+	go cluster.runReshard(job)
+	return reply.MakeIntReply(int64(job.id))
+}
+
+// checkReshard 检查目标节点是主节点，哈希槽都由当前节点负责，且没有正在迁往其他节点
+func (cluster *ClusterDatabase) checkReshard(target string, ranges []config.SlotRange) resp.Reply {
+	cluster.mu.RLock()
+	defer cluster.mu.RUnlock()
+	if state, ok := cluster.states[target]; !ok || state.replicaOf != "" {
+		return reply.MakeErrReply("ERR Node " + target + " is not a primary")
+	}
+	for _, r := range ranges {
+		for i := r.Start; i <= r.End; i++ {
+			if cluster.slots.owner(i) != cluster.self {
+				return reply.MakeErrReply("ERR I'm not the owner of hash slot " + strconv.Itoa(i))
+			}
+			if to, ok := cluster.migrating[i]; ok && to != target {
+				return reply.MakeErrReply("ERR hash slot " + strconv.Itoa(i) + " is already migrating to " + to)
+			}
+		}
+	}
+	return nil
+}
+
+// runReshard 搬完哈希槽中的所有键后将哈希槽交给目标节点
+func (cluster *ClusterDatabase) runReshard(job *migrationJob) {
+	inJob := make(map[int]bool)
+	for _, r := range job.slots {
+		for i := r.Start; i <= r.End; i++ {
+			inJob[i] = true
+		}
+	}
+	_, err := cluster.migrateKeys(job, func(key string) string {
+		if inJob[slot.KeySlot(key)] {
+			return job.target
+		}
+		return ""
+	}, func(string) bool {
+		return true
+	})
+	if err != nil {
+		// 保留迁移状态，已经搬走的键仍然可以通过 ASK 访问，再次执行 CLUSTER RESHARD 会继续迁移
+		cluster.finishJob(job, err)
+		return
+	}
+
+	msg := cluster.makeBusMessage(busMigrated)
+	msg.MigrateSlots = job.slots
+	cluster.mu.RLock()
+	state, ok := cluster.states[job.target]
+	var busAddr string
+	if ok {
+		busAddr = state.busAddr()
+	}
+	cluster.mu.RUnlock()
+	if !ok {
+		cluster.finishJob(job, errors.New("target node left the cluster"))
+		return
+	}
+	pong, err := sendBusMessage(busAddr, msg)
+	if err != nil {
+		cluster.finishJob(job, fmt.Errorf("hand over slots: %v", err))
+		return
+	}
+	cluster.handleBusMessage(pong)
+	cluster.mu.Lock()
+	for i := range inJob {
+		if cluster.migrating[i] == job.target {
+			delete(cluster.migrating, i)
+		}
+	}
+	cluster.mu.Unlock()
+	cluster.finishJob(job, nil)
+}
+
+// handleMigrationLocked 处理集群总线上的 import 与 migrated 消息，调用方需持有 mu 的写锁
+func (cluster *ClusterDatabase) handleMigrationLocked(msg *busMessage) {
+	if cluster.slots == nil {
+		return
+	}
+	switch msg.Type {
+	case busImport:
+		for _, r := range msg.MigrateSlots {
+			for i := r.Start; i <= r.End && i < slot.Count; i++ {
+				cluster.importing[i] = msg.Sender
+			}
+		}
+		util.LogrusObj.Info(fmt.Sprintf("importing slots %s from %s", formatRanges(msg.MigrateSlots), msg.Sender))
+	case busMigrated:
+		// 使用新的 configEpoch 声明迁入的哈希槽，覆盖源节点的声明
+		cluster.currentEpoch++
+		cluster.states[cluster.self].configEpoch = cluster.currentEpoch
+		for _, r := range msg.MigrateSlots {
+			if r.Start < 0 || r.End >= slot.Count {
+				continue
+			}
+			for i := r.Start; i <= r.End; i++ {
+				delete(cluster.importing, i)
+			}
+			cluster.slots.assign(cluster.self, r.Start, r.End)
+		}
+		util.LogrusObj.Info(fmt.Sprintf("took over slots %s from %s with config epoch %d",
+			formatRanges(msg.MigrateSlots), msg.Sender, cluster.currentEpoch))
+	}
+}
+
+// clusterRebalance CLUSTER REBALANCE
+// 哈希槽路由下将哈希槽平均分配给所有未下线的节点，由负责多余哈希槽的节点执行迁移；一致性哈希路由下重新检查当前节点的键
+func (cluster *ClusterDatabase) clusterRebalance(c resp.Connection) resp.Reply {
+	if cluster.slots == nil {
+		cluster.mu.Lock()
+		cluster.scheduleRebalanceLocked()
+		cluster.mu.Unlock()
+		return reply.MakeOkReply()
+	}
+	var nodes []string
+	for _, node := range cluster.sortedNodes() {
+		if !cluster.isFailed(node) {
+			nodes = append(nodes, node)
+		}
+	}
+	if len(nodes) == 0 {
+		return reply.MakeMultiRawReply(nil)
+	}
+	expected := make(map[string]int)
+	for i, node := range nodes {
+		expected[node] = slot.Count / len(nodes)
+		if i < slot.Count%len(nodes) {
+			expected[node]++
+		}
+	}
+	counts := cluster.slots.countByNode()
+
+	// 负责的哈希槽多于平均数的节点从最后的哈希槽开始交出多余的部分
+	var surplus []int
+	for i := slot.Count - 1; i >= 0; i-- {
+		owner := cluster.slots.owner(i)
+		if _, ok := expected[owner]; ok && counts[owner] > expected[owner] {
+			counts[owner]--
+			surplus = append(surplus, i)
+		}
+	}
+	// plan[source][target] 为需要迁移的哈希槽
+	plan := make(map[string]map[string][]int)
+	for _, node := range nodes {
+		for counts[node] < expected[node] && len(surplus) > 0 {
+			slotID := surplus[len(surplus)-1]
+			surplus = surplus[:len(surplus)-1]
+			source := cluster.slots.owner(slotID)
+			if plan[source] == nil {
+				plan[source] = make(map[string][]int)
+			}
+			plan[source][node] = append(plan[source][node], slotID)
+			counts[node]++
+		}
+	}
+
+	var result []resp.Reply
+	for _, source := range nodes {
+		for _, target := range nodes {
+			slotIDs := plan[source][target]
+			if len(slotIDs) == 0 {
+				continue
+			}
+			ranges := toSlotRanges(slotIDs)
+			cmdLine := utils.ToCmdLine("cluster", "reshard", nodeID(target))
+			for _, r := range ranges {
+				cmdLine = append(cmdLine, []byte(strconv.Itoa(r.Start)), []byte(strconv.Itoa(r.End)))
+			}
+			status := cluster.relay(source, c, cmdLine)
+			line := fmt.Sprintf("%s -> %s: %d slots, ", source, target, len(slotIDs))
+			if intReply, ok := status.(*reply.IntReply); ok {
+				line += "job " + strconv.FormatInt(intReply.Code, 10)
+			} else if errReply, ok := status.(reply.ErrorReply); ok {
+				line += errReply.Error()
+			}
+			result = append(result, reply.MakeStatusReply(line))
+		}
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// toSlotRanges 将升序的哈希槽合并为连续区间
+func toSlotRanges(slotIDs []int) []config.SlotRange {
+	var ranges []config.SlotRange
+	for _, id := range slotIDs {
+		if n := len(ranges); n > 0 && ranges[n-1].End == id-1 {
+			ranges[n-1].End = id
+			continue
+		}
+		ranges = append(ranges, config.SlotRange{Start: id, End: id})
+	}
+	return ranges
+}
+
+// clusterMigrations CLUSTER MIGRATIONS，返回当前节点发起的迁移任务及其进度
+func (cluster *ClusterDatabase) clusterMigrations() resp.Reply {
+	cluster.mu.RLock()
+	defer cluster.mu.RUnlock()
+	result := make([]resp.Reply, 0, len(cluster.jobs))
+	for _, job := range cluster.jobs {
+		total := atomic.LoadInt64(&job.keysTotal)
+		migrated := atomic.LoadInt64(&job.keysMigrated)
+		progress := 0.0
+		if total > 0 {
+			progress = float64(migrated) * 100 / float64(total)
+		} else if job.state == jobDone {
+			progress = 100
+		}
+		target := job.target
+		if target == "" {
+			target = "ring"
+		}
+		end := job.finishedAt
+		if end.IsZero() {
+			end = time.Now()
+		}
+		item := reply.MakeMapReply(nil, nil)
+		item.Add(reply.MakeBulkReply([]byte("id")), reply.MakeIntReply(int64(job.id)))
+		item.Add(reply.MakeBulkReply([]byte("target")), reply.MakeBulkReply([]byte(target)))
+		item.Add(reply.MakeBulkReply([]byte("slots")), reply.MakeBulkReply([]byte(formatRanges(job.slots))))
+		item.Add(reply.MakeBulkReply([]byte("state")), reply.MakeBulkReply([]byte(job.state)))
+		item.Add(reply.MakeBulkReply([]byte("keys-total")), reply.MakeIntReply(total))
+		item.Add(reply.MakeBulkReply([]byte("keys-migrated")), reply.MakeIntReply(migrated))
+		item.Add(reply.MakeBulkReply([]byte("progress")), reply.MakeDoubleReply(progress))
+		item.Add(reply.MakeBulkReply([]byte("started-at")), reply.MakeIntReply(job.startedAt.Unix()))
+		item.Add(reply.MakeBulkReply([]byte("elapsed-ms")), reply.MakeIntReply(int64(end.Sub(job.startedAt)/time.Millisecond)))
+		item.Add(reply.MakeBulkReply([]byte("error")), reply.MakeBulkReply([]byte(job.err)))
+		result = append(result, item)
+	}
+	return reply.MakeMultiRawReply(result)
+}
@@ -0,0 +1,40 @@
+package cluster
+
+import (
+	"github.com/ygxiaobai111/GolixirDB/interface/resp"
+	"github.com/ygxiaobai111/GolixirDB/resp/reply"
+	"strings"
+)
+
+// MSet MSET 与 MSETNX，key 位于多个节点时在分布式事务中写入，所有节点要么都写入要么都不写入
+func MSet(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 3 || len(args)%2 == 0 {
+		return reply.MakeArgNumErrReply(strings.ToLower(string(args[0])))
+	}
+	return cluster.execMultiKey(c, args)
+}
+
+// MGet MGET，key 位于多个节点时在分布式事务中读取，读取期间其他事务不能修改这些 key
+func MGet(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("mget")
+	}
+	return cluster.execMultiKey(c, args)
+}
+
+// execMultiKey 执行访问多个 key 的命令，key 位于同一个哈希槽（一致性哈希路由下为同一个节点）时直接交给负责的节点，
+// 否则在分布式事务中按节点拆分执行；重定向模式下所有 key 必须位于同一个哈希槽
+func (cluster *ClusterDatabase) execMultiKey(c resp.Connection, args [][]byte) resp.Reply {
+	keys := commandKeys(args)
+	raw := make([][]byte, len(keys))
+	for i, key := range keys {
+		raw[i] = []byte(key)
+	}
+	if len(cluster.groupKeys(raw)) == 1 {
+		return cluster.relayByKeys(keys, c, args)
+	}
+	if cluster.redirecting() {
+		return crossSlotReply
+	}
+	return cluster.execInTx(c, args)
+}
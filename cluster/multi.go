@@ -0,0 +1,154 @@
+package cluster
+
+import (
+	"github.com/ygxiaobai111/GolixirDB/database"
+	"github.com/ygxiaobai111/GolixirDB/interface/resp"
+	"github.com/ygxiaobai111/GolixirDB/lib/slot"
+	"github.com/ygxiaobai111/GolixirDB/resp/reply"
+	"strings"
+)
+
+/*
+MULTI/EXEC
+MULTI 之后的命令在连接上排队，EXEC 时依次在同一个分布式事务中执行：每条命令在负责的节点上锁住访问的键并记录 undo 日志，
+全部执行完后提交，任何一个节点失败时回滚所有节点，因此事务中的命令可以访问位于多个节点上的键
+命令本身的错误（例如 WRONGTYPE）与 redis 相同作为该命令的结果返回，不影响其他命令
+排队时检查命令及参数数量，出错时 EXEC 返回 EXECABORT；重定向模式下与 redis cluster 相同，所有键必须位于当前节点负责的同一个哈希槽
+*/
+
+// multiCommands 可以在 MULTI 中排队的命令
+var multiCommands = map[string]bool{
+	"ping":      true,
+	"del":       true,
+	"exists":    true,
+	"type":      true,
+	"rename":    true,
+	"renamenx":  true,
+	"set":       true,
+	"setnx":     true,
+	"get":       true,
+	"getset":    true,
+	"mset":      true,
+	"msetnx":    true,
+	"mget":      true,
+	"expire":    true,
+	"pexpire":   true,
+	"expireat":  true,
+	"pexpireat": true,
+	"ttl":       true,
+	"pttl":      true,
+	"persist":   true,
+	"dump":      true,
+	"restore":   true,
+}
+
+var execAbortReply = reply.MakeErrReply("EXECABORT Transaction discarded because of previous errors.")
+
+// execMulti MULTI，之后的命令在连接上排队直到 EXEC 或 DISCARD
+func execMulti(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 1 {
+		return reply.MakeArgNumErrReply("multi")
+	}
+	if c.InMultiState() {
+		return reply.MakeErrReply("ERR MULTI calls can not be nested")
+	}
+	c.SetMultiState(true)
+	return reply.MakeOkReply()
+}
+
+// execDiscard DISCARD，放弃排队的命令
+func execDiscard(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 1 {
+		return reply.MakeArgNumErrReply("discard")
+	}
+	if !c.InMultiState() {
+		return reply.MakeErrReply("ERR DISCARD without MULTI")
+	}
+	c.SetMultiState(false)
+	return reply.MakeOkReply()
+}
+
+// enqueue 检查命令后加入 MULTI 的队列，检查失败时 EXEC 会放弃整个事务
+func (cluster *ClusterDatabase) enqueue(c resp.Connection, cmdLine [][]byte) resp.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	var errReply reply.ErrorReply
+	if !multiCommands[cmdName] {
+		errReply = reply.MakeErrReply("ERR command '" + cmdName + "' can not be used in MULTI in cluster mode")
+	} else {
+		errReply = database.CheckCommand(cmdLine)
+	}
+	if errReply == nil && cluster.redirecting() {
+		errReply = cluster.checkQueuedSlot(c, cmdLine)
+	}
+	if errReply != nil {
+		c.AddTxError(errReply)
+		return errReply
+	}
+	c.EnqueueCmd(cmdLine)
+	return reply.MakeQueuedReply()
+}
+
+// checkQueuedSlot 重定向模式下检查命令的键与已排队的命令位于同一个哈希槽，且由当前节点负责
+func (cluster *ClusterDatabase) checkQueuedSlot(c resp.Connection, cmdLine [][]byte) reply.ErrorReply {
+	keys := commandKeys(cmdLine)
+	for _, queued := range c.GetQueuedCmdLine() {
+		if queuedKeys := commandKeys(queued); len(queuedKeys) > 0 {
+			keys = append(keys, queuedKeys[0])
+			break
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	raw := make([][]byte, len(keys))
+	for i, key := range keys {
+		raw[i] = []byte(key)
+	}
+	if !sameSlot(raw) {
+		return crossSlotReply
+	}
+	if node := cluster.peerPicker.PickNode(keys[0]); node != cluster.self {
+		if node == "" {
+			return clusterDownReply
+		}
+		return makeMovedReply(slot.KeySlot(keys[0]), node)
+	}
+	return nil
+}
+
+// execExec EXEC，在一个分布式事务中依次执行排队的命令，没有键的命令在当前节点执行
+func execExec(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 1 {
+		return reply.MakeArgNumErrReply("exec")
+	}
+	if !c.InMultiState() {
+		return reply.MakeErrReply("ERR EXEC without MULTI")
+	}
+	queued := c.GetQueuedCmdLine()
+	txErrors := c.GetTxErrors()
+	c.SetMultiState(false)
+	if len(txErrors) > 0 {
+		return execAbortReply
+	}
+	if len(queued) == 0 {
+		return &reply.EmptyMultiBulkReply{}
+	}
+	tx := cluster.newTx(c.GetDBIndex())
+	results := make([]resp.Reply, 0, len(queued))
+	for _, cmdLine := range queued {
+		if len(commandKeys(cmdLine)) == 0 {
+			results = append(results, cluster.db.Exec(c, cmdLine))
+			continue
+		}
+		result, errReply := tx.exec(cmdLine)
+		if errReply != nil {
+			tx.rollback()
+			return reply.MakeErrReply("EXECABORT Transaction discarded because of: " + errReply.Error())
+		}
+		results = append(results, result)
+	}
+	if errReply := tx.commit(); errReply != nil {
+		return errReply
+	}
+	return reply.MakeMultiRawReply(results)
+}
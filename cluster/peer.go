@@ -0,0 +1,77 @@
+package cluster
+
+import (
+	"crypto/subtle"
+	"github.com/ygxiaobai111/GolixirDB/config"
+	"github.com/ygxiaobai111/GolixirDB/interface/resp"
+	util "github.com/ygxiaobai111/GolixirDB/lib/logger"
+	"github.com/ygxiaobai111/GolixirDB/resp/reply"
+	"net"
+)
+
+/*
+节点认证
+local_、prepare_、commit_、replicate_ 等节点之间的内部命令可以修改任意事务与键，只有认证为集群节点的连接可以执行，
+其他连接执行时返回 NOPERM。节点建立到其他节点的连接后先执行 peer_ secret，重连后自动重新认证：
+配置了 clusterSecret 时 secret 必须与之相同；没有配置时只接受来自集群节点所在主机的连接，同一主机上的客户端也能通过认证，
+生产环境应当在所有节点上配置相同的 clusterSecret
+*/
+
+// relayPeerAuth 节点之间的认证命令：peer_ secret
+const relayPeerAuth = "peer_"
+
+var peerOnlyReply = reply.MakeErrReply("NOPERM this is a cluster internal command, only cluster peers can run it")
+
+// execPeerAuth peer_ secret，认证成功后连接可以执行节点之间的内部命令
+func execPeerAuth(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply(relayPeerAuth)
+	}
+	if secret := config.Properties().ClusterSecret; secret != "" {
+		if subtle.ConstantTimeCompare(args[1], []byte(secret)) != 1 {
+			return reply.MakeErrReply("ERR invalid cluster secret")
+		}
+	} else if !cluster.fromNodeHost(c) {
+		util.LogrusObj.Warn("reject peer auth from " + remoteHost(c) + ", set clusterSecret if nodes connect from other addresses")
+		return reply.MakeErrReply("ERR peer auth is only accepted from cluster nodes when clusterSecret is not set")
+	}
+	c.SetPeer(true)
+	return reply.MakeOkReply()
+}
+
+// fromNodeHost 返回连接是否来自某个集群节点所在的主机
+func (cluster *ClusterDatabase) fromNodeHost(c resp.Connection) bool {
+	remote := net.ParseIP(remoteHost(c))
+	if remote == nil {
+		return false
+	}
+	for _, node := range cluster.nodeList() {
+		host, _, err := net.SplitHostPort(node)
+		if err != nil {
+			continue
+		}
+		ips, err := net.LookupIP(host)
+		if err != nil {
+			continue
+		}
+		for _, ip := range ips {
+			if ip.Equal(remote) || (ip.IsLoopback() && remote.IsLoopback()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// remoteHost 返回连接的远程 IP，伪连接返回空
+func remoteHost(c resp.Connection) string {
+	addr := c.RemoteAddr()
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return ""
+	}
+	return host
+}
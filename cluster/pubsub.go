@@ -0,0 +1,41 @@
+package cluster
+
+import (
+	"github.com/ygxiaobai111/GolixirDB/interface/resp"
+	"github.com/ygxiaobai111/GolixirDB/resp/reply"
+)
+
+// relayPublish 节点之间转发 PUBLISH 使用的内部命令，收到的节点只在本地发布，避免再次广播
+const relayPublish = "publish_"
+
+// publish 将消息广播给所有节点，返回所有节点上收到消息的订阅者总数
+// 订阅只在客户端连接的节点上生效，键空间通知也只在键所在的节点上发布
+func publish(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 3 {
+		return reply.MakeArgNumErrReply("publish")
+	}
+	relayArgs := make([][]byte, len(args))
+	copy(relayArgs, args)
+	relayArgs[0] = []byte(relayPublish)
+	var count int64
+	for _, node := range cluster.nodeList() {
+		var r resp.Reply
+		if node == cluster.self {
+			r = cluster.db.Exec(c, args)
+		} else {
+			r = cluster.relay(node, c, relayArgs)
+		}
+		if intReply, ok := r.(*reply.IntReply); ok {
+			count += intReply.Code
+		}
+	}
+	return reply.MakeIntReply(count)
+}
+
+// onRelayedPublish 处理其他节点转发的 PUBLISH
+func onRelayedPublish(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	localArgs := make([][]byte, len(args))
+	copy(localArgs, args)
+	localArgs[0] = []byte("publish")
+	return cluster.db.Exec(c, localArgs)
+}
@@ -0,0 +1,83 @@
+package cluster
+
+import (
+	"github.com/ygxiaobai111/GolixirDB/config"
+	"github.com/ygxiaobai111/GolixirDB/interface/resp"
+	"github.com/ygxiaobai111/GolixirDB/lib/slot"
+	"github.com/ygxiaobai111/GolixirDB/resp/reply"
+	"strconv"
+)
+
+/*
+重定向模式
+开启 clusterRedirect 后，key 不属于当前节点时不再代为转发，而是与 redis cluster 一样返回 -MOVED slot host:port，
+客户端通过 CLUSTER SLOTS 等命令获取哈希槽分配后直接访问负责的节点，省去一次转发
+多 key 命令的所有 key 必须位于同一个哈希槽，否则返回 CROSSSLOT
+*/
+
+var crossSlotReply = reply.MakeErrReply("CROSSSLOT Keys in request don't hash to the same slot")
+
+// redirecting 返回是否使用重定向模式，只有哈希槽路由支持重定向
+func (cluster *ClusterDatabase) redirecting() bool {
+	return cluster.slots != nil && config.Properties().ClusterRedirect
+}
+
+// makeMovedReply 返回 MOVED 错误
+func makeMovedReply(slotID int, node string) reply.ErrorReply {
+	return reply.MakeErrReply("MOVED " + strconv.Itoa(slotID) + " " + node)
+}
+
+// relayByKey 将命令交给负责 key 的节点执行，重定向模式下 key 不属于当前节点时返回 MOVED
+func (cluster *ClusterDatabase) relayByKey(key string, c resp.Connection, args [][]byte) resp.Reply {
+	return cluster.relayByKeys([]string{key}, c, args)
+}
+
+// relayByKeys 将访问 keys 的命令交给负责 keys[0] 的节点执行，哈希槽路由下调用方需保证 keys 位于同一个哈希槽
+// keys 正在迁移时由 execMigrating 处理，执行过 ASKING 的连接可以访问正在导入的键，在当前节点执行前等待分布式事务释放 keys
+func (cluster *ClusterDatabase) relayByKeys(keys []string, c resp.Connection, args [][]byte) resp.Reply {
+	key := keys[0]
+	if c.IsAsking() && cluster.acceptAsking(key) {
+		release, ok := cluster.useKeys(c.GetDBIndex(), keys)
+		if !ok {
+			return keyLockedReply
+		}
+		defer release()
+		return cluster.db.Exec(c, args)
+	}
+	peer := cluster.peerPicker.PickNode(key)
+	if peer == cluster.self {
+		// 执行结束之前事务无法锁住 keys
+		release, ok := cluster.useKeys(c.GetDBIndex(), keys)
+		if !ok {
+			return keyLockedReply
+		}
+		defer release()
+		if target := cluster.migrationTarget(key); target != "" {
+			return cluster.execMigrating(target, keys, c, args)
+		}
+		if cluster.ring != nil {
+			cluster.pullKeys(c, keys)
+		}
+	}
+	if peer != "" && peer != cluster.self && cluster.redirecting() {
+		if cluster.isFailed(peer) {
+			return nodeFailedReply
+		}
+		return makeMovedReply(slot.KeySlot(key), peer)
+	}
+	return cluster.relay(peer, c, args)
+}
+
+// sameSlot 返回所有 key 是否位于同一个哈希槽
+func sameSlot(keys [][]byte) bool {
+	if len(keys) == 0 {
+		return true
+	}
+	first := slot.KeySlot(string(keys[0]))
+	for _, key := range keys[1:] {
+		if slot.KeySlot(string(key)) != first {
+			return false
+		}
+	}
+	return true
+}
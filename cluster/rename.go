@@ -2,6 +2,7 @@ package cluster
 
 import (
 	"github.com/ygxiaobai111/GolixirDB/interface/resp"
+	"github.com/ygxiaobai111/GolixirDB/lib/utils"
 	"github.com/ygxiaobai111/GolixirDB/resp/reply"
 )
 
@@ -12,13 +13,70 @@ func Rename(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Rep
 
 	src := string(args[1])
 	dest := string(args[2])
+	if cluster.redirecting() {
+		if !sameSlot(args[1:]) {
+			return crossSlotReply
+		}
+		return cluster.relayByKeys([]string{src, dest}, c, args)
+	}
 	//原本key的ip
 	srcPeer := cluster.peerPicker.PickNode(src)
 	// 目标key的ip
 	destPeer := cluster.peerPicker.PickNode(dest)
-	//槽位必须在一个节点
 	if srcPeer != destPeer {
-		return reply.MakeErrReply("ERR rename must within one slot in cluster mode")
+		if srcPeer == "" || destPeer == "" {
+			return clusterDownReply
+		}
+		return cluster.execInTx(c, args)
+	}
+	if cluster.slots == nil || sameSlot(args[1:]) {
+		return cluster.relayByKeys([]string{src, dest}, c, args)
 	}
 	return cluster.relay(srcPeer, c, args)
 }
+
+// rename 源键与目标键位于不同节点时在事务中完成 RENAME 与 RENAMENX：
+// 在源节点上 DUMP 并删除源键，在目标节点上以相同的过期时间 RESTORE 为目标键，任何一步失败都需要回滚两个节点
+func (tx *distributedTx) rename(nx bool, src, dest, srcPeer, destPeer string) (resp.Reply, reply.ErrorReply) {
+	if nx {
+		r, errReply := tx.prepare(destPeer, utils.ToCmdLine("exists", dest))
+		if errReply != nil {
+			return nil, errReply
+		}
+		if n, ok := r.(*reply.IntReply); ok && n.Code > 0 {
+			return reply.MakeIntReply(0), nil
+		}
+	}
+	r, errReply := tx.prepare(srcPeer, utils.ToCmdLine("dump", src))
+	if errReply != nil {
+		return nil, errReply
+	}
+	payload, ok := r.(*reply.BulkReply)
+	if !ok || payload.Arg == nil {
+		return reply.MakeErrReply("no such key"), nil
+	}
+	r, errReply = tx.prepare(srcPeer, utils.ToCmdLine("pttl", src))
+	if errReply != nil {
+		return nil, errReply
+	}
+	pttl, ok := r.(*reply.IntReply)
+	if !ok || pttl.Code == -2 {
+		return reply.MakeErrReply("no such key"), nil
+	}
+	if _, errReply = tx.prepare(srcPeer, utils.ToCmdLine("del", src)); errReply != nil {
+		return nil, errReply
+	}
+	restore := [][]byte{[]byte("RESTORE"), []byte(dest), restoreTTL(pttl.Code), payload.Arg, []byte("REPLACE")}
+	r, errReply = tx.prepare(destPeer, restore)
+	if errReply != nil {
+		return nil, errReply
+	}
+	if errReply, ok := r.(reply.ErrorReply); ok {
+		// 源键已经删除，必须回滚
+		return nil, errReply
+	}
+	if nx {
+		return reply.MakeIntReply(1), nil
+	}
+	return reply.MakeOkReply(), nil
+}
@@ -0,0 +1,425 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ygxiaobai111/GolixirDB/config"
+	"github.com/ygxiaobai111/GolixirDB/database"
+	"github.com/ygxiaobai111/GolixirDB/interface/resp"
+	util "github.com/ygxiaobai111/GolixirDB/lib/logger"
+	"github.com/ygxiaobai111/GolixirDB/lib/utils"
+	"github.com/ygxiaobai111/GolixirDB/resp/connection"
+	"github.com/ygxiaobai111/GolixirDB/resp/reply"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+主从复制
+从节点不负责哈希槽，通过配置 clusterReplicas 或 CLUSTER REPLICATE node-id 成为某个主节点的从节点，并通过集群总线告知其他节点
+主节点从心跳得知自己的从节点后为每个从节点建立复制连接：
+先清空从节点并将所有键以 RESTORE 发送过去（全量同步），之后将写入 aof 的每条写命令按顺序转发给从节点（命令传播）
+从节点处理不过来导致队列满时重新全量同步
+复制命令通过内部命令 replicate_ 发送，从节点直接在本地执行，不经过路由
+*/
+
+const (
+	relayReplicate = "replicate_"
+
+	replicaQueueSize     = 1 << 16 // 每个从节点等待发送的写命令数上限，超过后重新全量同步
+	replicaBatchSize     = 256     // 每条 replicate_ 最多携带的命令数
+	replicaRetryInterval = time.Second
+)
+
+// replCommand 一条需要复制的写命令
+type replCommand struct {
+	dbIndex int
+	offset  int64
+	cmdLine CmdLine
+}
+
+// replicaLink 主节点到一个从节点的复制连接
+type replicaLink struct {
+	replica string
+	mu      sync.Mutex
+	queue   chan replCommand
+	synced  bool  // 为 true 时写命令进入 queue，为 false 时需要全量同步，期间的写命令被丢弃
+	syncing bool  // 正在全量同步
+	offset  int64 // 已发送给从节点的复制偏移量，使用 sync/atomic 访问
+	wake    chan struct{}
+	stop    chan struct{}
+}
+
+func makeReplicaLink(replica string) *replicaLink {
+	return &replicaLink{
+		replica: replica,
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+	}
+}
+
+// push 将写命令放入队列，不会阻塞
+func (link *replicaLink) push(cmd replCommand) {
+	link.mu.Lock()
+	defer link.mu.Unlock()
+	if !link.synced {
+		return
+	}
+	select {
+	case link.queue <- cmd:
+	default:
+		link.synced = false
+		select {
+		case link.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// state 返回 INFO replication 中从节点的状态
+func (link *replicaLink) state() string {
+	link.mu.Lock()
+	defer link.mu.Unlock()
+	if link.syncing || !link.synced {
+		return "wait_bgsave"
+	}
+	return "online"
+}
+
+// feedReplicas 作为本地数据库的写命令订阅者，将写命令交给所有从节点的复制连接
+func (cluster *ClusterDatabase) feedReplicas(dbIndex int, cmdLine database.CmdLine) {
+	if cluster.replica.Get() {
+		// 从节点执行复制过来的命令时不产生新的偏移量
+		return
+	}
+	offset := atomic.AddInt64(&cluster.replOffset, 1)
+	cluster.linkMu.RLock()
+	defer cluster.linkMu.RUnlock()
+	for _, link := range cluster.replicaLinks {
+		link.push(replCommand{dbIndex: dbIndex, offset: offset, cmdLine: cmdLine})
+	}
+}
+
+// syncReplicaLinks 按心跳得知的从节点建立或关闭复制连接
+func (cluster *ClusterDatabase) syncReplicaLinks() {
+	wanted := make(map[string]bool)
+	cluster.mu.RLock()
+	if cluster.states[cluster.self].replicaOf == "" {
+		for addr, state := range cluster.states {
+			if state.replicaOf == cluster.self && !state.fail {
+				wanted[addr] = true
+			}
+		}
+	}
+	cluster.mu.RUnlock()
+
+	cluster.linkMu.Lock()
+	defer cluster.linkMu.Unlock()
+	for addr, link := range cluster.replicaLinks {
+		if !wanted[addr] {
+			close(link.stop)
+			delete(cluster.replicaLinks, addr)
+			util.LogrusObj.Info("stopped replicating to " + addr)
+		}
+	}
+	for addr := range wanted {
+		if _, ok := cluster.replicaLinks[addr]; !ok {
+			link := makeReplicaLink(addr)
+			cluster.replicaLinks[addr] = link
+			go cluster.runReplicaLink(link)
+			util.LogrusObj.Info("replica " + addr + " attached, starting full sync")
+		}
+	}
+}
+
+// stopReplicaLinks 关闭所有复制连接
+func (cluster *ClusterDatabase) stopReplicaLinks() {
+	cluster.linkMu.Lock()
+	defer cluster.linkMu.Unlock()
+	for addr, link := range cluster.replicaLinks {
+		close(link.stop)
+		delete(cluster.replicaLinks, addr)
+	}
+}
+
+// runReplicaLink 先全量同步，再持续将队列中的写命令发给从节点
+func (cluster *ClusterDatabase) runReplicaLink(link *replicaLink) {
+	for {
+		select {
+		case <-link.stop:
+			return
+		default:
+		}
+		link.mu.Lock()
+		synced, queue := link.synced, link.queue
+		link.mu.Unlock()
+		if !synced {
+			if err := cluster.fullSync(link); err != nil {
+				util.LogrusObj.Warn(fmt.Sprintf("full sync to replica %s failed: %v", link.replica, err))
+				if !sleepOrStop(link.stop, replicaRetryInterval) {
+					return
+				}
+			}
+			continue
+		}
+		select {
+		case <-link.stop:
+			return
+		case <-link.wake:
+		case cmd := <-queue:
+			batch := []replCommand{cmd}
+			for len(batch) < replicaBatchSize {
+				select {
+				case next := <-queue:
+					batch = append(batch, next)
+					continue
+				default:
+				}
+				break
+			}
+			if err := cluster.sendToReplica(link.replica, batch[len(batch)-1].offset, batch); err != nil {
+				util.LogrusObj.Warn(fmt.Sprintf("replicating to %s failed, will resync: %v", link.replica, err))
+				link.mu.Lock()
+				link.synced = false
+				link.mu.Unlock()
+				if !sleepOrStop(link.stop, replicaRetryInterval) {
+					return
+				}
+				continue
+			}
+			atomic.StoreInt64(&link.offset, batch[len(batch)-1].offset)
+		}
+	}
+}
+
+// sleepOrStop 等待 d，期间 stop 被关闭时返回 false
+func sleepOrStop(stop chan struct{}, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-stop:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// fullSync 清空从节点后发送所有键，开始前的写命令包含在发送的键中，开始后的写命令进入新的队列
+func (cluster *ClusterDatabase) fullSync(link *replicaLink) error {
+	link.mu.Lock()
+	link.queue = make(chan replCommand, replicaQueueSize)
+	link.synced = true
+	link.syncing = true
+	link.mu.Unlock()
+	defer func() {
+		link.mu.Lock()
+		link.syncing = false
+		link.mu.Unlock()
+	}()
+
+	offset := atomic.LoadInt64(&cluster.replOffset)
+	start := time.Now()
+	err := cluster.sendSnapshot(link.replica, offset)
+	if err != nil {
+		link.mu.Lock()
+		link.synced = false
+		link.mu.Unlock()
+		return err
+	}
+	atomic.StoreInt64(&link.offset, offset)
+	util.LogrusObj.Info(fmt.Sprintf("full sync to replica %s finished in %v", link.replica, time.Since(start)))
+	return nil
+}
+
+// sendSnapshot 清空从节点并以 RESTORE 发送所有键
+func (cluster *ClusterDatabase) sendSnapshot(replica string, offset int64) error {
+	if err := cluster.sendReplicateCmd(replica, utils.ToCmdLine(relayReplicate, cluster.self,
+		strconv.FormatInt(offset, 10), "sync")); err != nil {
+		return err
+	}
+	var batch []replCommand
+	for dbIndex := 0; dbIndex < config.Properties().Databases; dbIndex++ {
+		conn := &connection.Connection{}
+		conn.SelectDB(dbIndex)
+		keys, ok := cluster.db.Exec(conn, utils.ToCmdLine("keys", "*")).(*reply.MultiBulkReply)
+		if !ok {
+			continue
+		}
+		for _, key := range keys.Args {
+			payload, ok := cluster.db.Exec(conn, utils.ToCmdLine2("dump", key)).(*reply.BulkReply)
+			if !ok {
+				continue
+			}
+			pttl, ok := cluster.db.Exec(conn, utils.ToCmdLine2("pttl", key)).(*reply.IntReply)
+			if !ok || pttl.Code == -2 {
+				continue
+			}
+			batch = append(batch, replCommand{
+				dbIndex: dbIndex,
+				cmdLine: [][]byte{[]byte("restore"), key, restoreTTL(pttl.Code), payload.Arg, []byte("replace")},
+			})
+			if len(batch) >= replicaBatchSize {
+				if err := cluster.sendToReplica(replica, offset, batch); err != nil {
+					return err
+				}
+				batch = batch[:0]
+			}
+		}
+	}
+	if len(batch) > 0 {
+		return cluster.sendToReplica(replica, offset, batch)
+	}
+	return nil
+}
+
+// sendToReplica 发送 replicate_ primary offset [dbIndex argc arg ...] ...
+func (cluster *ClusterDatabase) sendToReplica(replica string, offset int64, batch []replCommand) error {
+	cmdLine := utils.ToCmdLine(relayReplicate, cluster.self, strconv.FormatInt(offset, 10))
+	for _, cmd := range batch {
+		cmdLine = append(cmdLine, []byte(strconv.Itoa(cmd.dbIndex)), []byte(strconv.Itoa(len(cmd.cmdLine))))
+		cmdLine = append(cmdLine, cmd.cmdLine...)
+	}
+	return cluster.sendReplicateCmd(replica, cmdLine)
+}
+
+func (cluster *ClusterDatabase) sendReplicateCmd(replica string, cmdLine CmdLine) error {
+	peerClient, err := cluster.getPeerClient(replica, time.Now().Add(relayTimeout))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = cluster.returnPeerClient(replica, peerClient)
+	}()
+	result := peerClient.Send(cmdLine)
+	if errReply, ok := result.(reply.ErrorReply); ok {
+		return errors.New(errReply.Error())
+	}
+	return nil
+}
+
+// onReplicate replicate_ primary offset sync 或 replicate_ primary offset [dbIndex argc arg ...] ...
+// 从节点执行主节点发来的全量同步或写命令
+func onReplicate(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 3 {
+		return reply.MakeArgNumErrReply(relayReplicate)
+	}
+	primary := string(args[1])
+	cluster.mu.RLock()
+	replicaOf := cluster.states[cluster.self].replicaOf
+	cluster.mu.RUnlock()
+	if replicaOf != primary {
+		return reply.MakeErrReply("ERR I'm not a replica of " + primary)
+	}
+	offset, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR invalid replication offset")
+	}
+	if len(args) == 4 && string(args[3]) == "sync" {
+		for dbIndex := 0; dbIndex < config.Properties().Databases; dbIndex++ {
+			conn := &connection.Connection{}
+			conn.SelectDB(dbIndex)
+			cluster.db.Exec(conn, utils.ToCmdLine("flushdb"))
+		}
+		atomic.StoreInt64(&cluster.replOffset, offset)
+		util.LogrusObj.Info("full sync from primary " + primary + " started")
+		return reply.MakeOkReply()
+	}
+	for i := 3; i < len(args); {
+		if i+1 >= len(args) {
+			return reply.MakeErrReply("ERR invalid replication stream")
+		}
+		dbIndex, err1 := strconv.Atoi(string(args[i]))
+		argc, err2 := strconv.Atoi(string(args[i+1]))
+		if err1 != nil || err2 != nil || dbIndex < 0 || dbIndex >= config.Properties().Databases ||
+			argc <= 0 || i+2+argc > len(args) {
+			return reply.MakeErrReply("ERR invalid replication stream")
+		}
+		conn := &connection.Connection{}
+		conn.SelectDB(dbIndex)
+		cluster.db.Exec(conn, args[i+2:i+2+argc])
+		i += 2 + argc
+	}
+	atomic.StoreInt64(&cluster.replOffset, offset)
+	return reply.MakeOkReply()
+}
+
+// setReplicaOfLocked 设置当前节点复制的主节点，为空表示成为主节点，调用方需持有 mu 的写锁
+func (cluster *ClusterDatabase) setReplicaOfLocked(primary string) {
+	cluster.states[cluster.self].replicaOf = primary
+	cluster.replica.Set(primary != "")
+	cluster.failoverAt = time.Time{}
+	if primary == "" {
+		util.LogrusObj.Info("became a primary")
+	} else {
+		util.LogrusObj.Info("became a replica of " + primary)
+	}
+}
+
+// clusterReplicate CLUSTER REPLICATE node-id，当前节点成为该主节点的从节点，本地数据会被全量同步覆盖
+func (cluster *ClusterDatabase) clusterReplicate(id string) resp.Reply {
+	if cluster.slots == nil {
+		return errNoSlots
+	}
+	primary := cluster.findNode(id)
+	if primary == "" {
+		return reply.MakeErrReply("ERR Unknown node " + id)
+	}
+	if primary == cluster.self {
+		return reply.MakeErrReply("ERR Can't replicate myself")
+	}
+	cluster.mu.Lock()
+	if cluster.states[primary].replicaOf != "" {
+		cluster.mu.Unlock()
+		return reply.MakeErrReply("ERR I can only replicate a master, not a replica.")
+	}
+	if len(cluster.slots.slotsOf(cluster.self)) > 0 {
+		cluster.mu.Unlock()
+		return reply.MakeErrReply("ERR To set a master the node must be empty and without assigned slots.")
+	}
+	cluster.setReplicaOfLocked(primary)
+	msg := cluster.makeBusMessageLocked(busPing)
+	cluster.mu.Unlock()
+	cluster.broadcastBus(msg)
+	return reply.MakeOkReply()
+}
+
+// replicasOf 返回主节点按地址排序的从节点，调用方需持有 mu
+func (cluster *ClusterDatabase) replicasOf(primary string) []string {
+	var replicas []string
+	for addr, state := range cluster.states {
+		if state.replicaOf == primary {
+			replicas = append(replicas, addr)
+		}
+	}
+	sort.Strings(replicas)
+	return replicas
+}
+
+// replicationInfo 返回 INFO replication 展示的复制状态
+func (cluster *ClusterDatabase) replicationInfo() database.ReplicationInfo {
+	info := database.ReplicationInfo{Offset: atomic.LoadInt64(&cluster.replOffset)}
+	cluster.mu.RLock()
+	if primary := cluster.states[cluster.self].replicaOf; primary != "" {
+		info.MasterAddr = primary
+		state, ok := cluster.states[primary]
+		info.MasterLinkUp = ok && !state.pfail
+	}
+	cluster.mu.RUnlock()
+
+	cluster.linkMu.RLock()
+	defer cluster.linkMu.RUnlock()
+	for addr, link := range cluster.replicaLinks {
+		info.Slaves = append(info.Slaves, database.SlaveInfo{
+			Addr:   addr,
+			State:  link.state(),
+			Offset: atomic.LoadInt64(&link.offset),
+		})
+	}
+	sort.Slice(info.Slaves, func(i, j int) bool {
+		return info.Slaves[i].Addr < info.Slaves[j].Addr
+	})
+	return info
+}
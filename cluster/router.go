@@ -11,7 +11,7 @@ func makeRouter() map[string]CmdFunc {
 
 	routerMap["del"] = Del
 
-	routerMap["exists"] = defaultFunc
+	routerMap["exists"] = exists
 	routerMap["type"] = defaultFunc
 	routerMap["rename"] = Rename
 	routerMap["renamenx"] = Rename
@@ -20,16 +20,79 @@ func makeRouter() map[string]CmdFunc {
 	routerMap["setnx"] = defaultFunc
 	routerMap["get"] = defaultFunc
 	routerMap["getset"] = defaultFunc
+	routerMap["mset"] = MSet
+	routerMap["msetnx"] = MSet
+	routerMap["mget"] = MGet
 
-	routerMap["flushdb"] = flushDB
+	routerMap["expire"] = defaultFunc
+	routerMap["pexpire"] = defaultFunc
+	routerMap["expireat"] = defaultFunc
+	routerMap["pexpireat"] = defaultFunc
+	routerMap["ttl"] = defaultFunc
+	routerMap["pttl"] = defaultFunc
+	routerMap["persist"] = defaultFunc
+
+	routerMap["object"] = subcommandKeyFunc
+	routerMap["memory"] = subcommandKeyFunc
+
+	routerMap["flushdb"] = flush
+	routerMap["flushall"] = flush
+	routerMap["keys"] = keys
+	routerMap["scan"] = scan
+	routerMap["dbsize"] = dbSize
+	routerMap["randomkey"] = randomKey
+	routerMap["multi"] = execMulti
+	routerMap["exec"] = execExec
+	routerMap["discard"] = execDiscard
+
+	routerMap["cluster"] = execCluster
+	routerMap["asking"] = execAsking
+	routerMap["dump"] = defaultFunc
+	routerMap["restore"] = defaultFunc
+	routerMap[relayPeerAuth] = execPeerAuth
 
 	routerMap["select"] = execSelect
+	routerMap["hello"] = execLocal
+	routerMap["info"] = execLocal
+	routerMap["slowlog"] = execLocal
+	routerMap["auth"] = execLocal
+	routerMap["config"] = execLocal
+	routerMap["subscribe"] = execLocal
+	routerMap["psubscribe"] = execLocal
+	routerMap["unsubscribe"] = execLocal
+	routerMap["punsubscribe"] = execLocal
+	routerMap["pubsub"] = execLocal
+	routerMap["publish"] = publish
+	// 以下命令只分析当前节点的数据
+	routerMap["bigkeys"] = execLocal
+	routerMap["hotkeys"] = execLocal
+	return routerMap
+}
+
+// makePeerRouter 节点之间的内部命令，只有通过 peer_ 认证的连接可以执行
+func makePeerRouter() map[string]CmdFunc {
+	routerMap := make(map[string]CmdFunc)
+	routerMap[relayLocal] = onRelayedLocal
+	routerMap[relayPrepare] = onPrepare
+	routerMap[relayCommit] = onCommit
+	routerMap[relayRollback] = onRollback
+	routerMap[relayTxStatus] = onTxStatus
+	routerMap[relayTxAck] = onTxAck
+	routerMap[relayHandoff] = onHandoff
+	routerMap[relayReplicate] = onReplicate
+	routerMap[relayPublish] = onRelayedPublish
 	return routerMap
 }
 
 // relay command to responsible peer, and return its reply to client
 func defaultFunc(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
-	key := string(args[1])
-	peer := cluster.peerPicker.PickNode(key)
-	return cluster.relay(peer, c, args)
+	return cluster.relayByKey(string(args[1]), c, args)
+}
+
+// subcommandKeyFunc 用于 OBJECT、MEMORY 等子命令后跟 key 的命令，没有 key 时在本地执行
+func subcommandKeyFunc(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 3 {
+		return execLocal(cluster, c, args)
+	}
+	return cluster.relayByKey(string(args[2]), c, args)
 }
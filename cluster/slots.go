@@ -0,0 +1,181 @@
+package cluster
+
+import (
+	"github.com/ygxiaobai111/GolixirDB/config"
+	"github.com/ygxiaobai111/GolixirDB/lib/slot"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+哈希槽路由
+与 redis cluster 相同，key 按 CRC16(key) mod 16384 映射到哈希槽，每个哈希槽由一个节点负责
+key 中包含 {hashtag} 时只使用 hashtag 计算哈希槽，需要一起操作的 key 可以使用相同的 hashtag 放在同一个节点
+哈希槽的分配来自配置 clusterSlots，未配置时按节点地址排序后平均分配
+没有配置 peers 的节点视为等待通过 CLUSTER MEET 加入已有集群的新节点，不负责任何哈希槽，哈希槽分配通过集群总线从其他节点获取
+*/
+
+// peerPicker 根据 key 选择负责该 key 的节点，没有节点负责时返回空字符串
+type peerPicker interface {
+	PickNode(key string) string
+}
+
+// slotTable 记录每个哈希槽由哪个节点负责
+type slotTable struct {
+	mu     sync.RWMutex
+	owners [slot.Count]string
+}
+
+// makeSlotTable 按配置分配哈希槽，slots 为空时将所有哈希槽平均分配给 nodes，只有一个节点时不分配
+func makeSlotTable(nodes []string, slots map[string][]config.SlotRange) *slotTable {
+	table := &slotTable{}
+	if len(slots) > 0 {
+		for node, ranges := range slots {
+			for _, r := range ranges {
+				table.assign(node, r.Start, r.End)
+			}
+		}
+		return table
+	}
+	if len(nodes) < 2 {
+		return table
+	}
+	sorted := make([]string, len(nodes))
+	copy(sorted, nodes)
+	sort.Strings(sorted)
+	for i, node := range sorted {
+		start := i * slot.Count / len(sorted)
+		end := (i+1)*slot.Count/len(sorted) - 1
+		table.assign(node, start, end)
+	}
+	return table
+}
+
+// assign 将 [start, end] 内的哈希槽分配给 node
+func (t *slotTable) assign(node string, start, end int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := start; i <= end; i++ {
+		t.owners[i] = node
+	}
+}
+
+// claim 处理节点通过集群总线声明的哈希槽，返回是否有哈希槽改变了负责节点，以及失去哈希槽的节点
+// 哈希槽未分配，或者当前负责节点的 configEpoch 小于声明者的 configEpoch 时接受声明
+func (t *slotTable) claim(node string, ranges []config.SlotRange, epoch uint64, epochOf func(node string) uint64) (bool, []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	changed := false
+	lost := make(map[string]bool)
+	for _, r := range ranges {
+		if r.Start < 0 || r.End >= slot.Count {
+			continue
+		}
+		for i := r.Start; i <= r.End; i++ {
+			owner := t.owners[i]
+			if owner == node {
+				continue
+			}
+			if owner == "" || epochOf(owner) < epoch {
+				t.owners[i] = node
+				changed = true
+				if owner != "" {
+					lost[owner] = true
+				}
+			}
+		}
+	}
+	losers := make([]string, 0, len(lost))
+	for owner := range lost {
+		losers = append(losers, owner)
+	}
+	return changed, losers
+}
+
+// unassign 取消 node 负责的所有哈希槽
+func (t *slotTable) unassign(node string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, owner := range t.owners {
+		if owner == node {
+			t.owners[i] = ""
+		}
+	}
+}
+
+// slotsOf 返回 node 负责的所有连续哈希槽区间
+func (t *slotTable) slotsOf(node string) []config.SlotRange {
+	var result []config.SlotRange
+	for _, r := range t.ranges() {
+		if r.node == node {
+			result = append(result, config.SlotRange{Start: r.start, End: r.end})
+		}
+	}
+	return result
+}
+
+// owner 返回负责哈希槽的节点
+func (t *slotTable) owner(slotID int) string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.owners[slotID]
+}
+
+// PickNode 返回负责 key 所在哈希槽的节点
+func (t *slotTable) PickNode(key string) string {
+	return t.owner(slot.KeySlot(key))
+}
+
+// slotRange 一段由同一节点负责的连续哈希槽
+type slotRange struct {
+	start int
+	end   int
+	node  string
+}
+
+// ranges 返回按哈希槽排序的所有已分配的连续区间
+func (t *slotTable) ranges() []slotRange {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var result []slotRange
+	for i := 0; i < slot.Count; i++ {
+		node := t.owners[i]
+		if node == "" {
+			continue
+		}
+		if n := len(result); n > 0 && result[n-1].node == node && result[n-1].end == i-1 {
+			result[n-1].end = i
+			continue
+		}
+		result = append(result, slotRange{start: i, end: i, node: node})
+	}
+	return result
+}
+
+// countByNode 返回每个节点负责的哈希槽数量
+func (t *slotTable) countByNode() map[string]int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	counts := make(map[string]int)
+	for _, node := range t.owners {
+		if node != "" {
+			counts[node]++
+		}
+	}
+	return counts
+}
+
+// formatRanges 将哈希槽区间格式化为 "0-100 200"
+func formatRanges(ranges []config.SlotRange) string {
+	items := make([]string, 0, len(ranges))
+	for _, r := range ranges {
+		item := strconv.Itoa(r.Start)
+		if r.End != r.Start {
+			item += "-" + strconv.Itoa(r.End)
+		}
+		items = append(items, item)
+	}
+	return strings.Join(items, " ")
+}
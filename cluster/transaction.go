@@ -0,0 +1,860 @@
+package cluster
+
+import (
+	"fmt"
+	"github.com/ygxiaobai111/GolixirDB/database"
+	"github.com/ygxiaobai111/GolixirDB/interface/resp"
+	util "github.com/ygxiaobai111/GolixirDB/lib/logger"
+	"github.com/ygxiaobai111/GolixirDB/lib/utils"
+	"github.com/ygxiaobai111/GolixirDB/resp/connection"
+	"github.com/ygxiaobai111/GolixirDB/resp/reply"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/*
+分布式事务
+需要修改多个节点的命令由收到命令的节点作为协调者，按 try-commit-cancel 执行：
+prepare_ txid dbIndex decider timeoutMs command [arg ...] 参与者锁住命令访问的键，写命令执行前先用 DUMP 记录键原来的值作为 undo 日志，
+再执行命令并返回只包含命令结果的数组，prepare_ 本身失败时返回错误，以此区分命令的错误与事务的失败
+commit_ txid [participant ...] 丢弃 undo 日志并释放锁，rollback_ txid 按相反的顺序执行 undo 日志恢复键原来的值后释放锁
+协调者以第一个参与者作为 decider 并最先提交它，decider 提交成功即代表事务提交，之后再提交其他参与者
+发给 decider 的 commit_ 带有其他参与者，decider 保留提交的结果直到协调者或参与者通过 txack_ txid node [node ...] 确认这些参与者都已提交
+键位于多个节点的命令（多 key 的 DEL、MSET、MGET、跨节点的 RENAME 等）按节点拆分后在同一个事务中执行，MULTI/EXEC 中的命令依次在同一个事务中执行
+参与者在 timeoutMs 内没有收到 commit_ 或 rollback_ 时认为协调者已经宕机：decider 直接回滚，
+其他参与者通过 txstatus_ txid 向 decider 询问结果，decider 上还没有提交的事务此时会被回滚，因此所有参与者得到相同的结果。
+decider 总是在其他参与者之前 prepare_，已提交的结果在确认之前不会删除，因此 decider 回答 unknown 的事务一定没有提交；
+decider 不可达或被标记为 FAIL 时参与者无法得知结果，继续锁住键并保留 undo 日志，直到 decider 恢复或通过 CLUSTER TXRESOLVE 手动处理
+其他命令访问被事务锁住的键时等待锁释放，超过 lockWaitTimeout 返回 TRYAGAIN；其他命令执行期间登记正在访问的键，
+事务等到访问结束后才能锁住这些键，键的迁移同样需要登记，因此事务锁住键之后键既不会被修改也不会被搬走
+开启 aof 时 undo 日志与事务的结果写入事务日志，节点重启后恢复尚未结束的事务，见 txjournal.go
+*/
+
+const (
+	relayPrepare  = "prepare_"
+	relayCommit   = "commit_"
+	relayRollback = "rollback_"
+	relayTxStatus = "txstatus_"
+	relayTxAck    = "txack_"
+
+	txTimeout       = 5 * time.Second // 参与者等待协调者提交或回滚的最长时间
+	lockWaitTimeout = 2 * time.Second // 等待其他事务释放键的最长时间
+	txHistoryTime   = time.Minute     // 已结束的事务结果保留的时间，用于拒绝迟到的 prepare_，decider 上尚未确认的提交不受限制
+
+	txCommitted = "committed"
+	txAborted   = "aborted"
+	txUnknown   = "unknown"
+)
+
+var keyLockedReply = reply.MakeErrReply("TRYAGAIN Keys are locked by a distributed transaction")
+
+// txState 参与者上尚未结束的事务
+type txState struct {
+	id        string
+	dbIndex   int
+	decider   string
+	keys      []string        // 已锁住的键
+	undoKeys  map[string]bool // 已记录 undo 日志的键
+	undoLog   []CmdLine       // 按记录的顺序排列的 undo 命令
+	deadline  time.Time
+	running   int  // 正在执行的 prepare_ 数量，执行期间不会超时
+	resolving bool // 正在向 decider 询问结果
+	inDoubt   bool // decider 无法回答，等待 decider 恢复或手动处理
+}
+
+// txRecord 已结束的事务的结果
+type txRecord struct {
+	outcome string
+	at      time.Time
+	waiting map[string]bool // decider 上尚未确认已提交的其他参与者，不为空时结果不会过期
+}
+
+// lockName 返回键在锁表中的名称
+func lockName(dbIndex int, key string) string {
+	return strconv.Itoa(dbIndex) + " " + key
+}
+
+// lockedByOthersLocked 返回 keys 中是否有被 txID 以外的事务锁住的键，调用方需持有 txMu
+func (cluster *ClusterDatabase) lockedByOthersLocked(dbIndex int, keys []string, txID string) bool {
+	for _, key := range keys {
+		if owner, ok := cluster.keyLocks[lockName(dbIndex, key)]; ok && owner != txID {
+			return true
+		}
+	}
+	return false
+}
+
+// usedByOthersLocked 返回 keys 中是否有 tx 尚未锁住且正在被其他命令访问的键，调用方需持有 txMu
+func (cluster *ClusterDatabase) usedByOthersLocked(dbIndex int, keys []string, tx *txState) bool {
+	for _, key := range keys {
+		name := lockName(dbIndex, key)
+		if cluster.keyLocks[name] != tx.id && cluster.keyUsers[name] > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// lockWhen 持有 txMu 检查 ready，不满足时等待事务释放锁或其他命令结束访问后重试
+// 满足时返回 true 并继续持有 txMu，超过 lockWaitTimeout 返回 false
+func (cluster *ClusterDatabase) lockWhen(ready func() bool) bool {
+	deadline := time.Now().Add(lockWaitTimeout)
+	for {
+		cluster.txMu.Lock()
+		if ready() {
+			return true
+		}
+		released := cluster.lockReleased
+		cluster.txMu.Unlock()
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return false
+		}
+		timer := time.NewTimer(remaining)
+		select {
+		case <-released:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// notifyReleasedLocked 唤醒等待 lockWhen 的协程，调用方需持有 txMu
+func (cluster *ClusterDatabase) notifyReleasedLocked() {
+	close(cluster.lockReleased)
+	cluster.lockReleased = make(chan struct{})
+}
+
+// waitKeys 等待 keys 上其他事务的锁释放且其他命令结束访问后为 tx 锁住 keys，超过 lockWaitTimeout 返回 false
+func (cluster *ClusterDatabase) waitKeys(dbIndex int, keys []string, tx *txState) bool {
+	ok := cluster.lockWhen(func() bool {
+		return !cluster.lockedByOthersLocked(dbIndex, keys, tx.id) && !cluster.usedByOthersLocked(dbIndex, keys, tx)
+	})
+	if !ok {
+		return false
+	}
+	defer cluster.txMu.Unlock()
+	for _, key := range keys {
+		name := lockName(dbIndex, key)
+		if _, ok := cluster.keyLocks[name]; !ok {
+			cluster.keyLocks[name] = tx.id
+			tx.keys = append(tx.keys, key)
+		}
+	}
+	return true
+}
+
+// useKeys 等待 keys 上的事务锁释放后登记正在访问 keys，登记期间事务无法锁住 keys，超过 lockWaitTimeout 返回 false
+// 返回 true 时调用方在访问结束后需要调用 release
+func (cluster *ClusterDatabase) useKeys(dbIndex int, keys []string) (release func(), ok bool) {
+	if !cluster.lockWhen(func() bool {
+		return !cluster.lockedByOthersLocked(dbIndex, keys, "")
+	}) {
+		return nil, false
+	}
+	defer cluster.txMu.Unlock()
+	for _, key := range keys {
+		cluster.keyUsers[lockName(dbIndex, key)]++
+	}
+	return func() {
+		cluster.txMu.Lock()
+		defer cluster.txMu.Unlock()
+		idle := false
+		for _, key := range keys {
+			name := lockName(dbIndex, key)
+			if cluster.keyUsers[name]--; cluster.keyUsers[name] <= 0 {
+				delete(cluster.keyUsers, name)
+				idle = true
+			}
+		}
+		if idle {
+			cluster.notifyReleasedLocked()
+		}
+	}, true
+}
+
+// finishLocked 结束事务，释放锁并记录结果，调用方需持有 txMu
+func (cluster *ClusterDatabase) finishLocked(tx *txState, outcome string) {
+	for _, key := range tx.keys {
+		delete(cluster.keyLocks, lockName(tx.dbIndex, key))
+	}
+	delete(cluster.txs, tx.id)
+	cluster.txHistory[tx.id] = txRecord{outcome: outcome, at: time.Now()}
+	cluster.notifyReleasedLocked()
+}
+
+// commandKeys 返回命令访问的键
+func commandKeys(cmdLine CmdLine) []string {
+	args := cmdLine[1:]
+	switch strings.ToLower(string(cmdLine[0])) {
+	case "ping":
+		return nil
+	case "del", "exists", "mget":
+		keys := make([]string, len(args))
+		for i, arg := range args {
+			keys[i] = string(arg)
+		}
+		return keys
+	case "mset", "msetnx":
+		keys := make([]string, 0, len(args)/2)
+		for i := 0; i < len(args); i += 2 {
+			keys = append(keys, string(args[i]))
+		}
+		return keys
+	case "rename", "renamenx":
+		if len(args) >= 2 {
+			return []string{string(args[0]), string(args[1])}
+		}
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	return []string{string(args[0])}
+}
+
+// makeUndo 返回将 key 恢复为当前值的命令，key 不存在时为 DEL
+func (cluster *ClusterDatabase) makeUndo(conn resp.Connection, key string) CmdLine {
+	payload, ok := cluster.db.Exec(conn, utils.ToCmdLine("dump", key)).(*reply.BulkReply)
+	if !ok {
+		return utils.ToCmdLine("del", key)
+	}
+	pttl, ok := cluster.db.Exec(conn, utils.ToCmdLine("pttl", key)).(*reply.IntReply)
+	if !ok || pttl.Code == -2 {
+		// key 在 DUMP 之后过期
+		return utils.ToCmdLine("del", key)
+	}
+	return [][]byte{[]byte("RESTORE"), []byte(key), restoreTTL(pttl.Code), payload.Arg, []byte("REPLACE")}
+}
+
+// onPrepare prepare_ txid dbIndex decider timeoutMs command [arg ...]，锁住键、记录 undo 日志后执行命令，返回只包含命令结果的数组
+func onPrepare(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 6 {
+		return reply.MakeArgNumErrReply(relayPrepare)
+	}
+	txID, decider := string(args[1]), string(args[3])
+	dbIndex, err1 := strconv.Atoi(string(args[2]))
+	timeoutMs, err2 := strconv.ParseInt(string(args[4]), 10, 64)
+	if err1 != nil || err2 != nil || timeoutMs <= 0 {
+		return reply.MakeSyntaxErrReply()
+	}
+	cmdLine := args[5:]
+	keys := commandKeys(cmdLine)
+	for _, key := range keys {
+		if cluster.peerPicker.PickNode(key) != cluster.self {
+			return reply.MakeErrReply("ERR key " + key + " is not served by " + cluster.self)
+		}
+		if cluster.migrationTarget(key) != "" {
+			return tryAgainReply
+		}
+	}
+
+	cluster.txMu.Lock()
+	if record, ok := cluster.txHistory[txID]; ok {
+		cluster.txMu.Unlock()
+		return reply.MakeErrReply("ERR transaction " + txID + " is already " + record.outcome)
+	}
+	tx, ok := cluster.txs[txID]
+	if !ok {
+		tx = &txState{
+			id:       txID,
+			dbIndex:  dbIndex,
+			decider:  decider,
+			undoKeys: make(map[string]bool),
+		}
+		cluster.txs[txID] = tx
+	}
+	tx.deadline = time.Now().Add(time.Duration(timeoutMs) * time.Millisecond)
+	tx.running++
+	cluster.txMu.Unlock()
+	defer func() {
+		cluster.txMu.Lock()
+		tx.running--
+		cluster.txMu.Unlock()
+	}()
+
+	if !cluster.waitKeys(dbIndex, keys, tx) {
+		return keyLockedReply
+	}
+	conn := &connection.Connection{}
+	conn.SelectDB(dbIndex)
+	if database.IsWriteCommand(string(cmdLine[0])) {
+		var undoLog, records []CmdLine
+		seen := make(map[string]bool, len(keys))
+		for _, key := range keys {
+			if tx.undoKeys[key] || seen[key] {
+				continue
+			}
+			seen[key] = true
+			undo := cluster.makeUndo(conn, key)
+			undoLog = append(undoLog, undo)
+			records = append(records, undoRecord(tx, undo))
+		}
+		// undo 日志写入事务日志之后才执行命令，重启后仍然可以回滚
+		cluster.txMu.Lock()
+		err := cluster.journalLocked(records...)
+		if err == nil {
+			for _, undo := range undoLog {
+				tx.undoKeys[string(undo[1])] = true
+			}
+			tx.undoLog = append(tx.undoLog, undoLog...)
+		}
+		cluster.txMu.Unlock()
+		if err != nil {
+			return reply.MakeErrReply("ERR write transaction journal failed: " + err.Error())
+		}
+	}
+	return reply.MakeMultiRawReply([]resp.Reply{cluster.db.Exec(conn, cmdLine)})
+}
+
+// unwrapPrepared 返回 prepare_ 回复中命令的结果，经过转发的回复中只包含批量字符串的数组会被解析为 MultiBulkReply
+func unwrapPrepared(r resp.Reply) (resp.Reply, bool) {
+	switch r := r.(type) {
+	case *reply.MultiRawReply:
+		if len(r.Replies) == 1 {
+			return r.Replies[0], true
+		}
+	case *reply.MultiBulkReply:
+		if len(r.Args) == 1 {
+			if r.Args[0] == nil {
+				return reply.MakeNullBulkReply(), true
+			}
+			return reply.MakeBulkReply(r.Args[0]), true
+		}
+	}
+	return nil, false
+}
+
+// commitTx 提交事务，事务已回滚时返回错误
+// 当前节点是 decider 时 waiting 为其他参与者，提交的结果保留到它们都确认提交为止
+func (cluster *ClusterDatabase) commitTx(txID string, waiting []string) reply.ErrorReply {
+	cluster.txMu.Lock()
+	defer cluster.txMu.Unlock()
+	if tx, ok := cluster.txs[txID]; ok {
+		var pending map[string]bool
+		if len(waiting) > 0 {
+			pending = make(map[string]bool, len(waiting))
+			for _, node := range waiting {
+				pending[node] = true
+			}
+		}
+		if len(tx.undoLog) > 0 || len(pending) > 0 {
+			if err := cluster.journalLocked(commitRecord(txID, pending)); err != nil && tx.decider == cluster.self {
+				// decider 重启后需要回答已提交，结果没有落盘时不能提交，由协调者回滚
+				return reply.MakeErrReply("ERR write transaction journal failed: " + err.Error())
+			}
+		}
+		cluster.finishLocked(tx, txCommitted)
+		if len(pending) > 0 {
+			record := cluster.txHistory[txID]
+			record.waiting = pending
+			cluster.txHistory[txID] = record
+		}
+		return nil
+	}
+	if record, ok := cluster.txHistory[txID]; ok && record.outcome == txCommitted {
+		return nil
+	}
+	return reply.MakeErrReply("ERR transaction " + txID + " is aborted")
+}
+
+// ackTx 记录 nodes 已经提交了事务，所有参与者都确认后提交的结果按 txHistoryTime 过期
+func (cluster *ClusterDatabase) ackTx(txID string, nodes []string) {
+	cluster.txMu.Lock()
+	defer cluster.txMu.Unlock()
+	record, ok := cluster.txHistory[txID]
+	if !ok || len(record.waiting) == 0 {
+		return
+	}
+	for _, node := range nodes {
+		delete(record.waiting, node)
+	}
+	_ = cluster.journalLocked(utils.ToCmdLine(append([]string{journalAck, txID}, nodes...)...))
+	if len(record.waiting) == 0 {
+		record.waiting = nil
+		record.at = time.Now()
+	}
+	cluster.txHistory[txID] = record
+}
+
+// rollbackTx 回滚事务，事务已提交时返回 false
+// 没有收到过 prepare_ 的事务同样记为回滚，迟到的 prepare_ 会被拒绝
+func (cluster *ClusterDatabase) rollbackTx(txID string) bool {
+	cluster.txMu.Lock()
+	tx, ok := cluster.txs[txID]
+	if !ok {
+		defer cluster.txMu.Unlock()
+		if record, ok := cluster.txHistory[txID]; ok {
+			return record.outcome == txAborted
+		}
+		cluster.txHistory[txID] = txRecord{outcome: txAborted, at: time.Now()}
+		return true
+	}
+	// 从事务表中移除后再执行 undo 日志，执行期间键仍然被锁住
+	delete(cluster.txs, txID)
+	cluster.txHistory[txID] = txRecord{outcome: txAborted, at: time.Now()}
+	undoLog := tx.undoLog
+	cluster.txUndoing++
+	cluster.txMu.Unlock()
+
+	conn := &connection.Connection{}
+	conn.SelectDB(tx.dbIndex)
+	for i := len(undoLog) - 1; i >= 0; i-- {
+		if r := cluster.db.Exec(conn, undoLog[i]); reply.IsErrorReply(r) {
+			util.LogrusObj.Error(fmt.Sprintf("transaction %s: undo %s failed: %s",
+				txID, strings.ToLower(string(undoLog[i][0])), string(r.ToBytes())))
+		}
+	}
+	cluster.txMu.Lock()
+	cluster.txUndoing--
+	if len(undoLog) > 0 {
+		_ = cluster.journalLocked(utils.ToCmdLine(journalAbort, txID))
+	}
+	cluster.finishLocked(tx, txAborted)
+	cluster.txMu.Unlock()
+	return true
+}
+
+// onCommit commit_ txid [participant ...]
+func onCommit(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply(relayCommit)
+	}
+	waiting := make([]string, 0, len(args)-2)
+	for _, arg := range args[2:] {
+		waiting = append(waiting, string(arg))
+	}
+	if err := cluster.commitTx(string(args[1]), waiting); err != nil {
+		return err
+	}
+	return reply.MakeOkReply()
+}
+
+// onTxAck txack_ txid node [node ...]，nodes 已经提交了当前节点作为 decider 的事务
+func onTxAck(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 3 {
+		return reply.MakeArgNumErrReply(relayTxAck)
+	}
+	nodes := make([]string, 0, len(args)-2)
+	for _, arg := range args[2:] {
+		nodes = append(nodes, string(arg))
+	}
+	cluster.ackTx(string(args[1]), nodes)
+	return reply.MakeOkReply()
+}
+
+// onRollback rollback_ txid
+func onRollback(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply(relayRollback)
+	}
+	if !cluster.rollbackTx(string(args[1])) {
+		return reply.MakeErrReply("ERR transaction " + string(args[1]) + " is committed")
+	}
+	return reply.MakeOkReply()
+}
+
+// onTxStatus txstatus_ txid，由 decider 回答事务的结果，尚未提交的事务会被回滚，不知道的事务回答 unknown
+func onTxStatus(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply(relayTxStatus)
+	}
+	txID := string(args[1])
+	cluster.txMu.Lock()
+	record, finished := cluster.txHistory[txID]
+	_, pending := cluster.txs[txID]
+	cluster.txMu.Unlock()
+	if finished {
+		return reply.MakeStatusReply(record.outcome)
+	}
+	if !pending {
+		return reply.MakeStatusReply(txUnknown)
+	}
+	if cluster.rollbackTx(txID) {
+		return reply.MakeStatusReply(txAborted)
+	}
+	return reply.MakeStatusReply(txCommitted)
+}
+
+// expireTransactions 处理超时的事务并清理过期的事务结果，由 gossipCron 定期调用
+func (cluster *ClusterDatabase) expireTransactions() {
+	now := time.Now()
+	nodes := make(map[string]bool)
+	for _, node := range cluster.nodeList() {
+		nodes[node] = true
+	}
+	var expired []*txState
+	cluster.txMu.Lock()
+	for id, record := range cluster.txHistory {
+		// 已经离开集群的参与者不会再确认
+		for node := range record.waiting {
+			if !nodes[node] {
+				delete(record.waiting, node)
+			}
+		}
+		if len(record.waiting) == 0 && now.Sub(record.at) > txHistoryTime {
+			delete(cluster.txHistory, id)
+		}
+	}
+	for _, tx := range cluster.txs {
+		if tx.running == 0 && !tx.resolving && now.After(tx.deadline) {
+			tx.resolving = true
+			expired = append(expired, tx)
+		}
+	}
+	cluster.rewriteJournalLocked()
+	cluster.txMu.Unlock()
+	for _, tx := range expired {
+		if tx.decider == cluster.self || tx.decider == "" {
+			util.LogrusObj.Warn("transaction " + tx.id + " timed out, rolling back")
+			cluster.rollbackTx(tx.id)
+			continue
+		}
+		go cluster.resolveTx(tx)
+	}
+}
+
+// resolveTx 向 decider 询问超时事务的结果
+// decider 不可达或已下线时无法得知事务是否提交，继续持有锁与 undo 日志，等待下一次检查或手动处理
+func (cluster *ClusterDatabase) resolveTx(tx *txState) {
+	conn := &connection.Connection{}
+	conn.SelectDB(tx.dbIndex)
+	r := cluster.relay(tx.decider, conn, utils.ToCmdLine(relayTxStatus, tx.id))
+	status, ok := r.(*reply.StatusReply)
+	switch {
+	case ok && status.Status == txCommitted:
+		util.LogrusObj.Warn("transaction " + tx.id + " timed out, committed by " + tx.decider)
+		cluster.commitTx(tx.id, nil)
+		if r := cluster.relay(tx.decider, conn, utils.ToCmdLine(relayTxAck, tx.id, cluster.self)); reply.IsErrorReply(r) {
+			util.LogrusObj.Warn(fmt.Sprintf("transaction %s: ack to %s failed: %s", tx.id, tx.decider, string(r.ToBytes())))
+		}
+	case ok && (status.Status == txAborted || status.Status == txUnknown):
+		util.LogrusObj.Warn("transaction " + tx.id + " timed out, " + status.Status + " by " + tx.decider + ", rolling back")
+		cluster.rollbackTx(tx.id)
+	default:
+		cluster.txMu.Lock()
+		if !tx.inDoubt {
+			tx.inDoubt = true
+			util.LogrusObj.Warn(fmt.Sprintf("transaction %s is in doubt, decider %s did not answer: %s, "+
+				"keys stay locked until it answers or CLUSTER TXRESOLVE %s COMMIT|ABORT is executed",
+				tx.id, tx.decider, strings.TrimSpace(string(r.ToBytes())), tx.id))
+		}
+		tx.resolving = false
+		cluster.txMu.Unlock()
+	}
+}
+
+// clusterTransactions CLUSTER TRANSACTIONS，列出当前节点上尚未结束的事务以及作为 decider 等待确认的已提交事务
+func (cluster *ClusterDatabase) clusterTransactions() resp.Reply {
+	now := time.Now()
+	cluster.txMu.Lock()
+	defer cluster.txMu.Unlock()
+	ids := make([]string, 0, len(cluster.txs))
+	for id := range cluster.txs {
+		ids = append(ids, id)
+	}
+	for id, record := range cluster.txHistory {
+		if len(record.waiting) > 0 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	result := make([]resp.Reply, 0, len(ids))
+	for _, id := range ids {
+		item := reply.MakeMapReply(nil, nil)
+		item.Add(reply.MakeBulkReply([]byte("id")), reply.MakeBulkReply([]byte(id)))
+		if tx, ok := cluster.txs[id]; ok {
+			state := "prepared"
+			if tx.inDoubt {
+				state = "in-doubt"
+			}
+			item.Add(reply.MakeBulkReply([]byte("state")), reply.MakeBulkReply([]byte(state)))
+			item.Add(reply.MakeBulkReply([]byte("decider")), reply.MakeBulkReply([]byte(tx.decider)))
+			item.Add(reply.MakeBulkReply([]byte("db")), reply.MakeIntReply(int64(tx.dbIndex)))
+			item.Add(reply.MakeBulkReply([]byte("keys")), reply.MakeIntReply(int64(len(tx.keys))))
+			item.Add(reply.MakeBulkReply([]byte("overdue-ms")), reply.MakeIntReply(int64(now.Sub(tx.deadline)/time.Millisecond)))
+		} else {
+			record := cluster.txHistory[id]
+			waiting := make([]string, 0, len(record.waiting))
+			for node := range record.waiting {
+				waiting = append(waiting, node)
+			}
+			sort.Strings(waiting)
+			item.Add(reply.MakeBulkReply([]byte("state")), reply.MakeBulkReply([]byte(record.outcome)))
+			item.Add(reply.MakeBulkReply([]byte("waiting")), reply.MakeBulkReply([]byte(strings.Join(waiting, ","))))
+		}
+		result = append(result, item)
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// clusterTxResolve CLUSTER TXRESOLVE txid COMMIT|ABORT，手动提交或回滚当前节点上 decider 无法回答的事务
+// 需要先确认 decider 上的结果，否则参与者之间的结果可能不一致
+func (cluster *ClusterDatabase) clusterTxResolve(txID string, outcome string) resp.Reply {
+	cluster.txMu.Lock()
+	tx, ok := cluster.txs[txID]
+	busy := ok && tx.running > 0
+	cluster.txMu.Unlock()
+	if !ok {
+		return reply.MakeErrReply("ERR Unknown transaction " + txID)
+	}
+	if busy {
+		return reply.MakeErrReply("ERR Transaction " + txID + " is executing a command")
+	}
+	switch strings.ToLower(outcome) {
+	case "commit":
+		if err := cluster.commitTx(txID, nil); err != nil {
+			return err
+		}
+	case "abort":
+		if !cluster.rollbackTx(txID) {
+			return reply.MakeErrReply("ERR transaction " + txID + " is committed")
+		}
+	default:
+		return reply.MakeSyntaxErrReply()
+	}
+	util.LogrusObj.Warn("transaction " + txID + " resolved manually: " + strings.ToLower(outcome))
+	return reply.MakeOkReply()
+}
+
+// distributedTx 协调者上的事务
+type distributedTx struct {
+	cluster      *ClusterDatabase
+	id           string
+	dbIndex      int
+	decider      string   // 第一个参与者
+	participants []string // 按第一次 prepare_ 的顺序排列
+}
+
+// newTx 创建在 dbIndex 中执行的事务
+func (cluster *ClusterDatabase) newTx(dbIndex int) *distributedTx {
+	seq := atomic.AddUint64(&cluster.txSeq, 1)
+	return &distributedTx{
+		cluster: cluster,
+		id:      cluster.self + "-" + strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + strconv.FormatUint(seq, 10),
+		dbIndex: dbIndex,
+	}
+}
+
+// execInTx 在一个分布式事务中执行命令并提交，命令执行失败时回滚
+func (cluster *ClusterDatabase) execInTx(c resp.Connection, cmdLine CmdLine) resp.Reply {
+	tx := cluster.newTx(c.GetDBIndex())
+	result, errReply := tx.exec(cmdLine)
+	if errReply != nil {
+		tx.rollback()
+		return errReply
+	}
+	if errReply := tx.commit(); errReply != nil {
+		return errReply
+	}
+	return result
+}
+
+// send 将事务的内部命令发给 node
+func (tx *distributedTx) send(node string, args [][]byte) resp.Reply {
+	conn := &connection.Connection{}
+	conn.SelectDB(tx.dbIndex)
+	if node != tx.cluster.self {
+		return tx.cluster.relay(node, conn, args)
+	}
+	switch string(args[0]) {
+	case relayPrepare:
+		return onPrepare(tx.cluster, conn, args)
+	case relayCommit:
+		return onCommit(tx.cluster, conn, args)
+	case relayTxAck:
+		return onTxAck(tx.cluster, conn, args)
+	default:
+		return onRollback(tx.cluster, conn, args)
+	}
+}
+
+// prepare 在 node 上锁住命令访问的键并执行命令，返回命令的结果，第二个返回值不为 nil 时事务需要回滚
+func (tx *distributedTx) prepare(node string, cmdLine CmdLine) (resp.Reply, reply.ErrorReply) {
+	if node == "" {
+		return nil, clusterDownReply
+	}
+	if tx.decider == "" {
+		tx.decider = node
+	}
+	known := false
+	for _, participant := range tx.participants {
+		known = known || participant == node
+	}
+	if !known {
+		tx.participants = append(tx.participants, node)
+	}
+	args := make([][]byte, 0, len(cmdLine)+5)
+	args = append(args, []byte(relayPrepare), []byte(tx.id), []byte(strconv.Itoa(tx.dbIndex)),
+		[]byte(tx.decider), []byte(strconv.FormatInt(int64(txTimeout/time.Millisecond), 10)))
+	args = append(args, cmdLine...)
+	r := tx.send(node, args)
+	if errReply, ok := r.(reply.ErrorReply); ok {
+		return nil, errReply
+	}
+	result, ok := unwrapPrepared(r)
+	if !ok {
+		return nil, reply.MakeErrReply("ERR unexpected reply of " + relayPrepare + " from " + node)
+	}
+	return result, nil
+}
+
+// exec 在事务中执行命令，键位于多个节点时按节点拆分，返回命令的结果，第二个返回值不为 nil 时事务需要回滚
+func (tx *distributedTx) exec(cmdLine CmdLine) (resp.Reply, reply.ErrorReply) {
+	keys := commandKeys(cmdLine)
+	nodes, indexes := tx.cluster.groupByNode(keys)
+	if len(nodes) <= 1 {
+		node := tx.cluster.self
+		if len(nodes) == 1 {
+			node = nodes[0]
+		}
+		return tx.prepare(node, cmdLine)
+	}
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	args := cmdLine[1:]
+	switch cmdName {
+	case "del", "exists":
+		var sum int64
+		for i, node := range nodes {
+			r, errReply := tx.prepare(node, keyArgs(cmdName, keys, indexes[i]))
+			if errReply != nil {
+				return nil, errReply
+			}
+			if n, ok := r.(*reply.IntReply); ok {
+				sum += n.Code
+			}
+		}
+		return reply.MakeIntReply(sum), nil
+	case "mget":
+		result := make([][]byte, len(args))
+		for i, node := range nodes {
+			r, errReply := tx.prepare(node, keyArgs(cmdName, keys, indexes[i]))
+			if errReply != nil {
+				return nil, errReply
+			}
+			values, ok := bulkArgs(r)
+			if !ok || len(values) != len(indexes[i]) {
+				return nil, reply.MakeErrReply("ERR unexpected reply of MGET from " + node)
+			}
+			for j, index := range indexes[i] {
+				result[index] = values[j]
+			}
+		}
+		return reply.MakeMultiBulkReply(result), nil
+	case "mset", "msetnx":
+		if cmdName == "msetnx" {
+			// 先在所有节点上锁住键并检查是否存在
+			for i, node := range nodes {
+				r, errReply := tx.prepare(node, keyArgs("exists", keys, indexes[i]))
+				if errReply != nil {
+					return nil, errReply
+				}
+				if n, ok := r.(*reply.IntReply); ok && n.Code > 0 {
+					return reply.MakeIntReply(0), nil
+				}
+			}
+		}
+		for i, node := range nodes {
+			r, errReply := tx.prepare(node, pairArgs("mset", args, indexes[i]))
+			if errReply != nil {
+				return nil, errReply
+			}
+			if errReply, ok := r.(reply.ErrorReply); ok {
+				// 其他节点可能已经写入，不能只让 MSET 失败
+				return nil, errReply
+			}
+		}
+		if cmdName == "msetnx" {
+			return reply.MakeIntReply(1), nil
+		}
+		return reply.MakeOkReply(), nil
+	case "rename", "renamenx":
+		return tx.rename(cmdName == "renamenx", string(args[0]), string(args[1]), nodes[0], nodes[1])
+	}
+	return crossSlotReply, nil
+}
+
+// groupByNode 将 keys 按负责的节点分组，返回按首次出现的顺序排列的节点及每个节点负责的键在 keys 中的下标
+func (cluster *ClusterDatabase) groupByNode(keys []string) ([]string, [][]int) {
+	var nodes []string
+	var indexes [][]int
+	indexOf := make(map[string]int)
+	for i, key := range keys {
+		node := cluster.peerPicker.PickNode(key)
+		j, ok := indexOf[node]
+		if !ok {
+			j = len(nodes)
+			indexOf[node] = j
+			nodes = append(nodes, node)
+			indexes = append(indexes, nil)
+		}
+		indexes[j] = append(indexes[j], i)
+	}
+	return nodes, indexes
+}
+
+// keyArgs 返回参数为 keys 中下标为 indexes 的键的命令
+func keyArgs(cmdName string, keys []string, indexes []int) CmdLine {
+	cmdLine := make(CmdLine, 0, len(indexes)+1)
+	cmdLine = append(cmdLine, []byte(cmdName))
+	for _, index := range indexes {
+		cmdLine = append(cmdLine, []byte(keys[index]))
+	}
+	return cmdLine
+}
+
+// pairArgs 返回参数为 args 中第 indexes 个键值对的命令
+func pairArgs(cmdName string, args [][]byte, indexes []int) CmdLine {
+	cmdLine := make(CmdLine, 0, 2*len(indexes)+1)
+	cmdLine = append(cmdLine, []byte(cmdName))
+	for _, index := range indexes {
+		cmdLine = append(cmdLine, args[2*index], args[2*index+1])
+	}
+	return cmdLine
+}
+
+// commit 先提交 decider 再提交其他参与者，decider 提交失败时回滚所有参与者并返回错误
+// 其他参与者提交失败时会在超时后从 decider 得知事务已提交，提交成功的参与者通过 txack_ 告知 decider
+func (tx *distributedTx) commit() reply.ErrorReply {
+	if tx.decider == "" {
+		return nil
+	}
+	commit := utils.ToCmdLine(relayCommit, tx.id)
+	for _, node := range tx.participants {
+		if node != tx.decider {
+			commit = append(commit, []byte(node))
+		}
+	}
+	if r := tx.send(tx.decider, commit); reply.IsErrorReply(r) {
+		tx.rollback()
+		return reply.MakeErrReply("ERR transaction aborted: " + strings.TrimSpace(strings.TrimPrefix(string(r.ToBytes()), "-")))
+	}
+	ack := utils.ToCmdLine(relayTxAck, tx.id)
+	for _, node := range tx.participants {
+		if node == tx.decider {
+			continue
+		}
+		if r := tx.send(node, utils.ToCmdLine(relayCommit, tx.id)); reply.IsErrorReply(r) {
+			util.LogrusObj.Warn(fmt.Sprintf("transaction %s: commit on %s failed: %s", tx.id, node, string(r.ToBytes())))
+			continue
+		}
+		ack = append(ack, []byte(node))
+	}
+	if len(ack) > 2 {
+		if r := tx.send(tx.decider, ack); reply.IsErrorReply(r) {
+			util.LogrusObj.Warn(fmt.Sprintf("transaction %s: ack to %s failed: %s", tx.id, tx.decider, string(r.ToBytes())))
+		}
+	}
+	return nil
+}
+
+// rollback 回滚所有参与者
+func (tx *distributedTx) rollback() {
+	for _, node := range tx.participants {
+		if r := tx.send(node, utils.ToCmdLine(relayRollback, tx.id)); reply.IsErrorReply(r) {
+			util.LogrusObj.Warn(fmt.Sprintf("transaction %s: rollback on %s failed: %s", tx.id, node, string(r.ToBytes())))
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package cluster
+
+import (
+	"github.com/ygxiaobai111/GolixirDB/config"
+	"github.com/ygxiaobai111/GolixirDB/interface/resp"
+	"github.com/ygxiaobai111/GolixirDB/lib/utils"
+	"github.com/ygxiaobai111/GolixirDB/resp/connection"
+	"github.com/ygxiaobai111/GolixirDB/resp/reply"
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// makeTestCluster 在 dir 中创建只有一个节点、开启 aof 的集群，所有键都由当前节点负责
+func makeTestCluster(t *testing.T, dir string) *ClusterDatabase {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	busPort := listener.Addr().(*net.TCPAddr).Port
+	_ = listener.Close()
+
+	props := config.Default()
+	props.ClusterMode = true
+	props.Self = "127.0.0.1:" + strconv.Itoa(busPort-1)
+	props.ClusterPort = busPort
+	props.AppendOnly = true
+	props.AppendFilename = filepath.Join(dir, "appendonly.aof")
+	props.AppendFsync = config.FsyncAlways
+	config.SetProperties(props)
+	cluster, err := MakeClusterDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cluster
+}
+
+// testExec 在当前节点的 0 号数据库中直接执行命令
+func (cluster *ClusterDatabase) testExec(args ...string) resp.Reply {
+	return cluster.db.Exec(&connection.Connection{}, utils.ToCmdLine(args...))
+}
+
+// testPrepare 在当前节点上以当前节点为 decider 执行 prepare_
+func (cluster *ClusterDatabase) testPrepare(t *testing.T, txID string, args ...string) {
+	cmdLine := append([]string{relayPrepare, txID, "0", cluster.self, "5000"}, args...)
+	if r := onPrepare(cluster, &connection.Connection{}, utils.ToCmdLine(cmdLine...)); reply.IsErrorReply(r) {
+		t.Fatalf("prepare %v: %s", args, r.ToBytes())
+	}
+}
+
+func expectValue(t *testing.T, cluster *ClusterDatabase, key string, value string) {
+	t.Helper()
+	r := cluster.testExec("get", key)
+	if value == "" {
+		if _, ok := r.(*reply.NullBulkReply); !ok {
+			t.Fatalf("expected %s to be absent, got %q", key, r.ToBytes())
+		}
+		return
+	}
+	if bulk, ok := r.(*reply.BulkReply); !ok || string(bulk.Arg) != value {
+		t.Fatalf("expected %s = %s, got %q", key, value, r.ToBytes())
+	}
+}
+
+func expectUnlocked(t *testing.T, cluster *ClusterDatabase) {
+	t.Helper()
+	cluster.txMu.Lock()
+	defer cluster.txMu.Unlock()
+	if len(cluster.keyLocks) != 0 || len(cluster.txs) != 0 {
+		t.Fatalf("expected no locked keys and no pending transactions, got %d and %d", len(cluster.keyLocks), len(cluster.txs))
+	}
+}
+
+func txStatus(cluster *ClusterDatabase, txID string) string {
+	r := onTxStatus(cluster, &connection.Connection{}, utils.ToCmdLine(relayTxStatus, txID))
+	if status, ok := r.(*reply.StatusReply); ok {
+		return status.Status
+	}
+	return string(r.ToBytes())
+}
+
+func TestTxRollback(t *testing.T) {
+	cluster := makeTestCluster(t, t.TempDir())
+	defer cluster.Close(false)
+	cluster.testExec("set", "k1", "v1")
+	cluster.testExec("set", "k2", "v2")
+
+	cluster.testPrepare(t, "tx1", "set", "k1", "new")
+	cluster.testPrepare(t, "tx1", "del", "k2")
+	cluster.testPrepare(t, "tx1", "set", "k3", "new")
+	// 同一个事务再次修改 k1，回滚后仍然恢复第一次修改之前的值
+	cluster.testPrepare(t, "tx1", "set", "k1", "newer")
+	expectValue(t, cluster, "k1", "newer")
+	expectValue(t, cluster, "k2", "")
+	expectValue(t, cluster, "k3", "new")
+
+	if !cluster.rollbackTx("tx1") {
+		t.Fatal("rollback of a prepared transaction failed")
+	}
+	expectValue(t, cluster, "k1", "v1")
+	expectValue(t, cluster, "k2", "v2")
+	expectValue(t, cluster, "k3", "")
+	expectUnlocked(t, cluster)
+	if status := txStatus(cluster, "tx1"); status != txAborted {
+		t.Fatalf("expected tx1 to be aborted, got %s", status)
+	}
+	if err := cluster.commitTx("tx1", nil); err == nil {
+		t.Fatal("commit after rollback should fail")
+	}
+	r := onPrepare(cluster, &connection.Connection{}, utils.ToCmdLine(relayPrepare, "tx1", "0", cluster.self, "5000", "set", "k1", "late"))
+	if !reply.IsErrorReply(r) {
+		t.Fatal("a late prepare of a rolled back transaction should be rejected")
+	}
+	expectValue(t, cluster, "k1", "v1")
+}
+
+func TestTxCommit(t *testing.T) {
+	cluster := makeTestCluster(t, t.TempDir())
+	defer cluster.Close(false)
+	cluster.testExec("set", "k1", "v1")
+
+	cluster.testPrepare(t, "tx1", "set", "k1", "new")
+	if err := cluster.commitTx("tx1", []string{"127.0.0.1:1"}); err != nil {
+		t.Fatalf("commit failed: %s", err.ToBytes())
+	}
+	expectValue(t, cluster, "k1", "new")
+	expectUnlocked(t, cluster)
+	if cluster.rollbackTx("tx1") {
+		t.Fatal("rollback after commit should fail")
+	}
+	if status := txStatus(cluster, "tx1"); status != txCommitted {
+		t.Fatalf("expected tx1 to be committed, got %s", status)
+	}
+	// 没有 prepare_ 过的事务在询问时回答 unknown
+	if status := txStatus(cluster, "tx2"); status != txUnknown {
+		t.Fatalf("expected an unknown transaction, got %s", status)
+	}
+}
+
+// TestTxJournalRecovery 重启后从事务日志恢复尚未结束的事务，回滚时恢复键原来的值；已结束的事务保持原来的结果
+func TestTxJournalRecovery(t *testing.T) {
+	dir := t.TempDir()
+	cluster := makeTestCluster(t, dir)
+	cluster.testExec("set", "k1", "v1")
+	cluster.testExec("set", "k2", "v2")
+
+	cluster.testPrepare(t, "pending", "set", "k1", "new")
+	cluster.testPrepare(t, "pending", "set", "k3", "new")
+	cluster.testPrepare(t, "committed", "set", "k2", "new")
+	if err := cluster.commitTx("committed", []string{"127.0.0.1:1"}); err != nil {
+		t.Fatalf("commit failed: %s", err.ToBytes())
+	}
+	cluster.testPrepare(t, "aborted", "set", "k4", "new")
+	cluster.rollbackTx("aborted")
+	// 正常关闭时 aof 缓冲区中的命令全部写入文件，事务日志不会记录尚未结束的事务的结果
+	if err := cluster.Close(true); err != nil {
+		t.Fatal(err)
+	}
+
+	cluster = makeTestCluster(t, dir)
+	defer cluster.Close(false)
+	// aof 中保存着事务修改后的值，事务日志恢复了修改之前的值并重新锁住键
+	expectValue(t, cluster, "k1", "new")
+	expectValue(t, cluster, "k3", "new")
+	cluster.txMu.Lock()
+	tx, ok := cluster.txs["pending"]
+	locked := cluster.keyLocks[lockName(0, "k1")] == "pending" && cluster.keyLocks[lockName(0, "k3")] == "pending"
+	waiting := cluster.txHistory["committed"].waiting
+	cluster.txMu.Unlock()
+	if !ok || len(tx.undoLog) != 2 || !locked {
+		t.Fatal("pending transaction was not recovered from the transaction journal")
+	}
+	if !waiting["127.0.0.1:1"] {
+		t.Fatal("unacknowledged participants of a committed transaction were not recovered")
+	}
+	if status := txStatus(cluster, "committed"); status != txCommitted {
+		t.Fatalf("expected committed, got %s", status)
+	}
+	if status := txStatus(cluster, "aborted"); status != txAborted {
+		t.Fatalf("expected aborted, got %s", status)
+	}
+
+	// decider 是当前节点，询问结果时回滚尚未提交的事务
+	if status := txStatus(cluster, "pending"); status != txAborted {
+		t.Fatalf("expected the recovered transaction to be rolled back, got %s", status)
+	}
+	expectValue(t, cluster, "k1", "v1")
+	expectValue(t, cluster, "k2", "new")
+	expectValue(t, cluster, "k3", "")
+	expectValue(t, cluster, "k4", "")
+	expectUnlocked(t, cluster)
+}
+
+// TestPeerOnlyCommands 节点之间的内部命令只有认证为集群节点的连接可以执行
+func TestPeerOnlyCommands(t *testing.T) {
+	cluster := makeTestCluster(t, t.TempDir())
+	defer cluster.Close(false)
+	props := *config.Properties()
+	props.ClusterSecret = "secret"
+	config.SetProperties(&props)
+
+	c := &connection.Connection{}
+	if r := cluster.Exec(c, utils.ToCmdLine(relayRollback, "tx1")); r != peerOnlyReply {
+		t.Fatalf("expected NOPERM before peer auth, got %q", r.ToBytes())
+	}
+	if r := cluster.Exec(c, utils.ToCmdLine(relayPeerAuth, "wrong")); !reply.IsErrorReply(r) || c.IsPeer() {
+		t.Fatal("peer auth with a wrong secret should fail")
+	}
+	if r := cluster.Exec(c, utils.ToCmdLine(relayPeerAuth, "secret")); reply.IsErrorReply(r) || !c.IsPeer() {
+		t.Fatalf("peer auth failed: %q", r.ToBytes())
+	}
+	if r := cluster.Exec(c, utils.ToCmdLine(relayRollback, "tx1")); reply.IsErrorReply(r) {
+		t.Fatalf("expected rollback_ to succeed after peer auth, got %q", r.ToBytes())
+	}
+
+	// 没有配置 clusterSecret 时只接受来自集群节点所在主机的连接，伪连接没有远程地址
+	props.ClusterSecret = ""
+	config.SetProperties(&props)
+	if r := cluster.Exec(&connection.Connection{}, utils.ToCmdLine(relayPeerAuth, "")); !reply.IsErrorReply(r) {
+		t.Fatal("peer auth without a secret should be rejected for a connection without a node address")
+	}
+}
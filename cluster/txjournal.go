@@ -0,0 +1,274 @@
+package cluster
+
+import (
+	"fmt"
+	"github.com/ygxiaobai111/GolixirDB/config"
+	util "github.com/ygxiaobai111/GolixirDB/lib/logger"
+	"github.com/ygxiaobai111/GolixirDB/lib/utils"
+	"github.com/ygxiaobai111/GolixirDB/resp/parser"
+	"github.com/ygxiaobai111/GolixirDB/resp/reply"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+事务日志
+开启 aof 时参与者把 undo 日志与事务的结果追加写入 <appendfilename>.tx 并立即刷盘，格式与 aof 相同：
+undo txid dbIndex decider command [arg ...]  写命令执行前记录的 undo 命令，写入后才执行命令
+commit txid [participant ...]                事务已提交，decider 上带有尚未确认的其他参与者，写入后才回复 commit_
+abort txid                                   事务已回滚，执行 undo 日志之后、释放锁之前写入
+ack txid node [node ...]                     decider 收到的提交确认
+重启时读取事务日志，没有结果的事务重新锁住键并恢复 undo 日志，超时后与其他事务一样向 decider 询问结果，
+decider 上尚未确认的提交也会恢复，因此节点在 prepare_ 之后重启不会丢失 undo 日志。
+只修改过键或作为 decider 等待确认的事务需要写入事务日志，事务日志超过 txJournalRewriteSize 时只保留尚未结束的内容重写
+*/
+
+const (
+	txJournalSuffix      = ".tx"
+	txJournalRewriteSize = 1 << 20
+
+	journalUndo   = "undo"
+	journalCommit = "commit"
+	journalAbort  = "abort"
+	journalAck    = "ack"
+)
+
+// txJournal 追加写入并刷盘的事务日志，由 txMu 保护
+type txJournal struct {
+	filename string
+	file     *os.File
+	size     int64
+}
+
+// openTxJournal 以追加方式打开事务日志
+func openTxJournal(filename string) (*txJournal, error) {
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return &txJournal{filename: filename, file: file, size: info.Size()}, nil
+}
+
+// append 写入 records 并刷盘
+func (j *txJournal) append(records ...CmdLine) error {
+	var data []byte
+	for _, record := range records {
+		data = append(data, reply.MakeMultiBulkReply(record).ToBytes()...)
+	}
+	n, err := j.file.Write(data)
+	j.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+// rewrite 用 records 替换事务日志的内容，先写入临时文件再替换，失败时保留原来的事务日志
+func (j *txJournal) rewrite(records []CmdLine) error {
+	tmpName := j.filename + ".rewrite"
+	tmp, err := os.OpenFile(tmpName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	var size int64
+	for _, record := range records {
+		n, err := tmp.Write(reply.MakeMultiBulkReply(record).ToBytes())
+		size += int64(n)
+		if err != nil {
+			_ = tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, j.filename); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(j.filename, os.O_APPEND|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	_ = j.file.Close()
+	j.file = file
+	j.size = size
+	return nil
+}
+
+func (j *txJournal) close() error {
+	return j.file.Close()
+}
+
+// readTxJournal 读取事务日志中的记录，文件不存在时返回空
+func readTxJournal(filename string) ([]CmdLine, error) {
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var records []CmdLine
+	for p := range parser.ParseStream(file) {
+		if p.Err != nil {
+			if p.Err == io.EOF || p.Err == io.ErrUnexpectedEOF {
+				// 最后一条记录写入时宕机，该记录对应的操作尚未执行
+				break
+			}
+			return records, p.Err
+		}
+		if r, ok := p.Data.(*reply.MultiBulkReply); ok && len(r.Args) >= 2 {
+			records = append(records, r.Args)
+		}
+	}
+	return records, nil
+}
+
+// journalLocked 写入事务日志，没有开启事务日志时什么都不做，调用方需持有 txMu
+func (cluster *ClusterDatabase) journalLocked(records ...CmdLine) error {
+	if cluster.journal == nil || len(records) == 0 {
+		return nil
+	}
+	if err := cluster.journal.append(records...); err != nil {
+		util.LogrusObj.Error("write transaction journal failed: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+// undoRecord 返回事务日志中的 undo 记录
+func undoRecord(tx *txState, undo CmdLine) CmdLine {
+	record := utils.ToCmdLine(journalUndo, tx.id, strconv.Itoa(tx.dbIndex), tx.decider)
+	return append(record, undo...)
+}
+
+// commitRecord 返回事务日志中的 commit 记录
+func commitRecord(txID string, waiting map[string]bool) CmdLine {
+	record := utils.ToCmdLine(journalCommit, txID)
+	for node := range waiting {
+		record = append(record, []byte(node))
+	}
+	return record
+}
+
+// rewriteJournalLocked 事务日志过大时只保留尚未结束的事务与尚未确认的提交，调用方需持有 txMu
+// 回滚中的事务已经从 txs 中移除但还没有写入 abort，此时重写会丢失它的 undo 日志
+func (cluster *ClusterDatabase) rewriteJournalLocked() {
+	if cluster.journal == nil || cluster.journal.size < txJournalRewriteSize || cluster.txUndoing > 0 {
+		return
+	}
+	var records []CmdLine
+	for _, tx := range cluster.txs {
+		for _, undo := range tx.undoLog {
+			records = append(records, undoRecord(tx, undo))
+		}
+	}
+	for id, record := range cluster.txHistory {
+		if len(record.waiting) > 0 {
+			records = append(records, commitRecord(id, record.waiting))
+		}
+	}
+	if err := cluster.journal.rewrite(records); err != nil {
+		util.LogrusObj.Error("rewrite transaction journal failed: " + err.Error())
+		return
+	}
+	util.LogrusObj.Info(fmt.Sprintf("rewrote transaction journal with %d records", len(records)))
+}
+
+// openJournal 开启 aof 时打开事务日志，恢复重启前尚未结束的事务，需要在加载 aof 之后、处理命令之前调用
+func (cluster *ClusterDatabase) openJournal() error {
+	if !config.Properties().AppendOnly {
+		return nil
+	}
+	filename := config.Properties().AppendFilename + txJournalSuffix
+	records, err := readTxJournal(filename)
+	if err != nil {
+		return err
+	}
+	journal, err := openTxJournal(filename)
+	if err != nil {
+		return err
+	}
+	cluster.journal = journal
+
+	now := time.Now()
+	cluster.txMu.Lock()
+	defer cluster.txMu.Unlock()
+	for _, record := range records {
+		txID := string(record[1])
+		switch string(record[0]) {
+		case journalUndo:
+			if len(record) < 6 {
+				continue
+			}
+			tx, ok := cluster.txs[txID]
+			if !ok {
+				dbIndex, _ := strconv.Atoi(string(record[2]))
+				tx = &txState{
+					id:       txID,
+					dbIndex:  dbIndex,
+					decider:  string(record[3]),
+					undoKeys: make(map[string]bool),
+				}
+				cluster.txs[txID] = tx
+			}
+			undo := record[4:]
+			key := string(undo[1])
+			if tx.undoKeys[key] {
+				continue
+			}
+			tx.undoKeys[key] = true
+			tx.undoLog = append(tx.undoLog, undo)
+		case journalCommit, journalAbort:
+			delete(cluster.txs, txID)
+			outcome := txCommitted
+			if string(record[0]) == journalAbort {
+				outcome = txAborted
+			}
+			history := txRecord{outcome: outcome, at: now}
+			if len(record) > 2 {
+				history.waiting = make(map[string]bool, len(record)-2)
+				for _, node := range record[2:] {
+					history.waiting[string(node)] = true
+				}
+			}
+			cluster.txHistory[txID] = history
+		case journalAck:
+			history, ok := cluster.txHistory[txID]
+			if !ok {
+				continue
+			}
+			for _, node := range record[2:] {
+				delete(history.waiting, string(node))
+			}
+			if len(history.waiting) == 0 {
+				history.waiting = nil
+			}
+			cluster.txHistory[txID] = history
+		}
+	}
+	// 重新锁住尚未结束的事务修改过的键，超时后向 decider 询问结果
+	for _, tx := range cluster.txs {
+		for _, undo := range tx.undoLog {
+			key := string(undo[1])
+			cluster.keyLocks[lockName(tx.dbIndex, key)] = tx.id
+			tx.keys = append(tx.keys, key)
+		}
+		tx.deadline = now.Add(txTimeout)
+		util.LogrusObj.Warn(fmt.Sprintf("recovered transaction %s with %d undo records from the transaction journal, decider %s",
+			tx.id, len(tx.undoLog), tx.decider))
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+/*
+命令行工具使用的最简 RESP2 客户端
+不使用 resp/client 是因为其依赖的日志包在导入时会在工作目录下创建日志文件
+*/
+
+// serverError 服务器返回的错误回复
+type serverError string
+
+func (e serverError) Error() string {
+	return string(e)
+}
+
+// conn 一个同步的 RESP2 连接，回复解析为 string、int64、nil、serverError 或 []interface{}
+type conn struct {
+	nc     net.Conn
+	reader *bufio.Reader
+}
+
+func dial(addr string) (*conn, error) {
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{nc: nc, reader: bufio.NewReader(nc)}, nil
+}
+
+func (c *conn) close() {
+	_ = c.nc.Close()
+}
+
+// do 发送一条命令并读取回复
+func (c *conn) do(args ...string) (interface{}, error) {
+	var sb strings.Builder
+	sb.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		sb.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n")
+	}
+	if _, err := c.nc.Write([]byte(sb.String())); err != nil {
+		return nil, err
+	}
+	return c.readReply()
+}
+
+func (c *conn) readLine() (string, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", errors.New("protocol error: " + strconv.Quote(line))
+	}
+	return line[:len(line)-2], nil
+}
+
+func (c *conn) readReply() (interface{}, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if line == "" {
+		return nil, errors.New("protocol error: empty line")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return serverError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.reader, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = c.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, errors.New("protocol error: unexpected reply " + strconv.Quote(line))
+}
+
+// formatReply 以 redis-cli 的风格格式化回复
+func formatReply(r interface{}, indent string) string {
+	switch v := r.(type) {
+	case nil:
+		return "(nil)"
+	case int64:
+		return "(integer) " + strconv.FormatInt(v, 10)
+	case string:
+		return strconv.Quote(v)
+	case serverError:
+		return "(error) " + string(v)
+	case []interface{}:
+		if len(v) == 0 {
+			return "(empty array)"
+		}
+		var sb strings.Builder
+		width := len(strconv.Itoa(len(v)))
+		for i, item := range v {
+			prefix := fmt.Sprintf("%*d) ", width, i+1)
+			if i > 0 {
+				sb.WriteString("\n" + indent)
+			}
+			sb.WriteString(prefix + formatReply(item, indent+strings.Repeat(" ", len(prefix))))
+		}
+		return sb.String()
+	}
+	return fmt.Sprint(r)
+}
@@ -0,0 +1,344 @@
+// golixir-cli 是 GolixirDB 的命令行工具
+//
+// 执行单条命令：
+//
+//	golixir-cli -h 127.0.0.1 -p 14332 set key value
+//
+// 分析所有数据库中内存占用最大的键与访问最频繁的键，通过 BIGKEYS、HOTKEYS 按 SCAN 游标分批遍历：
+//
+//	golixir-cli --bigkeys [--count 5] [--samples 0]
+//	golixir-cli --hotkeys [--count 10] [--samples 0]
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultBigKeysCount = 5
+	defaultHotKeysCount = 10
+	// scanBatch 每次 BIGKEYS、HOTKEYS 请求检查的键数
+	scanBatch = 1000
+)
+
+type options struct {
+	host     string
+	port     int
+	password string
+	db       int
+	bigKeys  bool
+	hotKeys  bool
+	count    int
+	samples  int
+}
+
+func main() {
+	opts := &options{}
+	fs := flag.NewFlagSet("golixir-cli", flag.ContinueOnError)
+	fs.StringVar(&opts.host, "h", "127.0.0.1", "server hostname")
+	fs.IntVar(&opts.port, "p", 14332, "server port")
+	fs.StringVar(&opts.password, "a", "", "password to use when connecting to the server")
+	fs.IntVar(&opts.db, "n", -1, "database number, analysis modes scan all databases when not set")
+	fs.BoolVar(&opts.bigKeys, "bigkeys", false, "report the keys using the most memory for each type")
+	fs.BoolVar(&opts.hotKeys, "hotkeys", false, "report the most frequently accessed keys")
+	fs.IntVar(&opts.count, "count", 0, "number of keys to report, defaults to 5 for --bigkeys and 10 for --hotkeys")
+	fs.IntVar(&opts.samples, "samples", 0, "maximum number of keys to inspect per database, 0 means all keys")
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			return
+		}
+		os.Exit(2)
+	}
+	if err := run(opts, fs.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+func run(opts *options, args []string) error {
+	if opts.bigKeys && opts.hotKeys {
+		return errors.New("--bigkeys and --hotkeys cannot be used together")
+	}
+	c, err := dial(net.JoinHostPort(opts.host, strconv.Itoa(opts.port)))
+	if err != nil {
+		return err
+	}
+	defer c.close()
+	if opts.password != "" {
+		if err := expectOK(c.do("auth", opts.password)); err != nil {
+			return err
+		}
+	}
+	if opts.bigKeys || opts.hotKeys {
+		return analyze(c, opts)
+	}
+	if len(args) == 0 {
+		return errors.New("no command given, see golixir-cli -help")
+	}
+	if opts.db >= 0 {
+		if err := expectOK(c.do("select", strconv.Itoa(opts.db))); err != nil {
+			return err
+		}
+	}
+	r, err := c.do(args...)
+	if err != nil {
+		return err
+	}
+	fmt.Println(formatReply(r, ""))
+	return nil
+}
+
+// expectOK 将错误回复转换为 error
+func expectOK(r interface{}, err error) error {
+	if err != nil {
+		return err
+	}
+	if e, ok := r.(serverError); ok {
+		return e
+	}
+	return nil
+}
+
+// keyspaceDBs 通过 INFO keyspace 获取包含键的数据库
+func keyspaceDBs(c *conn) ([]int, error) {
+	r, err := c.do("info", "keyspace")
+	if err != nil {
+		return nil, err
+	}
+	info, ok := r.(string)
+	if !ok {
+		return nil, fmt.Errorf("unexpected INFO reply: %s", formatReply(r, ""))
+	}
+	var dbs []int
+	for _, line := range strings.Split(info, "\r\n") {
+		if !strings.HasPrefix(line, "db") {
+			continue
+		}
+		colon := strings.IndexByte(line, ':')
+		if colon < 0 {
+			continue
+		}
+		if index, err := strconv.Atoi(line[2:colon]); err == nil {
+			dbs = append(dbs, index)
+		}
+	}
+	return dbs, nil
+}
+
+// analyze 使用 BIGKEYS 或 HOTKEYS 分批遍历每个数据库后输出结果
+// 每次只让服务器检查一批键，不会长时间占用服务器
+func analyze(c *conn, opts *options) error {
+	var dbs []int
+	if opts.db >= 0 {
+		dbs = []int{opts.db}
+	} else {
+		var err error
+		if dbs, err = keyspaceDBs(c); err != nil {
+			return err
+		}
+	}
+	if len(dbs) == 0 {
+		fmt.Println("No keys found.")
+		return nil
+	}
+	count := opts.count
+	if count <= 0 {
+		count = defaultBigKeysCount
+		if opts.hotKeys {
+			count = defaultHotKeysCount
+		}
+	}
+	for _, db := range dbs {
+		if err := expectOK(c.do("select", strconv.Itoa(db))); err != nil {
+			return err
+		}
+		var err error
+		if opts.hotKeys {
+			err = analyzeHotKeys(c, db, count, opts.samples)
+		} else {
+			err = analyzeBigKeys(c, db, count, opts.samples)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+// analyzeBatches 从游标 0 开始重复执行 BIGKEYS 或 HOTKEYS，每次让服务器检查一批键，
+// samples 不为 0 时检查大约 samples 个键后停止。每批的结果交给 consumer 合并，返回检查的键数
+func analyzeBatches(c *conn, command string, count int, samples int, consumer func(fields map[string]interface{})) (int64, error) {
+	cursor := "0"
+	var scanned int64
+	for {
+		batch := int64(scanBatch)
+		if samples > 0 && int64(samples)-scanned < batch {
+			batch = int64(samples) - scanned
+		}
+		r, err := c.do(command, "cursor", cursor, "count", strconv.Itoa(count), "samples", strconv.FormatInt(batch, 10))
+		if err != nil {
+			return scanned, err
+		}
+		if e, ok := r.(serverError); ok {
+			return scanned, e
+		}
+		fields, ok := toMap(r)
+		if !ok {
+			return scanned, fmt.Errorf("unexpected %s reply: %s", strings.ToUpper(command), formatReply(r, ""))
+		}
+		consumer(fields)
+		scanned += toInt(fields["scanned"])
+		cursor = toString(fields["cursor"])
+		if cursor == "0" || cursor == "" || (samples > 0 && scanned >= int64(samples)) {
+			return scanned, nil
+		}
+	}
+}
+
+// typeStat 一种类型的键的统计信息
+type typeStat struct {
+	keys    int64
+	bytes   int64
+	biggest *topKeys
+}
+
+// analyzeBigKeys 通过 BIGKEYS 按类型统计键数与内存占用，并列出每种类型内存占用最大的 count 个键
+func analyzeBigKeys(c *conn, db int, count int, samples int) error {
+	types := make(map[string]*typeStat)
+	scanned, err := analyzeBatches(c, "bigkeys", count, samples, func(fields map[string]interface{}) {
+		for typ, value := range fields {
+			if typ == "cursor" || typ == "scanned" {
+				continue
+			}
+			batch, _ := toMap(value)
+			stat, ok := types[typ]
+			if !ok {
+				stat = &typeStat{biggest: &topKeys{n: count}}
+				types[typ] = stat
+			}
+			stat.keys += toInt(batch["keys"])
+			stat.bytes += toInt(batch["bytes"])
+			biggest, _ := batch["biggest"].([]interface{})
+			for _, item := range biggest {
+				if pair, ok := item.([]interface{}); ok && len(pair) == 2 {
+					stat.biggest.add(keyStat{key: toString(pair[0]), score: toInt(pair[1])})
+				}
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("# db%d: scanned %d keys\n", db, scanned)
+	printBigKeys(types)
+	return nil
+}
+
+// analyzeHotKeys 通过 HOTKEYS 列出访问频率最高的 count 个键
+func analyzeHotKeys(c *conn, db int, count int, samples int) error {
+	hottest := &topKeys{n: count}
+	scanned, err := analyzeBatches(c, "hotkeys", count, samples, func(fields map[string]interface{}) {
+		hotKeys, _ := fields["hotkeys"].([]interface{})
+		for _, item := range hotKeys {
+			if entry, ok := item.([]interface{}); ok && len(entry) >= 2 {
+				hottest.add(keyStat{key: toString(entry[0]), score: toInt(entry[1])})
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("# db%d: scanned %d keys\n", db, scanned)
+	printHotKeys(hottest.items)
+	return nil
+}
+
+func printBigKeys(types map[string]*typeStat) {
+	if len(types) == 0 {
+		fmt.Println("No keys found.")
+		return
+	}
+	names := make([]string, 0, len(types))
+	for typ := range types {
+		names = append(names, typ)
+	}
+	sort.Strings(names)
+	for _, typ := range names {
+		stat := types[typ]
+		avg := float64(0)
+		if stat.keys > 0 {
+			avg = float64(stat.bytes) / float64(stat.keys)
+		}
+		fmt.Printf("%d %ss with %d bytes (avg %.2f bytes per key)\n", stat.keys, typ, stat.bytes, avg)
+		for i, item := range stat.biggest.items {
+			fmt.Printf("  %2d) %s %d bytes\n", i+1, strconv.Quote(item.key), item.score)
+		}
+	}
+}
+
+func printHotKeys(hotKeys []keyStat) {
+	if len(hotKeys) == 0 {
+		fmt.Println("No accessed keys found.")
+		return
+	}
+	for i, item := range hotKeys {
+		fmt.Printf("  %2d) %s freq %d\n", i+1, strconv.Quote(item.key), item.score)
+	}
+}
+
+// keyStat 一个键的统计信息，score 为 --bigkeys 的字节数或 --hotkeys 的访问频率
+type keyStat struct {
+	key   string
+	score int64
+}
+
+// topKeys 保存得分最高的 n 个键，按得分从高到低排列，得分相同时先遇到的在前
+type topKeys struct {
+	n     int
+	items []keyStat
+}
+
+func (t *topKeys) add(stat keyStat) {
+	if len(t.items) == t.n && stat.score <= t.items[len(t.items)-1].score {
+		return
+	}
+	i := sort.Search(len(t.items), func(i int) bool {
+		return t.items[i].score < stat.score
+	})
+	if len(t.items) < t.n {
+		t.items = append(t.items, keyStat{})
+	}
+	copy(t.items[i+1:], t.items[i:])
+	t.items[i] = stat
+}
+
+// toMap 将 RESP2 中展开为数组的 map 转换为 map
+func toMap(r interface{}) (map[string]interface{}, bool) {
+	items, ok := r.([]interface{})
+	if !ok || len(items)%2 != 0 {
+		return nil, false
+	}
+	result := make(map[string]interface{}, len(items)/2)
+	for i := 0; i < len(items); i += 2 {
+		result[toString(items[i])] = items[i+1]
+	}
+	return result, true
+}
+
+func toInt(r interface{}) int64 {
+	n, _ := r.(int64)
+	return n
+}
+
+func toString(r interface{}) string {
+	s, _ := r.(string)
+	return s
+}
@@ -1,9 +1,13 @@
 package config
 
 import (
+	"errors"
 	"flag"
+	"fmt"
 	"github.com/spf13/viper"
-	"log"
+	"os"
+	"strings"
+	"sync/atomic"
 )
 
 // ServerProperties 配置信息
@@ -12,46 +16,234 @@ type ServerProperties struct {
 	Port           int
 	AppendOnly     bool
 	AppendFilename string
+	AppendFsync    string // aof 刷盘策略：always、everysec 或 no
 	MaxClients     int
 	RequirePass    string
 	Databases      int
 	ClusterMode    bool
 	Peers          []string
 	Self           string
+	ClusterRouter  string // 键的路由方式：consistent-hash (默认) 或 slot
+	ClusterPort    int    // 集群总线端口，节点之间通过它交换心跳与成员信息，0 表示使用 port + 10000
+	// 节点超过该时间（毫秒）未回复心跳时被标记为 PFAIL
+	ClusterNodeTimeout int64
+	// 为 true 时 key 不属于当前节点则返回 MOVED 由客户端重定向，否则由当前节点转发，只在 slot 路由下生效
+	ClusterRedirect bool
+	// 哈希槽的分配，节点地址到其负责的哈希槽，为空时将哈希槽平均分配给所有节点
+	ClusterSlots map[string][]SlotRange
+	// 一致性哈希环上每个权重单位对应的虚拟节点数，为 0 时每个节点只放置一个点，与早期版本的键归属相同
+	ClusterVirtualNodes int
+	// 节点在一致性哈希环上的权重，未配置的节点权重为 1
+	ClusterNodeWeights map[string]int
+	// 节点之间执行内部命令前认证使用的密钥，所有节点必须相同，为空时只接受来自集群节点所在主机的认证
+	ClusterSecret string
+	// 从节点 -> 主节点，从节点不负责哈希槽，复制主节点的数据并在主节点下线后接替它，只在 slot 路由下生效
+	ClusterReplicas map[string]string
+	MetricsAddr     string // Prometheus 指标的 HTTP 监听地址，为空时不开启
+	// 执行时间超过该值（微秒）的命令记录到慢查询日志，负数表示关闭，0 表示记录所有命令
+	SlowlogLogSlowerThan int64
+	SlowlogMaxLen        int    // 慢查询日志最多保存的记录数
+	LogLevel             string // 日志级别：debug、info、warn 或 error
+	MaxMemory            int64  // 数据占用内存的上限，单位字节，0 表示不限制
+	MaxMemoryPolicy      string // 超过 MaxMemory 时的淘汰策略
+	MaxMemorySamples     int    // 淘汰时每个数据库采样的键数
+	// 键空间通知的事件类型，NotifyKeyspace 等常量的组合，0 表示关闭
+	NotifyKeyspaceEvents int
+
+	file      string            // 加载的配置文件路径，CONFIG REWRITE 写回该文件
+	overrides map[string]string // 启动时的命令行参数，重新加载配置时仍然生效
 }
 
-// Properties holds global config properties
-var Properties *ServerProperties
+// properties holds global config properties
+// 运行时修改配置会原子地替换整个配置，读取方不会看到修改了一半的配置
+// 未调用 SetProperties 时为默认配置，导入本包不会读取文件或解析命令行参数
+var properties atomic.Pointer[ServerProperties]
 
 func init() {
+	properties.Store(Default())
+}
+
+// Properties 返回当前生效的配置，返回的配置是只读的，修改配置使用 SetParams
+func Properties() *ServerProperties {
+	return properties.Load()
+}
+
+// SetProperties 替换当前生效的配置，用于启动时使用 Load 加载的配置
+func SetProperties(props *ServerProperties) {
+	properties.Store(props)
+}
+
+// envPrefix 环境变量前缀，例如 GOLIXIR_PORT 覆盖 port
+const envPrefix = "GOLIXIR_"
+
+// defaultConfigFile 未通过 -cf 指定时尝试读取的配置文件，不存在时使用默认配置
+const defaultConfigFile = "config.yaml"
+
+// Default 返回默认配置
+func Default() *ServerProperties {
+	return &ServerProperties{
+		Bind:                 "127.0.0.1",
+		Port:                 14332,
+		AppendFilename:       "appendonly.aof",
+		AppendFsync:          FsyncEverySec,
+		MaxClients:           10000,
+		Databases:            16,
+		SlowlogLogSlowerThan: 10000,
+		SlowlogMaxLen:        128,
+		LogLevel:             "debug",
+		MaxMemoryPolicy:      PolicyNoEviction,
+		MaxMemorySamples:     5,
+		ClusterRouter:        RouterConsistentHash,
+		ClusterNodeTimeout:   15000,
+		ClusterVirtualNodes:  0,
+	}
+}
+
+// Load 依次应用默认配置、配置文件与环境变量，并校验结果
+// path 为空时不读取配置文件，文件不存在或配置不合法时返回错误
+func Load(path string) (*ServerProperties, error) {
+	return load(path, nil)
+}
+
+// LoadFromArgs 解析命令行参数后加载配置，命令行参数的优先级最高
+// -cf 指定配置文件，其余每个配置项都有同名参数，例如 -port 6399 -slowlog-max-len 256
+func LoadFromArgs(args []string) (*ServerProperties, error) {
+	fs := flag.NewFlagSet("golixir", flag.ContinueOnError)
+	confFile := fs.String("cf", defaultConfigFile, "config file")
+	for _, p := range params {
+		fs.String(p.name, "", "overrides "+p.key+" in config file")
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, errors.New("config: unexpected argument " + fs.Arg(0))
+	}
+	overrides := make(map[string]string)
+	cfSet := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "cf" {
+			cfSet = true
+			return
+		}
+		overrides[f.Name] = f.Value.String()
+	})
+	path := *confFile
+	if !cfSet {
+		// 默认配置文件可以不存在
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			path = ""
+		}
+	}
+	return load(path, overrides)
+}
 
-	confFile := flag.String("cf", "config.yaml", "local configFile")
-
-	flag.Parse()
-	// 默认 config
-	Properties = &ServerProperties{
-		Bind:       "127.0.0.1",
-		Port:       14332,
-		AppendOnly: false,
-	}
-	confF := *confFile
-
-	// 设置配置文件的名称和路径
-	viper.SetConfigName(confF[:len(confF)-5])
-	viper.SetConfigType("yaml")
-	viper.AddConfigPath(".")
-
-	// 读取配置文件
-	err := viper.ReadInConfig()
-	if err != nil {
-		log.Panic(err)
-	}
-	Properties.Port = viper.GetInt("server.port")
-	Properties.Bind = viper.GetString("server.bind")
-	Properties.Databases = viper.GetInt("server.databases")
-	Properties.AppendOnly = viper.GetBool("server.appendOnly")
-	Properties.AppendFilename = viper.GetString("server.appendFilename")
-	Properties.ClusterMode = viper.GetBool("server.clusterMode")
-	Properties.Self = viper.GetString("server.self")
-	Properties.Peers = viper.GetStringSlice("server.peers")
+func load(path string, flags map[string]string) (*ServerProperties, error) {
+	props := Default()
+	if path != "" {
+		if err := applyFile(props, path); err != nil {
+			return nil, err
+		}
+		props.file = path
+	}
+	for _, p := range params {
+		env := envPrefix + strings.ToUpper(strings.ReplaceAll(p.name, "-", "_"))
+		if value, ok := os.LookupEnv(env); ok {
+			if err := p.set(props, value); err != nil {
+				return nil, fmt.Errorf("config: invalid value %q for %s (from %s): %v", value, p.name, env, err)
+			}
+		}
+	}
+	for _, p := range params {
+		if value, ok := flags[p.name]; ok {
+			if err := p.set(props, value); err != nil {
+				return nil, fmt.Errorf("config: invalid value %q for %s (from -%s): %v", value, p.name, p.name, err)
+			}
+		}
+	}
+	if err := props.validate(); err != nil {
+		return nil, err
+	}
+	props.overrides = flags
+	return props, nil
+}
+
+// applyFile 读取 yaml 配置文件中 server 下的配置项
+func applyFile(props *ServerProperties, path string) error {
+	v := viper.New()
+	v.SetConfigFile(path)
+	v.SetConfigType("yaml")
+	if err := v.ReadInConfig(); err != nil {
+		return fmt.Errorf("config: read %s: %v", path, err)
+	}
+	for _, p := range params {
+		key := "server." + p.key
+		if !v.IsSet(key) {
+			continue
+		}
+		var value string
+		switch raw := v.Get(key).(type) {
+		case []interface{}:
+			items := make([]string, len(raw))
+			for i, item := range raw {
+				items[i] = fmt.Sprint(item)
+			}
+			value = strings.Join(items, ",")
+		case nil:
+			continue
+		default:
+			value = fmt.Sprint(raw)
+		}
+		if err := p.set(props, value); err != nil {
+			return fmt.Errorf("config: invalid value %q for %s in %s: %v", value, p.key, path, err)
+		}
+	}
+	return nil
+}
+
+// validate 校验配置项之间的约束
+func (p *ServerProperties) validate() error {
+	if p.ClusterMode && p.Self == "" {
+		return errors.New("config: self must be set when cluster mode is enabled")
+	}
+	for node := range p.ClusterSlots {
+		if p.ClusterRouter != RouterSlot {
+			return errors.New("config: clusterSlots is only supported with slot routing, set clusterRouter: slot")
+		}
+		if node != p.Self && !contains(p.Peers, node) {
+			return errors.New("config: clusterSlots assigns slots to " + node + ", which is neither self nor a peer")
+		}
+	}
+	for replica, primary := range p.ClusterReplicas {
+		if p.ClusterRouter != RouterSlot {
+			return errors.New("config: clusterReplicas is only supported with slot routing, set clusterRouter: slot")
+		}
+		for _, node := range []string{replica, primary} {
+			if node != p.Self && !contains(p.Peers, node) {
+				return errors.New("config: clusterReplicas refers to " + node + ", which is neither self nor a peer")
+			}
+		}
+		if _, ok := p.ClusterReplicas[primary]; ok || replica == primary {
+			return errors.New("config: clusterReplicas makes " + primary + " both a primary and a replica")
+		}
+		if len(p.ClusterSlots[replica]) > 0 {
+			return errors.New("config: clusterSlots assigns slots to replica " + replica)
+		}
+	}
+	if len(p.ClusterNodeWeights) > 0 && p.ClusterVirtualNodes == 0 {
+		return errors.New("config: clusterNodeWeights requires virtual nodes, set clusterVirtualNodes (e.g. 160)")
+	}
+	if p.AppendOnly && p.AppendFilename == "" {
+		return errors.New("config: appendfilename must be set when appendonly is enabled")
+	}
+	return nil
+}
+
+func contains(items []string, target string) bool {
+	for _, item := range items {
+		if item == target {
+			return true
+		}
+	}
+	return false
 }
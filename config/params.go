@@ -0,0 +1,726 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ygxiaobai111/GolixirDB/lib/wildcard"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+运行时读取与修改配置，供 CONFIG GET/SET/REWRITE 使用
+CONFIG 命令使用 redis 风格的参数名，例如 slowlog-log-slower-than，对应 config.yaml 中 server 下的 slowlogLogSlowerThan
+同名的命令行参数 -slowlog-log-slower-than 与环境变量 GOLIXIR_SLOWLOG_LOG_SLOWER_THAN 会覆盖配置文件中的值
+*/
+
+// aof 刷盘策略
+const (
+	FsyncAlways   = "always"
+	FsyncEverySec = "everysec"
+	FsyncNo       = "no"
+)
+
+// 内存淘汰策略
+const (
+	PolicyNoEviction    = "noeviction"
+	PolicyAllKeysLRU    = "allkeys-lru"
+	PolicyAllKeysLFU    = "allkeys-lfu"
+	PolicyAllKeysRandom = "allkeys-random"
+	PolicyVolatileLRU   = "volatile-lru"
+	PolicyVolatileTTL   = "volatile-ttl"
+)
+
+var maxMemoryPolicies = []string{PolicyNoEviction, PolicyAllKeysLRU, PolicyAllKeysLFU,
+	PolicyAllKeysRandom, PolicyVolatileLRU, PolicyVolatileTTL}
+
+// 集群中键的路由方式
+const (
+	RouterSlot           = "slot"            // 与 redis cluster 相同的 16384 个哈希槽
+	RouterConsistentHash = "consistent-hash" // 带虚拟节点的一致性哈希
+)
+
+// SlotRange 一段连续的哈希槽，包含 Start 与 End
+type SlotRange struct {
+	Start int
+	End   int
+}
+
+// maxSlot 最大的哈希槽编号
+const maxSlot = 16383
+
+// parseSlotRanges 解析 地址=起始槽-结束槽 形式的哈希槽分配，单个槽可以省略结束槽
+// 同一节点可以出现多次，不同节点的槽不能重叠
+func parseSlotRanges(items []string) (map[string][]SlotRange, error) {
+	result := make(map[string][]SlotRange)
+	var owner [maxSlot + 1]string
+	for _, item := range items {
+		i := strings.LastIndexByte(item, '=')
+		if i <= 0 {
+			return nil, fmt.Errorf("expect addr=start-end, got %q", item)
+		}
+		node, spec := item[:i], item[i+1:]
+		startStr, endStr := spec, spec
+		if j := strings.IndexByte(spec, '-'); j >= 0 {
+			startStr, endStr = spec[:j], spec[j+1:]
+		}
+		start, err := parseInt(startStr, 0, maxSlot)
+		if err != nil {
+			return nil, fmt.Errorf("invalid slot in %q: %v", item, err)
+		}
+		end, err := parseInt(endStr, start, maxSlot)
+		if err != nil {
+			return nil, fmt.Errorf("invalid slot in %q: %v", item, err)
+		}
+		for slot := start; slot <= end; slot++ {
+			if owner[slot] != "" && owner[slot] != node {
+				return nil, fmt.Errorf("slot %d is assigned to both %s and %s", slot, owner[slot], node)
+			}
+			owner[slot] = node
+		}
+		result[node] = append(result[node], SlotRange{Start: int(start), End: int(end)})
+	}
+	return result, nil
+}
+
+// formatSlotRanges 将哈希槽分配格式化为 地址=起始槽-结束槽 的列表
+func formatSlotRanges(slots map[string][]SlotRange) []string {
+	items := make([]string, 0, len(slots))
+	for node, ranges := range slots {
+		for _, r := range ranges {
+			item := node + "=" + strconv.Itoa(r.Start)
+			if r.End != r.Start {
+				item += "-" + strconv.Itoa(r.End)
+			}
+			items = append(items, item)
+		}
+	}
+	sort.Strings(items)
+	return items
+}
+
+// 键空间通知的事件类型，与 redis 的 notify-keyspace-events 一致
+const (
+	NotifyKeyspace = 1 << iota // K，发布到 __keyspace@<db>__:<key>
+	NotifyKeyevent             // E，发布到 __keyevent@<db>__:<event>
+	NotifyGeneric              // g，DEL、EXPIRE、RENAME 等与类型无关的命令
+	NotifyString               // $，字符串命令
+	NotifyList                 // l
+	NotifySet                  // s
+	NotifyHash                 // h
+	NotifyZSet                 // z
+	NotifyExpired              // x，键过期
+	NotifyEvicted              // e，键被淘汰
+	NotifyStream               // t
+	NotifyKeyMiss              // m，读取不存在的键
+	NotifyModule               // d
+	NotifyNew                  // n，新建键
+	// NotifyAll 对应 A，不包括 m 与 n
+	NotifyAll = NotifyGeneric | NotifyString | NotifyList | NotifySet | NotifyHash | NotifyZSet |
+		NotifyExpired | NotifyEvicted | NotifyStream | NotifyModule
+)
+
+// notifyClasses 事件类型与字符的对应关系，按输出顺序排列
+var notifyClasses = []struct {
+	flag  int
+	class byte
+}{
+	{NotifyGeneric, 'g'}, {NotifyString, '$'}, {NotifyList, 'l'}, {NotifySet, 's'},
+	{NotifyHash, 'h'}, {NotifyZSet, 'z'}, {NotifyExpired, 'x'}, {NotifyEvicted, 'e'},
+	{NotifyStream, 't'}, {NotifyModule, 'd'}, {NotifyKeyspace, 'K'}, {NotifyKeyevent, 'E'},
+	{NotifyKeyMiss, 'm'}, {NotifyNew, 'n'},
+}
+
+// parseKeyspaceEvents 将 notify-keyspace-events 的字符串解析为事件类型的组合
+func parseKeyspaceEvents(value string) (int, error) {
+	flags := 0
+outer:
+	for i := 0; i < len(value); i++ {
+		if value[i] == 'A' {
+			flags |= NotifyAll
+			continue
+		}
+		for _, c := range notifyClasses {
+			if c.class == value[i] {
+				flags |= c.flag
+				continue outer
+			}
+		}
+		return 0, errors.New("invalid event class character. Use 'Ag$lshzxeKEtmdn'")
+	}
+	return flags, nil
+}
+
+// formatKeyspaceEvents 将事件类型的组合转换为字符串
+func formatKeyspaceEvents(flags int) string {
+	var sb strings.Builder
+	if flags&NotifyAll == NotifyAll {
+		sb.WriteByte('A')
+	}
+	for _, c := range notifyClasses {
+		if flags&c.flag == 0 || (c.flag&NotifyAll != 0 && flags&NotifyAll == NotifyAll) {
+			continue
+		}
+		sb.WriteByte(c.class)
+	}
+	return sb.String()
+}
+
+// param 描述一个配置项
+type param struct {
+	name     string // CONFIG GET/SET、命令行参数使用的名称，环境变量为 GOLIXIR_ 加上大写的名称
+	key      string // config.yaml 中 server 下的键
+	isString bool   // 写回文件时是否需要加引号
+	mutable  bool   // 是否可以通过 CONFIG SET 在运行时修改
+	get      func(p *ServerProperties) string
+	set      func(p *ServerProperties, value string) error // 校验并修改配置
+}
+
+var errNotInteger = errors.New("argument couldn't be parsed into an integer")
+
+// parseInt 解析整数并校验范围
+func parseInt(value string, min, max int64) (int64, error) {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, errNotInteger
+	}
+	if n < min || n > max {
+		return 0, fmt.Errorf("argument must be between %d and %d inclusive", min, max)
+	}
+	return n, nil
+}
+
+// parseMemory 解析内存大小，支持 redis 的单位：k=1000, kb=1024, m=1000^2, mb=1024^2, g=1000^3, gb=1024^3
+func parseMemory(value string) (int64, error) {
+	units := []struct {
+		suffix string
+		mul    int64
+	}{
+		{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000}, {"b", 1},
+	}
+	lower := strings.ToLower(value)
+	mul := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(lower, u.suffix) {
+			lower = strings.TrimSuffix(lower, u.suffix)
+			mul = u.mul
+			break
+		}
+	}
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/mul {
+		return 0, errors.New("argument must be a memory value")
+	}
+	return n * mul, nil
+}
+
+// parseBool 支持 yes/no 与 true/false
+func parseBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "yes", "true", "1":
+		return true, nil
+	case "no", "false", "0":
+		return false, nil
+	}
+	return false, errors.New("argument must be 'yes' or 'no'")
+}
+
+var params = []*param{
+	{
+		name: "bind", key: "bind", isString: true,
+		get: func(p *ServerProperties) string { return p.Bind },
+		set: func(p *ServerProperties, value string) error {
+			p.Bind = value
+			return nil
+		},
+	},
+	{
+		name: "port", key: "port",
+		get: func(p *ServerProperties) string { return strconv.Itoa(p.Port) },
+		set: func(p *ServerProperties, value string) error {
+			n, err := parseInt(value, 1, 65535)
+			if err != nil {
+				return err
+			}
+			p.Port = int(n)
+			return nil
+		},
+	},
+	{
+		name: "databases", key: "databases",
+		get: func(p *ServerProperties) string { return strconv.Itoa(p.Databases) },
+		set: func(p *ServerProperties, value string) error {
+			n, err := parseInt(value, 1, math.MaxInt32)
+			if err != nil {
+				return err
+			}
+			p.Databases = int(n)
+			return nil
+		},
+	},
+	{
+		name: "appendonly", key: "appendOnly",
+		get: func(p *ServerProperties) string { return yesNo(p.AppendOnly) },
+		set: func(p *ServerProperties, value string) (err error) {
+			p.AppendOnly, err = parseBool(value)
+			return err
+		},
+	},
+	{
+		name: "appendfilename", key: "appendFilename", isString: true,
+		get: func(p *ServerProperties) string { return p.AppendFilename },
+		set: func(p *ServerProperties, value string) error {
+			p.AppendFilename = value
+			return nil
+		},
+	},
+	{
+		name: "appendfsync", key: "appendFsync", mutable: true,
+		get: func(p *ServerProperties) string { return p.AppendFsync },
+		set: func(p *ServerProperties, value string) error {
+			value = strings.ToLower(value)
+			if value != FsyncAlways && value != FsyncEverySec && value != FsyncNo {
+				return errors.New("argument(s) must be one of the following: always, everysec, no")
+			}
+			p.AppendFsync = value
+			return nil
+		},
+	},
+	{
+		name: "maxclients", key: "maxClients", mutable: true,
+		get: func(p *ServerProperties) string { return strconv.Itoa(p.MaxClients) },
+		set: func(p *ServerProperties, value string) error {
+			n, err := parseInt(value, 1, math.MaxInt32)
+			if err != nil {
+				return err
+			}
+			p.MaxClients = int(n)
+			return nil
+		},
+	},
+	{
+		name: "requirepass", key: "requirePass", isString: true, mutable: true,
+		get: func(p *ServerProperties) string { return p.RequirePass },
+		set: func(p *ServerProperties, value string) error {
+			p.RequirePass = value
+			return nil
+		},
+	},
+	{
+		name: "cluster-enabled", key: "clusterMode",
+		get: func(p *ServerProperties) string { return yesNo(p.ClusterMode) },
+		set: func(p *ServerProperties, value string) (err error) {
+			p.ClusterMode, err = parseBool(value)
+			return err
+		},
+	},
+	{
+		name: "self", key: "self", isString: true,
+		get: func(p *ServerProperties) string { return p.Self },
+		set: func(p *ServerProperties, value string) error {
+			p.Self = value
+			return nil
+		},
+	},
+	{
+		// 多个地址以逗号或空格分隔
+		name: "peers", key: "peers",
+		get: func(p *ServerProperties) string { return strings.Join(p.Peers, " ") },
+		set: func(p *ServerProperties, value string) error {
+			p.Peers = strings.FieldsFunc(value, func(r rune) bool {
+				return r == ',' || r == ' '
+			})
+			return nil
+		},
+	},
+	{
+		name: "cluster-router", key: "clusterRouter",
+		get: func(p *ServerProperties) string { return p.ClusterRouter },
+		set: func(p *ServerProperties, value string) error {
+			value = strings.ToLower(value)
+			if value != RouterSlot && value != RouterConsistentHash {
+				return errors.New("argument must be " + RouterSlot + " or " + RouterConsistentHash)
+			}
+			p.ClusterRouter = value
+			return nil
+		},
+	},
+	{
+		name: "cluster-port", key: "clusterPort",
+		get: func(p *ServerProperties) string { return strconv.Itoa(p.ClusterPort) },
+		set: func(p *ServerProperties, value string) error {
+			n, err := parseInt(value, 0, 65535)
+			if err != nil {
+				return err
+			}
+			p.ClusterPort = int(n)
+			return nil
+		},
+	},
+	{
+		name: "cluster-node-timeout", key: "clusterNodeTimeout", mutable: true,
+		get: func(p *ServerProperties) string { return strconv.FormatInt(p.ClusterNodeTimeout, 10) },
+		set: func(p *ServerProperties, value string) error {
+			n, err := parseInt(value, 100, math.MaxInt32)
+			if err != nil {
+				return err
+			}
+			p.ClusterNodeTimeout = n
+			return nil
+		},
+	},
+	{
+		name: "cluster-redirect", key: "clusterRedirect", mutable: true,
+		get: func(p *ServerProperties) string { return yesNo(p.ClusterRedirect) },
+		set: func(p *ServerProperties, value string) (err error) {
+			p.ClusterRedirect, err = parseBool(value)
+			return err
+		},
+	},
+	{
+		// 格式为 地址=起始槽-结束槽，多项以逗号或空格分隔
+		name: "cluster-slots", key: "clusterSlots",
+		get: func(p *ServerProperties) string { return strings.Join(formatSlotRanges(p.ClusterSlots), " ") },
+		set: func(p *ServerProperties, value string) error {
+			slots, err := parseSlotRanges(strings.FieldsFunc(value, func(r rune) bool {
+				return r == ',' || r == ' '
+			}))
+			if err != nil {
+				return err
+			}
+			p.ClusterSlots = slots
+			return nil
+		},
+	},
+	{
+		name: "cluster-secret", key: "clusterSecret", isString: true,
+		get: func(p *ServerProperties) string { return p.ClusterSecret },
+		set: func(p *ServerProperties, value string) error {
+			p.ClusterSecret = value
+			return nil
+		},
+	},
+	{
+		name: "cluster-virtual-nodes", key: "clusterVirtualNodes",
+		get: func(p *ServerProperties) string { return strconv.Itoa(p.ClusterVirtualNodes) },
+		set: func(p *ServerProperties, value string) error {
+			n, err := parseInt(value, 0, 4096)
+			if err != nil {
+				return err
+			}
+			p.ClusterVirtualNodes = int(n)
+			return nil
+		},
+	},
+	{
+		// 格式为 地址=权重，多项以逗号或空格分隔
+		name: "cluster-node-weights", key: "clusterNodeWeights",
+		get: func(p *ServerProperties) string {
+			items := make([]string, 0, len(p.ClusterNodeWeights))
+			for node, weight := range p.ClusterNodeWeights {
+				items = append(items, node+"="+strconv.Itoa(weight))
+			}
+			sort.Strings(items)
+			return strings.Join(items, " ")
+		},
+		set: func(p *ServerProperties, value string) error {
+			weights := make(map[string]int)
+			for _, item := range strings.FieldsFunc(value, func(r rune) bool {
+				return r == ',' || r == ' '
+			}) {
+				i := strings.LastIndexByte(item, '=')
+				if i <= 0 {
+					return fmt.Errorf("expect addr=weight, got %q", item)
+				}
+				n, err := parseInt(item[i+1:], 1, 1024)
+				if err != nil {
+					return err
+				}
+				weights[item[:i]] = int(n)
+			}
+			p.ClusterNodeWeights = weights
+			return nil
+		},
+	},
+	{
+		// 格式为 从节点地址=主节点地址，多项以逗号或空格分隔
+		name: "cluster-replicas", key: "clusterReplicas",
+		get: func(p *ServerProperties) string {
+			items := make([]string, 0, len(p.ClusterReplicas))
+			for replica, primary := range p.ClusterReplicas {
+				items = append(items, replica+"="+primary)
+			}
+			sort.Strings(items)
+			return strings.Join(items, " ")
+		},
+		set: func(p *ServerProperties, value string) error {
+			replicas := make(map[string]string)
+			for _, item := range strings.FieldsFunc(value, func(r rune) bool {
+				return r == ',' || r == ' '
+			}) {
+				i := strings.IndexByte(item, '=')
+				if i <= 0 || i == len(item)-1 {
+					return fmt.Errorf("expect replica=primary, got %q", item)
+				}
+				replicas[item[:i]] = item[i+1:]
+			}
+			p.ClusterReplicas = replicas
+			return nil
+		},
+	},
+	{
+		name: "metrics-addr", key: "metricsAddr", isString: true,
+		get: func(p *ServerProperties) string { return p.MetricsAddr },
+		set: func(p *ServerProperties, value string) error {
+			p.MetricsAddr = value
+			return nil
+		},
+	},
+	{
+		name: "slowlog-log-slower-than", key: "slowlogLogSlowerThan", mutable: true,
+		get: func(p *ServerProperties) string { return strconv.FormatInt(p.SlowlogLogSlowerThan, 10) },
+		set: func(p *ServerProperties, value string) error {
+			n, err := parseInt(value, math.MinInt64, math.MaxInt64)
+			if err != nil {
+				return err
+			}
+			p.SlowlogLogSlowerThan = n
+			return nil
+		},
+	},
+	{
+		name: "slowlog-max-len", key: "slowlogMaxLen", mutable: true,
+		get: func(p *ServerProperties) string { return strconv.Itoa(p.SlowlogMaxLen) },
+		set: func(p *ServerProperties, value string) error {
+			n, err := parseInt(value, 0, math.MaxInt32)
+			if err != nil {
+				return err
+			}
+			p.SlowlogMaxLen = int(n)
+			return nil
+		},
+	},
+	{
+		name: "loglevel", key: "logLevel", mutable: true,
+		get: func(p *ServerProperties) string { return p.LogLevel },
+		set: func(p *ServerProperties, value string) error {
+			value = strings.ToLower(value)
+			if value != "debug" && value != "info" && value != "warn" && value != "error" {
+				return errors.New("argument(s) must be one of the following: debug, info, warn, error")
+			}
+			p.LogLevel = value
+			return nil
+		},
+	},
+	{
+		name: "maxmemory", key: "maxMemory", mutable: true,
+		get: func(p *ServerProperties) string { return strconv.FormatInt(p.MaxMemory, 10) },
+		set: func(p *ServerProperties, value string) error {
+			n, err := parseMemory(value)
+			if err != nil {
+				return err
+			}
+			p.MaxMemory = n
+			return nil
+		},
+	},
+	{
+		name: "maxmemory-policy", key: "maxMemoryPolicy", mutable: true,
+		get: func(p *ServerProperties) string { return p.MaxMemoryPolicy },
+		set: func(p *ServerProperties, value string) error {
+			value = strings.ToLower(value)
+			for _, policy := range maxMemoryPolicies {
+				if value == policy {
+					p.MaxMemoryPolicy = value
+					return nil
+				}
+			}
+			return errors.New("argument(s) must be one of the following: " + strings.Join(maxMemoryPolicies, ", "))
+		},
+	},
+	{
+		name: "maxmemory-samples", key: "maxMemorySamples", mutable: true,
+		get: func(p *ServerProperties) string { return strconv.Itoa(p.MaxMemorySamples) },
+		set: func(p *ServerProperties, value string) error {
+			n, err := parseInt(value, 1, 64)
+			if err != nil {
+				return err
+			}
+			p.MaxMemorySamples = int(n)
+			return nil
+		},
+	},
+	{
+		name: "notify-keyspace-events", key: "notifyKeyspaceEvents", isString: true, mutable: true,
+		get: func(p *ServerProperties) string { return formatKeyspaceEvents(p.NotifyKeyspaceEvents) },
+		set: func(p *ServerProperties, value string) error {
+			flags, err := parseKeyspaceEvents(value)
+			if err != nil {
+				return err
+			}
+			p.NotifyKeyspaceEvents = flags
+			return nil
+		},
+	},
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+func findParam(name string) *param {
+	name = strings.ToLower(name)
+	for _, p := range params {
+		if p.name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// updateMu 保证同一时间只有一个修改配置的操作
+var updateMu sync.Mutex
+
+// GetParams 返回名称与 pattern 匹配的配置项，结果为 name value 交替排列
+func GetParams(pattern string) []string {
+	matcher := wildcard.CompilePattern(strings.ToLower(pattern))
+	current := Properties()
+	var result []string
+	for _, p := range params {
+		if matcher.IsMatch(p.name) {
+			result = append(result, p.name, p.get(current))
+		}
+	}
+	return result
+}
+
+// SetParams 修改配置，pairs 为 name value 交替排列
+// 所有配置项校验通过后才会生效，任何一项失败时不修改任何配置
+func SetParams(pairs []string) error {
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return errors.New("wrong number of arguments for 'config|set' command")
+	}
+	updateMu.Lock()
+	defer updateMu.Unlock()
+	updated := *Properties()
+	seen := make(map[string]bool)
+	for i := 0; i < len(pairs); i += 2 {
+		p := findParam(pairs[i])
+		if p == nil {
+			return errors.New("Unknown option or number of arguments for CONFIG SET - '" + pairs[i] + "'")
+		}
+		if seen[p.name] {
+			return errors.New("CONFIG SET failed (possibly related to argument '" + pairs[i] + "') - duplicate parameter")
+		}
+		seen[p.name] = true
+		if !p.mutable {
+			return errors.New("CONFIG SET failed (possibly related to argument '" + pairs[i] + "') - can't set immutable config")
+		}
+		if err := p.set(&updated, pairs[i+1]); err != nil {
+			return errors.New("CONFIG SET failed (possibly related to argument '" + pairs[i] + "') - " + err.Error())
+		}
+	}
+	notify(Properties(), &updated)
+	properties.Store(&updated)
+	return nil
+}
+
+// Rewrite 将可以在运行时修改的配置写回配置文件，保留文件中的注释与其他内容
+func Rewrite() error {
+	path := Properties().file
+	if path == "" {
+		return errors.New("The server is running without a config file")
+	}
+	updateMu.Lock()
+	defer updateMu.Unlock()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+	current := Properties()
+
+	// server 块的范围为 [start, end)
+	start, end := -1, len(lines)
+	for i, line := range lines {
+		if start < 0 {
+			if strings.TrimSpace(line) == "server:" {
+				start = i + 1
+			}
+			continue
+		}
+		if line != "" && line[0] != ' ' && line[0] != '\t' && line[0] != '#' {
+			end = i
+			break
+		}
+	}
+	if start < 0 {
+		lines = append(lines, "server:")
+		start, end = len(lines), len(lines)
+	}
+
+	var missing []string
+	for _, p := range params {
+		if !p.mutable {
+			continue
+		}
+		value := p.get(current)
+		if p.isString {
+			value = strconv.Quote(value)
+		}
+		found := false
+		for i := start; i < end; i++ {
+			trimmed := strings.TrimLeft(lines[i], " \t")
+			colon := strings.IndexByte(trimmed, ':')
+			if colon < 0 || !strings.EqualFold(trimmed[:colon], p.key) {
+				continue
+			}
+			indent := lines[i][:len(lines[i])-len(trimmed)]
+			lines[i] = indent + p.key + ": " + value
+			found = true
+			break
+		}
+		if !found {
+			missing = append(missing, "  "+p.key+": "+value)
+		}
+	}
+	if len(missing) > 0 {
+		tail := append(missing, lines[end:]...)
+		lines = append(lines[:end], tail...)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	// 先写入临时文件再重命名，避免写入中途失败导致配置文件损坏
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), info.Mode()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
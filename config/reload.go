@@ -0,0 +1,85 @@
+package config
+
+/*
+重新加载配置文件，收到 SIGHUP 时调用
+只有可以通过 CONFIG SET 修改的配置项会生效，其余配置项的变化需要重启才能生效
+*/
+
+// Change 描述重新加载时一个配置项的变化
+type Change struct {
+	Name    string
+	Old     string
+	New     string
+	Applied bool // 为 false 表示该配置项需要重启才能生效
+}
+
+// String 返回便于记录日志的描述，密码不会出现在日志中
+func (c *Change) String() string {
+	old, updated := c.Old, c.New
+	if c.Name == "requirepass" {
+		old, updated = "******", "******"
+	}
+	s := c.Name + ": " + quoteEmpty(old) + " -> " + quoteEmpty(updated)
+	if !c.Applied {
+		s += " (requires restart, ignored)"
+	}
+	return s
+}
+
+func quoteEmpty(s string) string {
+	if s == "" {
+		return `""`
+	}
+	return s
+}
+
+// listeners 配置变化时调用的函数
+var listeners []func(old, updated *ServerProperties)
+
+// OnChange 注册配置变化时调用的函数，CONFIG SET 与重新加载配置都会触发
+// 应在启动时注册，回调中不能再修改配置
+func OnChange(fn func(old, updated *ServerProperties)) {
+	updateMu.Lock()
+	defer updateMu.Unlock()
+	listeners = append(listeners, fn)
+}
+
+// notify 调用方需持有 updateMu
+func notify(old, updated *ServerProperties) {
+	for _, fn := range listeners {
+		fn(old, updated)
+	}
+}
+
+// Reload 重新读取配置文件与环境变量，启动时的命令行参数仍然优先
+// 返回所有发生变化的配置项，读取或校验失败时不修改任何配置
+// 通过 CONFIG SET 修改但没有 CONFIG REWRITE 的配置会恢复为文件中的值
+func Reload() ([]*Change, error) {
+	updateMu.Lock()
+	defer updateMu.Unlock()
+	current := Properties()
+	loaded, err := load(current.file, current.overrides)
+	if err != nil {
+		return nil, err
+	}
+	updated := *current
+	var changes []*Change
+	for _, p := range params {
+		old, value := p.get(current), p.get(loaded)
+		if old == value {
+			continue
+		}
+		change := &Change{Name: p.name, Old: old, New: value, Applied: p.mutable}
+		if p.mutable {
+			if err := p.set(&updated, value); err != nil {
+				return nil, err
+			}
+		}
+		changes = append(changes, change)
+	}
+	if len(changes) > 0 {
+		notify(current, &updated)
+		properties.Store(&updated)
+	}
+	return changes, nil
+}
@@ -0,0 +1,47 @@
+package database
+
+import (
+	"github.com/ygxiaobai111/GolixirDB/config"
+	"github.com/ygxiaobai111/GolixirDB/interface/resp"
+	"github.com/ygxiaobai111/GolixirDB/resp/reply"
+)
+
+var (
+	// NoAuthReply 设置了 requirepass 而连接尚未认证时返回
+	NoAuthReply     = reply.MakeErrReply("NOAUTH Authentication required.")
+	wrongPassReply  = reply.MakeErrReply("WRONGPASS invalid username-password pair or user is disabled.")
+	noPasswordReply = reply.MakeErrReply("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+)
+
+// IsAuthenticated 判断连接是否可以执行命令
+func IsAuthenticated(c resp.Connection) bool {
+	return config.Properties().RequirePass == "" || c.IsAuthenticated()
+}
+
+// authenticate 校验用户名与密码，成功时将连接标记为已认证
+// 目前只有 default 用户
+func authenticate(c resp.Connection, username, password string) resp.Reply {
+	requirePass := config.Properties().RequirePass
+	if username != "default" || password != requirePass {
+		return wrongPassReply
+	}
+	c.SetAuthenticated(true)
+	return nil
+}
+
+// execAuth AUTH [username] password
+func execAuth(c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 1 && len(args) != 2 {
+		return reply.MakeArgNumErrReply("auth")
+	}
+	username, password := "default", string(args[0])
+	if len(args) == 2 {
+		username, password = string(args[0]), string(args[1])
+	} else if config.Properties().RequirePass == "" {
+		return noPasswordReply
+	}
+	if errReply := authenticate(c, username, password); errReply != nil {
+		return errReply
+	}
+	return reply.MakeOkReply()
+}
@@ -0,0 +1,216 @@
+package database
+
+import (
+	"github.com/ygxiaobai111/GolixirDB/interface/database"
+	"github.com/ygxiaobai111/GolixirDB/interface/resp"
+	"github.com/ygxiaobai111/GolixirDB/resp/reply"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/*
+大键与热键分析，用于排查内存或 CPU 异常的节点：
+BIGKEYS [CURSOR cursor] [COUNT count] [SAMPLES samples]  按类型统计键数与内存占用，并列出每种类型内存占用最大的 count 个键
+HOTKEYS [CURSOR cursor] [COUNT count] [SAMPLES samples]  列出访问频率最高的 count 个键，访问频率为 LFU 计数，与淘汰策略无关
+两个命令与 SCAN 使用相同的游标，从 cursor 开始按 SCAN 的方式分批遍历当前数据库，检查大约 samples 个键后停止，
+samples 为 0 时遍历到最后，回复中的 cursor 为下一次调用的游标，为 0 表示遍历结束。
+每次调用只统计本次遍历的键，golixir-cli --bigkeys / --hotkeys 每次检查一批键后合并各次的结果，不会长时间占用服务器
+*/
+
+const (
+	defaultBigKeysCount = 5
+	defaultHotKeysCount = 10
+	analysisBatch       = 1000 // 每次 SCAN 遍历的键数
+)
+
+// keyStat 一个键的统计信息
+type keyStat struct {
+	key   string
+	score int64 // 排序依据，BIGKEYS 为字节数，HOTKEYS 为访问频率
+	idle  time.Duration
+}
+
+// topKeys 保存得分最高的 n 个键，按得分从高到低排列
+type topKeys struct {
+	n     int
+	items []keyStat
+}
+
+func (t *topKeys) add(stat keyStat) {
+	if len(t.items) == t.n && stat.score <= t.items[len(t.items)-1].score {
+		return
+	}
+	i := sort.Search(len(t.items), func(i int) bool {
+		return t.items[i].score < stat.score
+	})
+	if len(t.items) < t.n {
+		t.items = append(t.items, keyStat{})
+	}
+	copy(t.items[i+1:], t.items[i:])
+	t.items[i] = stat
+}
+
+// analysisArgs BIGKEYS 与 HOTKEYS 的参数
+type analysisArgs struct {
+	cursor  int
+	count   int
+	samples int
+}
+
+// parseAnalysisArgs 解析 [CURSOR cursor] [COUNT count] [SAMPLES samples]
+func parseAnalysisArgs(args [][]byte, count int) (*analysisArgs, resp.Reply) {
+	result := &analysisArgs{count: count}
+	if len(args)%2 != 0 {
+		return nil, reply.MakeSyntaxErrReply()
+	}
+	for i := 0; i < len(args); i += 2 {
+		n, err := strconv.Atoi(string(args[i+1]))
+		if err != nil || n < 0 {
+			return nil, reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		switch strings.ToLower(string(args[i])) {
+		case "cursor":
+			if n > ScanBuckets {
+				n = ScanBuckets
+			}
+			result.cursor = n
+		case "count":
+			if n == 0 {
+				return nil, reply.MakeSyntaxErrReply()
+			}
+			result.count = n
+		case "samples":
+			result.samples = n
+		default:
+			return nil, reply.MakeSyntaxErrReply()
+		}
+	}
+	return result, nil
+}
+
+// scanLiveKeys 从 cursor 开始按 SCAN 的方式分批遍历未过期的键，不更新键的访问信息，
+// 遍历大约 samples 个键后停止，samples 为 0 时遍历到最后。返回遍历的键数与下一次遍历的游标
+func (db *DB) scanLiveKeys(cursor int, samples int, consumer func(key string, entity *database.DataEntity)) (int, int) {
+	scanned := 0
+	for cursor < ScanBuckets {
+		batch := analysisBatch
+		if samples > 0 && samples-scanned < batch {
+			batch = samples - scanned
+		}
+		cursor = db.data.Scan(cursor, batch, func(key string, val interface{}) bool {
+			entity, _ := val.(*database.DataEntity)
+			if entity == nil || db.IsExpired(key) {
+				return true
+			}
+			consumer(key, entity)
+			scanned++
+			return true
+		})
+		if cursor == 0 || (samples > 0 && scanned >= samples) {
+			break
+		}
+	}
+	if cursor >= ScanBuckets {
+		cursor = 0
+	}
+	return scanned, cursor
+}
+
+// execBigKeys BIGKEYS [CURSOR cursor] [COUNT count] [SAMPLES samples]
+func execBigKeys(db *DB, args [][]byte) resp.Reply {
+	opts, errReply := parseAnalysisArgs(args, defaultBigKeysCount)
+	if errReply != nil {
+		return errReply
+	}
+	type typeStat struct {
+		keys    int64
+		bytes   int64
+		biggest *topKeys
+	}
+	types := make(map[string]*typeStat)
+	scanned, cursor := db.scanLiveKeys(opts.cursor, opts.samples, func(key string, entity *database.DataEntity) {
+		typ := entityType(entity.Data)
+		if typ == "" {
+			typ = "unknown"
+		}
+		stat, ok := types[typ]
+		if !ok {
+			stat = &typeStat{biggest: &topKeys{n: opts.count}}
+			types[typ] = stat
+		}
+		size := atomic.LoadInt64(&entity.Size)
+		stat.keys++
+		stat.bytes += size
+		stat.biggest.add(keyStat{key: key, score: size})
+	})
+
+	names := make([]string, 0, len(types))
+	for typ := range types {
+		names = append(names, typ)
+	}
+	sort.Strings(names)
+	result := reply.MakeMapReply(nil, nil)
+	result.Add(reply.MakeBulkReply([]byte("cursor")), reply.MakeBulkReply([]byte(strconv.Itoa(cursor))))
+	result.Add(reply.MakeBulkReply([]byte("scanned")), reply.MakeIntReply(int64(scanned)))
+	for _, typ := range names {
+		stat := types[typ]
+		biggest := make([]resp.Reply, 0, len(stat.biggest.items))
+		for _, item := range stat.biggest.items {
+			biggest = append(biggest, reply.MakeMultiRawReply([]resp.Reply{
+				reply.MakeBulkReply([]byte(item.key)),
+				reply.MakeIntReply(item.score),
+			}))
+		}
+		typeReply := reply.MakeMapReply(nil, nil)
+		typeReply.Add(reply.MakeBulkReply([]byte("keys")), reply.MakeIntReply(stat.keys))
+		typeReply.Add(reply.MakeBulkReply([]byte("bytes")), reply.MakeIntReply(stat.bytes))
+		typeReply.Add(reply.MakeBulkReply([]byte("biggest")), reply.MakeMultiRawReply(biggest))
+		result.Add(reply.MakeBulkReply([]byte(typ)), typeReply)
+	}
+	return result
+}
+
+// execHotKeys HOTKEYS [CURSOR cursor] [COUNT count] [SAMPLES samples]
+// 每个键返回 key、访问频率与空闲秒数，访问频率相同时空闲时间短的在前
+func execHotKeys(db *DB, args [][]byte) resp.Reply {
+	opts, errReply := parseAnalysisArgs(args, defaultHotKeysCount)
+	if errReply != nil {
+		return errReply
+	}
+	hottest := &topKeys{n: opts.count}
+	scanned, cursor := db.scanLiveKeys(opts.cursor, opts.samples, func(key string, entity *database.DataEntity) {
+		freq := int64(lfuDecr(atomic.LoadUint32(&entity.LFU)))
+		if freq == 0 {
+			return
+		}
+		hottest.add(keyStat{key: key, score: freq, idle: idleTime(entity)})
+	})
+	sort.SliceStable(hottest.items, func(i, j int) bool {
+		a, b := hottest.items[i], hottest.items[j]
+		if a.score != b.score {
+			return a.score > b.score
+		}
+		return a.idle < b.idle
+	})
+	keys := make([]resp.Reply, 0, len(hottest.items))
+	for _, item := range hottest.items {
+		keys = append(keys, reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte(item.key)),
+			reply.MakeIntReply(item.score),
+			reply.MakeIntReply(int64(item.idle / time.Second)),
+		}))
+	}
+	result := reply.MakeMapReply(nil, nil)
+	result.Add(reply.MakeBulkReply([]byte("cursor")), reply.MakeBulkReply([]byte(strconv.Itoa(cursor))))
+	result.Add(reply.MakeBulkReply([]byte("scanned")), reply.MakeIntReply(int64(scanned)))
+	result.Add(reply.MakeBulkReply([]byte("hotkeys")), reply.MakeMultiRawReply(keys))
+	return result
+}
+
+func init() {
+	RegisterCommand("BigKeys", execBigKeys, -1, flagReadOnly)
+	RegisterCommand("HotKeys", execHotKeys, -1, flagReadOnly)
+}
@@ -1,25 +1,72 @@
 package database
 
 import (
+	"github.com/ygxiaobai111/GolixirDB/resp/reply"
 	"strings" // 引入字符串处理包
 )
 
 // cmdTable 是一个映射，用于存储所有注册的命令
 var cmdTable = make(map[string]*command)
 
+// 命令标志
+const (
+	flagWrite    = 1 << iota // 写命令，会修改数据
+	flagReadOnly             // 只读命令
+	flagDenyOOM              // 可能增加内存占用，超过 maxmemory 且无法淘汰时拒绝执行
+)
+
 // command 结构体定义了一个数据库命令
 type command struct {
 	executor ExecFunc // 命令执行函数
 	arity    int      // 允许的参数数量，arity < 0 表示其为可变参数但是(len(args) >= -arity )
+	flags    int      // 命令标志
 }
 
 // RegisterCommand 注册一个新命令
 // arity 表示允许的命令参数数量，arity < 0 表示 len(args) >= -arity。
 // 例如：`get`命令的arity为2，`mget`命令的arity为-2
-func RegisterCommand(name string, executor ExecFunc, arity int) {
+// flags 为 flagWrite、flagReadOnly 等标志的组合
+func RegisterCommand(name string, executor ExecFunc, arity int, flags int) {
 	name = strings.ToLower(name) // 将命令名转换为小写
 	cmdTable[name] = &command{
 		executor: executor, // 设置执行函数
 		arity:    arity,    // 设置参数数量
+		flags:    flags,    // 设置命令标志
+	}
+}
+
+// IsWriteCommand 判断命令是否会修改数据
+func IsWriteCommand(name string) bool {
+	name = strings.ToLower(name)
+	if name == "flushall" {
+		// 由 StandaloneDatabase 直接处理，不在 cmdTable 中
+		return true
+	}
+	cmd, ok := cmdTable[name]
+	if !ok {
+		return false
+	}
+	return cmd.flags&flagWrite > 0
+}
+
+// CheckCommand 检查命令是否存在以及参数数量是否正确，不执行命令，用于 MULTI 中排队前的校验
+func CheckCommand(cmdLine [][]byte) reply.ErrorReply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	cmd, ok := cmdTable[cmdName]
+	if !ok {
+		return reply.MakeErrReply("ERR unknown command '" + cmdName + "'")
+	}
+	if !validateArity(cmd.arity, cmdLine) {
+		return reply.MakeArgNumErrReply(cmdName)
+	}
+	return nil
+}
+
+// isDenyOOMCommand 判断命令在内存不足时是否应被拒绝
+func isDenyOOMCommand(name string) bool {
+	cmd, ok := cmdTable[name]
+	if !ok {
+		return false
 	}
+	return cmd.flags&flagDenyOOM > 0
 }
@@ -0,0 +1,61 @@
+package database
+
+import (
+	"github.com/ygxiaobai111/GolixirDB/config"
+	"github.com/ygxiaobai111/GolixirDB/interface/resp"
+	util "github.com/ygxiaobai111/GolixirDB/lib/logger"
+	"github.com/ygxiaobai111/GolixirDB/resp/reply"
+	"strings"
+)
+
+// execConfig CONFIG GET pattern [pattern ...] | SET parameter value [parameter value ...] | REWRITE
+func execConfig(db *DB, args [][]byte) resp.Reply {
+	sub := strings.ToLower(string(args[0]))
+	args = args[1:]
+	switch sub {
+	case "get":
+		if len(args) == 0 {
+			return reply.MakeArgNumErrReply("config|get")
+		}
+		result := reply.MakeMapReply(nil, nil)
+		seen := make(map[string]bool)
+		for _, pattern := range args {
+			pairs := config.GetParams(string(pattern))
+			for i := 0; i < len(pairs); i += 2 {
+				if seen[pairs[i]] {
+					continue
+				}
+				seen[pairs[i]] = true
+				result.Add(reply.MakeBulkReply([]byte(pairs[i])), reply.MakeBulkReply([]byte(pairs[i+1])))
+			}
+		}
+		return result
+	case "set":
+		if len(args) == 0 || len(args)%2 != 0 {
+			return reply.MakeArgNumErrReply("config|set")
+		}
+		pairs := make([]string, len(args))
+		for i, arg := range args {
+			pairs[i] = string(arg)
+		}
+		if err := config.SetParams(pairs); err != nil {
+			return reply.MakeErrReply("ERR " + err.Error())
+		}
+		return reply.MakeOkReply()
+	case "rewrite":
+		if len(args) != 0 {
+			return reply.MakeArgNumErrReply("config|rewrite")
+		}
+		if err := config.Rewrite(); err != nil {
+			util.LogrusObj.Warn("CONFIG REWRITE failed: " + err.Error())
+			return reply.MakeErrReply("ERR Rewriting config file: " + err.Error())
+		}
+		util.LogrusObj.Info("CONFIG REWRITE executed with success.")
+		return reply.MakeOkReply()
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + sub + "'. Try CONFIG GET, CONFIG SET, CONFIG REWRITE.")
+}
+
+func init() {
+	RegisterCommand("Config", execConfig, -2, 0)
+}
@@ -2,19 +2,30 @@
 package database
 
 import (
+	"github.com/ygxiaobai111/GolixirDB/config"
 	"github.com/ygxiaobai111/GolixirDB/datastruct/dict"
 	"github.com/ygxiaobai111/GolixirDB/interface/database"
 	"github.com/ygxiaobai111/GolixirDB/interface/resp"
+	"github.com/ygxiaobai111/GolixirDB/lib/utils"
+	"github.com/ygxiaobai111/GolixirDB/pubsub"
 	"github.com/ygxiaobai111/GolixirDB/resp/reply"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 // DB stores data and execute user's commands
 type DB struct {
 	index int
 	// key -> DataEntity
-	data   dict.Dict          //接口
-	addAof func(line CmdLine) //命令落盘
+	data dict.Dict //接口
+	// key -> expireTime (time.Time)
+	ttlMap dict.Dict
+	// 所有键值的近似内存占用，单位字节，使用 sync/atomic 访问
+	usedMemory int64
+	addAof     func(line CmdLine) //命令落盘
+	// 用于发布键空间通知，与所在的 StandaloneDatabase 共用
+	hub *pubsub.Hub
 }
 
 // ExecFunc is interface for command executor
@@ -27,7 +38,8 @@ type CmdLine = [][]byte
 // makeDB create DB instance
 func makeDB() *DB {
 	db := &DB{
-		data: dict.MakeSyncDict(),
+		data:   dict.MakeConcurrentDict(),
+		ttlMap: dict.MakeSyncDict(),
 		addAof: func(line CmdLine) {
 			/*
 				因为一开始要加载aof文件中的数据，
@@ -63,41 +75,97 @@ func validateArity(arity int, cmdArgs [][]byte) bool {
 /* ---- data Access ----- */
 
 // GetEntity returns DataEntity bind to given key
+// 会更新键的访问信息并计入 keyspace 命中统计
 func (db *DB) GetEntity(key string) (*database.DataEntity, bool) {
+	entity, ok := db.peekEntity(key)
+	if !ok {
+		atomic.AddInt64(&stats.keyspaceMisses, 1)
+		return nil, false
+	}
+	atomic.AddInt64(&stats.keyspaceHits, 1)
+	touchEntity(entity)
+	return entity, true
+}
 
+// peekEntity 与 GetEntity 相同但不更新访问信息，供内存淘汰与 OBJECT 等命令使用
+func (db *DB) peekEntity(key string) (*database.DataEntity, bool) {
 	raw, ok := db.data.Get(key)
 	if !ok {
 		return nil, false
 	}
+	if db.expireIfNeeded(key) {
+		return nil, false
+	}
 	entity, _ := raw.(*database.DataEntity)
 	return entity, true
 }
 
+// entitySize 返回原有实体的内存占用，不存在时为 0
+func entitySize(raw interface{}, exists bool) int64 {
+	if !exists {
+		return 0
+	}
+	entity, _ := raw.(*database.DataEntity)
+	if entity == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&entity.Size)
+}
+
 // PutEntity a DataEntity into DB
 func (db *DB) PutEntity(key string, entity *database.DataEntity) int {
-	return db.data.Put(key, entity)
+	initEntity(key, entity)
+	old, existed := db.data.Get(key)
+	result := db.data.Put(key, entity)
+	atomic.AddInt64(&db.usedMemory, entity.Size-entitySize(old, existed))
+	if !existed {
+		db.notify(config.NotifyNew, "new", key)
+	}
+	return result
 }
 
 // PutIfExists edit an existing DataEntity
 func (db *DB) PutIfExists(key string, entity *database.DataEntity) int {
-	return db.data.PutIfExists(key, entity)
+	old, existed := db.peekEntity(key)
+	if !existed {
+		return 0
+	}
+	initEntity(key, entity)
+	result := db.data.PutIfExists(key, entity)
+	if result > 0 {
+		atomic.AddInt64(&db.usedMemory, entity.Size-entitySize(old, true))
+	}
+	return result
 }
 
 // PutIfAbsent insert an DataEntity only if the key not exists
 func (db *DB) PutIfAbsent(key string, entity *database.DataEntity) int {
-	return db.data.PutIfAbsent(key, entity)
+	// 已过期的键视为不存在
+	db.expireIfNeeded(key)
+	initEntity(key, entity)
+	result := db.data.PutIfAbsent(key, entity)
+	if result > 0 {
+		atomic.AddInt64(&db.usedMemory, entity.Size)
+		db.notify(config.NotifyNew, "new", key)
+	}
+	return result
 }
 
 // Remove the given key from db
 func (db *DB) Remove(key string) {
+	old, existed := db.data.Get(key)
 	db.data.Remove(key)
+	db.ttlMap.Remove(key)
+	if existed {
+		atomic.AddInt64(&db.usedMemory, -entitySize(old, existed))
+	}
 }
 
 // Removes the given keys from db
 func (db *DB) Removes(keys ...string) (deleted int) {
 	deleted = 0
 	for _, key := range keys {
-		_, exists := db.data.Get(key)
+		_, exists := db.peekEntity(key)
 		if exists {
 			db.Remove(key)
 			deleted++
@@ -109,5 +177,48 @@ func (db *DB) Removes(keys ...string) (deleted int) {
 // Flush clean database
 func (db *DB) Flush() {
 	db.data.Clear()
+	db.ttlMap.Clear()
+	atomic.StoreInt64(&db.usedMemory, 0)
+}
+
+/* ---- TTL Functions ---- */
+
+// Expire sets ttlCmd of key
+func (db *DB) Expire(key string, expireTime time.Time) {
+	db.ttlMap.Put(key, expireTime)
+}
+
+// Persist cancel ttlCmd of key
+func (db *DB) Persist(key string) bool {
+	return db.ttlMap.Remove(key) > 0
+}
+
+// expireTime 返回键的过期时间，没有设置过期时间时第二个返回值为 false
+func (db *DB) expireTime(key string) (time.Time, bool) {
+	raw, ok := db.ttlMap.Get(key)
+	if !ok {
+		return time.Time{}, false
+	}
+	return raw.(time.Time), true
+}
+
+// IsExpired check whether a key is expired
+func (db *DB) IsExpired(key string) bool {
+	expireTime, ok := db.expireTime(key)
+	if !ok {
+		return false
+	}
+	return !time.Now().Before(expireTime)
+}
 
+// expireIfNeeded 键已过期时将其删除并返回 true，删除操作以 del 命令写入 aof
+func (db *DB) expireIfNeeded(key string) bool {
+	if !db.IsExpired(key) {
+		return false
+	}
+	db.Remove(key)
+	atomic.AddInt64(&stats.expiredKeys, 1)
+	db.addAof(utils.ToCmdLine("del", key))
+	db.notify(config.NotifyExpired, "expired", key)
+	return true
 }
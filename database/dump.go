@@ -0,0 +1,257 @@
+package database
+
+import (
+	"encoding/binary"
+	"github.com/ygxiaobai111/GolixirDB/config"
+	"github.com/ygxiaobai111/GolixirDB/interface/database"
+	"github.com/ygxiaobai111/GolixirDB/interface/resp"
+	"github.com/ygxiaobai111/GolixirDB/lib/utils"
+	"github.com/ygxiaobai111/GolixirDB/resp/reply"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/*
+DUMP 与 RESTORE
+序列化格式与 redis 相同：值的类型（1 字节）+ RDB 编码的值 + RDB 版本（2 字节，小端）+ CRC64（8 字节，小端）
+目前只有字符串类型，与 redis 之间可以互相 DUMP/RESTORE 字符串
+集群迁移键时也使用该格式在节点之间传输键值
+*/
+
+const (
+	rdbTypeString = 0
+	rdbVersion    = 9  // 写入的 RDB 版本，redis 5.0 及以上都能识别
+	rdbMaxVersion = 12 // RESTORE 接受的最大 RDB 版本
+
+	rdbEncInt8  = 0
+	rdbEncInt16 = 1
+	rdbEncInt32 = 2
+)
+
+var errBadPayload = reply.MakeErrReply("ERR DUMP payload version or checksum are wrong")
+
+// crc64Table redis 使用的 CRC-64-Jones，反转多项式 0x95ac9329ac4bc9b5，初始值与结果异或值均为 0
+var crc64Table = func() *[256]uint64 {
+	table := new([256]uint64)
+	for i := 0; i < 256; i++ {
+		crc := uint64(i)
+		for j := 0; j < 8; j++ {
+			if crc&1 == 1 {
+				crc = crc>>1 ^ 0x95ac9329ac4bc9b5
+			} else {
+				crc >>= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+func crc64(data []byte) uint64 {
+	var crc uint64
+	for _, b := range data {
+		crc = crc64Table[byte(crc)^b] ^ crc>>8
+	}
+	return crc
+}
+
+// appendRDBLength 按 RDB 的长度编码写入长度
+func appendRDBLength(buf []byte, n int) []byte {
+	switch {
+	case n < 1<<6:
+		return append(buf, byte(n))
+	case n < 1<<14:
+		return append(buf, byte(n>>8)|0x40, byte(n))
+	default:
+		buf = append(buf, 0x80)
+		return binary.BigEndian.AppendUint32(buf, uint32(n))
+	}
+}
+
+// serializeValue 将值序列化为 DUMP 格式
+func serializeValue(data interface{}) ([]byte, bool) {
+	value, ok := data.([]byte)
+	if !ok {
+		return nil, false
+	}
+	buf := make([]byte, 0, len(value)+16)
+	buf = append(buf, rdbTypeString)
+	buf = appendRDBLength(buf, len(value))
+	buf = append(buf, value...)
+	buf = binary.LittleEndian.AppendUint16(buf, rdbVersion)
+	buf = binary.LittleEndian.AppendUint64(buf, crc64(buf))
+	return buf, true
+}
+
+// deserializeValue 解析 DUMP 格式的值，格式错误或校验失败时返回 false
+func deserializeValue(payload []byte) (interface{}, bool) {
+	if len(payload) < 11 {
+		return nil, false
+	}
+	footer := len(payload) - 10
+	version := binary.LittleEndian.Uint16(payload[footer:])
+	checksum := binary.LittleEndian.Uint64(payload[footer+2:])
+	if version > rdbMaxVersion || (checksum != 0 && checksum != crc64(payload[:footer+2])) {
+		return nil, false
+	}
+	body := payload[:footer]
+	if body[0] != rdbTypeString {
+		return nil, false
+	}
+	value, rest, ok := readRDBString(body[1:])
+	if !ok || len(rest) != 0 {
+		return nil, false
+	}
+	return value, true
+}
+
+// readRDBString 读取 RDB 编码的字符串，支持整数编码，不支持 LZF 压缩
+func readRDBString(buf []byte) ([]byte, []byte, bool) {
+	if len(buf) == 0 {
+		return nil, nil, false
+	}
+	var n int
+	switch buf[0] >> 6 {
+	case 0:
+		n, buf = int(buf[0]&0x3F), buf[1:]
+	case 1:
+		if len(buf) < 2 {
+			return nil, nil, false
+		}
+		n, buf = int(buf[0]&0x3F)<<8|int(buf[1]), buf[2:]
+	case 2:
+		if buf[0] != 0x80 || len(buf) < 5 {
+			return nil, nil, false
+		}
+		n, buf = int(binary.BigEndian.Uint32(buf[1:])), buf[5:]
+	default:
+		var value int64
+		switch buf[0] & 0x3F {
+		case rdbEncInt8:
+			if len(buf) < 2 {
+				return nil, nil, false
+			}
+			value, buf = int64(int8(buf[1])), buf[2:]
+		case rdbEncInt16:
+			if len(buf) < 3 {
+				return nil, nil, false
+			}
+			value, buf = int64(int16(binary.LittleEndian.Uint16(buf[1:]))), buf[3:]
+		case rdbEncInt32:
+			if len(buf) < 5 {
+				return nil, nil, false
+			}
+			value, buf = int64(int32(binary.LittleEndian.Uint32(buf[1:]))), buf[5:]
+		default:
+			return nil, nil, false
+		}
+		return []byte(strconv.FormatInt(value, 10)), buf, true
+	}
+	if len(buf) < n {
+		return nil, nil, false
+	}
+	return buf[:n], buf[n:], true
+}
+
+// execDump DUMP key
+func execDump(db *DB, args [][]byte) resp.Reply {
+	entity, exists := db.GetEntity(string(args[0]))
+	if !exists {
+		return reply.MakeNullBulkReply()
+	}
+	payload, ok := serializeValue(entity.Data)
+	if !ok {
+		return reply.MakeErrReply("ERR unsupported value type")
+	}
+	return reply.MakeBulkReply(payload)
+}
+
+// execRestore RESTORE key ttl serialized-value [REPLACE] [ABSTTL] [IDLETIME seconds] [FREQ frequency]
+func execRestore(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	ttl, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if ttl < 0 {
+		return reply.MakeErrReply("ERR Invalid TTL value, must be >= 0")
+	}
+	replace, absTTL := false, false
+	idle, freq := int64(-1), int64(-1)
+	for i := 3; i < len(args); i++ {
+		opt := strings.ToUpper(string(args[i]))
+		switch {
+		case opt == "REPLACE":
+			replace = true
+		case opt == "ABSTTL":
+			absTTL = true
+		case (opt == "IDLETIME" || opt == "FREQ") && i+1 < len(args) && idle < 0 && freq < 0:
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if opt == "IDLETIME" {
+				if n < 0 {
+					return reply.MakeErrReply("ERR Invalid IDLETIME value, must be >= 0")
+				}
+				idle = n
+			} else {
+				if n < 0 || n > 255 {
+					return reply.MakeErrReply("ERR Invalid FREQ value, must be >= 0 and <= 255")
+				}
+				freq = n
+			}
+			i++
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	if _, exists := db.peekEntity(key); exists && !replace {
+		return reply.MakeErrReply("BUSYKEY Target key name already exists.")
+	}
+	data, ok := deserializeValue(args[2])
+	if !ok {
+		return errBadPayload
+	}
+	var expireAt time.Time
+	if ttl > 0 {
+		if absTTL {
+			expireAt = time.Unix(0, ttl*int64(time.Millisecond))
+		} else {
+			expireAt = time.Now().Add(time.Duration(ttl) * time.Millisecond)
+		}
+		if !time.Now().Before(expireAt) {
+			// 与 redis 相同，已过期的键不写入
+			if db.Removes(key) > 0 {
+				db.addAof(utils.ToCmdLine("del", key))
+				db.notify(config.NotifyGeneric, "del", key)
+			}
+			return reply.MakeOkReply()
+		}
+	}
+
+	entity := &database.DataEntity{Data: data}
+	db.Remove(key)
+	db.PutEntity(key, entity)
+	if idle >= 0 {
+		last := time.Now().Add(-time.Duration(idle) * time.Second)
+		atomic.StoreInt64(&entity.LastAccess, last.UnixNano()/int64(time.Millisecond))
+	}
+	if freq >= 0 {
+		atomic.StoreUint32(&entity.LFU, nowMinutes()<<8|uint32(freq))
+	}
+	db.addAof(utils.ToCmdLine("set", key, string(data.([]byte))))
+	if ttl > 0 {
+		db.Expire(key, expireAt)
+		db.addAof(makeExpireCmd(key, expireAt))
+	}
+	db.notify(config.NotifyGeneric, "restore", key)
+	return reply.MakeOkReply()
+}
+
+func init() {
+	RegisterCommand("Dump", execDump, 2, flagReadOnly)
+	RegisterCommand("Restore", execRestore, -4, flagWrite|flagDenyOOM)
+}
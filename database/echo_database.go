@@ -22,7 +22,7 @@ func (e EchoDatabase) AfterClientClose(c resp.Connection) {
 	util.LogrusObj.Info("EchoDatabase AfterClientClose")
 }
 
-func (e EchoDatabase) Close() {
+func (e EchoDatabase) Close(save bool) error {
 	util.LogrusObj.Info("EchoDatabase Close")
-
+	return nil
 }
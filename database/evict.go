@@ -0,0 +1,201 @@
+package database
+
+import (
+	"github.com/ygxiaobai111/GolixirDB/config"
+	"github.com/ygxiaobai111/GolixirDB/interface/database"
+	"github.com/ygxiaobai111/GolixirDB/lib/utils"
+	"github.com/ygxiaobai111/GolixirDB/resp/reply"
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+内存淘汰
+每个键的内存占用在写入时估算并累加到 DB.usedMemory，超过 maxmemory 时执行写命令前按 maxmemory-policy 淘汰键：
+noeviction      不淘汰，拒绝会增加内存的写命令
+allkeys-lru     在所有键中淘汰最久未访问的
+allkeys-lfu     在所有键中淘汰访问频率最低的
+allkeys-random  在所有键中随机淘汰
+volatile-lru    在设置了过期时间的键中淘汰最久未访问的
+volatile-ttl    在设置了过期时间的键中淘汰最先过期的
+与 redis 相同，淘汰采用近似算法：每轮在每个数据库中随机采样 maxmemory-samples 个键，淘汰其中得分最高的
+*/
+
+const (
+	// entryOverhead 每个键在字典与 DataEntity 中的固定开销，单位字节
+	entryOverhead = 64
+
+	lfuInitVal     = 5  // 新键的访问计数，避免刚写入的键马上被淘汰
+	lfuLogFactor   = 10 // 计数增长的对数因子，越大增长越慢
+	lfuDecayMinute = 1  // 每经过多少分钟计数减一
+)
+
+var oomReply = reply.MakeErrReply("OOM command not allowed when used memory > 'maxmemory'.")
+
+// evictMu 保证同一时间只有一个协程在淘汰键
+var evictMu sync.Mutex
+
+// sizeOf 估算键值的内存占用
+func sizeOf(key string, data interface{}) int64 {
+	size := int64(entryOverhead + len(key))
+	switch val := data.(type) {
+	case []byte:
+		size += int64(len(val))
+	}
+	return size
+}
+
+// nowMinutes 返回以分钟计的当前时间的低 16 位，用于 LFU 衰减
+func nowMinutes() uint32 {
+	return uint32(time.Now().Unix()/60) & 0xFFFF
+}
+
+// initEntity 计算实体的内存占用，新实体同时初始化访问信息
+func initEntity(key string, entity *database.DataEntity) {
+	entity.Size = sizeOf(key, entity.Data)
+	if atomic.LoadInt64(&entity.LastAccess) == 0 {
+		atomic.StoreInt64(&entity.LastAccess, time.Now().UnixNano()/int64(time.Millisecond))
+		atomic.StoreUint32(&entity.LFU, nowMinutes()<<8|lfuInitVal)
+	}
+}
+
+// lfuDecr 返回按距离上次衰减经过的时间衰减后的访问计数
+func lfuDecr(lfu uint32) uint32 {
+	ldt := lfu >> 8
+	counter := lfu & 0xFF
+	now := nowMinutes()
+	var elapsed uint32
+	if now >= ldt {
+		elapsed = now - ldt
+	} else {
+		elapsed = 0xFFFF - ldt + now
+	}
+	periods := elapsed / lfuDecayMinute
+	if periods >= counter {
+		return 0
+	}
+	return counter - periods
+}
+
+// lfuIncr 以对数概率增加访问计数，计数越大越难增长，最大为 255
+func lfuIncr(counter uint32) uint32 {
+	if counter == 255 {
+		return 255
+	}
+	base := float64(0)
+	if counter > lfuInitVal {
+		base = float64(counter - lfuInitVal)
+	}
+	if rand.Float64() < 1.0/(base*lfuLogFactor+1) {
+		counter++
+	}
+	return counter
+}
+
+// touchEntity 记录一次访问，更新最近访问时间与 LFU 计数
+func touchEntity(entity *database.DataEntity) {
+	atomic.StoreInt64(&entity.LastAccess, time.Now().UnixNano()/int64(time.Millisecond))
+	old := atomic.LoadUint32(&entity.LFU)
+	counter := lfuIncr(lfuDecr(old))
+	// 并发访问时只需有一次更新成功
+	atomic.CompareAndSwapUint32(&entity.LFU, old, nowMinutes()<<8|counter)
+}
+
+// idleTime 返回键距离上次访问经过的时间
+func idleTime(entity *database.DataEntity) time.Duration {
+	last := atomic.LoadInt64(&entity.LastAccess)
+	idle := time.Now().UnixNano()/int64(time.Millisecond) - last
+	if idle < 0 {
+		idle = 0
+	}
+	return time.Duration(idle) * time.Millisecond
+}
+
+// usedMemory 返回所有数据库中键值的近似内存占用
+func (mdb *StandaloneDatabase) usedMemory() int64 {
+	var used int64
+	for _, db := range mdb.dbSet {
+		used += atomic.LoadInt64(&db.usedMemory)
+	}
+	return used
+}
+
+// evictCandidate 一轮采样中得分最高的键
+type evictCandidate struct {
+	db    *DB
+	key   string
+	score float64
+}
+
+// sampleCandidate 在数据库中采样并返回得分最高的键，得分越高越先被淘汰
+func (db *DB) sampleCandidate(policy string, samples int, best *evictCandidate) {
+	var keys []string
+	switch policy {
+	case config.PolicyVolatileLRU, config.PolicyVolatileTTL:
+		keys = db.ttlMap.RandomDistinctKeys(samples)
+	default:
+		keys = db.data.RandomDistinctKeys(samples)
+	}
+	for _, key := range keys {
+		raw, ok := db.data.Get(key)
+		if !ok {
+			continue
+		}
+		entity, _ := raw.(*database.DataEntity)
+		if entity == nil {
+			continue
+		}
+		var score float64
+		switch policy {
+		case config.PolicyAllKeysLRU, config.PolicyVolatileLRU:
+			score = float64(idleTime(entity))
+		case config.PolicyAllKeysLFU:
+			score = float64(255 - lfuDecr(atomic.LoadUint32(&entity.LFU)))
+		case config.PolicyVolatileTTL:
+			expireTime, ok := db.expireTime(key)
+			if !ok {
+				continue
+			}
+			// 越早过期得分越高
+			score = math.MaxInt64 - float64(expireTime.UnixNano())
+		default:
+			score = rand.Float64()
+		}
+		if best.db == nil || score > best.score {
+			best.db = db
+			best.key = key
+			best.score = score
+		}
+	}
+}
+
+// freeMemoryIfNeeded 内存占用超过 maxmemory 时按淘汰策略删除键
+// 无法释放足够的内存时返回 false
+func (mdb *StandaloneDatabase) freeMemoryIfNeeded() bool {
+	props := config.Properties()
+	if props.MaxMemory <= 0 || mdb.usedMemory() <= props.MaxMemory {
+		return true
+	}
+	if props.MaxMemoryPolicy == config.PolicyNoEviction {
+		return false
+	}
+	evictMu.Lock()
+	defer evictMu.Unlock()
+	for mdb.usedMemory() > props.MaxMemory {
+		best := &evictCandidate{}
+		for _, db := range mdb.dbSet {
+			db.sampleCandidate(props.MaxMemoryPolicy, props.MaxMemorySamples, best)
+		}
+		if best.db == nil {
+			return false
+		}
+		best.db.Remove(best.key)
+		best.db.addAof(utils.ToCmdLine("del", best.key))
+		atomic.AddInt64(&stats.evictedKeys, 1)
+		best.db.notify(config.NotifyEvicted, "evicted", best.key)
+	}
+	return true
+}
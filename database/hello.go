@@ -0,0 +1,86 @@
+package database
+
+import (
+	"github.com/ygxiaobai111/GolixirDB/config"
+	"github.com/ygxiaobai111/GolixirDB/interface/resp"
+	"github.com/ygxiaobai111/GolixirDB/resp/reply"
+	"strconv"
+	"strings"
+)
+
+// golixirVersion 当前服务端版本，通过 HELLO 与 INFO 返回给客户端
+const golixirVersion = "1.0.0"
+
+// execHello 协商连接使用的协议版本并返回服务器信息
+// HELLO [protover [AUTH username password] [SETNAME clientname]]
+func execHello(c resp.Connection, args [][]byte) resp.Reply {
+	protocol := c.GetProtocol()
+	name := c.GetName()
+	if len(args) > 0 {
+		ver, err := strconv.Atoi(string(args[0]))
+		if err != nil {
+			return reply.MakeErrReply("ERR Protocol version is not an integer or out of range")
+		}
+		if ver != reply.Resp2 && ver != reply.Resp3 {
+			return reply.MakeErrReply("NOPROTO unsupported protocol version")
+		}
+		protocol = ver
+	}
+	var username, password string
+	hasAuth := false
+	for i := 1; i < len(args); i++ {
+		opt := strings.ToLower(string(args[i]))
+		if opt == "auth" && i+2 < len(args) {
+			username, password = string(args[i+1]), string(args[i+2])
+			hasAuth = true
+			i += 2
+			continue
+		}
+		if opt == "setname" && i+1 < len(args) {
+			name = string(args[i+1])
+			if !IsValidClientName(name) {
+				return reply.MakeErrReply("ERR Client names cannot contain spaces, newlines or special characters.")
+			}
+			i++
+			continue
+		}
+		return reply.MakeErrReply("ERR Syntax error in HELLO option '" + string(args[i]) + "'")
+	}
+	if hasAuth {
+		if errReply := authenticate(c, username, password); errReply != nil {
+			return errReply
+		}
+	}
+	if !IsAuthenticated(c) {
+		return reply.MakeErrReply("NOAUTH HELLO must be called with the client already authenticated, " +
+			"otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client " +
+			"and select the RESP protocol version at the same time")
+	}
+	c.SetProtocol(protocol)
+	c.SetName(name)
+
+	mode := "standalone"
+	if config.Properties().ClusterMode {
+		mode = "cluster"
+	}
+	result := reply.MakeStringMapReply(
+		"server", "golixir",
+		"version", golixirVersion,
+	)
+	result.Add(reply.MakeBulkReply([]byte("id")), reply.MakeIntReply(int64(c.GetID())))
+	result.Add(reply.MakeBulkReply([]byte("proto")), reply.MakeIntReply(int64(protocol)))
+	result.Add(reply.MakeBulkReply([]byte("mode")), reply.MakeBulkReply([]byte(mode)))
+	result.Add(reply.MakeBulkReply([]byte("role")), reply.MakeBulkReply([]byte("master")))
+	result.Add(reply.MakeBulkReply([]byte("modules")), &reply.EmptyMultiBulkReply{})
+	return result
+}
+
+// IsValidClientName 客户端名称不能包含空格、换行等特殊字符，空字符串表示清除名称
+func IsValidClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,289 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/ygxiaobai111/GolixirDB/config"
+	"github.com/ygxiaobai111/GolixirDB/interface/resp"
+	"github.com/ygxiaobai111/GolixirDB/resp/reply"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/*
+INFO [section [section ...]]
+字段名与 redis 保持一致，已有的 redis 监控面板与告警规则可以直接使用
+*/
+
+// infoSections 默认输出的段落及顺序
+var infoSections = []string{"server", "clients", "memory", "persistence", "stats", "replication", "cluster", "keyspace"}
+
+// runID 每次启动随机生成，用于区分不同的运行实例
+var runID = makeRunID()
+
+// peakMemory 记录观察到的最大内存占用
+var peakMemory uint64
+
+func makeRunID() string {
+	buf := make([]byte, 20)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// infoBuilder 用于拼接 INFO 的文本输出
+type infoBuilder struct {
+	sb strings.Builder
+}
+
+func (b *infoBuilder) section(name string) {
+	if b.sb.Len() > 0 {
+		b.sb.WriteString("\r\n")
+	}
+	b.sb.WriteString("# " + name + "\r\n")
+}
+
+func (b *infoBuilder) field(name string, value interface{}) {
+	b.sb.WriteString(fmt.Sprintf("%s:%v\r\n", name, value))
+}
+
+// humanBytes 将字节数转换为 redis 风格的可读形式
+func humanBytes(n uint64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.2fG", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.2fM", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.2fK", float64(n)/(1<<10))
+	}
+	return strconv.FormatUint(n, 10) + "B"
+}
+
+func execInfo(mdb *StandaloneDatabase, args [][]byte) resp.Reply {
+	sections := infoSections
+	if len(args) > 0 {
+		sections = make([]string, 0, len(args))
+		for _, arg := range args {
+			switch name := strings.ToLower(string(arg)); name {
+			case "default":
+				sections = append(sections, infoSections...)
+			case "all", "everything":
+				sections = append(sections, infoSections...)
+				sections = append(sections, "commandstats")
+			default:
+				sections = append(sections, name)
+			}
+		}
+	}
+	b := &infoBuilder{}
+	for _, section := range sections {
+		switch section {
+		case "server":
+			mdb.infoServer(b)
+		case "clients":
+			mdb.infoClients(b)
+		case "memory":
+			mdb.infoMemory(b)
+		case "persistence":
+			mdb.infoPersistence(b)
+		case "stats":
+			mdb.infoStats(b)
+		case "replication":
+			mdb.infoReplication(b)
+		case "cluster":
+			mdb.infoCluster(b)
+		case "keyspace":
+			mdb.infoKeyspace(b)
+		case "commandstats":
+			mdb.infoCommandStats(b)
+		}
+	}
+	return reply.MakeVerbatimStringReply("txt", b.sb.String())
+}
+
+func (mdb *StandaloneDatabase) infoServer(b *infoBuilder) {
+	mode := "standalone"
+	if config.Properties().ClusterMode {
+		mode = "cluster"
+	}
+	uptime := time.Since(stats.startTime)
+	executable, _ := os.Executable()
+	b.section("Server")
+	b.field("redis_version", golixirVersion)
+	b.field("golixir_version", golixirVersion)
+	b.field("redis_mode", mode)
+	b.field("os", runtime.GOOS+" "+runtime.GOARCH)
+	b.field("arch_bits", strconv.IntSize)
+	b.field("go_version", runtime.Version())
+	b.field("process_id", os.Getpid())
+	b.field("run_id", runID)
+	b.field("tcp_port", config.Properties().Port)
+	b.field("server_time_usec", time.Now().UnixMicro())
+	b.field("uptime_in_seconds", int64(uptime.Seconds()))
+	b.field("uptime_in_days", int64(uptime.Hours()/24))
+	b.field("executable", executable)
+}
+
+func (mdb *StandaloneDatabase) infoClients(b *infoBuilder) {
+	b.section("Clients")
+	b.field("connected_clients", ConnectedClients())
+	b.field("maxclients", config.Properties().MaxClients)
+	b.field("blocked_clients", 0)
+}
+
+func (mdb *StandaloneDatabase) infoMemory(b *infoBuilder) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	used := ms.HeapAlloc
+	for {
+		peak := atomic.LoadUint64(&peakMemory)
+		if used <= peak || atomic.CompareAndSwapUint64(&peakMemory, peak, used) {
+			break
+		}
+	}
+	peak := atomic.LoadUint64(&peakMemory)
+	b.section("Memory")
+	b.field("used_memory", used)
+	b.field("used_memory_human", humanBytes(used))
+	b.field("used_memory_rss", ms.Sys)
+	b.field("used_memory_rss_human", humanBytes(ms.Sys))
+	b.field("used_memory_peak", peak)
+	b.field("used_memory_peak_human", humanBytes(peak))
+	b.field("total_system_memory", 0)
+	b.field("used_memory_dataset", mdb.usedMemory())
+	b.field("maxmemory", config.Properties().MaxMemory)
+	b.field("maxmemory_human", humanBytes(uint64(config.Properties().MaxMemory)))
+	b.field("maxmemory_policy", config.Properties().MaxMemoryPolicy)
+	b.field("mem_allocator", "go")
+	b.field("gc_cycles", ms.NumGC)
+}
+
+func (mdb *StandaloneDatabase) infoPersistence(b *infoBuilder) {
+	loading := 0
+	if mdb.loading.Get() {
+		loading = 1
+	}
+	b.section("Persistence")
+	b.field("loading", loading)
+	if mdb.aofHandler == nil {
+		b.field("aof_enabled", 0)
+		b.field("aof_rewrite_in_progress", 0)
+		b.field("aof_last_bgrewrite_status", "ok")
+		b.field("aof_last_write_status", "ok")
+		return
+	}
+	writeStatus := "ok"
+	if !mdb.aofHandler.LastWriteOK() {
+		writeStatus = "err"
+	}
+	b.field("aof_enabled", 1)
+	b.field("aof_rewrite_in_progress", 0)
+	b.field("aof_rewrite_scheduled", 0)
+	b.field("aof_last_rewrite_time_sec", -1)
+	b.field("aof_last_bgrewrite_status", "ok")
+	b.field("aof_last_write_status", writeStatus)
+	b.field("aof_current_size", mdb.aofHandler.CurrentSize())
+	b.field("aof_buffer_length", mdb.aofHandler.BufferLength())
+}
+
+func (mdb *StandaloneDatabase) infoStats(b *infoBuilder) {
+	b.section("Stats")
+	b.field("total_connections_received", atomic.LoadInt64(&stats.connectionsReceived))
+	b.field("total_commands_processed", atomic.LoadInt64(&stats.totalCommands))
+	b.field("instantaneous_ops_per_sec", atomic.LoadInt64(&stats.opsPerSec))
+	b.field("rejected_connections", 0)
+	b.field("keyspace_hits", atomic.LoadInt64(&stats.keyspaceHits))
+	b.field("keyspace_misses", atomic.LoadInt64(&stats.keyspaceMisses))
+	b.field("expired_keys", atomic.LoadInt64(&stats.expiredKeys))
+	b.field("evicted_keys", atomic.LoadInt64(&stats.evictedKeys))
+}
+
+// ReplicationInfo INFO replication 展示的复制状态，集群模式下由集群层通过 SetReplicationInfo 提供
+type ReplicationInfo struct {
+	MasterAddr   string // 主节点地址，为空表示当前节点是主节点
+	MasterLinkUp bool
+	Offset       int64 // 主节点为已产生的复制偏移量，从节点为已执行的偏移量
+	Slaves       []SlaveInfo
+}
+
+// SlaveInfo 主节点上每个从节点的复制状态
+type SlaveInfo struct {
+	Addr   string
+	State  string // wait_bgsave 表示正在全量同步，online 表示正在接收写命令
+	Offset int64
+}
+
+// SetReplicationInfo 设置 INFO replication 的数据来源
+func (mdb *StandaloneDatabase) SetReplicationInfo(fn func() ReplicationInfo) {
+	mdb.replInfo.Store(fn)
+}
+
+func (mdb *StandaloneDatabase) infoReplication(b *infoBuilder) {
+	var info ReplicationInfo
+	if fn, ok := mdb.replInfo.Load().(func() ReplicationInfo); ok {
+		info = fn()
+	}
+	b.section("Replication")
+	if info.MasterAddr == "" {
+		b.field("role", "master")
+	} else {
+		host, port, _ := net.SplitHostPort(info.MasterAddr)
+		status := "down"
+		if info.MasterLinkUp {
+			status = "up"
+		}
+		b.field("role", "slave")
+		b.field("master_host", host)
+		b.field("master_port", port)
+		b.field("master_link_status", status)
+		b.field("slave_repl_offset", info.Offset)
+	}
+	b.field("connected_slaves", len(info.Slaves))
+	for i, slave := range info.Slaves {
+		host, port, _ := net.SplitHostPort(slave.Addr)
+		b.field("slave"+strconv.Itoa(i), fmt.Sprintf("ip=%s,port=%s,state=%s,offset=%d,lag=0", host, port, slave.State, slave.Offset))
+	}
+	b.field("master_replid", runID)
+	b.field("master_repl_offset", info.Offset)
+}
+
+func (mdb *StandaloneDatabase) infoCluster(b *infoBuilder) {
+	enabled := 0
+	if config.Properties().ClusterMode {
+		enabled = 1
+	}
+	b.section("Cluster")
+	b.field("cluster_enabled", enabled)
+}
+
+func (mdb *StandaloneDatabase) infoKeyspace(b *infoBuilder) {
+	b.section("Keyspace")
+	for _, db := range mdb.dbSet {
+		keys := db.data.Len()
+		if keys == 0 {
+			continue
+		}
+		expires, avgTTL := db.ttlStats()
+		b.field("db"+strconv.Itoa(db.index), fmt.Sprintf("keys=%d,expires=%d,avg_ttl=%d", keys, expires, avgTTL))
+	}
+}
+
+func (mdb *StandaloneDatabase) infoCommandStats(b *infoBuilder) {
+	b.section("Commandstats")
+	names, cmdStats := commandStatsSnapshot()
+	for i, name := range names {
+		cs := cmdStats[i]
+		perCall := 0.0
+		if cs.calls > 0 {
+			perCall = float64(cs.usec) / float64(cs.calls)
+		}
+		b.field("cmdstat_"+name, fmt.Sprintf("calls=%d,usec=%d,usec_per_call=%.2f,rejected_calls=%d,failed_calls=%d",
+			cs.calls, cs.usec, perCall, cs.rejectedCalls, cs.failedCalls))
+	}
+}
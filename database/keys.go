@@ -1,10 +1,15 @@
 package database
 
 import (
+	"github.com/ygxiaobai111/GolixirDB/config"
+	"github.com/ygxiaobai111/GolixirDB/datastruct/dict"
+	"github.com/ygxiaobai111/GolixirDB/interface/database"
 	"github.com/ygxiaobai111/GolixirDB/interface/resp"
 	"github.com/ygxiaobai111/GolixirDB/lib/utils"
 	"github.com/ygxiaobai111/GolixirDB/lib/wildcard"
 	"github.com/ygxiaobai111/GolixirDB/resp/reply"
+	"strconv"
+	"strings"
 )
 
 // execDel 删除数据库中的一个或多个键
@@ -14,7 +19,13 @@ func execDel(db *DB, args [][]byte) resp.Reply {
 		keys[i] = string(v)
 	}
 
-	deleted := db.Removes(keys...)
+	deleted := 0
+	for _, key := range keys {
+		if db.Removes(key) > 0 {
+			deleted++
+			db.notify(config.NotifyGeneric, "del", key)
+		}
+	}
 	if deleted > 0 {
 		db.addAof(utils.ToCmdLine2("del", args...))
 	}
@@ -42,6 +53,38 @@ func execFlushDB(db *DB, args [][]byte) resp.Reply {
 	return &reply.OkReply{}
 }
 
+// execFlushAll 清空所有数据库的数据，所有数据库共用一条 flushall 记录
+func execFlushAll(mdb *StandaloneDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	for _, db := range mdb.dbSet {
+		db.Flush()
+	}
+	mdb.dbSet[c.GetDBIndex()].addAof(utils.ToCmdLine2("flushall", args...))
+	return &reply.OkReply{}
+}
+
+// execDBSize 返回当前数据库的键数量，包括已过期但尚未删除的键
+func execDBSize(db *DB, args [][]byte) resp.Reply {
+	return reply.MakeIntReply(int64(db.data.Len()))
+}
+
+// execRandomKey 随机返回当前数据库中的一个未过期的键，数据库为空时返回 nil
+func execRandomKey(db *DB, args [][]byte) resp.Reply {
+	// 随机取样若干次，跳过已过期的键
+	for i := 0; i < randomKeyAttempts; i++ {
+		keys := db.data.RandomKeys(1)
+		if len(keys) == 0 {
+			break
+		}
+		if !db.IsExpired(keys[0]) {
+			return reply.MakeBulkReply([]byte(keys[0]))
+		}
+	}
+	return reply.MakeNullBulkReply()
+}
+
+// randomKeyAttempts RANDOMKEY 取样的最多次数
+const randomKeyAttempts = 100
+
 // execType 返回给定键的数据类型
 // 包括：string, list, hash, set 和 zset
 func execType(db *DB, args [][]byte) resp.Reply {
@@ -50,11 +93,20 @@ func execType(db *DB, args [][]byte) resp.Reply {
 	if !exists {
 		return reply.MakeStatusReply("none")
 	}
-	switch entity.Data.(type) {
+	typ := entityType(entity.Data)
+	if typ == "" {
+		return &reply.UnknownErrReply{}
+	}
+	return reply.MakeStatusReply(typ)
+}
+
+// entityType 返回值的类型名称，未知类型返回空字符串
+func entityType(data interface{}) string {
+	switch data.(type) {
 	case []byte:
-		return reply.MakeStatusReply("string")
+		return "string"
 	}
-	return &reply.UnknownErrReply{}
+	return ""
 }
 
 // execRename 重命名一个键
@@ -69,9 +121,20 @@ func execRename(db *DB, args [][]byte) resp.Reply {
 	if !ok {
 		return reply.MakeErrReply("no such key")
 	}
+	if src == dest {
+		return &reply.OkReply{}
+	}
+	expireAt, hasTTL := db.expireTime(src)
+	db.Remove(dest)
 	db.PutEntity(dest, entity)
 	db.Remove(src)
+	// 过期时间随键一起转移
+	if hasTTL {
+		db.Expire(dest, expireAt)
+	}
 	db.addAof(utils.ToCmdLine2("rename", args...))
+	db.notify(config.NotifyGeneric, "rename_from", src)
+	db.notify(config.NotifyGeneric, "rename_to", dest)
 
 	return &reply.OkReply{}
 }
@@ -90,9 +153,15 @@ func execRenameNx(db *DB, args [][]byte) resp.Reply {
 	if !ok {
 		return reply.MakeErrReply("no such key")
 	}
+	expireAt, hasTTL := db.expireTime(src)
 	db.Removes(src, dest) // 清除源键和目标键及其相关的时间生存期
 	db.PutEntity(dest, entity)
+	if hasTTL {
+		db.Expire(dest, expireAt)
+	}
 	db.addAof(utils.ToCmdLine2("renamenx", args...))
+	db.notify(config.NotifyGeneric, "rename_from", src)
+	db.notify(config.NotifyGeneric, "rename_to", dest)
 
 	return reply.MakeIntReply(1)
 }
@@ -102,7 +171,7 @@ func execKeys(db *DB, args [][]byte) resp.Reply {
 	pattern := wildcard.CompilePattern(string(args[0]))
 	result := make([][]byte, 0)
 	db.data.ForEach(func(key string, val interface{}) bool {
-		if pattern.IsMatch(key) {
+		if pattern.IsMatch(key) && !db.IsExpired(key) {
 			result = append(result, []byte(key))
 		}
 		return true
@@ -110,13 +179,92 @@ func execKeys(db *DB, args [][]byte) resp.Reply {
 	return reply.MakeMultiBulkReply(result)
 }
 
+// ScanBuckets SCAN 游标的取值范围，键按哈希值分到固定数量的分片中，游标为下一次扫描的起始分片
+const ScanBuckets = dict.ShardCount
+
+// scan 从 cursor 号分片开始扫描大约 count 个键，返回其中满足条件的键与下一次扫描的游标，游标为 0 表示扫描结束
+// 每次只访问游标之后的分片，分片的数量固定，因此扫描期间一直存在的键一定会被返回且只返回一次
+func (db *DB) scan(cursor int, count int, pattern *wildcard.Pattern, typ string) ([]string, int) {
+	if cursor >= ScanBuckets {
+		return nil, 0
+	}
+	keys := make([]string, 0, count)
+	next := db.data.Scan(cursor, count, func(key string, val interface{}) bool {
+		if db.IsExpired(key) {
+			return true
+		}
+		if pattern != nil && !pattern.IsMatch(key) {
+			return true
+		}
+		if typ != "" {
+			entity, _ := val.(*database.DataEntity)
+			if entity == nil || entityType(entity.Data) != typ {
+				return true
+			}
+		}
+		keys = append(keys, key)
+		return true
+	})
+	return keys, next
+}
+
+// execScan SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+func execScan(db *DB, args [][]byte) resp.Reply {
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR invalid cursor")
+	}
+	count := 10
+	var pattern *wildcard.Pattern
+	typ := ""
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return reply.MakeSyntaxErrReply()
+		}
+		opt := strings.ToLower(string(args[i]))
+		val := string(args[i+1])
+		switch opt {
+		case "match":
+			pattern = wildcard.CompilePattern(val)
+		case "count":
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				return reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if n < 1 {
+				return reply.MakeSyntaxErrReply()
+			}
+			count = n
+		case "type":
+			typ = strings.ToLower(val)
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	if cursor > ScanBuckets {
+		cursor = ScanBuckets
+	}
+	keys, next := db.scan(int(cursor), count, pattern, typ)
+	result := make([][]byte, len(keys))
+	for i, key := range keys {
+		result[i] = []byte(key)
+	}
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte(strconv.Itoa(next))),
+		reply.MakeMultiBulkReply(result),
+	})
+}
+
 func init() {
 	// 在初始化时注册数据库支持的命令
-	RegisterCommand("Del", execDel, -2)
-	RegisterCommand("Exists", execExists, -2)
-	RegisterCommand("Keys", execKeys, 2)
-	RegisterCommand("FlushDB", execFlushDB, -1)
-	RegisterCommand("Type", execType, 2)
-	RegisterCommand("Rename", execRename, 3)
-	RegisterCommand("RenameNx", execRenameNx, 3)
+	RegisterCommand("Del", execDel, -2, flagWrite)
+	RegisterCommand("Exists", execExists, -2, flagReadOnly)
+	RegisterCommand("Keys", execKeys, 2, flagReadOnly)
+	RegisterCommand("Scan", execScan, -2, flagReadOnly)
+	RegisterCommand("FlushDB", execFlushDB, -1, flagWrite)
+	RegisterCommand("DBSize", execDBSize, 1, flagReadOnly)
+	RegisterCommand("RandomKey", execRandomKey, 1, flagReadOnly)
+	RegisterCommand("Type", execType, 2, flagReadOnly)
+	RegisterCommand("Rename", execRename, 3, flagWrite)
+	RegisterCommand("RenameNx", execRenameNx, 3, flagWrite)
 }
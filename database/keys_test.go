@@ -0,0 +1,141 @@
+package database
+
+import (
+	"github.com/ygxiaobai111/GolixirDB/lib/utils"
+	"github.com/ygxiaobai111/GolixirDB/resp/reply"
+	"strconv"
+	"testing"
+)
+
+// scanOnce 执行一次 SCAN，返回下一次的游标与本次返回的键
+func scanOnce(t *testing.T, db *DB, args ...string) (string, []string) {
+	result, ok := db.Exec(nil, utils.ToCmdLine(append([]string{"scan"}, args...)...)).(*reply.MultiRawReply)
+	if !ok || len(result.Replies) != 2 {
+		t.Fatalf("unexpected SCAN reply for %v", args)
+	}
+	cursor := string(result.Replies[0].(*reply.BulkReply).Arg)
+	var keys []string
+	for _, key := range result.Replies[1].(*reply.MultiBulkReply).Args {
+		keys = append(keys, string(key))
+	}
+	return cursor, keys
+}
+
+// scanAll 从游标 0 开始遍历到游标为 0，返回每个键被返回的次数
+func scanAll(t *testing.T, db *DB, opts ...string) map[string]int {
+	seen := make(map[string]int)
+	cursor := "0"
+	for {
+		next, keys := scanOnce(t, db, append([]string{cursor}, opts...)...)
+		for _, key := range keys {
+			seen[key]++
+		}
+		if next == "0" {
+			return seen
+		}
+		n, err := strconv.Atoi(next)
+		if err != nil || n >= ScanBuckets {
+			t.Fatalf("invalid cursor %q", next)
+		}
+		cursor = next
+	}
+}
+
+func TestScan(t *testing.T) {
+	db := makeDB()
+	const n = 3000
+	for i := 0; i < n; i++ {
+		db.Exec(nil, utils.ToCmdLine("set", "str:"+strconv.Itoa(i), "v"))
+	}
+	seen := scanAll(t, db, "count", "100")
+	if len(seen) != n {
+		t.Fatalf("expected %d keys, got %d", n, len(seen))
+	}
+	for key, times := range seen {
+		if times != 1 {
+			t.Fatalf("key %s returned %d times", key, times)
+		}
+	}
+
+	matched := scanAll(t, db, "match", "str:1*", "count", "50")
+	for i := 0; i < n; i++ {
+		key := "str:" + strconv.Itoa(i)
+		if want := key[4] == '1'; want != (matched[key] == 1) {
+			t.Fatalf("MATCH str:1* returned %s %d times", key, matched[key])
+		}
+	}
+	if len(matched) != 1111 {
+		t.Fatalf("expected 1111 keys matching str:1*, got %d", len(matched))
+	}
+
+	if strs := scanAll(t, db, "type", "string"); len(strs) != n {
+		t.Fatalf("expected %d strings, got %d", n, len(strs))
+	}
+	if lists := scanAll(t, db, "type", "list"); len(lists) != 0 {
+		t.Fatalf("expected no lists, got %d", len(lists))
+	}
+}
+
+// TestScanDuringWrites 遍历期间一直存在的键一定被返回且只返回一次
+func TestScanDuringWrites(t *testing.T) {
+	db := makeDB()
+	const n = 2000
+	for i := 0; i < n; i++ {
+		db.Exec(nil, utils.ToCmdLine("set", "stable:"+strconv.Itoa(i), "v"))
+		db.Exec(nil, utils.ToCmdLine("set", "removed:"+strconv.Itoa(i), "v"))
+	}
+	seen := make(map[string]int)
+	cursor, round := "0", 0
+	for {
+		next, keys := scanOnce(t, db, cursor, "count", "20")
+		for _, key := range keys {
+			seen[key]++
+		}
+		if next == "0" {
+			break
+		}
+		for i := 0; i < 20; i++ {
+			db.Exec(nil, utils.ToCmdLine("set", "added:"+strconv.Itoa(round*20+i), "v"))
+			db.Exec(nil, utils.ToCmdLine("del", "removed:"+strconv.Itoa(round*20+i)))
+		}
+		cursor = next
+		round++
+	}
+	for i := 0; i < n; i++ {
+		if times := seen["stable:"+strconv.Itoa(i)]; times != 1 {
+			t.Fatalf("key stable:%d returned %d times", i, times)
+		}
+	}
+	for key, times := range seen {
+		if times != 1 {
+			t.Fatalf("key %s returned %d times", key, times)
+		}
+	}
+}
+
+func TestScanCursor(t *testing.T) {
+	db := makeDB()
+	db.Exec(nil, utils.ToCmdLine("set", "a", "v"))
+	db.Exec(nil, utils.ToCmdLine("set", "b", "v"))
+
+	// 游标超过范围时与遍历结束相同
+	for _, cursor := range []string{strconv.Itoa(ScanBuckets), "18446744073709551615"} {
+		next, keys := scanOnce(t, db, cursor)
+		if next != "0" || len(keys) != 0 {
+			t.Fatalf("cursor %s: expected an empty final batch, got cursor %s and %v", cursor, next, keys)
+		}
+	}
+	if r := db.Exec(nil, utils.ToCmdLine("scan", "-1")); !reply.IsErrorReply(r) {
+		t.Fatal("expected an error for a negative cursor")
+	}
+	if r := db.Exec(nil, utils.ToCmdLine("scan", "0", "count", "0")); !reply.IsErrorReply(r) {
+		t.Fatal("expected an error for COUNT 0")
+	}
+
+	// 已过期的键不会被返回
+	db.Exec(nil, utils.ToCmdLine("pexpireat", "a", "1"))
+	seen := scanAll(t, db)
+	if len(seen) != 1 || seen["b"] != 1 {
+		t.Fatalf("expected only b, got %v", seen)
+	}
+}
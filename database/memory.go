@@ -0,0 +1,117 @@
+package database
+
+import (
+	"github.com/ygxiaobai111/GolixirDB/interface/resp"
+	"github.com/ygxiaobai111/GolixirDB/resp/reply"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+/*
+MEMORY USAGE key [SAMPLES count] | STATS
+内存占用为写入时估算的近似值，包括键、值以及每个键的固定开销，用于排查大键
+*/
+
+// expireEntryOverhead 每个过期时间记录在 ttlMap 中的固定开销，单位字节
+const expireEntryOverhead = 40
+
+// execMemory MEMORY 命令需要访问所有数据库，由 StandaloneDatabase 直接处理
+func execMemory(mdb *StandaloneDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		return reply.MakeArgNumErrReply("memory")
+	}
+	sub := strings.ToLower(string(args[0]))
+	switch sub {
+	case "usage":
+		return execMemoryUsage(mdb.dbSet[c.GetDBIndex()], args[1:])
+	case "stats":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply("memory|stats")
+		}
+		return mdb.memoryStats()
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + sub + "'. Try MEMORY USAGE, MEMORY STATS.")
+}
+
+// execMemoryUsage 返回键占用的字节数，键不存在时返回 nil
+// 目前只有字符串类型，大小在写入时已精确计算，SAMPLES 参数仅做校验
+func execMemoryUsage(db *DB, args [][]byte) resp.Reply {
+	if len(args) != 1 && len(args) != 3 {
+		return reply.MakeArgNumErrReply("memory|usage")
+	}
+	if len(args) == 3 {
+		if strings.ToLower(string(args[1])) != "samples" {
+			return reply.MakeSyntaxErrReply()
+		}
+		if n, err := strconv.ParseInt(string(args[2]), 10, 64); err != nil || n < 0 {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+	}
+	key := string(args[0])
+	entity, exists := db.peekEntity(key)
+	if !exists {
+		return reply.MakeNullBulkReply()
+	}
+	size := atomic.LoadInt64(&entity.Size)
+	if _, hasTTL := db.expireTime(key); hasTTL {
+		size += expireEntryOverhead
+	}
+	return reply.MakeIntReply(size)
+}
+
+// memoryStats 统计数据与开销的内存占用，字段名与 redis 保持一致
+func (mdb *StandaloneDatabase) memoryStats() resp.Reply {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	total := int64(ms.HeapAlloc)
+	peak := int64(atomic.LoadUint64(&peakMemory))
+	if total > peak {
+		peak = total
+	}
+
+	result := reply.MakeMapReply(nil, nil)
+	addInt := func(name string, value int64) {
+		result.Add(reply.MakeBulkReply([]byte(name)), reply.MakeIntReply(value))
+	}
+	addInt("peak.allocated", peak)
+	addInt("total.allocated", total)
+
+	var keys, overhead, used int64
+	for _, db := range mdb.dbSet {
+		dbKeys := int64(db.data.Len())
+		if dbKeys == 0 {
+			continue
+		}
+		mainOverhead := dbKeys * entryOverhead
+		expiresOverhead := int64(db.ttlMap.Len()) * expireEntryOverhead
+		keys += dbKeys
+		overhead += mainOverhead + expiresOverhead
+		used += atomic.LoadInt64(&db.usedMemory) + expiresOverhead
+		dbStats := reply.MakeMapReply(nil, nil)
+		dbStats.Add(reply.MakeBulkReply([]byte("overhead.hashtable.main")), reply.MakeIntReply(mainOverhead))
+		dbStats.Add(reply.MakeBulkReply([]byte("overhead.hashtable.expires")), reply.MakeIntReply(expiresOverhead))
+		result.Add(reply.MakeBulkReply([]byte("db."+strconv.Itoa(db.index))), dbStats)
+	}
+	dataset := used - overhead
+	addInt("overhead.total", overhead)
+	addInt("keys.count", keys)
+	bytesPerKey := int64(0)
+	if keys > 0 {
+		bytesPerKey = used / keys
+	}
+	addInt("keys.bytes-per-key", bytesPerKey)
+	addInt("dataset.bytes", dataset)
+	datasetPercentage := float64(0)
+	if total > 0 {
+		datasetPercentage = float64(dataset) * 100 / float64(total)
+	}
+	result.Add(reply.MakeBulkReply([]byte("dataset.percentage")), reply.MakeDoubleReply(datasetPercentage))
+	peakPercentage := float64(0)
+	if peak > 0 {
+		peakPercentage = float64(total) * 100 / float64(peak)
+	}
+	result.Add(reply.MakeBulkReply([]byte("peak.percentage")), reply.MakeDoubleReply(peakPercentage))
+	return result
+}
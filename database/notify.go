@@ -0,0 +1,28 @@
+package database
+
+import (
+	"github.com/ygxiaobai111/GolixirDB/config"
+	"strconv"
+)
+
+/*
+键空间通知
+notify-keyspace-events 开启对应的事件类型后，修改键时发布两条消息：
+__keyspace@<db>__:<key>   消息内容为事件名，例如 set、del、expired
+__keyevent@<db>__:<event> 消息内容为键名
+*/
+
+// notify 发布键空间通知，class 为 config.NotifyGeneric 等事件类型
+func (db *DB) notify(class int, event string, key string) {
+	flags := config.Properties().NotifyKeyspaceEvents
+	if db.hub == nil || flags&class == 0 {
+		return
+	}
+	index := strconv.Itoa(db.index)
+	if flags&config.NotifyKeyspace != 0 {
+		db.hub.Publish("__keyspace@"+index+"__:"+key, []byte(event))
+	}
+	if flags&config.NotifyKeyevent != 0 {
+		db.hub.Publish("__keyevent@"+index+"__:"+event, []byte(key))
+	}
+}
@@ -0,0 +1,85 @@
+package database
+
+import (
+	"github.com/ygxiaobai111/GolixirDB/config"
+	"github.com/ygxiaobai111/GolixirDB/interface/resp"
+	"github.com/ygxiaobai111/GolixirDB/resp/reply"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/*
+OBJECT ENCODING | FREQ | IDLETIME | REFCOUNT key
+查看键的内部信息，不会更新键的访问时间与访问频率
+*/
+
+// embstrSizeLimit 不超过该长度的字符串编码为 embstr，与 redis 一致
+const embstrSizeLimit = 44
+
+const (
+	errLFUNotSelected = "ERR An LFU maxmemory policy is not selected, access frequency not tracked. " +
+		"Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust."
+	errLFUSelected = "ERR An LFU maxmemory policy is selected, idle time not tracked. " +
+		"Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust."
+)
+
+// objectEncoding 返回值的编码名称，名称与 redis 保持一致
+func objectEncoding(data interface{}) string {
+	switch val := data.(type) {
+	case []byte:
+		if len(val) <= 20 {
+			if n, err := strconv.ParseInt(string(val), 10, 64); err == nil && strconv.FormatInt(n, 10) == string(val) {
+				return "int"
+			}
+		}
+		if len(val) <= embstrSizeLimit {
+			return "embstr"
+		}
+		return "raw"
+	}
+	return "unknown"
+}
+
+// isLFUPolicy 判断当前淘汰策略是否基于访问频率
+func isLFUPolicy() bool {
+	return config.Properties().MaxMemoryPolicy == config.PolicyAllKeysLFU
+}
+
+// execObject OBJECT subcommand key
+func execObject(db *DB, args [][]byte) resp.Reply {
+	sub := strings.ToLower(string(args[0]))
+	switch sub {
+	case "encoding", "freq", "idletime", "refcount":
+	default:
+		return reply.MakeErrReply("ERR unknown subcommand '" + sub + "'. Try OBJECT ENCODING, OBJECT FREQ, OBJECT IDLETIME, OBJECT REFCOUNT.")
+	}
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply("object|" + sub)
+	}
+	entity, exists := db.peekEntity(string(args[1]))
+	if !exists {
+		return reply.MakeNullBulkReply()
+	}
+	switch sub {
+	case "encoding":
+		return reply.MakeBulkReply([]byte(objectEncoding(entity.Data)))
+	case "freq":
+		if !isLFUPolicy() {
+			return reply.MakeErrReply(errLFUNotSelected)
+		}
+		return reply.MakeIntReply(int64(lfuDecr(atomic.LoadUint32(&entity.LFU))))
+	case "idletime":
+		if isLFUPolicy() {
+			return reply.MakeErrReply(errLFUSelected)
+		}
+		return reply.MakeIntReply(int64(idleTime(entity) / time.Second))
+	}
+	// 值不会在键之间共享
+	return reply.MakeIntReply(1)
+}
+
+func init() {
+	RegisterCommand("Object", execObject, -2, flagReadOnly)
+}
@@ -0,0 +1,116 @@
+package database
+
+import (
+	"github.com/ygxiaobai111/GolixirDB/interface/resp"
+	"github.com/ygxiaobai111/GolixirDB/resp/reply"
+	"strings"
+)
+
+/*
+发布订阅命令：SUBSCRIBE、PSUBSCRIBE、UNSUBSCRIBE、PUNSUBSCRIBE、PUBLISH、PUBSUB
+订阅状态属于连接，由 StandaloneDatabase 直接处理
+*/
+
+// pubsubCommands 使用 RESP2 的连接订阅后只能执行这些命令
+var pubsubCommands = map[string]bool{
+	"subscribe":    true,
+	"psubscribe":   true,
+	"unsubscribe":  true,
+	"punsubscribe": true,
+	"ping":         true,
+	"quit":         true,
+	"reset":        true,
+}
+
+// execPubSubCommand 执行发布订阅相关的命令，不是发布订阅命令时第二个返回值为 false
+func (mdb *StandaloneDatabase) execPubSubCommand(c resp.Connection, cmdName string, args [][]byte) (resp.Reply, bool) {
+	switch cmdName {
+	case "subscribe":
+		if len(args) == 0 {
+			return reply.MakeArgNumErrReply(cmdName), true
+		}
+		return mdb.hub.Subscribe(c, toStrings(args)), true
+	case "psubscribe":
+		if len(args) == 0 {
+			return reply.MakeArgNumErrReply(cmdName), true
+		}
+		return mdb.hub.PSubscribe(c, toStrings(args)), true
+	case "unsubscribe":
+		return mdb.hub.Unsubscribe(c, toStrings(args)), true
+	case "punsubscribe":
+		return mdb.hub.PUnsubscribe(c, toStrings(args)), true
+	case "publish":
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply(cmdName), true
+		}
+		return reply.MakeIntReply(int64(mdb.hub.Publish(string(args[0]), args[1]))), true
+	case "pubsub":
+		return mdb.execPubSub(args), true
+	}
+	return nil, false
+}
+
+// execPubSub PUBSUB CHANNELS [pattern] | NUMSUB [channel ...] | NUMPAT
+func (mdb *StandaloneDatabase) execPubSub(args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		return reply.MakeArgNumErrReply("pubsub")
+	}
+	sub := strings.ToLower(string(args[0]))
+	switch sub {
+	case "channels":
+		if len(args) > 2 {
+			return reply.MakeArgNumErrReply("pubsub|channels")
+		}
+		pattern := ""
+		if len(args) == 2 {
+			pattern = string(args[1])
+		}
+		channels := mdb.hub.Channels(pattern)
+		result := make([][]byte, len(channels))
+		for i, channel := range channels {
+			result[i] = []byte(channel)
+		}
+		return reply.MakeMultiBulkReply(result)
+	case "numsub":
+		result := make([]resp.Reply, 0, 2*(len(args)-1))
+		for _, channel := range args[1:] {
+			result = append(result, reply.MakeBulkReply(channel),
+				reply.MakeIntReply(int64(mdb.hub.NumSub(string(channel)))))
+		}
+		return reply.MakeMultiRawReply(result)
+	case "numpat":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply("pubsub|numpat")
+		}
+		return reply.MakeIntReply(int64(mdb.hub.NumPat()))
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + sub + "'. Try PUBSUB CHANNELS, PUBSUB NUMSUB, PUBSUB NUMPAT.")
+}
+
+// checkSubscribeMode 使用 RESP2 的连接订阅后只能执行订阅相关的命令，RESP3 连接不受限制
+func (mdb *StandaloneDatabase) checkSubscribeMode(c resp.Connection, cmdName string, args [][]byte) resp.Reply {
+	if c == nil || c.GetProtocol() >= reply.Resp3 || !mdb.hub.IsSubscribed(c) {
+		return nil
+	}
+	if !pubsubCommands[cmdName] {
+		return reply.MakeErrReply("ERR Can't execute '" + cmdName +
+			"': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context")
+	}
+	if cmdName == "ping" {
+		// 订阅状态下 PING 以数组回复
+		message := []byte{}
+		if len(args) > 0 {
+			message = args[0]
+		}
+		return reply.MakeMultiBulkReply([][]byte{[]byte("pong"), message})
+	}
+	return nil
+}
+
+func toStrings(args [][]byte) []string {
+	result := make([]string, len(args))
+	for i, arg := range args {
+		result[i] = string(arg)
+	}
+	return result
+}
@@ -0,0 +1,249 @@
+package database
+
+import (
+	"github.com/ygxiaobai111/GolixirDB/config"
+	"github.com/ygxiaobai111/GolixirDB/interface/resp"
+	"github.com/ygxiaobai111/GolixirDB/resp/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+慢查询日志
+执行耗时超过 slowlogLogSlowerThan 微秒的命令会被记录到一个容量为 slowlogMaxLen 的环形缓冲区中
+*/
+
+const (
+	slowlogMaxArgc   = 32  // 最多记录的参数个数
+	slowlogMaxArgLen = 128 // 单个参数最多记录的字节数
+)
+
+// slowlogEntry 一条慢查询记录
+type slowlogEntry struct {
+	id         int64
+	timestamp  int64 // unix 时间戳，单位秒
+	duration   int64 // 执行耗时，单位微秒
+	args       [][]byte
+	clientAddr string
+	clientName string
+}
+
+// slowLog 保存最近的慢查询，新记录在前
+type slowLog struct {
+	mu      sync.Mutex
+	entries []*slowlogEntry // 环形缓冲区
+	head    int             // 下一条记录写入的位置
+	size    int             // 当前记录数
+	nextID  int64
+}
+
+var theSlowLog = &slowLog{}
+
+// truncateArgs 截断过长的参数列表与参数，避免慢查询日志占用过多内存
+func truncateArgs(cmdLine [][]byte) [][]byte {
+	argc := len(cmdLine)
+	if argc > slowlogMaxArgc {
+		argc = slowlogMaxArgc
+	}
+	args := make([][]byte, argc)
+	for i := 0; i < argc; i++ {
+		if i == slowlogMaxArgc-1 && len(cmdLine) > slowlogMaxArgc {
+			more := len(cmdLine) - slowlogMaxArgc + 1
+			args[i] = []byte("... (" + strconv.Itoa(more) + " more arguments)")
+			break
+		}
+		arg := cmdLine[i]
+		if len(arg) > slowlogMaxArgLen {
+			more := len(arg) - slowlogMaxArgLen
+			truncated := make([]byte, 0, slowlogMaxArgLen+32)
+			truncated = append(truncated, arg[:slowlogMaxArgLen]...)
+			truncated = append(truncated, "... ("+strconv.Itoa(more)+" more bytes)"...)
+			args[i] = truncated
+		} else {
+			args[i] = append([]byte(nil), arg...)
+		}
+	}
+	return args
+}
+
+// add 记录一条慢查询，超出容量时覆盖最旧的记录
+func (l *slowLog) add(c resp.Connection, cmdLine [][]byte, duration time.Duration) {
+	maxLen := config.Properties().SlowlogMaxLen
+	if maxLen <= 0 {
+		return
+	}
+	entry := &slowlogEntry{
+		timestamp:  time.Now().Unix(),
+		duration:   duration.Microseconds(),
+		args:       truncateArgs(cmdLine),
+		clientAddr: connAddr(c),
+		clientName: connName(c),
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.entries) != maxLen {
+		l.resize(maxLen)
+	}
+	entry.id = l.nextID
+	l.nextID++
+	l.entries[l.head] = entry
+	l.head = (l.head + 1) % maxLen
+	if l.size < maxLen {
+		l.size++
+	}
+}
+
+// resize 调整缓冲区容量，保留最新的记录
+func (l *slowLog) resize(maxLen int) {
+	recent := l.recent(maxLen)
+	l.entries = make([]*slowlogEntry, maxLen)
+	l.size = len(recent)
+	for i := range recent {
+		// recent 按从新到旧排列，倒序写回
+		l.entries[i] = recent[len(recent)-1-i]
+	}
+	l.head = l.size % maxLen
+}
+
+// recent 返回最新的 count 条记录，按从新到旧排列，count < 0 时返回全部
+func (l *slowLog) recent(count int) []*slowlogEntry {
+	if count < 0 || count > l.size {
+		count = l.size
+	}
+	result := make([]*slowlogEntry, 0, count)
+	for i := 1; i <= count; i++ {
+		idx := (l.head - i + len(l.entries)) % len(l.entries)
+		result = append(result, l.entries[idx])
+	}
+	return result
+}
+
+// reset 清空慢查询日志
+func (l *slowLog) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := range l.entries {
+		l.entries[i] = nil
+	}
+	l.head = 0
+	l.size = 0
+}
+
+// recordSlowCommand 命令耗时超过阈值时记录到慢查询日志，阈值为负数时关闭
+func recordSlowCommand(c resp.Connection, cmdLine [][]byte, duration time.Duration) {
+	threshold := config.Properties().SlowlogLogSlowerThan
+	if threshold < 0 || duration.Microseconds() < threshold {
+		return
+	}
+	theSlowLog.add(c, RedactArgs(cmdLine), duration)
+}
+
+// redacted 替换敏感参数的内容
+var redacted = []byte("(redacted)")
+
+// RedactArgs 返回隐藏了密码等敏感参数的命令，用于 SLOWLOG 与 MONITOR，不包含敏感参数时返回 cmdLine 本身
+// AUTH 与集群节点之间认证的 peer_ 隐藏所有参数，HELLO 隐藏 AUTH 之后的用户名与密码，CONFIG SET 隐藏 requirepass 与 cluster-secret 的值
+func RedactArgs(cmdLine [][]byte) [][]byte {
+	var hidden []int
+	switch strings.ToLower(string(cmdLine[0])) {
+	case "auth", "peer_":
+		for i := 1; i < len(cmdLine); i++ {
+			hidden = append(hidden, i)
+		}
+	case "hello":
+		for i := 2; i < len(cmdLine); i++ {
+			if strings.EqualFold(string(cmdLine[i]), "auth") {
+				for j := i + 1; j < len(cmdLine) && j <= i+2; j++ {
+					hidden = append(hidden, j)
+				}
+				break
+			}
+		}
+	case "config":
+		if len(cmdLine) > 1 && strings.EqualFold(string(cmdLine[1]), "set") {
+			for i := 2; i+1 < len(cmdLine); i += 2 {
+				if strings.EqualFold(string(cmdLine[i]), "requirepass") || strings.EqualFold(string(cmdLine[i]), "cluster-secret") {
+					hidden = append(hidden, i+1)
+				}
+			}
+		}
+	}
+	if len(hidden) == 0 {
+		return cmdLine
+	}
+	result := make([][]byte, len(cmdLine))
+	copy(result, cmdLine)
+	for _, i := range hidden {
+		result[i] = redacted
+	}
+	return result
+}
+
+// connAddr 返回连接的远程地址，内部使用的伪连接没有地址
+func connAddr(c resp.Connection) string {
+	if c == nil {
+		return ""
+	}
+	addr := c.RemoteAddr()
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
+
+// connName 返回连接名称
+func connName(c resp.Connection) string {
+	if c == nil {
+		return ""
+	}
+	return c.GetName()
+}
+
+// execSlowlog SLOWLOG GET [count] | LEN | RESET
+func execSlowlog(db *DB, args [][]byte) resp.Reply {
+	sub := strings.ToLower(string(args[0]))
+	switch sub {
+	case "get":
+		if len(args) > 2 {
+			return reply.MakeArgNumErrReply("slowlog|get")
+		}
+		count := 10
+		if len(args) == 2 {
+			n, err := strconv.Atoi(string(args[1]))
+			if err != nil || n < -1 {
+				return reply.MakeErrReply("ERR count should be greater than or equal to -1")
+			}
+			count = n
+		}
+		theSlowLog.mu.Lock()
+		entries := theSlowLog.recent(count)
+		theSlowLog.mu.Unlock()
+		result := make([]resp.Reply, 0, len(entries))
+		for _, e := range entries {
+			result = append(result, reply.MakeMultiRawReply([]resp.Reply{
+				reply.MakeIntReply(e.id),
+				reply.MakeIntReply(e.timestamp),
+				reply.MakeIntReply(e.duration),
+				reply.MakeMultiBulkReply(e.args),
+				reply.MakeBulkReply([]byte(e.clientAddr)),
+				reply.MakeBulkReply([]byte(e.clientName)),
+			}))
+		}
+		return reply.MakeMultiRawReply(result)
+	case "len":
+		theSlowLog.mu.Lock()
+		size := theSlowLog.size
+		theSlowLog.mu.Unlock()
+		return reply.MakeIntReply(int64(size))
+	case "reset":
+		theSlowLog.reset()
+		return reply.MakeOkReply()
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + sub + "'. Try SLOWLOG GET, SLOWLOG LEN, SLOWLOG RESET.")
+}
+
+func init() {
+	RegisterCommand("SlowLog", execSlowlog, -2, 0)
+}
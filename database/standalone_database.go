@@ -6,58 +6,156 @@ import (
 	"github.com/ygxiaobai111/GolixirDB/config"
 	"github.com/ygxiaobai111/GolixirDB/interface/resp"
 	util "github.com/ygxiaobai111/GolixirDB/lib/logger"
+	"github.com/ygxiaobai111/GolixirDB/lib/metrics"
+	"github.com/ygxiaobai111/GolixirDB/lib/sync/atomic"
+	"github.com/ygxiaobai111/GolixirDB/pubsub"
 	"github.com/ygxiaobai111/GolixirDB/resp/reply"
 	"runtime/debug"
 	"strconv"
 	"strings"
+	syncatomic "sync/atomic"
+	"time"
 )
 
 // StandaloneDatabase is a set of multiple database set
 type StandaloneDatabase struct {
 	dbSet      []*DB
 	aofHandler *aof.AofHandler
+	// 作为集群节点的本地存储时，命令统计由集群层完成
+	embedded bool
+	// 是否正在加载 aof 文件，加载期间执行的命令不计入统计
+	loading atomic.Boolean
+	// 关闭时通知主动过期协程退出
+	stopExpire chan struct{}
+	// 发布订阅，同时用于键空间通知
+	hub *pubsub.Hub
+	// 写命令的订阅者，类型为 func(dbIndex int, cmdLine CmdLine)，集群模式下用于向从节点复制
+	writeFeed syncatomic.Value
+	// INFO replication 的数据来源，类型为 func() ReplicationInfo
+	replInfo syncatomic.Value
+}
+
+// serverCommands 是不属于单个 DB、由 StandaloneDatabase 直接处理的命令
+var serverCommands = map[string]bool{
+	"select":   true,
+	"hello":    true,
+	"info":     true,
+	"auth":     true,
+	"memory":   true,
+	"flushall": true,
+
+	"subscribe":    true,
+	"psubscribe":   true,
+	"unsubscribe":  true,
+	"punsubscribe": true,
+	"publish":      true,
+	"pubsub":       true,
+}
+
+// isKnownCommand 判断命令是否存在
+func isKnownCommand(name string) bool {
+	if serverCommands[name] {
+		return true
+	}
+	_, ok := cmdTable[name]
+	return ok
+}
+
+// NewEmbeddedDatabase 创建作为集群节点本地存储的数据库
+func NewEmbeddedDatabase() *StandaloneDatabase {
+	mdb := NewStandaloneDatabase()
+	mdb.embedded = true
+	return mdb
 }
 
 // NewStandaloneDatabase creates a redis database,
 func NewStandaloneDatabase() *StandaloneDatabase {
-	mdb := &StandaloneDatabase{}
-	if config.Properties.Databases == 0 {
-		config.Properties.Databases = 16
+	mdb := &StandaloneDatabase{
+		hub: pubsub.MakeHub(),
+	}
+	stats.startSampler()
+	databases := config.Properties().Databases
+	if databases == 0 {
+		databases = 16
 	}
-	mdb.dbSet = make([]*DB, config.Properties.Databases)
+	mdb.dbSet = make([]*DB, databases)
 	for i := range mdb.dbSet {
 		singleDB := makeDB()
 		singleDB.index = i
+		singleDB.hub = mdb.hub
 		mdb.dbSet[i] = singleDB
 	}
-	if config.Properties.AppendOnly {
+	if config.Properties().AppendOnly {
+		mdb.loading.Set(true)
 		aofH, err := aof.NewAOFHandler(mdb)
+		mdb.loading.Set(false)
 		if err != nil {
 			panic(err)
 		}
 		mdb.aofHandler = aofH
-		//将这个函数赋给每个db
-		for _, db := range mdb.dbSet {
-			/*
-				//闭包bug 内部函数引用外部局部变量，变量将逃逸到堆
-				//此bug会导致AddAof()的第一个参数都是最后一个db.index的值
-				db.addAof = func(line CmdLine) {
-					mdb.aofHandler.AddAof(db.index, line)
-				}
-			*/
-			ndb := db
-			ndb.addAof = func(line CmdLine) {
+	}
+	//将这个函数赋给每个db
+	for _, db := range mdb.dbSet {
+		/*
+			//闭包bug 内部函数引用外部局部变量，变量将逃逸到堆
+			//此bug会导致AddAof()的第一个参数都是最后一个db.index的值
+			db.addAof = func(line CmdLine) {
+				mdb.aofHandler.AddAof(db.index, line)
+			}
+		*/
+		ndb := db
+		ndb.addAof = func(line CmdLine) {
+			if mdb.aofHandler != nil {
 				mdb.aofHandler.AddAof(ndb.index, line)
 			}
+			if feed, ok := mdb.writeFeed.Load().(func(int, CmdLine)); ok {
+				feed(ndb.index, line)
+			}
 		}
-
 	}
+	mdb.startActiveExpire()
+	mdb.registerMetrics()
 	return mdb
 }
 
+// SetWriteFeed 设置写命令的订阅者，写入 aof 的每条命令同时交给 feed，feed 不能阻塞
+func (mdb *StandaloneDatabase) SetWriteFeed(feed func(dbIndex int, cmdLine CmdLine)) {
+	mdb.writeFeed.Store(feed)
+}
+
+// registerMetrics 注册在抓取时计算的指标
+func (mdb *StandaloneDatabase) registerMetrics() {
+	metrics.RegisterGaugeFunc("golixir_connected_clients", "Number of client connections.", "",
+		func() map[string]float64 {
+			return map[string]float64{"": float64(ConnectedClients())}
+		})
+	metrics.RegisterGaugeFunc("golixir_db_keys", "Number of keys in each database.", "db",
+		func() map[string]float64 {
+			result := make(map[string]float64)
+			for _, db := range mdb.dbSet {
+				result[strconv.Itoa(db.index)] = float64(db.data.Len())
+			}
+			return result
+		})
+	metrics.RegisterGaugeFunc("golixir_aof_queue_depth", "Number of commands waiting to be written to the aof file.", "",
+		func() map[string]float64 {
+			depth := 0
+			if mdb.aofHandler != nil {
+				depth = mdb.aofHandler.BufferLength()
+			}
+			return map[string]float64{"": float64(depth)}
+		})
+}
+
 // Exec executes command
 // parameter `cmdLine` contains command and its arguments, for example: "set key value"
 func (mdb *StandaloneDatabase) Exec(c resp.Connection, cmdLine [][]byte) (result resp.Reply) {
+	if !mdb.embedded && !mdb.loading.Get() {
+		start := time.Now()
+		defer func() {
+			RecordCommand(c, cmdLine, result, time.Since(start))
+		}()
+	}
 	defer func() {
 		if err := recover(); err != nil {
 			util.LogrusObj.Warn(fmt.Sprintf("error occurs: %v\n%s", err, string(debug.Stack())))
@@ -65,12 +163,36 @@ func (mdb *StandaloneDatabase) Exec(c resp.Connection, cmdLine [][]byte) (result
 	}()
 
 	cmdName := strings.ToLower(string(cmdLine[0]))
-	//当命令为 select 则是选择数据库 单独处理
-	if cmdName == "select" {
+	if errReply := mdb.checkSubscribeMode(c, cmdName, cmdLine[1:]); errReply != nil {
+		return errReply
+	}
+	if result, ok := mdb.execPubSubCommand(c, cmdName, cmdLine[1:]); ok {
+		return result
+	}
+	switch cmdName {
+	case "select":
+		//当命令为 select 则是选择数据库 单独处理
 		if len(cmdLine) != 2 {
 			return reply.MakeArgNumErrReply("select")
 		}
 		return execSelect(c, mdb, cmdLine[1:])
+	case "hello":
+		// hello 只影响当前连接的协议版本
+		return execHello(c, cmdLine[1:])
+	case "info":
+		return execInfo(mdb, cmdLine[1:])
+	case "auth":
+		return execAuth(c, cmdLine[1:])
+	case "memory":
+		return execMemory(mdb, c, cmdLine[1:])
+	case "flushall":
+		return execFlushAll(mdb, c, cmdLine[1:])
+	}
+	// 执行写命令前按需淘汰键，加载 aof 时不淘汰
+	if !mdb.loading.Get() && IsWriteCommand(cmdName) {
+		if !mdb.freeMemoryIfNeeded() && isDenyOOMCommand(cmdName) {
+			return oomReply
+		}
 	}
 	// normal commands
 	dbIndex := c.GetDBIndex()
@@ -79,11 +201,25 @@ func (mdb *StandaloneDatabase) Exec(c resp.Connection, cmdLine [][]byte) (result
 }
 
 // Close graceful shutdown database
-func (mdb *StandaloneDatabase) Close() {
-
+// 停止写入 aof，save 为 true 时等待缓冲区中的命令全部写入文件后再刷盘
+func (mdb *StandaloneDatabase) Close(save bool) error {
+	close(mdb.stopExpire)
+	if mdb.aofHandler == nil {
+		return nil
+	}
+	pending := mdb.aofHandler.BufferLength()
+	if err := mdb.aofHandler.Close(save); err != nil {
+		util.LogrusObj.Error("close aof failed: " + err.Error())
+		return err
+	}
+	if save {
+		util.LogrusObj.Info(fmt.Sprintf("aof flushed, %d pending commands written", pending))
+	}
+	return nil
 }
 
 func (mdb *StandaloneDatabase) AfterClientClose(c resp.Connection) {
+	mdb.hub.UnsubscribeAll(c)
 }
 
 func execSelect(c resp.Connection, mdb *StandaloneDatabase, args [][]byte) resp.Reply {
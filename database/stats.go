@@ -0,0 +1,126 @@
+package database
+
+import (
+	"github.com/ygxiaobai111/GolixirDB/interface/resp"
+	"github.com/ygxiaobai111/GolixirDB/lib/metrics"
+	"github.com/ygxiaobai111/GolixirDB/resp/reply"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// commandStats 记录单个命令的调用次数与耗时，使用 sync/atomic 访问
+type commandStats struct {
+	calls         int64
+	usec          int64
+	rejectedCalls int64
+	failedCalls   int64
+}
+
+// serverStats 记录服务器运行期间的统计信息，供 INFO 命令使用
+type serverStats struct {
+	startTime time.Time
+
+	connectionsReceived int64
+	connectedClients    int64
+	totalCommands       int64
+	keyspaceHits        int64
+	keyspaceMisses      int64
+	expiredKeys         int64 // 因过期被删除的键数
+	evictedKeys         int64 // 因超过 maxmemory 被淘汰的键数
+	opsPerSec           int64 // 最近一秒执行的命令数
+
+	commands sync.Map // 命令名 -> *commandStats，每个连接执行命令时都会访问，不使用全局锁
+
+	samplerOnce sync.Once
+}
+
+// commandDuration 各命令耗时的直方图，通过 /metrics 暴露
+var commandDuration = metrics.NewHistogramVec("golixir_command_duration_seconds",
+	"Latency of executed commands.", "cmd", metrics.DefaultLatencyBuckets)
+
+var stats = &serverStats{
+	startTime: time.Now(),
+}
+
+// startSampler 每秒采样一次命令总数，计算 instantaneous_ops_per_sec
+func (s *serverStats) startSampler() {
+	s.samplerOnce.Do(func() {
+		go func() {
+			last := atomic.LoadInt64(&s.totalCommands)
+			for range time.Tick(time.Second) {
+				current := atomic.LoadInt64(&s.totalCommands)
+				atomic.StoreInt64(&s.opsPerSec, current-last)
+				last = current
+			}
+		}()
+	})
+}
+
+// ClientConnected 记录新建立的客户端连接，由协议处理器在接受连接时调用
+func ClientConnected() {
+	atomic.AddInt64(&stats.connectionsReceived, 1)
+	atomic.AddInt64(&stats.connectedClients, 1)
+}
+
+// ClientDisconnected 记录断开的客户端连接
+func ClientDisconnected() {
+	atomic.AddInt64(&stats.connectedClients, -1)
+}
+
+// ConnectedClients 返回当前的客户端连接数
+func ConnectedClients() int64 {
+	return atomic.LoadInt64(&stats.connectedClients)
+}
+
+// RecordCommand 记录一次命令执行，集群模式下由集群层调用，单机模式下由 StandaloneDatabase 调用
+// 未知命令不做统计，避免客户端随意构造命令名导致统计表无限增长
+func RecordCommand(c resp.Connection, cmdLine [][]byte, result resp.Reply, duration time.Duration) {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	atomic.AddInt64(&stats.totalCommands, 1)
+	if !isKnownCommand(cmdName) {
+		return
+	}
+	v, ok := stats.commands.Load(cmdName)
+	if !ok {
+		v, _ = stats.commands.LoadOrStore(cmdName, &commandStats{})
+	}
+	cs := v.(*commandStats)
+	switch result.(type) {
+	case *reply.ArgNumErrReply:
+		// 参数个数错误的命令没有真正执行
+		atomic.AddInt64(&cs.rejectedCalls, 1)
+		return
+	}
+	atomic.AddInt64(&cs.calls, 1)
+	atomic.AddInt64(&cs.usec, duration.Microseconds())
+	if result == nil || reply.IsErrorReply(result) {
+		atomic.AddInt64(&cs.failedCalls, 1)
+	}
+	commandDuration.Observe(cmdName, duration.Seconds())
+	recordSlowCommand(c, cmdLine, duration)
+}
+
+// commandStatsSnapshot 返回按命令名排序的统计快照
+func commandStatsSnapshot() ([]string, []commandStats) {
+	var names []string
+	stats.commands.Range(func(key, value interface{}) bool {
+		names = append(names, key.(string))
+		return true
+	})
+	sort.Strings(names)
+	result := make([]commandStats, len(names))
+	for i, name := range names {
+		v, _ := stats.commands.Load(name)
+		cs := v.(*commandStats)
+		result[i] = commandStats{
+			calls:         atomic.LoadInt64(&cs.calls),
+			usec:          atomic.LoadInt64(&cs.usec),
+			rejectedCalls: atomic.LoadInt64(&cs.rejectedCalls),
+			failedCalls:   atomic.LoadInt64(&cs.failedCalls),
+		}
+	}
+	return names, result
+}
@@ -1,15 +1,20 @@
 package database
 
 import (
+	"github.com/ygxiaobai111/GolixirDB/config"
 	"github.com/ygxiaobai111/GolixirDB/interface/database"
 	"github.com/ygxiaobai111/GolixirDB/interface/resp"
 	"github.com/ygxiaobai111/GolixirDB/lib/utils"
 	"github.com/ygxiaobai111/GolixirDB/resp/reply"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func (db *DB) getAsString(key string) ([]byte, reply.ErrorReply) {
 	entity, ok := db.GetEntity(key)
 	if !ok {
+		db.notify(config.NotifyKeyMiss, "keymiss", key)
 		return nil, nil
 	}
 	bytes, ok := entity.Data.([]byte)
@@ -32,17 +37,78 @@ func execGet(db *DB, args [][]byte) resp.Reply {
 	return reply.MakeBulkReply(bytes)
 }
 
-// execSet sets string value and time to live to the given key
+const (
+	upsertPolicy = iota // 默认，键存在与否都写入
+	insertPolicy        // NX，只在键不存在时写入
+	updatePolicy        // XX，只在键存在时写入
+)
+
+// execSet SET key value [NX|XX] [EX seconds|PX milliseconds|KEEPTTL]
 func execSet(db *DB, args [][]byte) resp.Reply {
 	key := string(args[0])
 	value := args[1]
+	policy := upsertPolicy
+	keepTTL := false
+	var expireAt time.Time
+	hasTTL := false
+	for i := 2; i < len(args); i++ {
+		opt := strings.ToUpper(string(args[i]))
+		switch {
+		case opt == "NX" && policy != updatePolicy:
+			policy = insertPolicy
+		case opt == "XX" && policy != insertPolicy:
+			policy = updatePolicy
+		case opt == "KEEPTTL" && !hasTTL:
+			keepTTL = true
+		case (opt == "EX" || opt == "PX") && !hasTTL && !keepTTL && i+1 < len(args):
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			unit := time.Second
+			if opt == "PX" {
+				unit = time.Millisecond
+			}
+			if n <= 0 || n > int64(1<<62)/int64(unit) {
+				return reply.MakeErrReply("ERR invalid expire time in 'set' command")
+			}
+			expireAt = time.Now().Add(time.Duration(n) * unit)
+			hasTTL = true
+			i++
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+
 	entity := &database.DataEntity{
 		Data: value,
 	}
-	db.PutEntity(key, entity)
-
-	db.addAof(utils.ToCmdLine2("set", args...))
-
+	var result int
+	switch policy {
+	case insertPolicy:
+		result = db.PutIfAbsent(key, entity)
+	case updatePolicy:
+		result = db.PutIfExists(key, entity)
+	default:
+		result = db.PutEntity(key, entity)
+	}
+	if result == 0 && policy != upsertPolicy {
+		return reply.MakeNullBulkReply()
+	}
+	if keepTTL {
+		// 重放与复制时同样需要保留原有的过期时间
+		db.addAof(utils.ToCmdLine2("set", args[0], args[1], []byte("KEEPTTL")))
+	} else {
+		db.addAof(utils.ToCmdLine2("set", args[0], args[1]))
+	}
+	db.notify(config.NotifyString, "set", key)
+	if hasTTL {
+		db.Expire(key, expireAt)
+		db.addAof(makeExpireCmd(key, expireAt))
+		db.notify(config.NotifyGeneric, "expire", key)
+	} else if !keepTTL && db.Persist(key) {
+		db.addAof(utils.ToCmdLine("persist", key))
+	}
 	return &reply.OkReply{}
 }
 
@@ -55,6 +121,9 @@ func execSetNX(db *DB, args [][]byte) resp.Reply {
 	}
 	result := db.PutIfAbsent(key, entity)
 	db.addAof(utils.ToCmdLine2("setnx", args...))
+	if result > 0 {
+		db.notify(config.NotifyString, "set", key)
+	}
 
 	return reply.MakeIntReply(int64(result))
 }
@@ -66,7 +135,9 @@ func execGetSet(db *DB, args [][]byte) resp.Reply {
 
 	entity, exists := db.GetEntity(key)
 	db.PutEntity(key, &database.DataEntity{Data: value})
+	db.Persist(key) // GETSET 会清除原有的过期时间
 	db.addAof(utils.ToCmdLine2("getset", args...))
+	db.notify(config.NotifyString, "set", key)
 
 	if !exists {
 		return reply.MakeNullBulkReply()
@@ -80,16 +151,61 @@ func execStrLen(db *DB, args [][]byte) resp.Reply {
 	key := string(args[0])
 	entity, exists := db.GetEntity(key)
 	if !exists {
+		db.notify(config.NotifyKeyMiss, "keymiss", key)
 		return reply.MakeNullBulkReply()
 	}
 	old := entity.Data.([]byte)
 	return reply.MakeIntReply(int64(len(old)))
 }
 
+// execMSet MSET key value [key value ...]，与 SET 相同会清除键原有的过期时间
+func execMSet(db *DB, args [][]byte) resp.Reply {
+	if len(args)%2 != 0 {
+		return reply.MakeArgNumErrReply("mset")
+	}
+	for i := 0; i < len(args); i += 2 {
+		key := string(args[i])
+		db.PutEntity(key, &database.DataEntity{Data: args[i+1]})
+		db.Persist(key)
+		db.notify(config.NotifyString, "set", key)
+	}
+	db.addAof(utils.ToCmdLine2("mset", args...))
+	return &reply.OkReply{}
+}
+
+// execMSetNX MSETNX key value [key value ...]，所有键都不存在时才写入
+func execMSetNX(db *DB, args [][]byte) resp.Reply {
+	if len(args)%2 != 0 {
+		return reply.MakeArgNumErrReply("msetnx")
+	}
+	for i := 0; i < len(args); i += 2 {
+		if _, exists := db.GetEntity(string(args[i])); exists {
+			return reply.MakeIntReply(0)
+		}
+	}
+	execMSet(db, args)
+	return reply.MakeIntReply(1)
+}
+
+// execMGet MGET key [key ...]，键不存在或不是字符串时返回 nil
+func execMGet(db *DB, args [][]byte) resp.Reply {
+	result := make([][]byte, len(args))
+	for i, arg := range args {
+		bytes, err := db.getAsString(string(arg))
+		if err == nil {
+			result[i] = bytes
+		}
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
 func init() {
-	RegisterCommand("Get", execGet, 2)
-	RegisterCommand("Set", execSet, -3)
-	RegisterCommand("SetNx", execSetNX, 3)
-	RegisterCommand("GetSet", execGetSet, 3)
-	RegisterCommand("StrLen", execStrLen, 2)
+	RegisterCommand("Get", execGet, 2, flagReadOnly)
+	RegisterCommand("Set", execSet, -3, flagWrite|flagDenyOOM)
+	RegisterCommand("SetNx", execSetNX, 3, flagWrite|flagDenyOOM)
+	RegisterCommand("GetSet", execGetSet, 3, flagWrite|flagDenyOOM)
+	RegisterCommand("MSet", execMSet, -3, flagWrite|flagDenyOOM)
+	RegisterCommand("MSetNX", execMSetNX, -3, flagWrite|flagDenyOOM)
+	RegisterCommand("MGet", execMGet, -2, flagReadOnly)
+	RegisterCommand("StrLen", execStrLen, 2, flagReadOnly)
 }
@@ -0,0 +1,208 @@
+package database
+
+import (
+	"github.com/ygxiaobai111/GolixirDB/config"
+	"github.com/ygxiaobai111/GolixirDB/interface/resp"
+	"github.com/ygxiaobai111/GolixirDB/lib/utils"
+	"github.com/ygxiaobai111/GolixirDB/resp/reply"
+	"strconv"
+	"time"
+)
+
+/*
+键的过期时间
+过期的键在访问时惰性删除，同时后台协程定期采样删除已过期的键，两种方式删除的键都以 del 命令写入 aof
+设置过期时间的命令统一以 pexpireat 写入 aof，重启后不会因为加载耗时而延长键的生存时间
+*/
+
+const (
+	activeExpireInterval   = 100 * time.Millisecond // 主动过期的执行间隔
+	activeExpireSamples    = 20                     // 每轮在每个数据库中采样的键数
+	activeExpireMaxElapsed = 25 * time.Millisecond  // 每次执行的最长耗时
+)
+
+// toUnixMilli 将时间转换为 unix 毫秒
+func toUnixMilli(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// makeExpireCmd 生成写入 aof 的 pexpireat 命令
+func makeExpireCmd(key string, expireAt time.Time) CmdLine {
+	return utils.ToCmdLine("pexpireat", key, strconv.FormatInt(toUnixMilli(expireAt), 10))
+}
+
+// setExpire 为已存在的键设置过期时间，过期时间已到时直接删除
+func (db *DB) setExpire(key string, expireAt time.Time) resp.Reply {
+	if _, exists := db.peekEntity(key); !exists {
+		return reply.MakeIntReply(0)
+	}
+	if !time.Now().Before(expireAt) {
+		db.Remove(key)
+		db.addAof(utils.ToCmdLine("del", key))
+		db.notify(config.NotifyGeneric, "del", key)
+		return reply.MakeIntReply(1)
+	}
+	db.Expire(key, expireAt)
+	db.addAof(makeExpireCmd(key, expireAt))
+	db.notify(config.NotifyGeneric, "expire", key)
+	return reply.MakeIntReply(1)
+}
+
+// parseExpire 解析过期时间参数，unit 为每个单位对应的时长，absolute 表示参数为 unix 时间戳
+func parseExpire(arg []byte, unit time.Duration, absolute bool, cmdName string) (time.Time, resp.Reply) {
+	n, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return time.Time{}, reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	// 避免换算成纳秒时溢出
+	limit := int64(1<<62) / int64(unit)
+	if n > limit || n < -limit {
+		return time.Time{}, reply.MakeErrReply("ERR invalid expire time in '" + cmdName + "' command")
+	}
+	if absolute {
+		return time.Unix(0, n*int64(unit)), nil
+	}
+	return time.Now().Add(time.Duration(n) * unit), nil
+}
+
+// execExpire EXPIRE key seconds
+func execExpire(db *DB, args [][]byte) resp.Reply {
+	expireAt, errReply := parseExpire(args[1], time.Second, false, "expire")
+	if errReply != nil {
+		return errReply
+	}
+	return db.setExpire(string(args[0]), expireAt)
+}
+
+// execPExpire PEXPIRE key milliseconds
+func execPExpire(db *DB, args [][]byte) resp.Reply {
+	expireAt, errReply := parseExpire(args[1], time.Millisecond, false, "pexpire")
+	if errReply != nil {
+		return errReply
+	}
+	return db.setExpire(string(args[0]), expireAt)
+}
+
+// execExpireAt EXPIREAT key unix-time-seconds
+func execExpireAt(db *DB, args [][]byte) resp.Reply {
+	expireAt, errReply := parseExpire(args[1], time.Second, true, "expireat")
+	if errReply != nil {
+		return errReply
+	}
+	return db.setExpire(string(args[0]), expireAt)
+}
+
+// execPExpireAt PEXPIREAT key unix-time-milliseconds
+func execPExpireAt(db *DB, args [][]byte) resp.Reply {
+	expireAt, errReply := parseExpire(args[1], time.Millisecond, true, "pexpireat")
+	if errReply != nil {
+		return errReply
+	}
+	return db.setExpire(string(args[0]), expireAt)
+}
+
+// ttl 返回键的剩余生存时间，键不存在时返回 -2，没有过期时间时返回 -1
+func (db *DB) ttl(key string, unit time.Duration) resp.Reply {
+	if _, exists := db.peekEntity(key); !exists {
+		return reply.MakeIntReply(-2)
+	}
+	expireAt, ok := db.expireTime(key)
+	if !ok {
+		return reply.MakeIntReply(-1)
+	}
+	remaining := time.Until(expireAt)
+	if remaining < 0 {
+		remaining = 0
+	}
+	// 与 redis 相同，向上取整
+	return reply.MakeIntReply(int64((remaining + unit - 1) / unit))
+}
+
+// execTTL TTL key
+func execTTL(db *DB, args [][]byte) resp.Reply {
+	return db.ttl(string(args[0]), time.Second)
+}
+
+// execPTTL PTTL key
+func execPTTL(db *DB, args [][]byte) resp.Reply {
+	return db.ttl(string(args[0]), time.Millisecond)
+}
+
+// execPersist PERSIST key
+func execPersist(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	if _, exists := db.peekEntity(key); !exists {
+		return reply.MakeIntReply(0)
+	}
+	if !db.Persist(key) {
+		return reply.MakeIntReply(0)
+	}
+	db.addAof(utils.ToCmdLine2("persist", args...))
+	db.notify(config.NotifyGeneric, "persist", key)
+	return reply.MakeIntReply(1)
+}
+
+// ttlStats 返回设置了过期时间的键数与平均剩余生存时间（毫秒），供 INFO keyspace 使用
+func (db *DB) ttlStats() (expires int, avgTTL int64) {
+	now := time.Now()
+	var total int64
+	db.ttlMap.ForEach(func(key string, val interface{}) bool {
+		remaining := val.(time.Time).Sub(now)
+		if remaining > 0 {
+			total += int64(remaining / time.Millisecond)
+		}
+		expires++
+		return true
+	})
+	if expires > 0 {
+		avgTTL = total / int64(expires)
+	}
+	return expires, avgTTL
+}
+
+// activeExpireCycle 在每个数据库中采样设置了过期时间的键并删除已过期的
+// 一轮中过期的键超过四分之一时继续采样，直到超过耗时上限
+func (mdb *StandaloneDatabase) activeExpireCycle() {
+	start := time.Now()
+	for _, db := range mdb.dbSet {
+		for time.Since(start) < activeExpireMaxElapsed {
+			keys := db.ttlMap.RandomDistinctKeys(activeExpireSamples)
+			expired := 0
+			for _, key := range keys {
+				if db.expireIfNeeded(key) {
+					expired++
+				}
+			}
+			if len(keys) == 0 || expired*4 <= len(keys) {
+				break
+			}
+		}
+	}
+}
+
+// startActiveExpire 启动定期删除过期键的协程，Close 时停止
+func (mdb *StandaloneDatabase) startActiveExpire() {
+	mdb.stopExpire = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(activeExpireInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				mdb.activeExpireCycle()
+			case <-mdb.stopExpire:
+				return
+			}
+		}
+	}()
+}
+
+func init() {
+	RegisterCommand("Expire", execExpire, 3, flagWrite)
+	RegisterCommand("PExpire", execPExpire, 3, flagWrite)
+	RegisterCommand("ExpireAt", execExpireAt, 3, flagWrite)
+	RegisterCommand("PExpireAt", execPExpireAt, 3, flagWrite)
+	RegisterCommand("TTL", execTTL, 2, flagReadOnly)
+	RegisterCommand("PTTL", execPTTL, 2, flagReadOnly)
+	RegisterCommand("Persist", execPersist, 2, flagWrite)
+}
@@ -0,0 +1,232 @@
+package dict
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+// ShardCount ConcurrentDict 的分片数，同时也是 Scan 游标的取值范围
+const ShardCount = 1 << 14
+
+// ConcurrentDict 按键的哈希值分片的线程安全字典，每个分片由读写锁保护
+// 分片数固定，Scan 以分片为单位遍历，键的数量单独计数，Len 不需要遍历字典
+type ConcurrentDict struct {
+	shards []shard
+	count  int64 // 键的数量，使用 sync/atomic 访问
+}
+
+type shard struct {
+	mu sync.RWMutex
+	m  map[string]interface{} // 第一次写入时创建
+}
+
+// MakeConcurrentDict 创建一个新的字典
+func MakeConcurrentDict() *ConcurrentDict {
+	return &ConcurrentDict{shards: make([]shard, ShardCount)}
+}
+
+// ShardOf 返回键所在的分片
+func ShardOf(key string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() & (ShardCount - 1))
+}
+
+func (dict *ConcurrentDict) shardOf(key string) *shard {
+	return &dict.shards[ShardOf(key)]
+}
+
+// Get 返回键绑定的值以及键是否存在
+func (dict *ConcurrentDict) Get(key string) (val interface{}, exists bool) {
+	s := dict.shardOf(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	val, exists = s.m[key]
+	return
+}
+
+// Len 返回字典中的元素数量
+func (dict *ConcurrentDict) Len() int {
+	return int(atomic.LoadInt64(&dict.count))
+}
+
+// Put 将键值对放入字典并返回新插入的键值对数量
+func (dict *ConcurrentDict) Put(key string, val interface{}) (result int) {
+	s := dict.shardOf(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.m == nil {
+		s.m = make(map[string]interface{})
+	}
+	_, existed := s.m[key]
+	s.m[key] = val
+	if existed {
+		return 0
+	}
+	atomic.AddInt64(&dict.count, 1)
+	return 1
+}
+
+// PutIfAbsent 如果键不存在则放入值，并返回更新的键值对数量
+func (dict *ConcurrentDict) PutIfAbsent(key string, val interface{}) (result int) {
+	s := dict.shardOf(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, existed := s.m[key]; existed {
+		return 0
+	}
+	if s.m == nil {
+		s.m = make(map[string]interface{})
+	}
+	s.m[key] = val
+	atomic.AddInt64(&dict.count, 1)
+	return 1
+}
+
+// PutIfExists 如果键存在则放入值，并返回插入的键值对数量
+func (dict *ConcurrentDict) PutIfExists(key string, val interface{}) (result int) {
+	s := dict.shardOf(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, existed := s.m[key]; existed {
+		s.m[key] = val
+		return 1
+	}
+	return 0
+}
+
+// Remove 移除键并返回被删除的键值对数量
+func (dict *ConcurrentDict) Remove(key string) (result int) {
+	s := dict.shardOf(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, existed := s.m[key]; existed {
+		delete(s.m, key)
+		atomic.AddInt64(&dict.count, -1)
+		return 1
+	}
+	return 0
+}
+
+// snapshot 返回分片中的键值对，遍历时先复制再调用 consumer，consumer 可以修改字典
+func (s *shard) snapshot() ([]string, []interface{}) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.m) == 0 {
+		return nil, nil
+	}
+	keys := make([]string, 0, len(s.m))
+	vals := make([]interface{}, 0, len(s.m))
+	for key, val := range s.m {
+		keys = append(keys, key)
+		vals = append(vals, val)
+	}
+	return keys, vals
+}
+
+// Keys 返回字典中的所有键
+func (dict *ConcurrentDict) Keys() []string {
+	result := make([]string, 0, dict.Len())
+	dict.ForEach(func(key string, val interface{}) bool {
+		result = append(result, key)
+		return true
+	})
+	return result
+}
+
+// ForEach 遍历字典，consumer 返回 false 时停止遍历
+func (dict *ConcurrentDict) ForEach(consumer Consumer) {
+	for i := range dict.shards {
+		keys, vals := dict.shards[i].snapshot()
+		for j, key := range keys {
+			if !consumer(key, vals[j]) {
+				return
+			}
+		}
+	}
+}
+
+// Scan 从 cursor 号分片开始按分片遍历，遍历的键数达到 count 或遍历完最后一个分片后停止，
+// 返回下一次遍历的起始分片，遍历结束时返回 0。每次都遍历完整的分片，因此遍历期间一直存在的键一定会被遍历且只遍历一次
+func (dict *ConcurrentDict) Scan(cursor int, count int, consumer Consumer) int {
+	visited := 0
+	for i := cursor; i < ShardCount; i++ {
+		keys, vals := dict.shards[i].snapshot()
+		for j, key := range keys {
+			consumer(key, vals[j])
+		}
+		visited += len(keys)
+		if visited >= count && i+1 < ShardCount {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// RandomKeys 随机返回给定数量的键，可能包含重复的键，字典为空时返回空切片
+// 每个键从随机的分片开始找到第一个非空的分片，取其中的一个键
+func (dict *ConcurrentDict) RandomKeys(limit int) []string {
+	result := make([]string, 0, limit)
+	for len(result) < limit && dict.Len() > 0 {
+		start := rand.Intn(ShardCount)
+		for i := 0; i < ShardCount; i++ {
+			if key, ok := dict.shards[(start+i)&(ShardCount-1)].anyKey(); ok {
+				result = append(result, key)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// anyKey 返回分片中的任意一个键
+func (s *shard) anyKey() (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for key := range s.m {
+		return key, true
+	}
+	return "", false
+}
+
+// RandomDistinctKeys 随机返回给定数量的键，不包含重复的键，键的数量不足时返回所有键
+// 从随机的分片开始依次取键，每个分片最多取一个，分片不够时再从头取剩余的键
+func (dict *ConcurrentDict) RandomDistinctKeys(limit int) []string {
+	result := make([]string, 0, limit)
+	if limit <= 0 {
+		return result
+	}
+	seen := make(map[string]bool, limit)
+	for round := 0; round < 2 && len(result) < limit && len(result) < dict.Len(); round++ {
+		start := rand.Intn(ShardCount)
+		for i := 0; i < ShardCount && len(result) < limit; i++ {
+			s := &dict.shards[(start+i)&(ShardCount-1)]
+			s.mu.RLock()
+			for key := range s.m {
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				result = append(result, key)
+				if round == 0 || len(result) >= limit {
+					break
+				}
+			}
+			s.mu.RUnlock()
+		}
+	}
+	return result
+}
+
+// Clear 移除字典中的所有键
+func (dict *ConcurrentDict) Clear() {
+	for i := range dict.shards {
+		s := &dict.shards[i]
+		s.mu.Lock()
+		atomic.AddInt64(&dict.count, -int64(len(s.m)))
+		s.m = nil
+		s.mu.Unlock()
+	}
+}
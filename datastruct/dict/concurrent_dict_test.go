@@ -0,0 +1,110 @@
+package dict
+
+import (
+	"strconv"
+	"testing"
+)
+
+// scanAll 从游标 0 开始按 count 遍历到结束，每批之间调用 between，返回每个键被遍历的次数
+func scanAll(t *testing.T, dict *ConcurrentDict, count int, between func(round int)) map[string]int {
+	seen := make(map[string]int)
+	cursor, round := 0, 0
+	for {
+		next := dict.Scan(cursor, count, func(key string, val interface{}) bool {
+			seen[key]++
+			return true
+		})
+		if next == 0 {
+			return seen
+		}
+		if next <= cursor || next >= ShardCount {
+			t.Fatalf("cursor must increase within [1, %d), got %d after %d", ShardCount, next, cursor)
+		}
+		cursor = next
+		if between != nil {
+			between(round)
+		}
+		round++
+	}
+}
+
+func TestConcurrentDictScan(t *testing.T) {
+	dict := MakeConcurrentDict()
+	const n = 10000
+	for i := 0; i < n; i++ {
+		dict.Put("key"+strconv.Itoa(i), i)
+	}
+	for _, count := range []int{1, 10, 1000, n * 2} {
+		seen := scanAll(t, dict, count, nil)
+		if len(seen) != n {
+			t.Fatalf("count %d: expected %d keys, got %d", count, n, len(seen))
+		}
+		for key, times := range seen {
+			if times != 1 {
+				t.Fatalf("count %d: key %s returned %d times", count, key, times)
+			}
+		}
+	}
+}
+
+// TestConcurrentDictScanDuringWrites 遍历期间一直存在的键恰好被遍历一次，遍历期间加入或删除的键最多被遍历一次
+func TestConcurrentDictScanDuringWrites(t *testing.T) {
+	dict := MakeConcurrentDict()
+	const n = 5000
+	for i := 0; i < n; i++ {
+		dict.Put("stable"+strconv.Itoa(i), i)
+		dict.Put("removed"+strconv.Itoa(i), i)
+	}
+	seen := scanAll(t, dict, 100, func(round int) {
+		for i := 0; i < 50; i++ {
+			dict.Put("added"+strconv.Itoa(round*50+i), i)
+			dict.Remove("removed" + strconv.Itoa(round*50+i))
+		}
+	})
+	for i := 0; i < n; i++ {
+		if times := seen["stable"+strconv.Itoa(i)]; times != 1 {
+			t.Fatalf("key stable%d returned %d times", i, times)
+		}
+	}
+	for key, times := range seen {
+		if times != 1 {
+			t.Fatalf("key %s returned %d times", key, times)
+		}
+	}
+}
+
+func TestConcurrentDictScanEnd(t *testing.T) {
+	dict := MakeConcurrentDict()
+	if next := dict.Scan(0, 10, func(key string, val interface{}) bool { return true }); next != 0 {
+		t.Fatalf("scanning an empty dict should finish in one call, got cursor %d", next)
+	}
+	dict.Put("a", 1)
+	called := false
+	if next := dict.Scan(ShardCount, 10, func(key string, val interface{}) bool {
+		called = true
+		return true
+	}); next != 0 || called {
+		t.Fatal("cursor past the last shard should return 0 without visiting keys")
+	}
+}
+
+func TestConcurrentDictLen(t *testing.T) {
+	dict := MakeConcurrentDict()
+	dict.Put("a", 1)
+	dict.Put("a", 2)
+	dict.PutIfAbsent("b", 1)
+	dict.PutIfAbsent("b", 2)
+	dict.PutIfExists("c", 1)
+	if dict.Len() != 2 {
+		t.Fatalf("expected 2 keys, got %d", dict.Len())
+	}
+	dict.Remove("a")
+	dict.Remove("a")
+	if dict.Len() != 1 {
+		t.Fatalf("expected 1 key, got %d", dict.Len())
+	}
+	dict.Clear()
+	if dict.Len() != 0 || len(dict.Keys()) != 0 {
+		t.Fatalf("expected an empty dict after Clear, got %d", dict.Len())
+	}
+}
@@ -12,6 +12,8 @@ type Dict interface {
 	PutIfExists(key string, val interface{}) (result int)
 	Remove(key string) (result int)
 	ForEach(consumer Consumer)
+	// Scan 从游标 cursor 开始遍历大约 count 个键，返回下一次遍历的游标，遍历结束时返回 0
+	Scan(cursor int, count int, consumer Consumer) int
 	Keys() []string
 	RandomKeys(limit int) []string
 	RandomDistinctKeys(limit int) []string
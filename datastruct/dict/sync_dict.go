@@ -80,42 +80,53 @@ func (dict *SyncDict) Keys() []string {
 	return result
 }
 
-// ForEach 遍历字典
+// ForEach 遍历字典，consumer 返回 false 时停止遍历
 func (dict *SyncDict) ForEach(consumer Consumer) {
 	dict.m.Range(func(key, value interface{}) bool {
-		consumer(key.(string), value)
-		return true
+		return consumer(key.(string), value)
 	})
 }
 
-// RandomKeys 随机返回给定数量的键，可能包含重复的键
+// Scan 没有分片，一次遍历所有的键
+func (dict *SyncDict) Scan(cursor int, count int, consumer Consumer) int {
+	dict.ForEach(consumer)
+	return 0
+}
+
+// RandomKeys 随机返回给定数量的键，可能包含重复的键，字典为空时返回空切片
 func (dict *SyncDict) RandomKeys(limit int) []string {
-	result := make([]string, limit)
+	result := make([]string, 0, limit)
 	for i := 0; i < limit; i++ {
+		found := false
 		dict.m.Range(func(key, value interface{}) bool {
-			result[i] = key.(string)
+			result = append(result, key.(string))
+			found = true
 			return false
 		})
+		if !found {
+			break
+		}
 	}
 	return result
 }
 
-// RandomDistinctKeys 随机返回给定数量的键，不包含重复的键
+// RandomDistinctKeys 随机返回给定数量的键，不包含重复的键，键的数量不足时返回所有键
 func (dict *SyncDict) RandomDistinctKeys(limit int) []string {
-	result := make([]string, limit)
-	i := 0
+	result := make([]string, 0, limit)
+	if limit <= 0 {
+		return result
+	}
 	dict.m.Range(func(key, value interface{}) bool {
-		result[i] = key.((string))
-		i++
-		if i == limit {
-			return false
-		}
-		return true
+		result = append(result, key.(string))
+		return len(result) < limit
 	})
 	return result
 }
 
-// Clear 移除字典中的所有键
+// Clear 移除字典中的所有键，逐个删除而不是替换 sync.Map，避免与并发的读取产生竞争
 func (dict *SyncDict) Clear() {
-	*dict = *MakeSyncDict()
+	dict.m.Range(func(key, value interface{}) bool {
+		dict.m.Delete(key)
+		return true
+	})
 }
@@ -9,10 +9,16 @@ type CmdLine = [][]byte
 type Database interface {
 	Exec(client resp.Connection, args [][]byte) resp.Reply
 	AfterClientClose(c resp.Connection)
-	Close()
+	// Close 关闭数据库，save 为 true 时将尚未持久化的数据写入磁盘
+	Close(save bool) error
 }
 
 // DataEntity stores data bound to a key, including a string, list, hash, set and so on
 type DataEntity struct {
 	Data interface{}
+
+	// 以下字段由数据库维护，用于内存淘汰与 OBJECT、MEMORY 命令，并发访问时需使用 sync/atomic
+	Size       int64  // 键与值的近似内存占用，单位字节
+	LastAccess int64  // 最近一次访问的时间，unix 毫秒
+	LFU        uint32 // 高 16 位为最近一次衰减的时间（分钟），低 8 位为对数访问计数
 }
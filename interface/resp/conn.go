@@ -1,8 +1,37 @@
 package resp
 
+import "net"
+
 type Connection interface {
 	Write([]byte) error
+	// used for disconnecting slow pub/sub subscribers
+	Close() error
 	// used for multi database
 	GetDBIndex() int
 	SelectDB(int)
+	// used for RESP3 negotiation
+	GetProtocol() int
+	SetProtocol(int)
+	// RemoteAddr returns nil for internal fake connections
+	RemoteAddr() net.Addr
+	// used for CLIENT commands
+	GetID() uint64
+	GetName() string
+	SetName(string)
+	// used for AUTH
+	IsAuthenticated() bool
+	SetAuthenticated(bool)
+	// used for cluster internal commands, only authenticated peers may run them
+	IsPeer() bool
+	SetPeer(bool)
+	// used for cluster ASK redirection
+	IsAsking() bool
+	SetAsking(bool)
+	// used for MULTI/EXEC
+	InMultiState() bool
+	SetMultiState(bool)
+	GetQueuedCmdLine() [][][]byte
+	EnqueueCmd([][]byte)
+	AddTxError(err error)
+	GetTxErrors() []error
 }
@@ -3,51 +3,152 @@ package consistenthash
 import (
 	"hash/crc32"
 	"sort"
+	"strconv"
+	"sync"
 )
 
 // HashFunc 定义了生成哈希码的函数类型
 type HashFunc func(data []byte) uint32
 
+// DefaultReplicas 开启虚拟节点时推荐的每个权重单位的虚拟节点数
+// 每个节点只有一个点时，3~5 个节点的集群中各节点分到的键数可能相差数倍
+const DefaultReplicas = 160
+
+// LegacyReplicas 不使用虚拟节点，与早期版本相同，每个节点只在 hash(节点名) 处放置一个点并忽略权重，
+// 从早期版本升级的集群使用该模式时键的归属不变
+const LegacyReplicas = 0
+
+// hashSpace 哈希环的大小
+const hashSpace = 1 << 32
+
 // NodeMap 存储节点，并且可以从 NodeMap 中选择节点
+// 每个节点在环上放置 replicas * weight 个虚拟节点，replicas 为 LegacyReplicas 时只放置一个点，可以在运行时增删节点
 type NodeMap struct {
+	mu          sync.RWMutex
 	hashFunc    HashFunc       // 用于生成哈希值的函数
-	nodeHashs   []int          // 存储节点哈希值的已排序切片
-	nodehashMap map[int]string // 哈希值到节点名称的映射
+	replicas    int            // 每个权重单位的虚拟节点数，LegacyReplicas 表示不使用虚拟节点
+	weights     map[string]int // 节点名称到权重的映射
+	nodeHashs   []int          // 存储虚拟节点哈希值的已排序切片
+	nodehashMap map[int]string // 虚拟节点哈希值到节点名称的映射
+}
+
+// NodeShare 描述一个节点在哈希环上拥有的份额
+type NodeShare struct {
+	Node   string
+	Weight int
+	Points int     // 虚拟节点数
+	Share  float64 // 拥有的哈希空间比例，所有节点之和为 1
 }
 
-// NewNodeMap 创建一个新的 NodeMap
-func NewNodeMap(fn HashFunc) *NodeMap {
+// NewNodeMap 创建一个新的 NodeMap，replicas <= 0 时使用 LegacyReplicas，fn 为 nil 时使用 crc32
+func NewNodeMap(replicas int, fn HashFunc) *NodeMap {
 	m := &NodeMap{
 		hashFunc:    fn,
+		replicas:    replicas,
+		weights:     make(map[string]int),
 		nodehashMap: make(map[int]string),
 	}
 	if m.hashFunc == nil {
 		m.hashFunc = crc32.ChecksumIEEE // 默认使用 crc32.ChecksumIEEE 作为哈希函数
 	}
+	if m.replicas <= 0 {
+		m.replicas = LegacyReplicas
+	}
 	return m
 }
 
 // IsEmpty 返回 NodeMap 是否为空（没有节点）
 func (m *NodeMap) IsEmpty() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	return len(m.nodeHashs) == 0
 }
 
-// AddNode 将给定的节点添加到一致性哈希环中
+// AddNode 将给定的节点以权重 1 添加到一致性哈希环中
 func (m *NodeMap) AddNode(keys ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range keys {
+		if key != "" {
+			m.weights[key] = 1
+		}
+	}
+	m.rebuild()
+}
+
+// AddWeightedNode 添加节点或修改已有节点的权重，权重越大分到的键越多，weight <= 0 时移除节点
+func (m *NodeMap) AddWeightedNode(key string, weight int) {
+	if key == "" {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if weight <= 0 {
+		delete(m.weights, key)
+	} else {
+		m.weights[key] = weight
+	}
+	m.rebuild()
+}
+
+// RemoveNode 从哈希环中移除节点，原本属于这些节点的键由环上的下一个节点接管
+func (m *NodeMap) RemoveNode(keys ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	for _, key := range keys {
-		if key == "" {
+		delete(m.weights, key)
+	}
+	m.rebuild()
+}
+
+// Nodes 返回按名称排序的所有节点
+func (m *NodeMap) Nodes() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.sortedNodes()
+}
+
+func (m *NodeMap) sortedNodes() []string {
+	nodes := make([]string, 0, len(m.weights))
+	for node := range m.weights {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// rebuild 根据节点与权重重新生成哈希环，调用方需持有写锁
+// 按节点名称的顺序放置虚拟节点，哈希冲突时先放置的节点胜出，保证所有节点计算出相同的环
+func (m *NodeMap) rebuild() {
+	m.nodeHashs = m.nodeHashs[:0]
+	m.nodehashMap = make(map[int]string)
+	for _, node := range m.sortedNodes() {
+		if m.replicas == LegacyReplicas {
+			hash := int(m.hashFunc([]byte(node)))
+			if _, ok := m.nodehashMap[hash]; !ok {
+				m.nodehashMap[hash] = node
+				m.nodeHashs = append(m.nodeHashs, hash)
+			}
 			continue
 		}
-		hash := int(m.hashFunc([]byte(key)))
-		m.nodeHashs = append(m.nodeHashs, hash)
-		m.nodehashMap[hash] = key
+		points := m.replicas * m.weights[node]
+		for i := 0; i < points; i++ {
+			hash := int(m.hashFunc([]byte(node + "#" + strconv.Itoa(i))))
+			if _, ok := m.nodehashMap[hash]; ok {
+				continue
+			}
+			m.nodehashMap[hash] = node
+			m.nodeHashs = append(m.nodeHashs, hash)
+		}
 	}
 	sort.Ints(m.nodeHashs) // 对节点哈希值进行排序
 }
 
 // PickNode 根据提供的键值，在哈希环上获取最接近的节点
 func (m *NodeMap) PickNode(key string) string {
-	if m.IsEmpty() {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if len(m.nodeHashs) == 0 {
 		return ""
 	}
 
@@ -65,3 +166,31 @@ func (m *NodeMap) PickNode(key string) string {
 
 	return m.nodehashMap[m.nodeHashs[idx]]
 }
+
+// Distribution 返回每个节点拥有的哈希空间比例，按节点名称排序
+// 每个虚拟节点拥有从环上前一个虚拟节点（不含）到自身（含）的区间
+func (m *NodeMap) Distribution() []NodeShare {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	owned := make(map[string]int64)
+	points := make(map[string]int)
+	for i, hash := range m.nodeHashs {
+		prev := m.nodeHashs[len(m.nodeHashs)-1] - hashSpace
+		if i > 0 {
+			prev = m.nodeHashs[i-1]
+		}
+		node := m.nodehashMap[hash]
+		owned[node] += int64(hash - prev)
+		points[node]++
+	}
+	result := make([]NodeShare, 0, len(m.weights))
+	for _, node := range m.sortedNodes() {
+		result = append(result, NodeShare{
+			Node:   node,
+			Weight: m.weights[node],
+			Points: points[node],
+			Share:  float64(owned[node]) / hashSpace,
+		})
+	}
+	return result
+}
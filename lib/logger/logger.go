@@ -40,6 +40,16 @@ func init() {
 	LogrusObj = logger
 }
 
+// SetLevel 设置日志级别，支持 debug、info、warn 与 error
+func SetLevel(level string) error {
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	LogrusObj.SetLevel(lvl)
+	return nil
+}
+
 func setOutPutFile() (*os.File, error) {
 	now := time.Now()
 	logFilePath := ""
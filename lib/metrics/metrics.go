@@ -0,0 +1,272 @@
+// Package metrics 提供一个轻量的指标注册表，并以 Prometheus 文本格式输出
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// collector 表示一个可以输出 Prometheus 文本格式的指标
+type collector interface {
+	name() string
+	write(buf *bytes.Buffer)
+}
+
+// registry 保存所有已注册的指标，按注册顺序输出
+type registry struct {
+	mu         sync.RWMutex
+	collectors []collector
+}
+
+var defaultRegistry = &registry{}
+
+// register 注册指标，同名指标会被替换
+func (r *registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, exist := range r.collectors {
+		if exist.name() == c.name() {
+			r.collectors[i] = c
+			return
+		}
+	}
+	r.collectors = append(r.collectors, c)
+}
+
+// WriteTo 将所有指标以 Prometheus 文本格式写入 buf
+func WriteTo(buf *bytes.Buffer) {
+	defaultRegistry.mu.RLock()
+	collectors := make([]collector, len(defaultRegistry.collectors))
+	copy(collectors, defaultRegistry.collectors)
+	defaultRegistry.mu.RUnlock()
+	for _, c := range collectors {
+		c.write(buf)
+	}
+}
+
+func writeHeader(buf *bytes.Buffer, name, help, typ string) {
+	buf.WriteString("# HELP " + name + " " + help + "\n")
+	buf.WriteString("# TYPE " + name + " " + typ + "\n")
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels 拼接标签，labels 为 name value 交替排列，值为空的标签不输出
+func formatLabels(labels ...string) string {
+	parts := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		if labels[i] == "" {
+			continue
+		}
+		parts = append(parts, labels[i]+`="`+labelEscaper.Replace(labels[i+1])+`"`)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+/* ---- Counter ---- */
+
+// CounterVec 是带有一个标签的计数器
+type CounterVec struct {
+	metricName string
+	help       string
+	label      string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounterVec 创建并注册计数器
+func NewCounterVec(name, help, label string) *CounterVec {
+	c := &CounterVec{
+		metricName: name,
+		help:       help,
+		label:      label,
+		values:     make(map[string]float64),
+	}
+	defaultRegistry.register(c)
+	return c
+}
+
+// Inc 将标签值对应的计数加一
+func (c *CounterVec) Inc(labelValue string) {
+	c.Add(labelValue, 1)
+}
+
+// Add 将标签值对应的计数增加 delta
+func (c *CounterVec) Add(labelValue string, delta float64) {
+	c.mu.Lock()
+	c.values[labelValue] += delta
+	c.mu.Unlock()
+}
+
+func (c *CounterVec) name() string {
+	return c.metricName
+}
+
+func (c *CounterVec) write(buf *bytes.Buffer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeHeader(buf, c.metricName, c.help, "counter")
+	for _, lv := range sortedKeys(c.values) {
+		buf.WriteString(c.metricName + formatLabels(c.label, lv) + " " + formatFloat(c.values[lv]) + "\n")
+	}
+}
+
+/* ---- Gauge ---- */
+
+// gaugeFunc 在输出时调用 collect 获取当前值，返回值的 key 为标签值
+type gaugeFunc struct {
+	metricName string
+	help       string
+	label      string
+	collect    func() map[string]float64
+}
+
+// RegisterGaugeFunc 注册一个在抓取时计算取值的仪表盘指标
+// label 为空时 collect 返回的 map 中只应包含一个 key 为空字符串的值
+func RegisterGaugeFunc(name, help, label string, collect func() map[string]float64) {
+	defaultRegistry.register(&gaugeFunc{
+		metricName: name,
+		help:       help,
+		label:      label,
+		collect:    collect,
+	})
+}
+
+func (g *gaugeFunc) name() string {
+	return g.metricName
+}
+
+func (g *gaugeFunc) write(buf *bytes.Buffer) {
+	values := g.collect()
+	writeHeader(buf, g.metricName, g.help, "gauge")
+	for _, lv := range sortedKeys(values) {
+		buf.WriteString(g.metricName + formatLabels(g.label, lv) + " " + formatFloat(values[lv]) + "\n")
+	}
+}
+
+/* ---- Histogram ---- */
+
+// DefaultLatencyBuckets 命令耗时直方图默认的桶边界，单位为秒
+var DefaultLatencyBuckets = []float64{
+	0.00001, 0.00005, 0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005,
+	0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1,
+}
+
+// histogram 单个标签值的直方图，使用 sync/atomic 访问
+type histogram struct {
+	counts []uint64 // 每个桶的计数，不是累计值
+	sum    uint64   // 观测值之和，按 math.Float64bits 存储
+	count  uint64
+}
+
+// HistogramVec 是带有一个标签的直方图
+type HistogramVec struct {
+	metricName string
+	help       string
+	label      string
+	buckets    []float64
+
+	series sync.Map // 标签值 -> *histogram，Observe 位于命令执行的路径上，不使用全局锁
+}
+
+// NewHistogramVec 创建并注册直方图，buckets 必须升序排列
+func NewHistogramVec(name, help, label string, buckets []float64) *HistogramVec {
+	h := &HistogramVec{
+		metricName: name,
+		help:       help,
+		label:      label,
+		buckets:    buckets,
+	}
+	defaultRegistry.register(h)
+	return h
+}
+
+// Observe 记录标签值对应的一个观测值
+func (h *HistogramVec) Observe(labelValue string, v float64) {
+	idx := sort.SearchFloat64s(h.buckets, v)
+	value, ok := h.series.Load(labelValue)
+	if !ok {
+		value, _ = h.series.LoadOrStore(labelValue, &histogram{counts: make([]uint64, len(h.buckets))})
+	}
+	s := value.(*histogram)
+	if idx < len(h.buckets) {
+		atomic.AddUint64(&s.counts[idx], 1)
+	}
+	for {
+		old := atomic.LoadUint64(&s.sum)
+		if atomic.CompareAndSwapUint64(&s.sum, old, math.Float64bits(math.Float64frombits(old)+v)) {
+			break
+		}
+	}
+	atomic.AddUint64(&s.count, 1)
+}
+
+func (h *HistogramVec) name() string {
+	return h.metricName
+}
+
+func (h *HistogramVec) write(buf *bytes.Buffer) {
+	writeHeader(buf, h.metricName, h.help, "histogram")
+	var labelValues []string
+	h.series.Range(func(key, value interface{}) bool {
+		labelValues = append(labelValues, key.(string))
+		return true
+	})
+	sort.Strings(labelValues)
+	for _, lv := range labelValues {
+		value, _ := h.series.Load(lv)
+		s := value.(*histogram)
+		// 各个值之间可能相差正在进行的观测，保证 +Inf 桶不小于其他桶
+		var cumulative uint64
+		counts := make([]uint64, len(h.buckets))
+		for i := range h.buckets {
+			counts[i] = atomic.LoadUint64(&s.counts[i])
+			cumulative += counts[i]
+		}
+		count := atomic.LoadUint64(&s.count)
+		if count < cumulative {
+			count = cumulative
+		}
+		cumulative = 0
+		for i, upper := range h.buckets {
+			cumulative += counts[i]
+			buf.WriteString(h.metricName + "_bucket" + formatLabels(h.label, lv, "le", formatFloat(upper)) +
+				" " + strconv.FormatUint(cumulative, 10) + "\n")
+		}
+		sum := math.Float64frombits(atomic.LoadUint64(&s.sum))
+		buf.WriteString(h.metricName + "_bucket" + formatLabels(h.label, lv, "le", "+Inf") +
+			" " + strconv.FormatUint(count, 10) + "\n")
+		buf.WriteString(h.metricName + "_sum" + formatLabels(h.label, lv) + " " + formatFloat(sum) + "\n")
+		buf.WriteString(h.metricName + "_count" + formatLabels(h.label, lv) + " " + strconv.FormatUint(count, 10) + "\n")
+	}
+}
@@ -0,0 +1,26 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// contentType Prometheus 文本格式的 Content-Type
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Handler 返回输出所有指标的 http.Handler
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		WriteTo(&buf)
+		w.Header().Set("Content-Type", contentType)
+		_, _ = w.Write(buf.Bytes())
+	})
+}
+
+// ListenAndServe 在 addr 上启动 HTTP 服务，通过 /metrics 暴露指标，阻塞直到出错
+func ListenAndServe(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	return http.ListenAndServe(addr, mux)
+}
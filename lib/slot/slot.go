@@ -0,0 +1,58 @@
+// Package slot 实现与 redis cluster 相同的哈希槽计算：CRC16(key) mod 16384
+package slot
+
+// Count 哈希槽的数量
+const Count = 16384
+
+// crc16Table CRC16-CCITT (XMODEM) 的查找表，多项式 0x1021
+var crc16Table = makeTable(0x1021)
+
+func makeTable(poly uint16) *[256]uint16 {
+	table := new([256]uint16)
+	for i := 0; i < 256; i++ {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ poly
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}
+
+// CRC16 计算 redis cluster 使用的 CRC16 校验和
+func CRC16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^b]
+	}
+	return crc
+}
+
+// HashTag 返回 key 中参与计算哈希槽的部分
+// key 中第一个 { 与其后第一个 } 之间的内容非空时只使用该内容，例如 {user1000}.following 与 {user1000}.followers 位于同一个槽
+func HashTag(key string) string {
+	for i := 0; i < len(key); i++ {
+		if key[i] != '{' {
+			continue
+		}
+		for j := i + 1; j < len(key); j++ {
+			if key[j] == '}' {
+				if j == i+1 {
+					return key
+				}
+				return key[i+1 : j]
+			}
+		}
+		return key
+	}
+	return key
+}
+
+// KeySlot 返回 key 所在的哈希槽
+func KeySlot(key string) int {
+	return int(CRC16([]byte(HashTag(key)))) % Count
+}
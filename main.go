@@ -1,22 +1,82 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"github.com/ygxiaobai111/GolixirDB/config"
 	util "github.com/ygxiaobai111/GolixirDB/lib/logger"
+	"github.com/ygxiaobai111/GolixirDB/lib/metrics"
 	"github.com/ygxiaobai111/GolixirDB/resp/handler"
 	"github.com/ygxiaobai111/GolixirDB/tcp"
+	"os"
 )
 
 func main() {
-	err := tcp.ListenAndServeWithSignal(
+	props, err := config.LoadFromArgs(os.Args[1:])
+	if err != nil {
+		if err == flag.ErrHelp {
+			return
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	config.SetProperties(props)
+	if err = util.SetLevel(props.LogLevel); err != nil {
+		util.LogrusObj.Warn(err)
+	}
+	config.OnChange(func(old, updated *config.ServerProperties) {
+		if old.LogLevel != updated.LogLevel {
+			if err := util.SetLevel(updated.LogLevel); err != nil {
+				util.LogrusObj.Warn(err)
+			}
+		}
+	})
+	if config.Properties().MetricsAddr != "" {
+		go func() {
+			util.LogrusObj.Info("metrics listening on " + config.Properties().MetricsAddr)
+			if err := metrics.ListenAndServe(config.Properties().MetricsAddr); err != nil {
+				util.LogrusObj.Error(err)
+			}
+		}()
+	}
+	h, err := handler.MakeHandler()
+	if err != nil {
+		// 日志可能只写入文件，同时输出到标准错误
+		util.LogrusObj.Error(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	err = tcp.ListenAndServeWithSignal(
 		&tcp.Config{
 			Address: fmt.Sprintf("%s:%d",
-				config.Properties.Bind,
-				config.Properties.Port),
+				config.Properties().Bind,
+				config.Properties().Port),
+			OnReload: reloadConfig,
+			Shutdown: h.ShutdownRequested(),
 		},
-		handler.MakeHandler())
+		h)
 	if err != nil {
 		util.LogrusObj.Error(err)
+		os.Exit(1)
+	}
+}
+
+// reloadConfig 重新加载配置文件并记录发生变化的配置项
+func reloadConfig() {
+	changes, err := config.Reload()
+	if err != nil {
+		util.LogrusObj.Error("reload config failed, keeping current config: " + err.Error())
+		return
+	}
+	if len(changes) == 0 {
+		util.LogrusObj.Info("config reloaded, nothing changed")
+		return
+	}
+	for _, change := range changes {
+		if change.Applied {
+			util.LogrusObj.Info("config reloaded, " + change.String())
+		} else {
+			util.LogrusObj.Warn("config reloaded, " + change.String())
+		}
 	}
 }
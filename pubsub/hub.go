@@ -0,0 +1,326 @@
+// Package pubsub 实现发布订阅
+package pubsub
+
+import (
+	"github.com/ygxiaobai111/GolixirDB/interface/resp"
+	"github.com/ygxiaobai111/GolixirDB/lib/wildcard"
+	"github.com/ygxiaobai111/GolixirDB/resp/reply"
+	"sort"
+	"sync"
+)
+
+/*
+消息通过每个订阅者独立的带缓冲 channel 异步推送，发布消息不会因为某个订阅者读取缓慢而阻塞
+积压超过 subscriberBufferSize 条消息的订阅者会被断开，与 MONITOR 的处理方式相同
+订阅确认与消息使用同一个 channel，客户端看到的顺序与服务器处理的顺序一致
+RESP3 连接以 push 类型接收消息，RESP2 连接以数组接收
+*/
+
+// subscriberBufferSize 每个订阅者最多积压的消息数
+const subscriberBufferSize = 1024
+
+// subscriber 一个订阅了频道或模式的连接
+type subscriber struct {
+	conn     resp.Connection
+	channels map[string]struct{} // 由 Hub.mu 保护
+	patterns map[string]struct{} // 由 Hub.mu 保护
+
+	// 多个发布者可能同时向同一个订阅者推送，mu 保护 ch 的发送与关闭
+	mu     sync.Mutex
+	ch     chan []byte
+	closed bool
+}
+
+// count 返回订阅的频道与模式总数
+func (s *subscriber) count() int {
+	return len(s.channels) + len(s.patterns)
+}
+
+// patternSubs 订阅了同一个模式的连接
+type patternSubs struct {
+	pattern *wildcard.Pattern
+	subs    map[*subscriber]struct{}
+}
+
+// Hub 记录所有频道与模式的订阅关系
+type Hub struct {
+	mu          sync.RWMutex
+	channels    map[string]map[*subscriber]struct{}
+	patterns    map[string]*patternSubs
+	subscribers map[resp.Connection]*subscriber
+}
+
+// MakeHub 创建 Hub
+func MakeHub() *Hub {
+	return &Hub{
+		channels:    make(map[string]map[*subscriber]struct{}),
+		patterns:    make(map[string]*patternSubs),
+		subscribers: make(map[resp.Connection]*subscriber),
+	}
+}
+
+// getSubscriber 返回连接对应的订阅者，不存在时创建并启动推送协程，调用方需持有写锁
+func (hub *Hub) getSubscriber(c resp.Connection) *subscriber {
+	sub, ok := hub.subscribers[c]
+	if ok {
+		return sub
+	}
+	sub = &subscriber{
+		conn:     c,
+		ch:       make(chan []byte, subscriberBufferSize),
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+	}
+	hub.subscribers[c] = sub
+	go func() {
+		for msg := range sub.ch {
+			if err := c.Write(msg); err != nil {
+				// 连接关闭后由 UnsubscribeAll 清理
+				for range sub.ch {
+				}
+				return
+			}
+		}
+	}()
+	return sub
+}
+
+// send 非阻塞地推送消息，缓冲区已满时断开连接
+func (hub *Hub) send(sub *subscriber, msg []byte) bool {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.closed {
+		return false
+	}
+	select {
+	case sub.ch <- msg:
+		return true
+	default:
+		sub.closed = true
+		close(sub.ch)
+		go func() {
+			_ = sub.conn.Close()
+		}()
+		return false
+	}
+}
+
+// makeMessage 按连接的协议版本编码推送消息
+func makeMessage(c resp.Connection, args ...resp.Reply) []byte {
+	return reply.ToProtocolBytes(reply.MakePushReply(args), c.GetProtocol())
+}
+
+func bulk(s string) resp.Reply {
+	return reply.MakeBulkReply([]byte(s))
+}
+
+// Subscribe 订阅频道，每个频道回复一条 subscribe 确认
+func (hub *Hub) Subscribe(c resp.Connection, channels []string) resp.Reply {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	sub := hub.getSubscriber(c)
+	for _, channel := range channels {
+		if _, ok := sub.channels[channel]; !ok {
+			sub.channels[channel] = struct{}{}
+			subs, ok := hub.channels[channel]
+			if !ok {
+				subs = make(map[*subscriber]struct{})
+				hub.channels[channel] = subs
+			}
+			subs[sub] = struct{}{}
+		}
+		hub.send(sub, makeMessage(c, bulk("subscribe"), bulk(channel), reply.MakeIntReply(int64(sub.count()))))
+	}
+	return &reply.NoReply{}
+}
+
+// PSubscribe 订阅模式，每个模式回复一条 psubscribe 确认
+func (hub *Hub) PSubscribe(c resp.Connection, patterns []string) resp.Reply {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	sub := hub.getSubscriber(c)
+	for _, pattern := range patterns {
+		if _, ok := sub.patterns[pattern]; !ok {
+			sub.patterns[pattern] = struct{}{}
+			ps, ok := hub.patterns[pattern]
+			if !ok {
+				ps = &patternSubs{
+					pattern: wildcard.CompilePattern(pattern),
+					subs:    make(map[*subscriber]struct{}),
+				}
+				hub.patterns[pattern] = ps
+			}
+			ps.subs[sub] = struct{}{}
+		}
+		hub.send(sub, makeMessage(c, bulk("psubscribe"), bulk(pattern), reply.MakeIntReply(int64(sub.count()))))
+	}
+	return &reply.NoReply{}
+}
+
+// Unsubscribe 取消订阅频道，channels 为空时取消所有频道
+func (hub *Hub) Unsubscribe(c resp.Connection, channels []string) resp.Reply {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	sub, ok := hub.subscribers[c]
+	if !ok {
+		return makeEmptyUnsubscribeReply("unsubscribe")
+	}
+	if len(channels) == 0 {
+		channels = sortedKeys(sub.channels)
+		if len(channels) == 0 {
+			hub.send(sub, makeMessage(c, bulk("unsubscribe"), reply.MakeNullBulkReply(), reply.MakeIntReply(int64(sub.count()))))
+			return &reply.NoReply{}
+		}
+	}
+	for _, channel := range channels {
+		hub.removeChannel(sub, channel)
+		hub.send(sub, makeMessage(c, bulk("unsubscribe"), bulk(channel), reply.MakeIntReply(int64(sub.count()))))
+	}
+	return &reply.NoReply{}
+}
+
+// PUnsubscribe 取消订阅模式，patterns 为空时取消所有模式
+func (hub *Hub) PUnsubscribe(c resp.Connection, patterns []string) resp.Reply {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	sub, ok := hub.subscribers[c]
+	if !ok {
+		return makeEmptyUnsubscribeReply("punsubscribe")
+	}
+	if len(patterns) == 0 {
+		patterns = sortedKeys(sub.patterns)
+		if len(patterns) == 0 {
+			hub.send(sub, makeMessage(c, bulk("punsubscribe"), reply.MakeNullBulkReply(), reply.MakeIntReply(int64(sub.count()))))
+			return &reply.NoReply{}
+		}
+	}
+	for _, pattern := range patterns {
+		hub.removePattern(sub, pattern)
+		hub.send(sub, makeMessage(c, bulk("punsubscribe"), bulk(pattern), reply.MakeIntReply(int64(sub.count()))))
+	}
+	return &reply.NoReply{}
+}
+
+// makeEmptyUnsubscribeReply 未订阅任何频道的连接执行 UNSUBSCRIBE 时的回复
+func makeEmptyUnsubscribeReply(kind string) resp.Reply {
+	return reply.MakePushReply([]resp.Reply{bulk(kind), reply.MakeNullBulkReply(), reply.MakeIntReply(0)})
+}
+
+func (hub *Hub) removeChannel(sub *subscriber, channel string) {
+	delete(sub.channels, channel)
+	if subs, ok := hub.channels[channel]; ok {
+		delete(subs, sub)
+		if len(subs) == 0 {
+			delete(hub.channels, channel)
+		}
+	}
+}
+
+func (hub *Hub) removePattern(sub *subscriber, pattern string) {
+	delete(sub.patterns, pattern)
+	if ps, ok := hub.patterns[pattern]; ok {
+		delete(ps.subs, sub)
+		if len(ps.subs) == 0 {
+			delete(hub.patterns, pattern)
+		}
+	}
+}
+
+// UnsubscribeAll 连接关闭时取消其所有订阅
+func (hub *Hub) UnsubscribeAll(c resp.Connection) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	sub, ok := hub.subscribers[c]
+	if !ok {
+		return
+	}
+	for channel := range sub.channels {
+		hub.removeChannel(sub, channel)
+	}
+	for pattern := range sub.patterns {
+		hub.removePattern(sub, pattern)
+	}
+	delete(hub.subscribers, c)
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if !sub.closed {
+		sub.closed = true
+		close(sub.ch)
+	}
+}
+
+// Publish 向频道发布消息，返回收到消息的连接数
+func (hub *Hub) Publish(channel string, message []byte) int {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	if len(hub.channels) == 0 && len(hub.patterns) == 0 {
+		return 0
+	}
+	received := 0
+	for sub := range hub.channels[channel] {
+		if hub.send(sub, makeMessage(sub.conn, bulk("message"), bulk(channel), reply.MakeBulkReply(message))) {
+			received++
+		}
+	}
+	for pattern, ps := range hub.patterns {
+		if !ps.pattern.IsMatch(channel) {
+			continue
+		}
+		for sub := range ps.subs {
+			msg := makeMessage(sub.conn, bulk("pmessage"), bulk(pattern), bulk(channel), reply.MakeBulkReply(message))
+			if hub.send(sub, msg) {
+				received++
+			}
+		}
+	}
+	return received
+}
+
+// IsSubscribed 判断连接是否订阅了任何频道或模式
+func (hub *Hub) IsSubscribed(c resp.Connection) bool {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	sub, ok := hub.subscribers[c]
+	return ok && sub.count() > 0
+}
+
+// Channels 返回至少有一个订阅者且匹配 pattern 的频道，pattern 为空时返回所有频道
+func (hub *Hub) Channels(pattern string) []string {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	var p *wildcard.Pattern
+	if pattern != "" {
+		p = wildcard.CompilePattern(pattern)
+	}
+	result := make([]string, 0, len(hub.channels))
+	for channel := range hub.channels {
+		if p == nil || p.IsMatch(channel) {
+			result = append(result, channel)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// NumSub 返回频道的订阅者数量
+func (hub *Hub) NumSub(channel string) int {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	return len(hub.channels[channel])
+}
+
+// NumPat 返回被订阅的模式数量
+func (hub *Hub) NumPat() int {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	return len(hub.patterns)
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
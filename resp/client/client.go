@@ -2,14 +2,20 @@ package client
 
 import (
 	// 引入所需的包
+	"errors"
 	"github.com/ygxiaobai111/GolixirDB/interface/resp"
 	util "github.com/ygxiaobai111/GolixirDB/lib/logger"
 	"github.com/ygxiaobai111/GolixirDB/lib/sync/wait"
+	"github.com/ygxiaobai111/GolixirDB/lib/utils"
 	"github.com/ygxiaobai111/GolixirDB/resp/parser"
 	"github.com/ygxiaobai111/GolixirDB/resp/reply"
+	"io"
 	"net"
 	"runtime/debug"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -20,6 +26,13 @@ type Client struct {
 	waitingReqs chan *request // 等待响应的请求队列
 	ticker      *time.Ticker  // 定时器，用于心跳检测
 	addr        string        // 服务器地址
+	protocol    int           // 通过 HELLO 协商的协议版本，重连后需要重新协商
+	password    string        // 通过 AUTH 认证使用的密码，重连后需要重新认证
+	dbIndex     int32         // 服务器上当前选择的数据库，-1 表示未知，重连后需要重新选择，使用 sync/atomic 访问
+	setup       [][][]byte    // 通过 Setup 执行成功的命令，重连后需要重新执行
+	onPush      func(push *reply.PushReply)
+	readerDone  chan struct{} // 当前连接的读协程退出时关闭，只由写协程在重连时替换
+	writerDone  chan struct{} // 写协程退出时关闭
 
 	working *sync.WaitGroup // 用于跟踪未完成请求（包括等待和正在处理的请求）
 }
@@ -40,6 +53,16 @@ const (
 	maxWait  = 3 * time.Second // 最大等待时间
 )
 
+var (
+	timeoutReply       = reply.MakeErrReply("server time out")
+	requestFailedReply = reply.MakeErrReply("request failed")
+)
+
+// IsTransportError 判断回复是否由超时或发送失败产生，而不是服务器返回的错误
+func IsTransportError(r resp.Reply) bool {
+	return r == timeoutReply || r == requestFailedReply
+}
+
 // MakeClient 创建一个新的客户端实例
 func MakeClient(addr string) (*Client, error) {
 	conn, err := net.Dial("tcp", addr) // 建立 TCP 连接
@@ -49,8 +72,10 @@ func MakeClient(addr string) (*Client, error) {
 	return &Client{
 		addr:        addr,
 		conn:        conn,
+		protocol:    reply.Resp2,
 		pendingReqs: make(chan *request, chanSize),
 		waitingReqs: make(chan *request, chanSize),
+		writerDone:  make(chan struct{}),
 		working:     &sync.WaitGroup{},
 	}, nil
 }
@@ -58,51 +83,149 @@ func MakeClient(addr string) (*Client, error) {
 // Start 启动客户端的异步协程
 func (client *Client) Start() {
 	client.ticker = time.NewTicker(10 * time.Second) // 设置心跳检测间隔
+	client.startReader()                             // 处理读操作的协程
 	go client.handleWrite()                          // 处理写操作的协程
-	go func() {
-		err := client.handleRead() // 处理读操作的协程
-		if err != nil {
-			util.LogrusObj.Error(err)
-		}
-	}()
-	go client.heartbeat() // 心跳检测协程
+	go client.heartbeat()                            // 心跳检测协程
 }
 
-// Close 停止异步协程并关闭连接
+// Close 停止异步协程并关闭连接，尚未收到响应的请求以失败结束
 func (client *Client) Close() {
 	// 关闭操作和资源清理
 	client.ticker.Stop()
 	close(client.pendingReqs)
 	client.working.Wait()
+	<-client.writerDone
 	_ = client.conn.Close()
-	close(client.waitingReqs)
+	<-client.readerDone
+	client.failWaiting(errors.New("client closed"))
 }
 
-// handleConnectionError 处理连接错误
-func (client *Client) handleConnectionError(err error) error {
-	// 连接错误处理，重连逻辑
-	err1 := client.conn.Close()
-	if err1 != nil {
-		if opErr, ok := err1.(*net.OpError); ok {
-			if opErr.Err.Error() != "use of closed network connection" {
-				return err1
+// startReader 为当前连接启动读协程
+func (client *Client) startReader() {
+	done := make(chan struct{})
+	client.readerDone = done
+	go client.handleRead(client.conn, done)
+}
+
+// failWaiting 让已发送但还没有收到响应的请求以 err 失败，调用时读协程必须已经退出
+func (client *Client) failWaiting(err error) {
+	for {
+		select {
+		case req := <-client.waitingReqs:
+			if req == nil {
+				continue
 			}
-		} else {
-			return err1
+			req.err = err
+			if req.waiting != nil {
+				req.waiting.Done()
+			}
+		default:
+			return
 		}
 	}
+}
+
+// handleConnectionError 处理连接错误，只在写协程中调用
+// 先关闭旧连接并等待旧连接的读协程退出，再让旧连接上尚未收到响应的请求失败，之后才建立新连接，
+// 因此旧连接的读协程不会取走新连接上请求的位置，新连接的响应也不会交给旧连接上的请求
+func (client *Client) handleConnectionError(err error) error {
+	_ = client.conn.Close()
+	<-client.readerDone
+	client.failWaiting(err)
 	conn, err1 := net.Dial("tcp", client.addr)
 	if err1 != nil {
 		util.LogrusObj.Error(err1)
 		return err1
 	}
 	client.conn = conn
-	go func() {
-		_ = client.handleRead()
-	}()
+	client.startReader()
+	// 新连接需要重新认证并协商协议，响应由占位请求接收
+	for _, args := range client.handshake() {
+		req := &request{
+			args:      args,
+			heartbeat: true,
+			waiting:   &wait.Wait{},
+		}
+		req.waiting.Add(1)
+		_, err1 = client.conn.Write(reply.MakeMultiBulkReply(req.args).ToBytes())
+		if err1 != nil {
+			return err1
+		}
+		client.waitingReqs <- req
+	}
 	return nil
 }
 
+// handshake 返回重连后需要重新发送的认证、协议协商与选择数据库命令
+func (client *Client) handshake() [][][]byte {
+	var cmds [][][]byte
+	if client.password != "" {
+		cmds = append(cmds, utils.ToCmdLine("AUTH", client.password))
+	}
+	cmds = append(cmds, client.setup...)
+	if client.protocol != reply.Resp2 {
+		cmds = append(cmds, utils.ToCmdLine("HELLO", strconv.Itoa(client.protocol)))
+	}
+	if dbIndex := atomic.LoadInt32(&client.dbIndex); dbIndex > 0 {
+		cmds = append(cmds, utils.ToCmdLine("SELECT", strconv.Itoa(int(dbIndex))))
+	}
+	return cmds
+}
+
+// DBIndex 返回服务器上当前选择的数据库，-1 表示未知
+func (client *Client) DBIndex() int {
+	return int(atomic.LoadInt32(&client.dbIndex))
+}
+
+// trackSelect 根据 SELECT 的结果记录当前选择的数据库，没有收到响应时记为未知
+func (client *Client) trackSelect(args [][]byte, result resp.Reply) {
+	if len(args) != 2 || !strings.EqualFold(string(args[0]), "select") {
+		return
+	}
+	if IsTransportError(result) {
+		atomic.StoreInt32(&client.dbIndex, -1)
+		return
+	}
+	if reply.IsErrorReply(result) {
+		return
+	}
+	if dbIndex, err := strconv.Atoi(string(args[1])); err == nil {
+		atomic.StoreInt32(&client.dbIndex, int32(dbIndex))
+	}
+}
+
+// Auth 使用密码认证，认证成功后重连时会自动重新认证
+func (client *Client) Auth(password string) resp.Reply {
+	result := client.Send(utils.ToCmdLine("AUTH", password))
+	if !reply.IsErrorReply(result) {
+		client.password = password
+	}
+	return result
+}
+
+// Setup 执行建立连接时需要的命令，例如集群节点之间的认证，执行成功后重连时会在认证之后自动重新执行
+func (client *Client) Setup(args [][]byte) resp.Reply {
+	result := client.Send(args)
+	if !reply.IsErrorReply(result) {
+		client.setup = append(client.setup, args)
+	}
+	return result
+}
+
+// Hello 与服务器协商协议版本，protocol 为 3 时之后的响应均使用 RESP3 编码
+func (client *Client) Hello(protocol int) resp.Reply {
+	result := client.Send(utils.ToCmdLine("HELLO", strconv.Itoa(protocol)))
+	if !reply.IsErrorReply(result) {
+		client.protocol = protocol
+	}
+	return result
+}
+
+// SetPushHandler 设置 RESP3 推送消息的处理函数，推送消息不对应任何请求
+func (client *Client) SetPushHandler(handler func(push *reply.PushReply)) {
+	client.onPush = handler
+}
+
 // heartbeat 心跳检测逻辑
 func (client *Client) heartbeat() {
 	for range client.ticker.C {
@@ -112,6 +235,7 @@ func (client *Client) heartbeat() {
 
 // handleWrite 处理写操作
 func (client *Client) handleWrite() {
+	defer close(client.writerDone)
 	for req := range client.pendingReqs {
 		client.doRequest(req)
 	}
@@ -119,24 +243,42 @@ func (client *Client) handleWrite() {
 
 // Send 向 Redis 服务器发送请求
 func (client *Client) Send(args [][]byte) resp.Reply {
-	// 发送请求并处理响应
-	request := &request{
-		args:      args,
-		heartbeat: false,
-		waiting:   &wait.Wait{},
-	}
-	request.waiting.Add(1)
+	return client.Pipeline([][][]byte{args}, maxWait)[0]
+}
+
+// Pipeline 连续发送多条命令后再依次等待响应，只需要一次往返
+// 所有命令共用 timeout，超时前没有收到响应的命令返回超时错误
+func (client *Client) Pipeline(cmds [][][]byte, timeout time.Duration) []resp.Reply {
 	client.working.Add(1)
 	defer client.working.Done()
-	client.pendingReqs <- request
-	timeout := request.waiting.WaitWithTimeout(maxWait)
-	if timeout {
-		return reply.MakeErrReply("server time out")
+	requests := make([]*request, len(cmds))
+	for i, args := range cmds {
+		requests[i] = &request{
+			args:      args,
+			heartbeat: false,
+			waiting:   &wait.Wait{},
+		}
+		requests[i].waiting.Add(1)
+		client.pendingReqs <- requests[i]
 	}
-	if request.err != nil {
-		return reply.MakeErrReply("request failed")
+	deadline := time.Now().Add(timeout)
+	replies := make([]resp.Reply, len(cmds))
+	for i, request := range requests {
+		remaining := time.Until(deadline)
+		if remaining < time.Millisecond {
+			// 响应按顺序到达，前面的命令超时后仍给已经到达的响应留出处理时间
+			remaining = time.Millisecond
+		}
+		if request.waiting.WaitWithTimeout(remaining) {
+			replies[i] = timeoutReply
+		} else if request.err != nil {
+			replies[i] = requestFailedReply
+		} else {
+			replies[i] = request.reply
+		}
+		client.trackSelect(request.args, replies[i])
 	}
-	return request.reply
+	return replies
 }
 
 func (client *Client) doHeartbeat() {
@@ -195,15 +337,34 @@ func (client *Client) finishRequest(reply resp.Reply) {
 	}
 }
 
-func (client *Client) handleRead() error {
-	// 处理读操作，接收服务器响应
-	ch := parser.ParseStream(client.conn)
+// handleRead 读取 conn 上的响应并依次交给等待响应的请求，连接断开后关闭 conn 与 done
+// 读取失败时不取走等待响应的请求，它们在下一次写入触发重连或 Close 时失败
+func (client *Client) handleRead(conn net.Conn, done chan struct{}) {
+	defer close(done)
+	// 连接已经无法读取，关闭后下一次写入会失败并触发重连
+	defer conn.Close()
+	ch := parser.ParseStream(conn)
 	for payload := range ch {
 		if payload.Err != nil {
+			if isConnError(payload.Err) {
+				continue
+			}
 			client.finishRequest(reply.MakeErrReply(payload.Err.Error()))
 			continue
 		}
+		if push, ok := payload.Data.(*reply.PushReply); ok {
+			// 推送消息属于带外数据，不能当作请求的响应
+			if client.onPush != nil {
+				client.onPush(push)
+			}
+			continue
+		}
 		client.finishRequest(payload.Data)
 	}
-	return nil
+}
+
+// isConnError 判断读取错误是否由连接断开产生，而不是服务器返回了无法解析的响应
+func isConnError(err error) bool {
+	var netErr net.Error
+	return err == io.EOF || err == io.ErrUnexpectedEOF || errors.As(err, &netErr)
 }
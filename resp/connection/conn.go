@@ -1,12 +1,18 @@
 package connection
 
 import (
+	"github.com/ygxiaobai111/GolixirDB/lib/sync/atomic"
 	"github.com/ygxiaobai111/GolixirDB/lib/sync/wait"
+	"github.com/ygxiaobai111/GolixirDB/resp/reply"
 	"net"
 	"sync"
+	syncatomic "sync/atomic"
 	"time"
 )
 
+// nextID 用于为每个连接分配递增的 ID
+var nextID uint64
+
 // Connection 表示一个连接
 type Connection struct {
 	conn net.Conn // 网络连接
@@ -14,32 +20,69 @@ type Connection struct {
 	waitingReply wait.Wait
 	// 发送响应时的锁
 	mu sync.Mutex
-	// 选定的数据库
-	selectedDB int
+	// 选定的数据库，CLIENT LIST 会在其他连接的协程中读取，使用 sync/atomic 访问
+	selectedDB int32
+	// 通过 HELLO 协商的协议版本，0 表示默认的 RESP2，使用 sync/atomic 访问
+	protocol int32
+
+	id        uint64    // 连接 ID，从 1 开始递增
+	createdAt time.Time // 连接建立时间
+	// 保护 name 与 lastCmd，CLIENT LIST 会在其他连接的协程中读取
+	metaMu          sync.Mutex
+	name            string // 通过 CLIENT SETNAME 设置的名称
+	lastCmd         string // 最近执行的命令
+	lastInteraction int64  // 最近一次执行命令的时间，unix 纳秒
+	noEvict         atomic.Boolean
+	authenticated   atomic.Boolean // 是否已通过 AUTH 认证
+	closeAfterReply atomic.Boolean // 回复当前命令后关闭连接，用于 CLIENT KILL 自身
+	asking          bool           // 执行过 ASKING，下一条命令可以访问正在导入的哈希槽
+	peer            atomic.Boolean // 是否为通过 peer_ 认证的集群节点，只有集群节点可以执行节点之间的内部命令
+
+	multiState bool       // 是否处于 MULTI 中
+	queue      [][][]byte // MULTI 中排队的命令
+	txErrors   []error    // 排队时出现的错误，存在错误时 EXEC 放弃执行
 }
 
 // NewConn 创建一个新的Connection实例
 func NewConn(conn net.Conn) *Connection {
+	now := time.Now()
 	return &Connection{
-		conn: conn,
+		conn:            conn,
+		id:              syncatomic.AddUint64(&nextID, 1),
+		createdAt:       now,
+		lastInteraction: now.UnixNano(),
 	}
 }
 
-// RemoteAddr 返回远程网络地址
+// RemoteAddr 返回远程网络地址，加载 aof 使用的伪连接返回 nil
 func (c *Connection) RemoteAddr() net.Addr {
+	if c.conn == nil {
+		return nil
+	}
 	return c.conn.RemoteAddr()
 }
 
-// Close 与客户端断开连接
+// LocalAddr 返回本地网络地址
+func (c *Connection) LocalAddr() net.Addr {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.LocalAddr()
+}
+
+// Close 与客户端断开连接，伪连接没有网络连接，什么都不做
 func (c *Connection) Close() error {
+	if c.conn == nil {
+		return nil
+	}
 	c.waitingReply.WaitWithTimeout(10 * time.Second)
 	_ = c.conn.Close()
 	return nil
 }
 
-// Write 通过tcp连接向客户端发送响应
+// Write 通过tcp连接向客户端发送响应，伪连接丢弃响应
 func (c *Connection) Write(b []byte) error {
-	if len(b) == 0 {
+	if len(b) == 0 || c.conn == nil {
 		return nil
 	}
 	c.mu.Lock()
@@ -55,10 +98,152 @@ func (c *Connection) Write(b []byte) error {
 
 // GetDBIndex 返回选定的数据库索引
 func (c *Connection) GetDBIndex() int {
-	return c.selectedDB
+	return int(syncatomic.LoadInt32(&c.selectedDB))
 }
 
 // SelectDB 选择一个数据库
 func (c *Connection) SelectDB(dbNum int) {
-	c.selectedDB = dbNum
+	syncatomic.StoreInt32(&c.selectedDB, int32(dbNum))
+}
+
+// GetProtocol 返回连接使用的协议版本
+func (c *Connection) GetProtocol() int {
+	protocol := int(syncatomic.LoadInt32(&c.protocol))
+	if protocol == 0 {
+		return reply.Resp2
+	}
+	return protocol
+}
+
+// SetProtocol 设置连接使用的协议版本
+func (c *Connection) SetProtocol(protocol int) {
+	syncatomic.StoreInt32(&c.protocol, int32(protocol))
+}
+
+// GetID 返回连接 ID
+func (c *Connection) GetID() uint64 {
+	return c.id
+}
+
+// GetName 返回连接名称
+func (c *Connection) GetName() string {
+	c.metaMu.Lock()
+	defer c.metaMu.Unlock()
+	return c.name
+}
+
+// SetName 设置连接名称
+func (c *Connection) SetName(name string) {
+	c.metaMu.Lock()
+	defer c.metaMu.Unlock()
+	c.name = name
+}
+
+// IsAuthenticated 返回连接是否已通过认证
+func (c *Connection) IsAuthenticated() bool {
+	return c.authenticated.Get()
+}
+
+// SetAuthenticated 设置连接的认证状态
+func (c *Connection) SetAuthenticated(authenticated bool) {
+	c.authenticated.Set(authenticated)
+}
+
+// IsPeer 返回连接是否为已认证的集群节点
+func (c *Connection) IsPeer() bool {
+	return c.peer.Get()
+}
+
+// SetPeer 设置连接是否为已认证的集群节点
+func (c *Connection) SetPeer(peer bool) {
+	c.peer.Set(peer)
+}
+
+// IsAsking 返回连接是否执行过 ASKING
+func (c *Connection) IsAsking() bool {
+	return c.asking
+}
+
+// SetAsking 设置 ASKING 标记，执行下一条命令后清除
+func (c *Connection) SetAsking(asking bool) {
+	c.asking = asking
+}
+
+// CreatedAt 返回连接建立的时间
+func (c *Connection) CreatedAt() time.Time {
+	return c.createdAt
+}
+
+// Touch 记录连接最近执行的命令及时间
+func (c *Connection) Touch(cmdName string) {
+	c.metaMu.Lock()
+	defer c.metaMu.Unlock()
+	c.lastCmd = cmdName
+	c.lastInteraction = time.Now().UnixNano()
+}
+
+// LastCmd 返回连接最近执行的命令
+func (c *Connection) LastCmd() string {
+	c.metaMu.Lock()
+	defer c.metaMu.Unlock()
+	return c.lastCmd
+}
+
+// IdleTime 返回连接自最近一次执行命令以来的空闲时间
+func (c *Connection) IdleTime() time.Duration {
+	c.metaMu.Lock()
+	defer c.metaMu.Unlock()
+	return time.Since(time.Unix(0, c.lastInteraction))
+}
+
+// IsNoEvict 返回连接是否设置了 CLIENT NO-EVICT
+func (c *Connection) IsNoEvict() bool {
+	return c.noEvict.Get()
+}
+
+// SetNoEvict 设置 CLIENT NO-EVICT
+func (c *Connection) SetNoEvict(noEvict bool) {
+	c.noEvict.Set(noEvict)
+}
+
+// CloseAfterReply 标记在回复当前命令后关闭连接
+func (c *Connection) CloseAfterReply() {
+	c.closeAfterReply.Set(true)
+}
+
+// ShouldClose 返回是否需要在回复后关闭连接
+func (c *Connection) ShouldClose() bool {
+	return c.closeAfterReply.Get()
+}
+
+// InMultiState 返回连接是否处于 MULTI 中
+func (c *Connection) InMultiState() bool {
+	return c.multiState
+}
+
+// SetMultiState 进入或退出 MULTI，同时清空排队的命令与错误
+func (c *Connection) SetMultiState(state bool) {
+	c.multiState = state
+	c.queue = nil
+	c.txErrors = nil
+}
+
+// GetQueuedCmdLine 返回 MULTI 中排队的命令
+func (c *Connection) GetQueuedCmdLine() [][][]byte {
+	return c.queue
+}
+
+// EnqueueCmd 将命令加入 MULTI 的队列
+func (c *Connection) EnqueueCmd(cmdLine [][]byte) {
+	c.queue = append(c.queue, cmdLine)
+}
+
+// AddTxError 记录排队时出现的错误
+func (c *Connection) AddTxError(err error) {
+	c.txErrors = append(c.txErrors, err)
+}
+
+// GetTxErrors 返回排队时出现的错误
+func (c *Connection) GetTxErrors() []error {
+	return c.txErrors
 }
@@ -0,0 +1,365 @@
+package handler
+
+import (
+	"github.com/ygxiaobai111/GolixirDB/database"
+	"github.com/ygxiaobai111/GolixirDB/interface/resp"
+	"github.com/ygxiaobai111/GolixirDB/resp/connection"
+	"github.com/ygxiaobai111/GolixirDB/resp/reply"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+CLIENT 命令管理当前所有连接：
+CLIENT LIST / INFO / ID / SETNAME / GETNAME / KILL / PAUSE / UNPAUSE / NO-EVICT
+这些命令需要访问所有活跃连接，因此在协议处理器中实现，不经过数据库
+*/
+
+const (
+	pauseOff   = iota
+	pauseWrite // 只阻塞写命令
+	pauseAll   // 阻塞除 CLIENT 以外的所有命令
+)
+
+// containerCommands 带有子命令的命令，CLIENT LIST 中的 cmd 字段显示为 cmd|sub
+var containerCommands = map[string]bool{
+	"client":  true,
+	"slowlog": true,
+	"config":  true,
+	"cluster": true,
+	"memory":  true,
+	"object":  true,
+	"pubsub":  true,
+}
+
+// clientPause 记录 CLIENT PAUSE 的状态
+type clientPause struct {
+	mu       sync.Mutex
+	mode     int
+	deadline time.Time
+	changed  chan struct{} // 暂停状态变化时关闭，唤醒等待中的连接
+}
+
+// set 设置暂停状态并唤醒所有等待中的连接
+func (p *clientPause) set(mode int, deadline time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// 重复 PAUSE 时不会缩短已有的暂停
+	if mode != pauseOff && p.mode != pauseOff && time.Now().Before(p.deadline) {
+		if p.mode > mode {
+			mode = p.mode
+		}
+		if p.deadline.After(deadline) {
+			deadline = p.deadline
+		}
+	}
+	p.mode = mode
+	p.deadline = deadline
+	if p.changed != nil {
+		close(p.changed)
+	}
+	p.changed = make(chan struct{})
+}
+
+// wait 命令受暂停影响时阻塞，直到暂停超时或被 CLIENT UNPAUSE 解除
+func (p *clientPause) wait(cmdName string) {
+	for {
+		p.mu.Lock()
+		mode, deadline, changed := p.mode, p.deadline, p.changed
+		p.mu.Unlock()
+		remaining := time.Until(deadline)
+		if mode == pauseOff || remaining <= 0 {
+			return
+		}
+		if mode == pauseWrite && !database.IsWriteCommand(cmdName) {
+			return
+		}
+		timer := time.NewTimer(remaining)
+		select {
+		case <-changed:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// touch 记录连接最近执行的命令
+func touch(client *connection.Connection, cmdLine [][]byte) {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	if containerCommands[cmdName] && len(cmdLine) > 1 {
+		cmdName += "|" + strings.ToLower(string(cmdLine[1]))
+	}
+	client.Touch(cmdName)
+}
+
+// clientInfo 以 CLIENT LIST 的格式描述一个连接
+func (h *RespHandler) clientInfo(client *connection.Connection) string {
+	var sb strings.Builder
+	addr, laddr := "", ""
+	if a := client.RemoteAddr(); a != nil {
+		addr = a.String()
+	}
+	if a := client.LocalAddr(); a != nil {
+		laddr = a.String()
+	}
+	flags := ""
+	if h.monitors.isMonitor(client) {
+		flags += "O"
+	}
+	if client.IsNoEvict() {
+		flags += "e"
+	}
+	if flags == "" {
+		flags = "N"
+	}
+	sb.WriteString("id=" + strconv.FormatUint(client.GetID(), 10))
+	sb.WriteString(" addr=" + addr)
+	sb.WriteString(" laddr=" + laddr)
+	sb.WriteString(" name=" + client.GetName())
+	sb.WriteString(" age=" + strconv.FormatInt(int64(time.Since(client.CreatedAt())/time.Second), 10))
+	sb.WriteString(" idle=" + strconv.FormatInt(int64(client.IdleTime()/time.Second), 10))
+	sb.WriteString(" flags=" + flags)
+	sb.WriteString(" db=" + strconv.Itoa(client.GetDBIndex()))
+	sb.WriteString(" cmd=" + client.LastCmd())
+	sb.WriteString(" user=default")
+	sb.WriteString(" resp=" + strconv.Itoa(client.GetProtocol()))
+	return sb.String()
+}
+
+// clients 返回按 ID 排序的所有活跃连接
+func (h *RespHandler) clients() []*connection.Connection {
+	var result []*connection.Connection
+	h.activeConn.Range(func(key, value interface{}) bool {
+		result = append(result, key.(*connection.Connection))
+		return true
+	})
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].GetID() < result[j].GetID()
+	})
+	return result
+}
+
+// execClient CLIENT subcommand [arguments ...]
+func (h *RespHandler) execClient(client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("client")
+	}
+	sub := strings.ToLower(string(args[1]))
+	args = args[2:]
+	switch sub {
+	case "id":
+		if len(args) != 0 {
+			return reply.MakeArgNumErrReply("client|id")
+		}
+		return reply.MakeIntReply(int64(client.GetID()))
+	case "info":
+		if len(args) != 0 {
+			return reply.MakeArgNumErrReply("client|info")
+		}
+		return reply.MakeVerbatimStringReply("txt", h.clientInfo(client)+"\n")
+	case "list":
+		return h.execClientList(args)
+	case "getname":
+		if len(args) != 0 {
+			return reply.MakeArgNumErrReply("client|getname")
+		}
+		name := client.GetName()
+		if name == "" {
+			return reply.MakeNullBulkReply()
+		}
+		return reply.MakeBulkReply([]byte(name))
+	case "setname":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply("client|setname")
+		}
+		name := string(args[0])
+		if !database.IsValidClientName(name) {
+			return reply.MakeErrReply("ERR Client names cannot contain spaces, newlines or special characters.")
+		}
+		client.SetName(name)
+		return reply.MakeOkReply()
+	case "kill":
+		return h.execClientKill(client, args)
+	case "pause":
+		return h.execClientPause(args)
+	case "unpause":
+		if len(args) != 0 {
+			return reply.MakeArgNumErrReply("client|unpause")
+		}
+		h.pause.set(pauseOff, time.Time{})
+		return reply.MakeOkReply()
+	case "no-evict":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply("client|no-evict")
+		}
+		switch strings.ToLower(string(args[0])) {
+		case "on":
+			client.SetNoEvict(true)
+		case "off":
+			client.SetNoEvict(false)
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+		return reply.MakeOkReply()
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + sub + "'. Try CLIENT LIST, CLIENT INFO, CLIENT ID, " +
+		"CLIENT SETNAME, CLIENT GETNAME, CLIENT KILL, CLIENT PAUSE, CLIENT UNPAUSE, CLIENT NO-EVICT.")
+}
+
+// execClientList CLIENT LIST [TYPE normal|master|replica|pubsub] [ID client-id ...]
+func (h *RespHandler) execClientList(args [][]byte) resp.Reply {
+	var ids map[uint64]bool
+	typ := "normal"
+	for i := 0; i < len(args); i++ {
+		opt := strings.ToLower(string(args[i]))
+		switch {
+		case opt == "type" && i+1 < len(args):
+			typ = strings.ToLower(string(args[i+1]))
+			if typ != "normal" && typ != "master" && typ != "replica" && typ != "pubsub" {
+				return reply.MakeErrReply("ERR Unknown client type '" + string(args[i+1]) + "'")
+			}
+			i++
+		case opt == "id" && i+1 < len(args):
+			ids = make(map[uint64]bool)
+			for i++; i < len(args); i++ {
+				id, err := strconv.ParseUint(string(args[i]), 10, 64)
+				if err != nil || id == 0 {
+					return reply.MakeErrReply("ERR Invalid client ID")
+				}
+				ids[id] = true
+			}
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	var sb strings.Builder
+	// 目前所有连接都是普通客户端
+	if typ == "normal" {
+		for _, c := range h.clients() {
+			if ids != nil && !ids[c.GetID()] {
+				continue
+			}
+			sb.WriteString(h.clientInfo(c))
+			sb.WriteByte('\n')
+		}
+	}
+	return reply.MakeVerbatimStringReply("txt", sb.String())
+}
+
+// execClientKill 支持两种形式：
+// CLIENT KILL ip:port
+// CLIENT KILL [ID client-id] [ADDR ip:port] [LADDR ip:port] [USER username] [SKIPME yes|no]
+func (h *RespHandler) execClientKill(client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		return reply.MakeArgNumErrReply("client|kill")
+	}
+	var (
+		id     uint64
+		addr   string
+		laddr  string
+		user   string
+		skipMe = true
+		legacy = len(args) == 1
+	)
+	if legacy {
+		addr = string(args[0])
+		skipMe = false
+	} else {
+		if len(args)%2 != 0 {
+			return reply.MakeSyntaxErrReply()
+		}
+		for i := 0; i < len(args); i += 2 {
+			opt := strings.ToLower(string(args[i]))
+			val := string(args[i+1])
+			switch opt {
+			case "id":
+				n, err := strconv.ParseUint(val, 10, 64)
+				if err != nil || n == 0 {
+					return reply.MakeErrReply("ERR client-id should be greater than 0")
+				}
+				id = n
+			case "addr":
+				addr = val
+			case "laddr":
+				laddr = val
+			case "user":
+				user = val
+			case "skipme":
+				switch strings.ToLower(val) {
+				case "yes":
+					skipMe = true
+				case "no":
+					skipMe = false
+				default:
+					return reply.MakeSyntaxErrReply()
+				}
+			default:
+				return reply.MakeSyntaxErrReply()
+			}
+		}
+	}
+
+	var killed int64
+	for _, c := range h.clients() {
+		if id != 0 && c.GetID() != id {
+			continue
+		}
+		if addr != "" && (c.RemoteAddr() == nil || c.RemoteAddr().String() != addr) {
+			continue
+		}
+		if laddr != "" && (c.LocalAddr() == nil || c.LocalAddr().String() != laddr) {
+			continue
+		}
+		// 所有连接都以 default 用户身份运行
+		if user != "" && user != "default" {
+			continue
+		}
+		if c == client {
+			if skipMe {
+				continue
+			}
+			// 先回复再关闭自己
+			c.CloseAfterReply()
+		} else {
+			target := c
+			go func() {
+				_ = target.Close()
+			}()
+		}
+		killed++
+	}
+	if legacy {
+		if killed == 0 {
+			return reply.MakeErrReply("ERR No such client")
+		}
+		return reply.MakeOkReply()
+	}
+	return reply.MakeIntReply(killed)
+}
+
+// execClientPause CLIENT PAUSE timeout [WRITE|ALL]
+func (h *RespHandler) execClientPause(args [][]byte) resp.Reply {
+	if len(args) != 1 && len(args) != 2 {
+		return reply.MakeArgNumErrReply("client|pause")
+	}
+	timeout, err := strconv.ParseInt(string(args[0]), 10, 64)
+	if err != nil || timeout < 0 {
+		return reply.MakeErrReply("ERR timeout is not an integer or out of range")
+	}
+	mode := pauseAll
+	if len(args) == 2 {
+		switch strings.ToLower(string(args[1])) {
+		case "write":
+			mode = pauseWrite
+		case "all":
+			mode = pauseAll
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	h.pause.set(mode, time.Now().Add(time.Duration(timeout)*time.Millisecond))
+	return reply.MakeOkReply()
+}
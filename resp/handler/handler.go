@@ -10,8 +10,10 @@ import (
 	"github.com/ygxiaobai111/GolixirDB/config"
 	"github.com/ygxiaobai111/GolixirDB/database"
 	databaseface "github.com/ygxiaobai111/GolixirDB/interface/database"
+	"github.com/ygxiaobai111/GolixirDB/interface/resp"
 	util "github.com/ygxiaobai111/GolixirDB/lib/logger"
 	"github.com/ygxiaobai111/GolixirDB/lib/sync/atomic"
+	"github.com/ygxiaobai111/GolixirDB/lib/sync/wait"
 	"github.com/ygxiaobai111/GolixirDB/resp/connection"
 	"github.com/ygxiaobai111/GolixirDB/resp/parser"
 	"github.com/ygxiaobai111/GolixirDB/resp/reply"
@@ -22,7 +24,8 @@ import (
 )
 
 var (
-	unknownErrReplyBytes = []byte("-ERR unknown\r\n") // 未知错误回复的字节表示
+	unknownErrReplyBytes    = []byte("-ERR unknown\r\n")                       // 未知错误回复的字节表示
+	maxClientsErrReplyBytes = []byte("-ERR max number of clients reached\r\n") // 连接数超过 maxclients 时的回复
 )
 
 // RespHandler 实现了tcp.Handler，充当redis处理程序
@@ -30,30 +33,49 @@ type RespHandler struct {
 	activeConn sync.Map              // 存储活跃的客户端连接
 	db         databaseface.Database // 数据库接口
 	closing    atomic.Boolean        // 是否拒绝新客户端和新请求的标志
+	monitors   monitorHub            // 处于 MONITOR 状态的客户端
+	pause      clientPause           // CLIENT PAUSE 的状态
+
+	// 关闭时持有写锁，保证设置 closing 之后不会再有命令开始执行
+	closingMu sync.RWMutex
+	inflight  wait.Wait // 正在执行的命令
+	closeOnce sync.Once
+	closeErr  error
+	// SHUTDOWN 命令通过关闭该 channel 通知服务器停止
+	shutdownCh   chan struct{}
+	shutdownOnce sync.Once
+	noSave       atomic.Boolean // SHUTDOWN NOSAVE
 }
 
-// MakeHandler 创建一个RespHandler实例
-func MakeHandler() *RespHandler {
+// MakeHandler 创建一个RespHandler实例，数据库无法启动时返回错误
+func MakeHandler() (*RespHandler, error) {
 	var db databaseface.Database
 	//db = database.NewEchoDatabase()  //示例
-	//是否开启集群
-	if config.Properties.ClusterMode && len(config.Properties.Peers) > 0 && config.Properties.Self != "" {
-		db = cluster.MakeClusterDatabase() // 初始化数据库
+	//是否开启集群，没有配置 peers 的节点等待通过 CLUSTER MEET 加入集群
+	if config.Properties().ClusterMode && config.Properties().Self != "" {
+		clusterDB, err := cluster.MakeClusterDatabase() // 初始化数据库
+		if err != nil {
+			return nil, err
+		}
+		db = clusterDB
 	} else {
 		db = database.NewStandaloneDatabase() // 初始化数据库
 
 	}
 
 	return &RespHandler{
-		db: db,
-	}
+		db:         db,
+		shutdownCh: make(chan struct{}),
+	}, nil
 }
 
 // closeClient 关闭客户端连接并进行清理
 func (h *RespHandler) closeClient(client *connection.Connection) {
 	_ = client.Close()
+	h.monitors.remove(client)
 	h.db.AfterClientClose(client)
 	h.activeConn.Delete(client)
+	database.ClientDisconnected()
 }
 
 // Handle 接收并执行redis命令
@@ -61,10 +83,20 @@ func (h *RespHandler) Handle(ctx context.Context, conn net.Conn) {
 	if h.closing.Get() {
 		// 如果处理程序正在关闭，拒绝新连接
 		_ = conn.Close()
+		return
 	}
 
 	client := connection.NewConn(conn) // 创建新的客户端连接
+	if database.ConnectedClients() >= int64(config.Properties().MaxClients) && config.Properties().MaxClients > 0 {
+		// 超过最大连接数，告知客户端后断开
+		_ = client.Write(maxClientsErrReplyBytes)
+		_ = conn.Close()
+		return
+	}
+	// 未设置密码时建立的连接视为已认证，之后设置密码不影响已有连接
+	client.SetAuthenticated(config.Properties().RequirePass == "")
 	h.activeConn.Store(client, 1)
+	database.ClientConnected()
 
 	ch := parser.ParseStream(conn) // 解析输入流
 	for payload := range ch {
@@ -88,6 +120,10 @@ func (h *RespHandler) Handle(ctx context.Context, conn net.Conn) {
 			}
 			continue
 		}
+		if client.ShouldClose() {
+			// 连接已被关闭，丢弃剩余的命令
+			continue
+		}
 		if payload.Data == nil {
 			// 空负载错误
 			util.LogrusObj.Error("empty payload")
@@ -99,25 +135,50 @@ func (h *RespHandler) Handle(ctx context.Context, conn net.Conn) {
 			util.LogrusObj.Error("require multi bulk reply")
 			continue
 		}
-		result := h.db.Exec(client, r.Args) // 执行数据库命令
+		if !h.beginCommand() {
+			// 正在关闭，不再执行新的命令
+			continue
+		}
+		result := h.exec(client, r.Args) // 执行命令
 		if result != nil {
-			_ = client.Write(result.ToBytes()) // 返回执行结果
+			_ = client.Write(reply.ToProtocolBytes(result, client.GetProtocol())) // 按协商的协议返回执行结果
 		} else {
 			_ = client.Write(unknownErrReplyBytes) // 返回未知错误
 		}
+		h.inflight.Done()
+		if client.ShouldClose() {
+			// CLIENT KILL 关闭了自身连接，解析器读取出错后走正常的关闭流程
+			_ = client.Close()
+		}
 	}
+	// 解析器遇到无法继续解析的协议错误时停止读取，回复错误后关闭连接
+	h.closeClient(client)
+	util.LogrusObj.Info("connection closed: " + client.RemoteAddr().String())
 }
 
-// Close 停止处理程序
-func (h *RespHandler) Close() error {
-	util.LogrusObj.Info("handler shutting down...")
-	h.closing.Set(true)
-	// 待实现：并发等待
-	h.activeConn.Range(func(key interface{}, val interface{}) bool {
-		client := key.(*connection.Connection)
-		_ = client.Close() // 关闭所有客户端连接
-		return true
-	})
-	h.db.Close() // 关闭数据库
-	return nil
+// exec 执行连接级别的命令，其余命令交给数据库执行
+func (h *RespHandler) exec(client *connection.Connection, cmdLine [][]byte) resp.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	if h.monitors.isMonitor(client) {
+		// monitor 只接收推送，不再执行命令
+		return errMonitorReply
+	}
+	touch(client, cmdLine)
+	if !database.IsAuthenticated(client) && cmdName != "auth" && cmdName != "hello" {
+		return database.NoAuthReply
+	}
+	switch cmdName {
+	case "client":
+		// CLIENT 命令不受 CLIENT PAUSE 影响，否则无法解除暂停
+		return h.execClient(client, cmdLine)
+	case "shutdown":
+		return h.execShutdown(cmdLine)
+	}
+	h.pause.wait(cmdName)
+	h.monitors.feed(client, cmdLine)
+	if cmdName == "monitor" {
+		h.monitors.add(client)
+		return reply.MakeOkReply()
+	}
+	return h.db.Exec(client, cmdLine)
 }
@@ -0,0 +1,170 @@
+package handler
+
+import (
+	"github.com/ygxiaobai111/GolixirDB/database"
+	"github.com/ygxiaobai111/GolixirDB/resp/connection"
+	"github.com/ygxiaobai111/GolixirDB/resp/reply"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+MONITOR 将连接变为实时的命令流，每执行一条命令向所有 monitor 推送一行：
++1339518083.107412 [0 127.0.0.1:60866] "keys" "*"
+推送通过带缓冲的 channel 异步完成，缓冲区写满的 monitor 会被断开，不会阻塞其他客户端
+*/
+
+// monitorBufferSize 每个 monitor 最多积压的行数
+const monitorBufferSize = 1024
+
+var errMonitorReply = reply.MakeErrReply("ERR Replica/Monitor can't interact with the keyspace")
+
+// monitor 一个处于 MONITOR 状态的客户端
+type monitor struct {
+	client *connection.Connection
+	ch     chan []byte
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// feed 非阻塞地推送一行，缓冲区已满时返回 false
+func (m *monitor) feed(line []byte) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return true
+	}
+	select {
+	case m.ch <- line:
+		return true
+	default:
+		return false
+	}
+}
+
+func (m *monitor) close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.closed {
+		m.closed = true
+		close(m.ch)
+	}
+}
+
+// monitorHub 管理所有 monitor
+type monitorHub struct {
+	count    int32    // monitor 数量，为 0 时跳过格式化
+	monitors sync.Map // *connection.Connection -> *monitor
+}
+
+// add 将客户端注册为 monitor 并启动推送协程
+func (hub *monitorHub) add(client *connection.Connection) {
+	m := &monitor{
+		client: client,
+		ch:     make(chan []byte, monitorBufferSize),
+	}
+	if _, loaded := hub.monitors.LoadOrStore(client, m); loaded {
+		return
+	}
+	atomic.AddInt32(&hub.count, 1)
+	go func() {
+		for line := range m.ch {
+			if err := client.Write(line); err != nil {
+				hub.remove(client)
+				return
+			}
+		}
+	}()
+}
+
+// remove 移除 monitor，客户端断开连接时调用
+func (hub *monitorHub) remove(client *connection.Connection) {
+	raw, ok := hub.monitors.LoadAndDelete(client)
+	if !ok {
+		return
+	}
+	atomic.AddInt32(&hub.count, -1)
+	raw.(*monitor).close()
+}
+
+// isMonitor 判断客户端是否处于 MONITOR 状态
+func (hub *monitorHub) isMonitor(client *connection.Connection) bool {
+	_, ok := hub.monitors.Load(client)
+	return ok
+}
+
+// feed 将一条命令推送给所有 monitor，推送不及时的 monitor 会被断开
+func (hub *monitorHub) feed(client *connection.Connection, cmdLine [][]byte) {
+	if atomic.LoadInt32(&hub.count) == 0 {
+		return
+	}
+	line := formatMonitorLine(time.Now(), client, cmdLine)
+	hub.monitors.Range(func(key, value interface{}) bool {
+		m := value.(*monitor)
+		if !m.feed(line) {
+			hub.remove(m.client)
+			go func() {
+				_ = m.client.Close()
+			}()
+		}
+		return true
+	})
+}
+
+// formatMonitorLine 格式化一行 monitor 输出
+func formatMonitorLine(now time.Time, client *connection.Connection, cmdLine [][]byte) []byte {
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '+')
+	buf = strconv.AppendInt(buf, now.Unix(), 10)
+	buf = append(buf, '.')
+	usec := strconv.Itoa(now.Nanosecond() / 1000)
+	for i := len(usec); i < 6; i++ {
+		buf = append(buf, '0')
+	}
+	buf = append(buf, usec...)
+	buf = append(buf, " ["...)
+	buf = strconv.AppendInt(buf, int64(client.GetDBIndex()), 10)
+	buf = append(buf, ' ')
+	if addr := client.RemoteAddr(); addr != nil {
+		buf = append(buf, addr.String()...)
+	}
+	buf = append(buf, ']')
+	for _, arg := range database.RedactArgs(cmdLine) {
+		buf = append(buf, ' ')
+		buf = appendQuoted(buf, arg)
+	}
+	buf = append(buf, reply.CRLF...)
+	return buf
+}
+
+// appendQuoted 以 redis 的方式对参数加引号并转义不可打印字符
+func appendQuoted(buf []byte, arg []byte) []byte {
+	const hex = "0123456789abcdef"
+	buf = append(buf, '"')
+	for _, c := range arg {
+		switch c {
+		case '\\', '"':
+			buf = append(buf, '\\', c)
+		case '\n':
+			buf = append(buf, '\\', 'n')
+		case '\r':
+			buf = append(buf, '\\', 'r')
+		case '\t':
+			buf = append(buf, '\\', 't')
+		case '\a':
+			buf = append(buf, '\\', 'a')
+		case '\b':
+			buf = append(buf, '\\', 'b')
+		default:
+			if c < 0x20 || c >= 0x7f {
+				buf = append(buf, '\\', 'x', hex[c>>4], hex[c&0xf])
+			} else {
+				buf = append(buf, c)
+			}
+		}
+	}
+	return append(buf, '"')
+}
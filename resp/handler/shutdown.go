@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"errors"
+	"github.com/ygxiaobai111/GolixirDB/config"
+	"github.com/ygxiaobai111/GolixirDB/interface/resp"
+	util "github.com/ygxiaobai111/GolixirDB/lib/logger"
+	"github.com/ygxiaobai111/GolixirDB/resp/connection"
+	"github.com/ygxiaobai111/GolixirDB/resp/reply"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+优雅关闭：
+1. 停止接受新连接（由 tcp 服务器关闭 listener）
+2. 不再开始执行新的命令，等待正在执行的命令完成，最多等待 shutdownTimeout
+3. 关闭所有客户端连接
+4. 关闭数据库：关闭到其他节点的连接池，将 aof 缓冲区写入文件并刷盘
+*/
+
+// shutdownTimeout 等待正在执行的命令完成的最长时间
+const shutdownTimeout = 10 * time.Second
+
+var errInflightTimeout = errors.New("timed out waiting for in-flight commands")
+
+// beginCommand 开始执行一条命令，正在关闭时返回 false
+func (h *RespHandler) beginCommand() bool {
+	h.closingMu.RLock()
+	defer h.closingMu.RUnlock()
+	if h.closing.Get() {
+		return false
+	}
+	h.inflight.Add(1)
+	return true
+}
+
+// ShutdownRequested 返回的 channel 在执行 SHUTDOWN 命令后关闭
+func (h *RespHandler) ShutdownRequested() <-chan struct{} {
+	return h.shutdownCh
+}
+
+// execShutdown SHUTDOWN [NOSAVE|SAVE]
+// 默认与 SAVE 会将 aof 缓冲区中的命令全部写入文件，NOSAVE 丢弃尚未写入的命令以尽快退出
+// 没有开启 aof 时无法 SAVE，返回错误而不是关闭服务器
+func (h *RespHandler) execShutdown(cmdLine [][]byte) resp.Reply {
+	noSave := false
+	switch len(cmdLine) {
+	case 1:
+	case 2:
+		switch strings.ToLower(string(cmdLine[1])) {
+		case "nosave":
+			noSave = true
+		case "save":
+			if !config.Properties().AppendOnly {
+				return reply.MakeErrReply("ERR SHUTDOWN SAVE requires appendonly to be enabled")
+			}
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	default:
+		return reply.MakeSyntaxErrReply()
+	}
+	util.LogrusObj.Warn("user requested shutdown...")
+	h.shutdownOnce.Do(func() {
+		h.noSave.Set(noSave)
+		close(h.shutdownCh)
+	})
+	// 关闭成功时连接直接断开，不返回响应
+	return &reply.NoReply{}
+}
+
+// Close 停止处理程序，可以重复调用
+func (h *RespHandler) Close() error {
+	h.closeOnce.Do(func() {
+		h.closeErr = h.close()
+	})
+	return h.closeErr
+}
+
+func (h *RespHandler) close() error {
+	util.LogrusObj.Info("handler shutting down...")
+	h.closingMu.Lock()
+	h.closing.Set(true)
+	h.closingMu.Unlock()
+
+	// 唤醒被 CLIENT PAUSE 阻塞的命令
+	h.pause.set(pauseOff, time.Time{})
+	var err error
+	if h.inflight.WaitWithTimeout(shutdownTimeout) {
+		util.LogrusObj.Warn(errInflightTimeout.Error())
+		err = errInflightTimeout
+	}
+
+	// 并发关闭所有客户端连接，每个连接最多等待其正在发送的响应完成
+	var wg sync.WaitGroup
+	h.activeConn.Range(func(key interface{}, val interface{}) bool {
+		client := key.(*connection.Connection)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = client.Close()
+		}()
+		return true
+	})
+	wg.Wait()
+
+	save := !h.noSave.Get()
+	if dbErr := h.db.Close(save); dbErr != nil && err == nil {
+		err = dbErr
+	}
+	if err == nil {
+		util.LogrusObj.Info("GolixirDB is now ready to exit, bye bye...")
+	}
+	return err
+}
@@ -2,6 +2,7 @@ package parser
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"github.com/ygxiaobai111/GolixirDB/interface/resp"
 	util "github.com/ygxiaobai111/GolixirDB/lib/logger"
@@ -10,14 +11,26 @@ import (
 	"io"
 	"runtime/debug"
 	"strconv"
-	"strings"
 )
 
 /*
-解析器，用于解析客户端发过来的数据
+解析器，用于解析客户端发过来的数据以及服务端返回的响应
 *3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n
+除 RESP2 类型外，同时支持 RESP3 的 map、set、double、boolean、null、big number、verbatim string 和 push 类型
+不以类型前缀开头的行按 inline 命令解析，例如 GET foo\r\n
+与 redis 相同限制聚合类型的元素数量、批量字符串的长度以及单行（inline 命令与类型前缀所在的行）的长度，并限制聚合类型的嵌套层数，
+超过限制时无法继续解析之后的数据，与 IO 错误一样停止读取
 */
 
+const (
+	maxMultiBulkLen = 1024 * 1024       // 聚合类型最多包含的元素数量
+	maxBulkLen      = 512 * 1024 * 1024 // 批量字符串的最大长度，与 redis 的 proto-max-bulk-len 默认值相同
+	maxNestingDepth = 16                // 聚合类型最多嵌套的层数
+	maxLineLen      = 64 * 1024         // 单行的最大长度，与 redis 的 PROTO_INLINE_MAX_SIZE 相同
+	// 批量字符串不超过该长度时按声明的长度一次分配，更长时随读取的数据增长，避免客户端只发送长度就占用大量内存
+	bulkPreallocLen = 64 * 1024
+)
+
 // Payload 用于存储 redis.Reply 或者错误
 type Payload struct {
 	Data resp.Reply
@@ -31,20 +44,6 @@ func ParseStream(reader io.Reader) <-chan *Payload {
 	return ch
 }
 
-// readState 用于记录读取状态
-type readState struct {
-	readingMultiLine  bool //正在解析的是单行还是多行数据
-	expectedArgsCount int  //解析器需要解析的参数个数
-	msgType           byte
-	args              [][]byte //数据本身
-	bulkLen           int64
-}
-
-// finished 检查是否完成了所有参数的读取
-func (s *readState) finished() bool {
-	return s.expectedArgsCount > 0 && len(s.args) == s.expectedArgsCount
-}
-
 func parse0(reader io.Reader, ch chan<- *Payload) {
 	defer func() {
 		if err := recover(); err != nil {
@@ -52,207 +51,280 @@ func parse0(reader io.Reader, ch chan<- *Payload) {
 		}
 	}()
 	bufReader := bufio.NewReader(reader)
-	var state readState
-	var err error
-	var msg []byte
 	for {
-		// read line
-		var ioErr bool
-		msg, ioErr, err = readLine(bufReader, &state)
+		result, ioErr, err := readRequest(bufReader)
 		if err != nil {
+			ch <- &Payload{
+				Err: err,
+			}
 			if ioErr { // 遇到 IO 错误，停止读取
-				ch <- &Payload{
-					Err: err,
-				}
 				close(ch)
 				return
 			}
-			// 协议错误，重置读取状态
-			ch <- &Payload{
-				Err: err,
-			}
-			state = readState{}
+			// 协议错误，丢弃当前数据继续读取下一条
 			continue
 		}
-
-		// parse line
-		if !state.readingMultiLine {
-			// 接收新的响应
-			if msg[0] == '*' {
-				// 多批量回复
-				err = parseMultiBulkHeader(msg, &state)
-				if err != nil {
-					ch <- &Payload{
-						Err: errors.New("protocol error: " + string(msg)),
-					}
-					state = readState{} // 重置状态
-					continue
-				}
-				if state.expectedArgsCount == 0 {
-					ch <- &Payload{
-						Data: &reply.EmptyMultiBulkReply{},
-					}
-					state = readState{} // 重置状态
-					continue
-				}
-			} else if msg[0] == '$' { // 批量回复
-				err = parseBulkHeader(msg, &state)
-				if err != nil {
-					ch <- &Payload{
-						Err: errors.New("protocol error: " + string(msg)),
-					}
-					state = readState{} // 重置状态
-					continue
-				}
-				if state.bulkLen == -1 { // 空批量回复
-					ch <- &Payload{
-						Data: &reply.NullBulkReply{},
-					}
-					state = readState{} // 重置状态
-					continue
-				}
-			} else {
-				// 单行回复
-				result, err := parseSingleLineReply(msg)
-				ch <- &Payload{
-					Data: result,
-					Err:  err,
-				}
-				state = readState{} // 重置状态
-				continue
-			}
-		} else {
-			// 接收后续的批量回复
-			err = readBody(msg, &state)
-			if err != nil {
-				ch <- &Payload{
-					Err: errors.New("protocol error: " + string(msg)),
-				}
-				state = readState{} // 重置状态
-				continue
-			}
-			// 如果发送完成
-			if state.finished() {
-				var result resp.Reply
-				if state.msgType == '*' {
-					//代表数组
-					result = reply.MakeMultiBulkReply(state.args)
-				} else if state.msgType == '$' {
-					result = reply.MakeBulkReply(state.args[0])
-				}
-				ch <- &Payload{
-					Data: result,
-					Err:  err,
-				}
-				state = readState{}
-			}
+		if result == nil {
+			// 空行，忽略
+			continue
+		}
+		ch <- &Payload{
+			Data: result,
 		}
 	}
 }
 
-// readLine 读取一行数据
-func readLine(bufReader *bufio.Reader, state *readState) ([]byte, bool, error) {
+func protocolError(msg []byte) error {
+	return errors.New("protocol error: " + string(msg))
+}
+
+// readLine 读取一行数据，返回的数据不包含行尾
+// RESP 协议要求以 \r\n 结尾，inline 命令允许仅以 \n 结尾，由调用方校验
+// 超过 maxLineLen 仍未读到行尾时返回错误，避免不结束的行无限占用内存
+func readLine(bufReader *bufio.Reader) ([]byte, bool, bool, error) {
 	var msg []byte
-	var err error
-	if state.bulkLen == 0 { // 读取普通行
-		msg, err = bufReader.ReadBytes('\n')
+	for {
+		chunk, err := bufReader.ReadSlice('\n')
+		if len(msg)+len(chunk) > maxLineLen {
+			return nil, false, true, errors.New("protocol error: too big inline request")
+		}
+		msg = append(msg, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
 		if err != nil {
-			return nil, true, err
+			return nil, false, true, err
+		}
+		break
+	}
+	msg = msg[:len(msg)-1]
+	if len(msg) > 0 && msg[len(msg)-1] == '\r' {
+		return msg[:len(msg)-1], true, false, nil
+	}
+	return msg, false, false, nil
+}
+
+// readRequest 读取一条完整的请求或回复，不以类型前缀开头的行按 inline 命令处理
+// 第二个返回值表示错误后是否需要停止读取，例如 IO 错误
+func readRequest(bufReader *bufio.Reader) (resp.Reply, bool, error) {
+	return readReply0(bufReader, true, 0)
+}
+
+// readReply 读取一条完整的回复，聚合类型会递归读取其所有元素，depth 为当前的嵌套层数
+func readReply(bufReader *bufio.Reader, depth int) (resp.Reply, bool, error) {
+	return readReply0(bufReader, false, depth)
+}
+
+func readReply0(bufReader *bufio.Reader, allowInline bool, depth int) (resp.Reply, bool, error) {
+	line, crlf, ioErr, err := readLine(bufReader)
+	if err != nil {
+		return nil, ioErr, err
+	}
+	if len(line) == 0 || !isTypePrefix(line[0]) {
+		if !allowInline {
+			return nil, false, protocolError(line)
 		}
-		if len(msg) == 0 || msg[len(msg)-2] != '\r' {
-			return nil, false, errors.New("protocol error: " + string(msg))
+		// 不以类型前缀开头的是 inline 命令
+		return readInline(line)
+	}
+	if !crlf {
+		return nil, false, protocolError(line)
+	}
+	switch line[0] {
+	case '+': // 状态回复
+		return reply.MakeStatusReply(string(line[1:])), false, nil
+	case '-': // 错误回复
+		return reply.MakeErrReply(string(line[1:])), false, nil
+	case ':': // 整数回复
+		val, err := strconv.ParseInt(string(line[1:]), 10, 64)
+		if err != nil {
+			return nil, false, protocolError(line)
 		}
-	} else { // 读取批量行（二进制安全）
-		msg = make([]byte, state.bulkLen+2)
-		_, err = io.ReadFull(bufReader, msg)
+		return reply.MakeIntReply(val), false, nil
+	case '$', '!', '=': // 批量字符串、批量错误、verbatim string
+		return readBulk(bufReader, line)
+	case '*': // 多批量回复
+		return readArray(bufReader, line, depth)
+	case '%', '~', '>': // map、set、push
+		return readAggregate(bufReader, line, depth)
+	case ',': // double
+		val, err := strconv.ParseFloat(string(line[1:]), 64)
 		if err != nil {
-			return nil, true, err
+			return nil, false, protocolError(line)
 		}
-		if len(msg) == 0 ||
-			msg[len(msg)-2] != '\r' ||
-			msg[len(msg)-1] != '\n' {
-			return nil, false, errors.New("protocol error: " + string(msg))
+		return reply.MakeDoubleReply(val), false, nil
+	case '#': // boolean
+		if len(line) != 2 || (line[1] != 't' && line[1] != 'f') {
+			return nil, false, protocolError(line)
 		}
-		state.bulkLen = 0
+		return reply.MakeBooleanReply(line[1] == 't'), false, nil
+	case '_': // null
+		return reply.MakeNullReply(), false, nil
+	case '(': // big number
+		return reply.MakeBigNumberReply(string(line[1:])), false, nil
+	case '|': // attribute，附加信息直接丢弃，返回其后的真正回复
+		if _, ioErr, err := readAggregate(bufReader, line, depth); err != nil {
+			return nil, ioErr, err
+		}
+		return readReply(bufReader, depth)
 	}
-	return msg, false, nil
+	return nil, false, protocolError(line)
 }
 
-func parseMultiBulkHeader(msg []byte, state *readState) error {
-	var err error
-	var expectedLine uint64
-	expectedLine, err = strconv.ParseUint(string(msg[1:len(msg)-2]), 10, 32)
+// isTypePrefix 判断是否为 RESP 类型前缀
+func isTypePrefix(c byte) bool {
+	switch c {
+	case '+', '-', ':', '$', '*', '!', '=', '%', '~', '>', ',', '#', '_', '(', '|':
+		return true
+	}
+	return false
+}
+
+// readInline 将 inline 命令转换为多批量回复，空行返回 nil
+func readInline(line []byte) (resp.Reply, bool, error) {
+	args, err := splitArgs(line)
 	if err != nil {
-		return errors.New("protocol error: " + string(msg))
-	}
-	if expectedLine == 0 {
-		state.expectedArgsCount = 0
-		return nil
-	} else if expectedLine > 0 {
-		// first line of multi bulk reply
-		state.msgType = msg[0]
-		state.readingMultiLine = true
-		state.expectedArgsCount = int(expectedLine)
-		state.args = make([][]byte, 0, expectedLine)
-		return nil
-	} else {
-		return errors.New("protocol error: " + string(msg))
+		return nil, false, err
+	}
+	if len(args) == 0 {
+		return nil, false, nil
+	}
+	return reply.MakeMultiBulkReply(args), false, nil
+}
+
+// readLength 解析类型前缀之后的长度
+func readLength(line []byte) (int64, error) {
+	length, err := strconv.ParseInt(string(line[1:]), 10, 64)
+	if err != nil || length < -1 {
+		return 0, protocolError(line)
 	}
+	return length, nil
 }
 
-func parseBulkHeader(msg []byte, state *readState) error {
-	var err error
-	state.bulkLen, err = strconv.ParseInt(string(msg[1:len(msg)-2]), 10, 64)
+// readBulk 读取二进制安全的批量数据
+func readBulk(bufReader *bufio.Reader, header []byte) (resp.Reply, bool, error) {
+	bulkLen, err := readLength(header)
 	if err != nil {
-		return errors.New("protocol error: " + string(msg))
-	}
-	if state.bulkLen == -1 { // null bulk
-		return nil
-	} else if state.bulkLen > 0 {
-		state.msgType = msg[0]
-		state.readingMultiLine = true
-		state.expectedArgsCount = 1
-		state.args = make([][]byte, 0, 1)
-		return nil
+		return nil, false, err
+	}
+	if bulkLen == -1 { // 空批量回复
+		return reply.MakeNullBulkReply(), false, nil
+	}
+	if bulkLen > maxBulkLen {
+		return nil, true, errors.New("protocol error: invalid bulk length")
+	}
+	var body []byte
+	if bulkLen+2 <= bulkPreallocLen {
+		body = make([]byte, bulkLen+2)
+		_, err = io.ReadFull(bufReader, body)
 	} else {
-		return errors.New("protocol error: " + string(msg))
+		var buf bytes.Buffer
+		_, err = io.CopyN(&buf, bufReader, bulkLen+2)
+		body = buf.Bytes()
 	}
+	if err != nil {
+		return nil, true, err
+	}
+	if body[bulkLen] != '\r' || body[bulkLen+1] != '\n' {
+		return nil, false, protocolError(body)
+	}
+	body = body[:bulkLen]
+	switch header[0] {
+	case '!':
+		return reply.MakeErrReply(string(body)), false, nil
+	case '=':
+		if len(body) < 4 || body[3] != ':' {
+			return nil, false, protocolError(body)
+		}
+		return reply.MakeVerbatimStringReply(string(body[:3]), string(body[4:])), false, nil
+	}
+	return reply.MakeBulkReply(body), false, nil
 }
 
-func parseSingleLineReply(msg []byte) (resp.Reply, error) {
-	str := strings.TrimSuffix(string(msg), "\r\n")
-	var result resp.Reply
-	switch msg[0] {
-	case '+': // status reply
-		result = reply.MakeStatusReply(str[1:])
-	case '-': // err reply
-		result = reply.MakeErrReply(str[1:])
-	case ':': // int reply
-		val, err := strconv.ParseInt(str[1:], 10, 64)
-		if err != nil {
-			return nil, errors.New("protocol error: " + string(msg))
-		}
-		result = reply.MakeIntReply(val)
+// checkAggregate 检查聚合类型的元素数量与嵌套层数
+func checkAggregate(length int64, depth int) error {
+	if length > maxMultiBulkLen {
+		return errors.New("protocol error: invalid multibulk length")
+	}
+	if depth >= maxNestingDepth {
+		return errors.New("protocol error: too many nested aggregates")
 	}
-	return result, nil
+	return nil
 }
 
-// read the non-first lines of multi bulk reply or bulk reply
-func readBody(msg []byte, state *readState) error {
-	line := msg[0 : len(msg)-2]
-	var err error
-	if line[0] == '$' {
-		// bulk reply
-		state.bulkLen, err = strconv.ParseInt(string(line[1:]), 10, 64)
+// readElements 读取聚合类型中的 n 个元素，n 来自对端，不按 n 预先分配
+func readElements(bufReader *bufio.Reader, n int64, depth int) ([]resp.Reply, bool, error) {
+	var elements []resp.Reply
+	for i := int64(0); i < n; i++ {
+		element, ioErr, err := readReply(bufReader, depth+1)
 		if err != nil {
-			return errors.New("protocol error: " + string(msg))
+			return nil, ioErr, err
 		}
-		if state.bulkLen <= 0 { // null bulk in multi bulks
-			state.args = append(state.args, []byte{})
-			state.bulkLen = 0
+		elements = append(elements, element)
+	}
+	return elements, false, nil
+}
+
+// readArray 读取数组，元素全部为批量字符串时返回 MultiBulkReply，否则返回 MultiRawReply
+func readArray(bufReader *bufio.Reader, header []byte, depth int) (resp.Reply, bool, error) {
+	length, err := readLength(header)
+	if err != nil {
+		return nil, false, err
+	}
+	if length == -1 {
+		return reply.MakeNullReply(), false, nil
+	}
+	if length == 0 {
+		return &reply.EmptyMultiBulkReply{}, false, nil
+	}
+	if err := checkAggregate(length, depth); err != nil {
+		return nil, true, err
+	}
+	elements, ioErr, err := readElements(bufReader, length, depth)
+	if err != nil {
+		return nil, ioErr, err
+	}
+	args := make([][]byte, 0, len(elements))
+	for _, element := range elements {
+		switch e := element.(type) {
+		case *reply.BulkReply:
+			args = append(args, e.Arg)
+		case *reply.NullBulkReply, *reply.NullReply:
+			// RESP3 中数组的空元素编码为 null
+			args = append(args, nil)
+		default:
+			return reply.MakeMultiRawReply(elements), false, nil
 		}
-	} else {
-		state.args = append(state.args, line)
 	}
-	return nil
+	return reply.MakeMultiBulkReply(args), false, nil
+}
+
+// readAggregate 读取 RESP3 的 map、set、push 以及 attribute
+func readAggregate(bufReader *bufio.Reader, header []byte, depth int) (resp.Reply, bool, error) {
+	length, err := readLength(header)
+	if err != nil || length < 0 {
+		return nil, false, protocolError(header)
+	}
+	if err := checkAggregate(length, depth); err != nil {
+		return nil, true, err
+	}
+	n := length
+	if header[0] == '%' || header[0] == '|' {
+		n = 2 * length
+	}
+	elements, ioErr, err := readElements(bufReader, n, depth)
+	if err != nil {
+		return nil, ioErr, err
+	}
+	switch header[0] {
+	case '~':
+		return reply.MakeSetReply(elements), false, nil
+	case '>':
+		return reply.MakePushReply(elements), false, nil
+	}
+	result := reply.MakeMapReply(nil, nil)
+	for i := 0; i+1 < len(elements); i += 2 {
+		result.Add(elements[i], elements[i+1])
+	}
+	return result, false, nil
 }
@@ -0,0 +1,107 @@
+package parser
+
+import (
+	"bytes"
+	"github.com/ygxiaobai111/GolixirDB/resp/reply"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// parseAll 解析 data 中的所有内容，返回读取结束之前的所有 Payload，最后一个是 io.EOF 或导致停止读取的错误
+func parseAll(data []byte) []*Payload {
+	var payloads []*Payload
+	for payload := range ParseStream(bytes.NewReader(data)) {
+		payloads = append(payloads, payload)
+	}
+	return payloads
+}
+
+func TestParseStream(t *testing.T) {
+	data := []byte("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\nGET key\r\n\r\n")
+	payloads := parseAll(data)
+	if len(payloads) != 3 || payloads[2].Err != io.EOF {
+		t.Fatalf("expected 2 requests followed by EOF, got %d payloads", len(payloads))
+	}
+	expected := []string{"*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n", "*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n"}
+	for i, want := range expected {
+		if payloads[i].Err != nil {
+			t.Fatalf("request %d: %v", i, payloads[i].Err)
+		}
+		if got := string(payloads[i].Data.ToBytes()); got != want {
+			t.Errorf("request %d: expected %q, got %q", i, want, got)
+		}
+	}
+}
+
+// TestParseLimits 超过限制时返回协议错误并停止读取，之后的数据不会被解析
+func TestParseLimits(t *testing.T) {
+	const trailing = "*1\r\n$4\r\nPING\r\n"
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"unterminated inline", strings.Repeat("a", maxLineLen+1), "too big inline request"},
+		{"long inline", strings.Repeat("a", maxLineLen+1) + "\r\n", "too big inline request"},
+		{"long header", "*" + strings.Repeat("1", maxLineLen) + "\r\n", "too big inline request"},
+		{"bulk length", "*1\r\n$" + strconv.Itoa(maxBulkLen+1) + "\r\n", "invalid bulk length"},
+		{"multibulk length", "*" + strconv.Itoa(maxMultiBulkLen+1) + "\r\n", "invalid multibulk length"},
+		{"map length", "%" + strconv.Itoa(maxMultiBulkLen+1) + "\r\n", "invalid multibulk length"},
+		{"nesting", strings.Repeat("*1\r\n", maxNestingDepth+1) + ":1\r\n", "too many nested aggregates"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payloads := parseAll([]byte(tt.data + trailing))
+			if len(payloads) != 1 {
+				t.Fatalf("expected parsing to stop at the first error, got %d payloads", len(payloads))
+			}
+			if payloads[0].Err == nil || !strings.Contains(payloads[0].Err.Error(), tt.err) {
+				t.Fatalf("expected error %q, got %v", tt.err, payloads[0].Err)
+			}
+		})
+	}
+}
+
+// TestParseWithinLimits 恰好达到限制的数据可以正常解析
+func TestParseWithinLimits(t *testing.T) {
+	arg := strings.Repeat("a", maxLineLen-2)
+	payloads := parseAll([]byte(arg + "\r\n"))
+	if len(payloads) != 2 || payloads[0].Err != nil {
+		t.Fatalf("expected an inline request of %d bytes to be accepted, got %v", maxLineLen, payloads[0].Err)
+	}
+	if r, ok := payloads[0].Data.(*reply.MultiBulkReply); !ok || len(r.Args) != 1 || string(r.Args[0]) != arg {
+		t.Fatalf("unexpected inline request %v", payloads[0].Data)
+	}
+
+	nested := strings.Repeat("*1\r\n", maxNestingDepth) + ":1\r\n"
+	payloads = parseAll([]byte(nested))
+	if len(payloads) != 2 || payloads[0].Err != nil {
+		t.Fatalf("expected %d nested arrays to be accepted, got %v", maxNestingDepth, payloads[0].Err)
+	}
+
+	// 长度超过单行限制的批量字符串不受 maxLineLen 影响
+	long := strings.Repeat("b", 2*maxLineLen)
+	payloads = parseAll([]byte("*1\r\n$" + strconv.Itoa(len(long)) + "\r\n" + long + "\r\n"))
+	if len(payloads) != 2 || payloads[0].Err != nil {
+		t.Fatalf("expected a long bulk string to be accepted, got %v", payloads[0].Err)
+	}
+	if r, ok := payloads[0].Data.(*reply.MultiBulkReply); !ok || string(r.Args[0]) != long {
+		t.Fatal("long bulk string was not parsed intact")
+	}
+}
+
+// TestParseProtocolError 普通的协议错误只丢弃当前数据，继续解析之后的请求
+func TestParseProtocolError(t *testing.T) {
+	payloads := parseAll([]byte("*x\r\n*1\r\n$4\r\nPING\r\n"))
+	if len(payloads) != 3 {
+		t.Fatalf("expected error, request and EOF, got %d payloads", len(payloads))
+	}
+	if payloads[0].Err == nil {
+		t.Fatal("expected a protocol error for an invalid array length")
+	}
+	if payloads[1].Err != nil || string(payloads[1].Data.ToBytes()) != "*1\r\n$4\r\nPING\r\n" {
+		t.Fatalf("expected PING after the protocol error, got %v", payloads[1])
+	}
+}
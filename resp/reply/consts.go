@@ -27,6 +27,23 @@ func MakeOkReply() *OkReply {
 	return theOkReply
 }
 
+// QueuedReply 是用于返回 +QUEUED 的结构体，表示命令已在 MULTI 中排队
+type QueuedReply struct{}
+
+var queuedBytes = []byte("+QUEUED\r\n")
+
+// ToBytes 方法用于将 QueuedReply 序列化为字节序列
+func (r *QueuedReply) ToBytes() []byte {
+	return queuedBytes
+}
+
+var theQueuedReply = new(QueuedReply)
+
+// MakeQueuedReply 用于创建一个 QueuedReply 实例
+func MakeQueuedReply() *QueuedReply {
+	return theQueuedReply
+}
+
 var nullBulkBytes = []byte("$-1\r\n")
 
 // NullBulkReply 用于表示空的批量回复
@@ -37,6 +54,11 @@ func (r *NullBulkReply) ToBytes() []byte {
 	return nullBulkBytes
 }
 
+// ToResp3Bytes 方法用于将 NullBulkReply 序列化为 RESP3 的 null
+func (r *NullBulkReply) ToResp3Bytes() []byte {
+	return resp3NullBytes
+}
+
 // MakeNullBulkReply 用于创建一个 NullBulkReply 实例
 func MakeNullBulkReply() *NullBulkReply {
 	return &NullBulkReply{}
@@ -7,8 +7,6 @@ import (
 )
 
 var (
-	nullBulkReplyBytes = []byte("$-1") // 空的批量回复的字节表示
-
 	CRLF = "\r\n"
 )
 
@@ -28,8 +26,8 @@ func MakeBulkReply(arg []byte) *BulkReply {
 
 // ToBytes 将BulkReply序列化为redis响应
 func (r *BulkReply) ToBytes() []byte {
-	if len(r.Arg) == 0 {
-		return nullBulkReplyBytes
+	if r.Arg == nil {
+		return nullBulkBytes
 	}
 	return []byte("$" + strconv.Itoa(len(r.Arg)) + CRLF + string(r.Arg) + CRLF)
 }
@@ -63,6 +61,48 @@ func (r *MultiBulkReply) ToBytes() []byte {
 	return buf.Bytes()
 }
 
+// ToResp3Bytes 将MultiBulkReply序列化为RESP3响应，空元素编码为 null
+func (r *MultiBulkReply) ToResp3Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("*" + strconv.Itoa(len(r.Args)) + CRLF)
+	for _, arg := range r.Args {
+		if arg == nil {
+			buf.Write(resp3NullBytes)
+		} else {
+			buf.WriteString("$" + strconv.Itoa(len(arg)) + CRLF + string(arg) + CRLF)
+		}
+	}
+	return buf.Bytes()
+}
+
+/* ---- Multi Raw Reply ---- */
+
+// MultiRawReply 存储由任意回复组成的列表，例如嵌套数组
+type MultiRawReply struct {
+	Replies []resp.Reply
+}
+
+// MakeMultiRawReply 创建MultiRawReply实例
+func MakeMultiRawReply(replies []resp.Reply) *MultiRawReply {
+	return &MultiRawReply{
+		Replies: replies,
+	}
+}
+
+// ToBytes 将MultiRawReply序列化为RESP2响应
+func (r *MultiRawReply) ToBytes() []byte {
+	var buf bytes.Buffer
+	writeAggregate(&buf, '*', len(r.Replies), r.Replies, Resp2)
+	return buf.Bytes()
+}
+
+// ToResp3Bytes 将MultiRawReply序列化为RESP3响应，元素按RESP3编码
+func (r *MultiRawReply) ToResp3Bytes() []byte {
+	var buf bytes.Buffer
+	writeAggregate(&buf, '*', len(r.Replies), r.Replies, Resp3)
+	return buf.Bytes()
+}
+
 /* ---- Status Reply ---- */
 
 // StatusReply 存储一个简单的状态字符串
@@ -132,5 +172,6 @@ func MakeErrReply(status string) *StandardErrReply {
 
 // IsErrorReply 判断给定的回复是否为错误
 func IsErrorReply(reply resp.Reply) bool {
-	return reply.ToBytes()[0] == '-'
+	bs := reply.ToBytes()
+	return len(bs) > 0 && bs[0] == '-'
 }
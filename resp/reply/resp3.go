@@ -0,0 +1,304 @@
+package reply
+
+import (
+	"bytes"
+	"github.com/ygxiaobai111/GolixirDB/interface/resp"
+	"math"
+	"strconv"
+)
+
+/*
+RESP3 协议新增的回复类型
+每种类型的 ToBytes 仍然返回 RESP2 编码，保证未执行 HELLO 3 的客户端可以正常解析，
+ToResp3Bytes 返回 RESP3 编码
+*/
+
+const (
+	// Resp2 RESP2 协议版本，新连接的默认协议
+	Resp2 = 2
+	// Resp3 RESP3 协议版本，需要客户端通过 HELLO 3 协商
+	Resp3 = 3
+)
+
+// Resp3Reply 表示在 RESP3 协议下有独立编码的回复
+type Resp3Reply interface {
+	resp.Reply
+	// ToResp3Bytes 将回复序列化为 RESP3 编码
+	ToResp3Bytes() []byte
+}
+
+// ToProtocolBytes 按照连接协商的协议版本序列化回复
+func ToProtocolBytes(r resp.Reply, protocol int) []byte {
+	if protocol >= Resp3 {
+		if r3, ok := r.(Resp3Reply); ok {
+			return r3.ToResp3Bytes()
+		}
+	}
+	return r.ToBytes()
+}
+
+// writeAggregate 写入聚合类型的头部及所有元素
+func writeAggregate(buf *bytes.Buffer, prefix byte, length int, elements []resp.Reply, protocol int) {
+	buf.WriteByte(prefix)
+	buf.WriteString(strconv.Itoa(length) + CRLF)
+	for _, e := range elements {
+		buf.Write(ToProtocolBytes(e, protocol))
+	}
+}
+
+/* ---- Map Reply ---- */
+
+// MapReply 存储键值对，RESP2 下编码为 key value 交替的数组
+type MapReply struct {
+	Keys   []resp.Reply
+	Values []resp.Reply
+}
+
+// MakeMapReply 创建MapReply实例，keys 与 values 长度必须一致
+func MakeMapReply(keys []resp.Reply, values []resp.Reply) *MapReply {
+	return &MapReply{
+		Keys:   keys,
+		Values: values,
+	}
+}
+
+// MakeStringMapReply 使用字符串键值对创建MapReply实例，pairs 为 key value 交替排列
+func MakeStringMapReply(pairs ...string) *MapReply {
+	r := &MapReply{
+		Keys:   make([]resp.Reply, 0, len(pairs)/2),
+		Values: make([]resp.Reply, 0, len(pairs)/2),
+	}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		r.Add(MakeBulkReply([]byte(pairs[i])), MakeBulkReply([]byte(pairs[i+1])))
+	}
+	return r
+}
+
+// Add 追加一个键值对
+func (r *MapReply) Add(key resp.Reply, value resp.Reply) {
+	r.Keys = append(r.Keys, key)
+	r.Values = append(r.Values, value)
+}
+
+func (r *MapReply) elements() []resp.Reply {
+	elements := make([]resp.Reply, 0, 2*len(r.Keys))
+	for i := range r.Keys {
+		elements = append(elements, r.Keys[i], r.Values[i])
+	}
+	return elements
+}
+
+// ToBytes 将MapReply序列化为RESP2数组
+func (r *MapReply) ToBytes() []byte {
+	var buf bytes.Buffer
+	writeAggregate(&buf, '*', 2*len(r.Keys), r.elements(), Resp2)
+	return buf.Bytes()
+}
+
+// ToResp3Bytes 将MapReply序列化为RESP3 map
+func (r *MapReply) ToResp3Bytes() []byte {
+	var buf bytes.Buffer
+	writeAggregate(&buf, '%', len(r.Keys), r.elements(), Resp3)
+	return buf.Bytes()
+}
+
+/* ---- Set Reply ---- */
+
+// SetReply 存储无序且不重复的元素集合
+type SetReply struct {
+	Members []resp.Reply
+}
+
+// MakeSetReply 创建SetReply实例
+func MakeSetReply(members []resp.Reply) *SetReply {
+	return &SetReply{
+		Members: members,
+	}
+}
+
+// ToBytes 将SetReply序列化为RESP2数组
+func (r *SetReply) ToBytes() []byte {
+	var buf bytes.Buffer
+	writeAggregate(&buf, '*', len(r.Members), r.Members, Resp2)
+	return buf.Bytes()
+}
+
+// ToResp3Bytes 将SetReply序列化为RESP3 set
+func (r *SetReply) ToResp3Bytes() []byte {
+	var buf bytes.Buffer
+	writeAggregate(&buf, '~', len(r.Members), r.Members, Resp3)
+	return buf.Bytes()
+}
+
+/* ---- Push Reply ---- */
+
+// PushReply 表示服务器主动推送的带外数据，例如发布订阅消息
+type PushReply struct {
+	Args []resp.Reply
+}
+
+// MakePushReply 创建PushReply实例
+func MakePushReply(args []resp.Reply) *PushReply {
+	return &PushReply{
+		Args: args,
+	}
+}
+
+// ToBytes 将PushReply序列化为RESP2数组
+func (r *PushReply) ToBytes() []byte {
+	var buf bytes.Buffer
+	writeAggregate(&buf, '*', len(r.Args), r.Args, Resp2)
+	return buf.Bytes()
+}
+
+// ToResp3Bytes 将PushReply序列化为RESP3 push
+func (r *PushReply) ToResp3Bytes() []byte {
+	var buf bytes.Buffer
+	writeAggregate(&buf, '>', len(r.Args), r.Args, Resp3)
+	return buf.Bytes()
+}
+
+/* ---- Double Reply ---- */
+
+// DoubleReply 存储一个浮点数
+type DoubleReply struct {
+	Value float64
+}
+
+// MakeDoubleReply 创建DoubleReply实例
+func MakeDoubleReply(value float64) *DoubleReply {
+	return &DoubleReply{
+		Value: value,
+	}
+}
+
+func (r *DoubleReply) format() string {
+	switch {
+	case math.IsInf(r.Value, 1):
+		return "inf"
+	case math.IsInf(r.Value, -1):
+		return "-inf"
+	case math.IsNaN(r.Value):
+		return "nan"
+	}
+	return strconv.FormatFloat(r.Value, 'f', -1, 64)
+}
+
+// ToBytes 将DoubleReply序列化为RESP2批量字符串
+func (r *DoubleReply) ToBytes() []byte {
+	return MakeBulkReply([]byte(r.format())).ToBytes()
+}
+
+// ToResp3Bytes 将DoubleReply序列化为RESP3 double
+func (r *DoubleReply) ToResp3Bytes() []byte {
+	return []byte("," + r.format() + CRLF)
+}
+
+/* ---- Boolean Reply ---- */
+
+// BooleanReply 存储一个布尔值，RESP2 下编码为整数 1 或 0
+type BooleanReply struct {
+	Value bool
+}
+
+var (
+	trueReply  = &BooleanReply{Value: true}
+	falseReply = &BooleanReply{Value: false}
+)
+
+// MakeBooleanReply 返回BooleanReply实例
+func MakeBooleanReply(value bool) *BooleanReply {
+	if value {
+		return trueReply
+	}
+	return falseReply
+}
+
+// ToBytes 将BooleanReply序列化为RESP2整数
+func (r *BooleanReply) ToBytes() []byte {
+	if r.Value {
+		return []byte(":1" + CRLF)
+	}
+	return []byte(":0" + CRLF)
+}
+
+// ToResp3Bytes 将BooleanReply序列化为RESP3 boolean
+func (r *BooleanReply) ToResp3Bytes() []byte {
+	if r.Value {
+		return []byte("#t" + CRLF)
+	}
+	return []byte("#f" + CRLF)
+}
+
+/* ---- Null Reply ---- */
+
+// NullReply 表示RESP3中的空值，RESP2 下编码为空批量回复
+type NullReply struct{}
+
+var resp3NullBytes = []byte("_\r\n")
+
+// MakeNullReply 创建NullReply实例
+func MakeNullReply() *NullReply {
+	return &NullReply{}
+}
+
+// ToBytes 将NullReply序列化为RESP2空批量回复
+func (r *NullReply) ToBytes() []byte {
+	return nullBulkBytes
+}
+
+// ToResp3Bytes 将NullReply序列化为RESP3 null
+func (r *NullReply) ToResp3Bytes() []byte {
+	return resp3NullBytes
+}
+
+/* ---- Big Number Reply ---- */
+
+// BigNumberReply 存储超出int64范围的整数的十进制表示
+type BigNumberReply struct {
+	Value string
+}
+
+// MakeBigNumberReply 创建BigNumberReply实例
+func MakeBigNumberReply(value string) *BigNumberReply {
+	return &BigNumberReply{
+		Value: value,
+	}
+}
+
+// ToBytes 将BigNumberReply序列化为RESP2批量字符串
+func (r *BigNumberReply) ToBytes() []byte {
+	return MakeBulkReply([]byte(r.Value)).ToBytes()
+}
+
+// ToResp3Bytes 将BigNumberReply序列化为RESP3 big number
+func (r *BigNumberReply) ToResp3Bytes() []byte {
+	return []byte("(" + r.Value + CRLF)
+}
+
+/* ---- Verbatim String Reply ---- */
+
+// VerbatimStringReply 存储带格式说明的字符串，Format 为三个字符，例如 txt 或 mkd
+type VerbatimStringReply struct {
+	Format string
+	Text   string
+}
+
+// MakeVerbatimStringReply 创建VerbatimStringReply实例
+func MakeVerbatimStringReply(format string, text string) *VerbatimStringReply {
+	return &VerbatimStringReply{
+		Format: format,
+		Text:   text,
+	}
+}
+
+// ToBytes 将VerbatimStringReply序列化为RESP2批量字符串
+func (r *VerbatimStringReply) ToBytes() []byte {
+	return MakeBulkReply([]byte(r.Text)).ToBytes()
+}
+
+// ToResp3Bytes 将VerbatimStringReply序列化为RESP3 verbatim string
+func (r *VerbatimStringReply) ToResp3Bytes() []byte {
+	body := r.Format + ":" + r.Text
+	return []byte("=" + strconv.Itoa(len(body)) + CRLF + body + CRLF)
+}
@@ -20,25 +20,42 @@ import (
 // Config stores tcp server properties
 type Config struct {
 	Address string
+	// OnReload 收到 SIGHUP 时调用，用于重新加载配置，为 nil 时忽略 SIGHUP
+	OnReload func()
+	// Shutdown 关闭时停止服务器，例如客户端执行了 SHUTDOWN 命令，可以为 nil
+	Shutdown <-chan struct{}
 }
 
-// ListenAndServeWithSignal 绑定端口并处理请求，阻塞直到接收到停止信号
+// ListenAndServeWithSignal 绑定端口并处理请求，阻塞直到接收到停止信号或 cfg.Shutdown 关闭
+// 返回关闭过程中遇到的错误
 func ListenAndServeWithSignal(cfg *Config, handler tcp.Handler) error {
 	// 创建一个用于关闭服务器的通道
 	closeChan := make(chan struct{})
 	// 创建一个用于接收系统信号的通道
-	sigCh := make(chan os.Signal)
+	sigCh := make(chan os.Signal, 1)
 	// 注册需要监听的系统信号，包括 SIGHUP, SIGQUIT, SIGTERM, SIGINT
 	signal.Notify(sigCh, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT)
 
 	// 启动一个新的协程来监听系统信号
 	go func() {
-		// 阻塞等待信号
-		sig := <-sigCh
-		// 判断接收到的信号类型，如果是停止信号之一，则向 closeChan 发送消息，用于通知服务器关闭
-		switch sig {
-		case syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT:
+		defer signal.Stop(sigCh)
+		for {
+			select {
+			case sig := <-sigCh:
+				// SIGHUP 重新加载配置，不影响已有连接
+				if sig == syscall.SIGHUP {
+					util.LogrusObj.Info("received SIGHUP, reloading config")
+					if cfg.OnReload != nil {
+						cfg.OnReload()
+					}
+					continue
+				}
+				// 其余信号均为停止信号，向 closeChan 发送消息，用于通知服务器关闭
+				util.LogrusObj.Info("received " + sig.String())
+			case <-cfg.Shutdown:
+			}
 			closeChan <- struct{}{}
+			return
 		}
 	}()
 
@@ -50,25 +67,16 @@ func ListenAndServeWithSignal(cfg *Config, handler tcp.Handler) error {
 
 	util.LogrusObj.Info(fmt.Sprintf("bind: %s, start listening...", cfg.Address))
 	// 调用 ListenAndServe 函数来处理监听和请求，同时传入 closeChan 以便可以基于信号关闭服务器
-	ListenAndServe(listener, handler, closeChan)
-	return nil
+	return ListenAndServe(listener, handler, closeChan)
 }
 
-// ListenAndServe 监听端口和处理请求，阻塞直到关闭
-func ListenAndServe(listener net.Listener, handler tcp.Handler, closeChan <-chan struct{}) {
+// ListenAndServe 监听端口和处理请求，阻塞直到关闭，返回 handler 关闭时的错误
+func ListenAndServe(listener net.Listener, handler tcp.Handler, closeChan <-chan struct{}) error {
 	// listen signal
 	go func() {
 		<-closeChan
 		util.LogrusObj.Info("shutting down...")
 		_ = listener.Close() // listener.Accept() will return err immediately
-		_ = handler.Close()  // close connections
-	}()
-
-	// listen port
-	defer func() {
-		// close during unexpected error
-		_ = listener.Close()
-		_ = handler.Close()
 	}()
 	ctx := context.Background()
 	var waitDone sync.WaitGroup
@@ -89,7 +97,10 @@ func ListenAndServe(listener net.Listener, handler tcp.Handler, closeChan <-chan
 			handler.Handle(ctx, conn)
 		}()
 	}
+	// 不再接受新连接后关闭 handler，等待正在执行的命令完成并关闭所有连接
+	_ = listener.Close()
+	err := handler.Close()
 	//等待所有用户完成服务
 	waitDone.Wait()
-
+	return err
 }